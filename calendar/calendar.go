@@ -0,0 +1,230 @@
+// Package calendar tells working days from weekends and public holidays of a region, including the days a
+// government moves between them.
+package calendar
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const dateLayout = "2006-01-02"
+
+type DayType string
+
+const (
+	Workday DayType = "WORKDAY"
+	Weekend DayType = "WEEKEND"
+	Holiday DayType = "HOLIDAY"
+)
+
+// DayTypes lists every day type.
+var DayTypes = []DayType{Workday, Weekend, Holiday}
+
+//go:embed regions/*.yaml
+var regions embed.FS
+
+// Regions holds the calendar files shipped with the service.
+func Regions() fs.FS {
+	sub, _ := fs.Sub(regions, "regions")
+	return sub
+}
+
+// File is the content of a calendar file. A region may be split over several files, e.g. one a year.
+type File struct {
+	Region string `yaml:"region"`
+	// Weekend is Saturday and Sunday when no file of the region sets it.
+	Weekend []string `yaml:"weekend"`
+	// Holidays are days off by date, with their names.
+	Holidays map[string]string `yaml:"holidays"`
+	// Workdays are weekend days made working, e.g. in exchange for a day off next to a holiday.
+	Workdays map[string]string `yaml:"workdays"`
+}
+
+// Calendar is the calendar of one region.
+type Calendar struct {
+	region   string
+	weekend  map[time.Weekday]bool
+	holidays map[string]string
+	workdays map[string]bool
+}
+
+// Default has Saturday and Sunday off and no holidays.
+func Default() *Calendar {
+	return &Calendar{
+		weekend:  map[time.Weekday]bool{time.Saturday: true, time.Sunday: true},
+		holidays: map[string]string{},
+		workdays: map[string]bool{},
+	}
+}
+
+// Load merges the .yaml files of fsys for region, compared ignoring case. An empty region is the Default calendar.
+// A date may not be both a holiday and a workday, whichever files list it.
+func Load(fsys fs.FS, region string) (*Calendar, error) {
+	calendar := Default()
+	if region == "" {
+		return calendar, nil
+	}
+	calendar.region = region
+	names, err := fs.Glob(fsys, "*.yaml")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	var weekendFrom string
+	// The files listing each holiday and workday, to report conflicts once every file is read.
+	holidayFrom, workdayFrom := map[string]string{}, map[string]string{}
+	found := false
+	for _, name := range names {
+		file, err := readFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.EqualFold(file.Region, region) {
+			continue
+		}
+		found = true
+		if file.Weekend != nil {
+			weekend, err := parseWeekend(file.Weekend)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			if weekendFrom != "" && !sameWeekend(weekend, calendar.weekend) {
+				return nil, fmt.Errorf("%s: weekend differs from the one of %s", name, weekendFrom)
+			}
+			calendar.weekend, weekendFrom = weekend, name
+		}
+		if err := calendar.add(file); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		for date := range file.Holidays {
+			holidayFrom[date] = name
+		}
+		for date := range file.Workdays {
+			workdayFrom[date] = name
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("no calendar file for region %q", region)
+	}
+	dates := make([]string, 0, len(workdayFrom))
+	for date := range workdayFrom {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+	for _, date := range dates {
+		if holiday, conflict := holidayFrom[date]; conflict {
+			return nil, fmt.Errorf("%s is a holiday in %s and a workday in %s", date, holiday, workdayFrom[date])
+		}
+	}
+	return calendar, nil
+}
+
+func readFile(fsys fs.FS, name string) (*File, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	var file File
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return &file, nil
+}
+
+func (calendar *Calendar) add(file *File) error {
+	for date, name := range file.Holidays {
+		if _, err := time.Parse(dateLayout, date); err != nil {
+			return fmt.Errorf("holiday %q: %w", date, err)
+		}
+		calendar.holidays[date] = name
+	}
+	for date := range file.Workdays {
+		if _, err := time.Parse(dateLayout, date); err != nil {
+			return fmt.Errorf("workday %q: %w", date, err)
+		}
+		calendar.workdays[date] = true
+	}
+	return nil
+}
+
+func parseWeekend(days []string) (map[time.Weekday]bool, error) {
+	weekend := make(map[time.Weekday]bool, len(days))
+	for _, day := range days {
+		weekday, found := weekdays[strings.ToLower(day)]
+		if !found {
+			return nil, fmt.Errorf("unknown weekday %q", day)
+		}
+		weekend[weekday] = true
+	}
+	return weekend, nil
+}
+
+func sameWeekend(a, b map[time.Weekday]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for day := range a {
+		if !b[day] {
+			return false
+		}
+	}
+	return true
+}
+
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+// Region is the region the calendar was loaded for, empty for Default.
+func (calendar *Calendar) Region() string {
+	return calendar.region
+}
+
+// Version identifies the day types of the calendar: calendars with the same version tell every date alike.
+func (calendar *Calendar) Version() string {
+	var days []string
+	for day, off := range calendar.weekend {
+		if off {
+			days = append(days, "weekend "+day.String())
+		}
+	}
+	for date := range calendar.holidays {
+		days = append(days, "holiday "+date)
+	}
+	for date := range calendar.workdays {
+		days = append(days, "workday "+date)
+	}
+	sort.Strings(days)
+	sum := sha256.Sum256([]byte(strings.Join(days, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// DayType tells what the calendar date of date is. A workday moved to a weekend is a Workday.
+func (calendar *Calendar) DayType(date time.Time) DayType {
+	day := date.Format(dateLayout)
+	_, holiday := calendar.holidays[day]
+	switch {
+	case calendar.workdays[day]:
+		return Workday
+	case holiday:
+		return Holiday
+	case calendar.weekend[date.Weekday()]:
+		return Weekend
+	default:
+		return Workday
+	}
+}
@@ -0,0 +1,139 @@
+package calendar
+
+import (
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func date(value string) time.Time {
+	parsed, _ := time.Parse(dateLayout, value)
+	return parsed
+}
+
+func TestCalendar(t *testing.T) {
+	files := fstest.MapFS{
+		"kz-2026.yaml": {Data: []byte(`
+region: KZ
+weekend: [saturday, sunday]
+holidays:
+  "2026-03-23": Наурыз мейрамы
+workdays:
+  "2026-03-28": вместо 27 марта
+`)},
+		"kz-2027.yaml": {Data: []byte(`
+region: kz
+holidays:
+  "2027-01-01": Новый год
+`)},
+		"ae.yaml": {Data: []byte(`
+region: AE
+weekend: [Friday, Saturday]
+`)},
+	}
+
+	testCases := []struct {
+		title    string
+		region   string
+		date     string
+		expected DayType
+	}{
+		{title: "Будний день", region: "KZ", date: "2026-03-20", expected: Workday},
+		{title: "Выходной", region: "KZ", date: "2026-03-22", expected: Weekend},
+		{title: "Праздник в будний день", region: "KZ", date: "2026-03-23", expected: Holiday},
+		{title: "Перенесённый рабочий день в субботу", region: "KZ", date: "2026-03-28", expected: Workday},
+		{title: "Праздник из файла другого года", region: "kz", date: "2027-01-01", expected: Holiday},
+		{title: "Выходные региона", region: "AE", date: "2026-03-20", expected: Weekend},
+		{title: "Воскресенье рабочее в регионе", region: "AE", date: "2026-03-22", expected: Workday},
+		{title: "Без региона суббота и воскресенье", date: "2026-03-22", expected: Weekend},
+		{title: "Без региона праздников нет", date: "2026-03-23", expected: Workday},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.title, func(t *testing.T) {
+			calendar, err := Load(files, tc.region)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, calendar.DayType(date(tc.date)))
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	testCases := []struct {
+		title         string
+		files         fstest.MapFS
+		expectedError string
+	}{
+		{
+			title:         "Нет файла региона",
+			files:         fstest.MapFS{"ae.yaml": {Data: []byte("region: AE")}},
+			expectedError: `no calendar file for region "KZ"`,
+		},
+		{
+			title:         "Неизвестный день недели",
+			files:         fstest.MapFS{"kz.yaml": {Data: []byte("region: KZ\nweekend: [sabbath]")}},
+			expectedError: `kz.yaml: unknown weekday "sabbath"`,
+		},
+		{
+			title: "Выходные расходятся между файлами",
+			files: fstest.MapFS{
+				"kz-2026.yaml": {Data: []byte("region: KZ\nweekend: [saturday, sunday]")},
+				"kz-2027.yaml": {Data: []byte("region: KZ\nweekend: [sunday]")},
+			},
+			expectedError: "kz-2027.yaml: weekend differs from the one of kz-2026.yaml",
+		},
+		{
+			title:         "День и праздник, и рабочий",
+			files:         fstest.MapFS{"kz.yaml": {Data: []byte("region: KZ\nholidays:\n  \"2026-03-23\": Наурыз\nworkdays:\n  \"2026-03-23\": ошибка")}},
+			expectedError: "2026-03-23 is a holiday in kz.yaml and a workday in kz.yaml",
+		},
+		{
+			title: "Праздник в раннем файле, рабочий день в позднем",
+			files: fstest.MapFS{
+				"kz-a.yaml": {Data: []byte("region: KZ\nholidays:\n  \"2026-03-23\": Наурыз")},
+				"kz-b.yaml": {Data: []byte("region: KZ\nworkdays:\n  \"2026-03-23\": ошибка")},
+			},
+			expectedError: "2026-03-23 is a holiday in kz-a.yaml and a workday in kz-b.yaml",
+		},
+		{
+			title: "Рабочий день в раннем файле, праздник в позднем",
+			files: fstest.MapFS{
+				"kz-a.yaml": {Data: []byte("region: KZ\nworkdays:\n  \"2026-03-23\": ошибка")},
+				"kz-b.yaml": {Data: []byte("region: KZ\nholidays:\n  \"2026-03-23\": Наурыз")},
+			},
+			expectedError: "2026-03-23 is a holiday in kz-b.yaml and a workday in kz-a.yaml",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.title, func(t *testing.T) {
+			_, err := Load(tc.files, "KZ")
+			require.EqualError(t, err, tc.expectedError)
+		})
+	}
+}
+
+func TestRegions(t *testing.T) {
+	calendar, err := Load(Regions(), "KZ")
+	require.NoError(t, err)
+	require.Equal(t, Holiday, calendar.DayType(date("2026-03-24")), "перенос с 21 марта")
+	require.Equal(t, Holiday, calendar.DayType(date("2026-12-16")))
+	require.Equal(t, Workday, calendar.DayType(date("2026-12-17")))
+}
+
+func TestVersion(t *testing.T) {
+	kz, err := Load(Regions(), "KZ")
+	require.NoError(t, err)
+	again, err := Load(Regions(), "kz")
+	require.NoError(t, err)
+	require.Equal(t, kz.Version(), again.Version())
+	require.NotEqual(t, Default().Version(), kz.Version())
+
+	before, err := Load(fstest.MapFS{"kz.yaml": {Data: []byte("region: KZ\nholidays:\n  \"2026-03-23\": Наурыз")}}, "KZ")
+	require.NoError(t, err)
+	after, err := Load(fstest.MapFS{"kz.yaml": {Data: []byte("region: KZ\nholidays:\n  \"2026-03-24\": Наурыз")}}, "KZ")
+	require.NoError(t, err)
+	require.NotEqual(t, before.Version(), after.Version(), "перенос праздника меняет версию")
+}
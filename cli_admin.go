@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kas2000/logger"
+	"github.com/kas2000/service-todo/config"
+	"github.com/kas2000/service-todo/todo"
+	"github.com/urfave/cli/v2"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// adminCommands work directly against the configured database. Their writes are appended to the change log, so the
+// read model of running instances picks them up.
+var adminCommands = []*cli.Command{
+	{
+		Name:  "seed",
+		Usage: "Create random tasks",
+		Flags: []cli.Flag{
+			&cli.IntFlag{Name: "count", Usage: "Number of tasks", Value: 50},
+			&cli.Int64Flag{Name: "seed", Usage: "Random `SEED`, current time by default"},
+		},
+		Action: withTodoRepo(seedTodos),
+	},
+	{
+		Name:      "export",
+		Usage:     "Write all tasks as JSONL",
+		ArgsUsage: "[FILE]",
+		Action:    withTodoRepo(exportTodos),
+	},
+	{
+		Name:      "import",
+		Usage:     "Restore tasks from JSONL written by export",
+		ArgsUsage: "[FILE]",
+		Action:    withTodoRepo(importTodos),
+	},
+	{
+		Name:  "doctor",
+		Usage: "Find tasks with an unknown status, a missing created_at or a duplicate title and active date",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{Name: "fix", Usage: "Repair the tasks found and recreate the unique index"},
+		},
+		Action: withTodoRepo(doctorTodos),
+	},
+	{
+		Name:  "rebuild",
+		Usage: "Rewrite the collection of tasks from the event log",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{Name: "backfill", Usage: "First record the current state of tasks that have no events"},
+		},
+		Action: withMongo(rebuildTodos),
+	},
+	{
+		Name:   "reproject",
+		Usage:  "Rewrite the read model of task listings from the tasks",
+		Action: withMongo(reprojectTodos),
+	},
+}
+
+func withTodoRepo(action func(c *cli.Context, todoRepo todo.TodoRepository) error) cli.ActionFunc {
+	return withMongo(func(c *cli.Context, cfg *config.Config, mongoDB *mongo.Database) error {
+		log, err := logger.New(cfg.Log.Level)
+		if err != nil {
+			return err
+		}
+		return action(c, todo.NewChangeLoggingRepo(newTodoRepo(mongoDB, cfg.Mongo), todo.NewChangeLog(mongoDB), log))
+	})
+}
+
+func withMongo(action func(c *cli.Context, cfg *config.Config, mongoDB *mongo.Database) error) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		cfg, err := loadConfig(c)
+		if err != nil {
+			return err
+		}
+		mongoClient, mongoDB, err := connectMongo(cfg.Mongo)
+		if err != nil {
+			return fmt.Errorf("couldn't connect to mongodb: %w", err)
+		}
+		defer mongoClient.Disconnect(context.TODO())
+
+		if err := todo.Migrate(context.TODO(), mongoDB, cfg.Mongo.Collection); err != nil {
+			return fmt.Errorf("couldn't migrate database: %w", err)
+		}
+		c.Context = todo.ContextWithLocation(c.Context, cfg.Calendar.Location())
+		return action(c, cfg, mongoDB)
+	}
+}
+
+func seedTodos(c *cli.Context, todoRepo todo.TodoRepository) error {
+	seed := c.Int64("seed")
+	if !c.IsSet("seed") {
+		seed = time.Now().UnixNano()
+	}
+	created, err := todo.Seed(c.Context, todoRepo, c.Int("count"), rand.New(rand.NewSource(seed)))
+	fmt.Fprintf(c.App.ErrWriter, "created %d tasks\n", created)
+	return err
+}
+
+func exportTodos(c *cli.Context, todoRepo todo.TodoRepository) error {
+	w := c.App.Writer
+	if c.Args().Present() {
+		file, err := os.Create(c.Args().First())
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	exported, err := todo.Export(c.Context, todoRepo, w)
+	fmt.Fprintf(c.App.ErrWriter, "exported %d tasks\n", exported)
+	return err
+}
+
+func importTodos(c *cli.Context, todoRepo todo.TodoRepository) error {
+	var r io.Reader = os.Stdin
+	if c.Args().Present() {
+		file, err := os.Open(c.Args().First())
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+	result, err := todo.Import(c.Context, todoRepo, r)
+	fmt.Fprintf(c.App.ErrWriter, "imported %d tasks, skipped %d duplicates\n", result.Imported, result.Skipped)
+	return err
+}
+
+func doctorTodos(c *cli.Context, todoRepo todo.TodoRepository) error {
+	findings, err := todo.Doctor(c.Context, todoRepo, c.Bool("fix"))
+	if len(findings) > 0 {
+		table := tabwriter.NewWriter(c.App.Writer, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "ID\tPROBLEM\tFIX")
+		for _, finding := range findings {
+			fmt.Fprintf(table, "%s\t%s\t%s\n", finding.TodoID.Hex(), finding.Problem, finding.Fix)
+		}
+		table.Flush()
+	}
+	if err != nil {
+		return err
+	}
+	if !c.Bool("fix") && len(findings) > 0 {
+		return fmt.Errorf("found %d problems, run with --fix to repair them", len(findings))
+	}
+	fmt.Fprintf(c.App.ErrWriter, "%d problems\n", len(findings))
+	return nil
+}
+
+func rebuildTodos(c *cli.Context, cfg *config.Config, mongoDB *mongo.Database) error {
+	projection := todo.NewTodoRepo(mongoDB, cfg.Mongo.Collection)
+	result, err := todo.RebuildProjection(c.Context, todo.NewEventStore(mongoDB), projection, c.Bool("backfill"))
+	fmt.Fprintf(c.App.ErrWriter, "backfilled %d tasks, restored %d, deleted %d\n", result.Backfilled, result.Restored, result.Deleted)
+	if err != nil {
+		return err
+	}
+	// The projection is rewritten around the change log, so the read model is rebuilt from it.
+	if err := reprojectTodos(c, cfg, mongoDB); err != nil {
+		return err
+	}
+	if result.Orphaned > 0 {
+		return fmt.Errorf("%d tasks have no events, run with --backfill to record them", result.Orphaned)
+	}
+	return nil
+}
+
+func reprojectTodos(c *cli.Context, cfg *config.Config, mongoDB *mongo.Database) error {
+	businessCalendar, err := loadCalendar(cfg.Calendar)
+	if err != nil {
+		return fmt.Errorf("couldn't load calendar: %w", err)
+	}
+	views := todo.NewTodoViews(mongoDB, businessCalendar)
+	position, err := todo.RebuildViews(c.Context, todo.NewChangeLog(mongoDB), newTodoRepo(mongoDB, cfg.Mongo), views)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.App.ErrWriter, "read model rebuilt at change %d\n", position)
+	return nil
+}
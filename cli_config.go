@@ -0,0 +1,28 @@
+package main
+
+import (
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+var configCommand = &cli.Command{
+	Name:  "config",
+	Usage: "Inspect the configuration",
+	Subcommands: []*cli.Command{
+		{
+			Name:   "print",
+			Usage:  "Print the effective configuration as YAML with secrets redacted",
+			Action: printConfig,
+		},
+	},
+}
+
+func printConfig(c *cli.Context) error {
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
+	encoder := yaml.NewEncoder(c.App.Writer)
+	encoder.SetIndent(2)
+	return encoder.Encode(cfg.Redacted())
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/kas2000/service-todo/client"
+	"github.com/kas2000/service-todo/todo"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	defaultBaseURL = "http://localhost:8080/api"
+	outputTable    = "table"
+	outputJSON     = "json"
+)
+
+var todoCommand = &cli.Command{
+	Name:  "todo",
+	Usage: "Manage tasks of a running instance",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "profile",
+			Aliases: []string{"p"},
+			Usage:   "Read base URL and token from profile `NAME` in the service-todo config directory",
+			Value:   "default",
+			EnvVars: []string{"TODO_PROFILE"},
+		},
+		&cli.StringFlag{
+			Name:    "base-url",
+			Usage:   "Instance `URL` including the URL prefix",
+			EnvVars: []string{"TODO_BASE_URL"},
+		},
+		&cli.StringFlag{
+			Name:    "token",
+			Usage:   "Bearer `TOKEN` sent with every request",
+			EnvVars: []string{"TODO_TOKEN"},
+		},
+		&cli.StringFlag{
+			Name:    "list",
+			Aliases: []string{"l"},
+			Usage:   "Work on the tasks of list `ID` rather than on those of the default list",
+			EnvVars: []string{"TODO_LIST"},
+		},
+		&cli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Usage:   "Output `FORMAT`: table or json",
+			Value:   outputTable,
+		},
+	},
+	Subcommands: []*cli.Command{
+		{
+			Name:      "add",
+			Usage:     "Create a task",
+			ArgsUsage: "TITLE",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "date",
+					Usage: "Active date as `YYYY-MM-DD`, today by default",
+				},
+			},
+			Action: todoAdd,
+		},
+		{
+			Name:  "list",
+			Usage: "List tasks",
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "status", Usage: "Filter by `STATUS`: active, todo, in_progress, blocked, done or cancelled"},
+				&cli.StringFlag{Name: "title", Usage: "Filter by `TITLE`"},
+				&cli.StringFlag{Name: "sort", Usage: "Sort by `FIELDS`, e.g. -createdAt,title"},
+			},
+			Action: todoList,
+		},
+		{
+			Name:      "done",
+			Usage:     "Mark a task as done",
+			ArgsUsage: "ID",
+			Action:    todoDone,
+		},
+		{
+			Name:      "move",
+			Usage:     "Move a task to another status: todo, in_progress, blocked, done or cancelled",
+			ArgsUsage: "ID STATUS",
+			Action:    todoMove,
+		},
+		{
+			Name:      "transfer",
+			Usage:     "Move a task to another list",
+			ArgsUsage: "ID LIST_ID",
+			Action:    todoTransfer,
+		},
+		{
+			Name:      "rm",
+			Usage:     "Delete a task",
+			ArgsUsage: "ID",
+			Action:    todoRemove,
+		},
+		{
+			Name:   "lists",
+			Usage:  "List the task lists",
+			Action: todoLists,
+			Subcommands: []*cli.Command{
+				{
+					Name:      "add",
+					Usage:     "Create a task list",
+					ArgsUsage: "NAME",
+					Action:    todoListAdd,
+				},
+			},
+		},
+	},
+}
+
+// profilePath is <user config dir>/service-todo/<profile>.env.
+func profilePath(profile string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "service-todo", profile+".env"), nil
+}
+
+// newTodoClient resolves the base URL and token from flags or env first and the profile second.
+// A missing default profile is fine; a missing named one is an error.
+func newTodoClient(c *cli.Context) (*client.Client, error) {
+	baseURL := c.String("base-url")
+	token := c.String("token")
+
+	path, err := profilePath(c.String("profile"))
+	if err != nil {
+		return nil, err
+	}
+	profile, err := godotenv.Read(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) || c.IsSet("profile") {
+			return nil, fmt.Errorf("couldn't read profile %q: %w", c.String("profile"), err)
+		}
+		profile = map[string]string{}
+	}
+	if baseURL == "" {
+		baseURL = profile["TODO_BASE_URL"]
+	}
+	if baseURL == "" {
+		baseURL = defaultBaseURL
+	}
+	if token == "" {
+		token = profile["TODO_TOKEN"]
+	}
+	return client.New(baseURL, token).InList(c.String("list")), nil
+}
+
+func printTodos(c *cli.Context, todos []*todo.TodoDTO) error {
+	switch c.String("output") {
+	case outputJSON:
+		encoder := json.NewEncoder(c.App.Writer)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(todos)
+	case outputTable:
+		return writeTodoTable(c.App.Writer, todos)
+	default:
+		return fmt.Errorf("unknown output format %q", c.String("output"))
+	}
+}
+
+func writeTodoTable(w io.Writer, todos []*todo.TodoDTO) error {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "ID\tSTATUS\tACTIVE AT\tDAY\tTITLE")
+	for _, t := range todos {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", t.ID, t.Status, t.ActiveAt, t.DayType, t.Title)
+	}
+	return table.Flush()
+}
+
+func printLists(c *cli.Context, lists []*todo.TodoListDTO) error {
+	switch c.String("output") {
+	case outputJSON:
+		encoder := json.NewEncoder(c.App.Writer)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(lists)
+	case outputTable:
+		table := tabwriter.NewWriter(c.App.Writer, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(table, "ID\tARCHIVED\tNAME")
+		for _, list := range lists {
+			fmt.Fprintf(table, "%s\t%t\t%s\n", list.ID, list.Archived, list.Name)
+		}
+		return table.Flush()
+	default:
+		return fmt.Errorf("unknown output format %q", c.String("output"))
+	}
+}
+
+func requireArg(c *cli.Context, name string) (string, error) {
+	if c.NArg() != 1 || c.Args().First() == "" {
+		return "", fmt.Errorf("expected exactly one %s argument", name)
+	}
+	return c.Args().First(), nil
+}
+
+func todoAdd(c *cli.Context) error {
+	title, err := requireArg(c, "TITLE")
+	if err != nil {
+		return err
+	}
+	date := c.String("date")
+	if date == "" {
+		date = time.Now().Format("2006-01-02")
+	}
+	todoClient, err := newTodoClient(c)
+	if err != nil {
+		return err
+	}
+	created, err := todoClient.Create(title, date)
+	if err != nil {
+		return err
+	}
+	return printTodos(c, []*todo.TodoDTO{created})
+}
+
+func todoList(c *cli.Context) error {
+	todoClient, err := newTodoClient(c)
+	if err != nil {
+		return err
+	}
+	todos, err := todoClient.List(client.ListOptions{
+		Status: c.String("status"),
+		Title:  c.String("title"),
+		Sort:   c.String("sort"),
+	})
+	if err != nil {
+		return err
+	}
+	return printTodos(c, todos)
+}
+
+func todoDone(c *cli.Context) error {
+	id, err := requireArg(c, "ID")
+	if err != nil {
+		return err
+	}
+	todoClient, err := newTodoClient(c)
+	if err != nil {
+		return err
+	}
+	return todoClient.Done(id)
+}
+
+func todoMove(c *cli.Context) error {
+	if c.NArg() != 2 {
+		return errors.New("expected ID and STATUS arguments")
+	}
+	todoClient, err := newTodoClient(c)
+	if err != nil {
+		return err
+	}
+	return todoClient.Transition(c.Args().Get(0), strings.ToUpper(c.Args().Get(1)))
+}
+
+func todoRemove(c *cli.Context) error {
+	id, err := requireArg(c, "ID")
+	if err != nil {
+		return err
+	}
+	todoClient, err := newTodoClient(c)
+	if err != nil {
+		return err
+	}
+	return todoClient.Delete(id)
+}
+
+func todoTransfer(c *cli.Context) error {
+	if c.NArg() != 2 {
+		return errors.New("expected ID and LIST_ID arguments")
+	}
+	todoClient, err := newTodoClient(c)
+	if err != nil {
+		return err
+	}
+	return todoClient.MoveToList(c.Args().Get(0), c.Args().Get(1))
+}
+
+func todoLists(c *cli.Context) error {
+	todoClient, err := newTodoClient(c)
+	if err != nil {
+		return err
+	}
+	lists, err := todoClient.Lists()
+	if err != nil {
+		return err
+	}
+	return printLists(c, lists)
+}
+
+func todoListAdd(c *cli.Context) error {
+	name, err := requireArg(c, "NAME")
+	if err != nil {
+		return err
+	}
+	todoClient, err := newTodoClient(c)
+	if err != nil {
+		return err
+	}
+	created, err := todoClient.CreateList(name)
+	if err != nil {
+		return err
+	}
+	return printLists(c, []*todo.TodoListDTO{created})
+}
@@ -0,0 +1,212 @@
+// Package client talks to a running service-todo instance over its v2 REST API.
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kas2000/service-todo/todo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+	// listID selects the list of the tasks; they are those of the default list when empty.
+	listID string
+
+	// consistencyToken is the latest one returned by a write; reads send it back to see the client's own writes.
+	mu               sync.Mutex
+	consistencyToken int64
+}
+
+// New returns a client for baseURL, which includes the URL prefix, e.g. http://localhost:8080/api.
+func New(baseURL string, token string) *Client {
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		token:      token,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Error is a problem details response returned by the service.
+type Error struct {
+	todo.Problem
+}
+
+func (e *Error) Error() string {
+	message := e.Detail
+	if message == "" {
+		message = e.Title
+	}
+	for _, field := range e.Errors {
+		if field.Message != "" {
+			message += "; " + field.Message
+		} else {
+			message += "; " + field.Field + " " + field.Rule
+		}
+	}
+	return fmt.Sprintf("%s (%d %s)", message, e.Status, e.Code)
+}
+
+type ListOptions struct {
+	Status string
+	Title  string
+	Sort   string
+}
+
+// InList makes the client work on the tasks of the list listID rather than on those of the default list.
+func (c *Client) InList(listID string) *Client {
+	c.listID = listID
+	return c
+}
+
+func (c *Client) tasksURL() string {
+	if c.listID != "" {
+		return c.listsURL() + "/" + url.PathEscape(c.listID) + "/tasks"
+	}
+	return c.baseURL + "/" + todo.APIVersion2 + "/todo-list/tasks"
+}
+
+func (c *Client) listsURL() string {
+	return c.baseURL + "/" + todo.APIVersion2 + "/todo-lists"
+}
+
+func (c *Client) do(method string, url string, body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	c.mu.Lock()
+	if method == http.MethodGet && c.consistencyToken > 0 {
+		req.Header.Set(todo.ConsistencyTokenHeader, strconv.FormatInt(c.consistencyToken, 10))
+	}
+	c.mu.Unlock()
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if token, err := strconv.ParseInt(resp.Header.Get(todo.ConsistencyTokenHeader), 10, 64); err == nil {
+		c.mu.Lock()
+		if token > c.consistencyToken {
+			c.consistencyToken = token
+		}
+		c.mu.Unlock()
+	}
+
+	if resp.StatusCode >= 300 {
+		apiErr := &Error{}
+		if err := json.NewDecoder(resp.Body).Decode(&apiErr.Problem); err != nil || apiErr.Status == 0 {
+			return fmt.Errorf("unexpected response: %s", resp.Status)
+		}
+		return apiErr
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *Client) Create(title string, activeAt string) (*todo.TodoDTO, error) {
+	var created todo.TodoDTO
+	err := c.do(http.MethodPost, c.tasksURL(), todo.CreateTodoDTO{Title: title, ActiveAt: activeAt}, &created)
+	if err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (c *Client) Get(id string) (*todo.TodoDTO, error) {
+	var found todo.TodoDTO
+	if err := c.do(http.MethodGet, c.tasksURL()+"/"+url.PathEscape(id), nil, &found); err != nil {
+		return nil, err
+	}
+	return &found, nil
+}
+
+func (c *Client) List(opts ListOptions) ([]*todo.TodoDTO, error) {
+	query := url.Values{}
+	if opts.Status != "" {
+		query.Set("status", opts.Status)
+	}
+	if opts.Title != "" {
+		query.Set("title", opts.Title)
+	}
+	if opts.Sort != "" {
+		query.Set("sort", opts.Sort)
+	}
+	target := c.tasksURL()
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	todos := []*todo.TodoDTO{}
+	if err := c.do(http.MethodGet, target, nil, &todos); err != nil {
+		return nil, err
+	}
+	return todos, nil
+}
+
+func (c *Client) Done(id string) error {
+	return c.do(http.MethodPut, c.tasksURL()+"/"+url.PathEscape(id)+"/done", nil, nil)
+}
+
+// Transition moves a task to status, e.g. IN_PROGRESS.
+func (c *Client) Transition(id string, status string) error {
+	return c.do(http.MethodPost, c.tasksURL()+"/"+url.PathEscape(id)+"/transitions", todo.TransitionTodoDTO{Status: status}, nil)
+}
+
+// MoveToList moves a task to the list listID.
+func (c *Client) MoveToList(id string, listID string) error {
+	objID, err := primitive.ObjectIDFromHex(listID)
+	if err != nil {
+		return fmt.Errorf("invalid list id %q", listID)
+	}
+	return c.do(http.MethodPost, c.tasksURL()+"/"+url.PathEscape(id)+"/move", todo.MoveTodoDTO{ListID: objID}, nil)
+}
+
+func (c *Client) Delete(id string) error {
+	return c.do(http.MethodDelete, c.tasksURL()+"/"+url.PathEscape(id), nil, nil)
+}
+
+func (c *Client) CreateList(name string) (*todo.TodoListDTO, error) {
+	var created todo.TodoListDTO
+	if err := c.do(http.MethodPost, c.listsURL(), todo.CreateTodoListDTO{Name: name}, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (c *Client) Lists() ([]*todo.TodoListDTO, error) {
+	lists := []*todo.TodoListDTO{}
+	if err := c.do(http.MethodGet, c.listsURL(), nil, &lists); err != nil {
+		return nil, err
+	}
+	return lists, nil
+}
@@ -0,0 +1,109 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kas2000/service-todo/todo"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.RequestURI()+" "+r.Header.Get("Authorization"))
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/transitions"):
+			var transition todo.TransitionTodoDTO
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&transition))
+			require.Equal(t, todo.StatusInProgress, transition.Status)
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodPost:
+			var dto todo.CreateTodoDTO
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&dto))
+			w.Header().Set(todo.ConsistencyTokenHeader, "7")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(todo.TodoDTO{ID: "1", Title: dto.Title, ActiveAt: dto.ActiveAt, Status: todo.StatusTodo})
+		case r.Method == http.MethodGet:
+			// Reads wait for the client's own writes.
+			require.Equal(t, "7", r.Header.Get(todo.ConsistencyTokenHeader))
+			json.NewEncoder(w).Encode([]*todo.TodoDTO{{ID: "1", Title: "Купить книгу", Status: todo.StatusDone}})
+		case r.Method == http.MethodPut:
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Content-Type", todo.ProblemContentType)
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(todo.NewProblem(todo.ErrTodoNotFound, "todo-service", r.URL.Path))
+		}
+	}))
+	defer server.Close()
+
+	todoClient := New(server.URL+"/api/", "secret")
+
+	created, err := todoClient.Create("Купить книгу", "2023-08-05")
+	require.NoError(t, err)
+	require.Equal(t, "2023-08-05", created.ActiveAt)
+
+	todos, err := todoClient.List(ListOptions{Status: "done"})
+	require.NoError(t, err)
+	require.Len(t, todos, 1)
+
+	require.NoError(t, todoClient.Done("1"))
+	require.NoError(t, todoClient.Transition("1", todo.StatusInProgress))
+
+	err = todoClient.Delete("1")
+	var apiErr *Error
+	require.ErrorAs(t, err, &apiErr)
+	require.Equal(t, http.StatusNotFound, apiErr.Status)
+
+	require.Equal(t, []string{
+		"POST /api/v2/todo-list/tasks Bearer secret",
+		"GET /api/v2/todo-list/tasks?status=done Bearer secret",
+		"PUT /api/v2/todo-list/tasks/1/done Bearer secret",
+		"POST /api/v2/todo-list/tasks/1/transitions Bearer secret",
+		"DELETE /api/v2/todo-list/tasks/1 Bearer secret",
+	}, requests)
+}
+
+func TestClientLists(t *testing.T) {
+	listID := "64da1f106083a1acd4d8f111"
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.RequestURI())
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/move"):
+			var move todo.MoveTodoDTO
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&move))
+			require.Equal(t, listID, move.ListID.Hex())
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodPost:
+			var dto todo.CreateTodoListDTO
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&dto))
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(todo.TodoListDTO{ID: listID, Name: dto.Name})
+		default:
+			json.NewEncoder(w).Encode([]*todo.TodoDTO{{ID: "1", ListID: listID, Title: "Купить книгу", Status: todo.StatusTodo}})
+		}
+	}))
+	defer server.Close()
+
+	todoClient := New(server.URL+"/api", "")
+	list, err := todoClient.CreateList("Работа")
+	require.NoError(t, err)
+	require.Equal(t, "Работа", list.Name)
+	require.NoError(t, todoClient.MoveToList("1", list.ID))
+	require.Error(t, todoClient.MoveToList("1", "work"))
+
+	todos, err := todoClient.InList(list.ID).List(ListOptions{})
+	require.NoError(t, err)
+	require.Equal(t, listID, todos[0].ListID)
+
+	require.Equal(t, []string{
+		"POST /api/v2/todo-lists",
+		"POST /api/v2/todo-list/tasks/1/move",
+		"GET /api/v2/todo-lists/" + listID + "/tasks",
+	}, requests)
+}
@@ -0,0 +1,446 @@
+// Package config loads the service configuration from defaults, the environment, a YAML/TOML/dotenv file and
+// command-line flags, in increasing order of precedence. The file wins over the environment as it did when the
+// service only read dotenv files.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/go-playground/validator/v10"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// FileSuffix marks a variable holding the path of a file with the actual value, e.g. DB_PASSWORD_FILE.
+const FileSuffix = "_FILE"
+
+const redacted = "REDACTED"
+
+// Config is the whole service configuration. Settings with an env tag can also be set from the environment
+// and by flag; secret ones are masked by Redacted.
+type Config struct {
+	HTTP      HTTPConfig      `yaml:"http" toml:"http"`
+	GRPC      GRPCConfig      `yaml:"grpc" toml:"grpc"`
+	Mongo     MongoConfig     `yaml:"mongo" toml:"mongo"`
+	Log       LogConfig       `yaml:"log" toml:"log"`
+	Health    HealthConfig    `yaml:"health" toml:"health"`
+	Tracing   TracingConfig   `yaml:"tracing" toml:"tracing"`
+	Jobs      JobsConfig      `yaml:"jobs" toml:"jobs"`
+	ReadModel ReadModelConfig `yaml:"read_model" toml:"read_model"`
+	Calendar  CalendarConfig  `yaml:"calendar" toml:"calendar"`
+	Lifecycle LifecycleConfig `yaml:"lifecycle" toml:"lifecycle"`
+	Pipeline  PipelineConfig  `yaml:"pipeline" toml:"pipeline"`
+}
+
+type HTTPConfig struct {
+	Port      string `yaml:"port" toml:"port" env:"PORT" flag:"port" usage:"HTTP port" validate:"required,numeric"`
+	URLPrefix string `yaml:"url_prefix" toml:"url_prefix" env:"URL_PREFIX" flag:"url-prefix" usage:"Prefix of every route"`
+	// APIVersion is the version served on routes without a version segment.
+	APIVersion      string        `yaml:"api_version" toml:"api_version" env:"API_VERSION" flag:"api-version" usage:"API version of unversioned routes" validate:"oneof=v1 v2"`
+	Timeout         time.Duration `yaml:"timeout" toml:"timeout" env:"HTTP_TIMEOUT" flag:"http-timeout" usage:"Read and write timeout" validate:"gte=1s"`
+	GracefulTimeout time.Duration `yaml:"graceful_timeout" toml:"graceful_timeout" env:"HTTP_GRACEFUL_TIMEOUT" flag:"http-graceful-timeout" usage:"Time to finish requests on shutdown" validate:"gte=1s"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout" env:"HTTP_SHUTDOWN_TIMEOUT" flag:"http-shutdown-timeout" usage:"Time to stop workers and close connections on shutdown, after the requests" validate:"gte=1s"`
+	// Validation checks requests against the OpenAPI document; "all" checks responses too and is meant for development.
+	Validation string `yaml:"validation" toml:"validation" env:"HTTP_VALIDATION" flag:"http-validation" usage:"Check requests, or requests and responses, against the OpenAPI document: off, requests or all" validate:"oneof=off requests all"`
+}
+
+type GRPCConfig struct {
+	// Port is optional; the gRPC server is not started without it.
+	Port string `yaml:"port" toml:"port" env:"GRPC_PORT" flag:"grpc-port" usage:"gRPC port, disabled when empty" validate:"omitempty,numeric"`
+}
+
+type MongoConfig struct {
+	URI        string `yaml:"uri" toml:"uri" env:"DB_URI" flag:"db-uri" usage:"MongoDB connection string" validate:"required,uri"`
+	Database   string `yaml:"database" toml:"database" env:"DB_NAME" flag:"db-name" usage:"MongoDB database" validate:"required"`
+	Collection string `yaml:"collection" toml:"collection" env:"DB_COLLECTION" flag:"db-collection" usage:"Collection of tasks" validate:"required"`
+	Username   string `yaml:"username" toml:"username" env:"DB_USERNAME" flag:"db-username" usage:"MongoDB user, overrides the one in the URI"`
+	Password   string `yaml:"password" toml:"password" env:"DB_PASSWORD" flag:"db-password" usage:"MongoDB password, prefer DB_PASSWORD_FILE" secret:"true"`
+	// PasswordFile is the file counterpart of Password for config files.
+	PasswordFile string `yaml:"password_file" toml:"password_file"`
+	// Storage "events" records every change in an event log and keeps the collection of tasks as its projection.
+	Storage string `yaml:"storage" toml:"storage" env:"DB_STORAGE" flag:"db-storage" usage:"Task storage: documents or events" validate:"oneof=documents events"`
+}
+
+type LogConfig struct {
+	Level string `yaml:"level" toml:"level" env:"LOG_LEVEL" flag:"log-level" usage:"Log level" validate:"oneof=debug info warn error"`
+}
+
+type HealthConfig struct {
+	Timeout time.Duration `yaml:"timeout" toml:"timeout" env:"HEALTH_TIMEOUT" flag:"health-timeout" usage:"Timeout of each readiness check" validate:"gt=0"`
+	// StartupTimeout bounds the migrations run before the service reports ready.
+	StartupTimeout time.Duration `yaml:"startup_timeout" toml:"startup_timeout" env:"STARTUP_TIMEOUT" flag:"startup-timeout" usage:"Time allowed for startup migrations" validate:"gt=0"`
+}
+
+type TracingConfig struct {
+	Exporter string `yaml:"exporter" toml:"exporter" env:"TRACING_EXPORTER" flag:"tracing-exporter" usage:"Trace exporter: none, otlp or stdout" validate:"oneof=none otlp stdout"`
+	// Endpoint is the OTLP/HTTP collector URL; the exporter's OTEL_EXPORTER_OTLP_* variables apply when empty.
+	Endpoint string `yaml:"endpoint" toml:"endpoint" env:"TRACING_ENDPOINT" flag:"tracing-endpoint" usage:"OTLP/HTTP collector URL" validate:"omitempty,url"`
+	// File receives the spans of the stdout exporter instead of stdout.
+	File        string `yaml:"file" toml:"file" env:"TRACING_FILE" flag:"tracing-file" usage:"File for the stdout exporter"`
+	ServiceName string `yaml:"service_name" toml:"service_name" env:"TRACING_SERVICE_NAME" flag:"tracing-service-name" usage:"Service name reported with spans" validate:"required"`
+}
+
+type ReadModelConfig struct {
+	// Wait bounds how long a read with a consistency token waits for the read model to catch up.
+	Wait time.Duration `yaml:"wait" toml:"wait" env:"READ_MODEL_WAIT" flag:"read-model-wait" usage:"Longest wait of a read for its consistency token" validate:"gt=0"`
+}
+
+type JobsConfig struct {
+	Workers int `yaml:"workers" toml:"workers" env:"JOB_WORKERS" flag:"job-workers" usage:"Background jobs run at once" validate:"gte=1"`
+	// Lease is how long a running job stays claimed without a heartbeat before another instance may take it over.
+	Lease time.Duration `yaml:"lease" toml:"lease" env:"JOB_LEASE" flag:"job-lease" usage:"Time before a job of a stopped worker is recovered" validate:"gte=1s"`
+}
+
+type CalendarConfig struct {
+	// TimeZone decides which day is today for callers that name no zone of their own.
+	TimeZone string `yaml:"time_zone" toml:"time_zone" env:"TIME_ZONE" flag:"time-zone" usage:"IANA time zone of callers naming none" validate:"required,timezone"`
+	// Region picks the weekends and holidays of the business calendar; Saturday and Sunday are off without it.
+	Region string `yaml:"region" toml:"region" env:"CALENDAR_REGION" flag:"calendar-region" usage:"Region of the business calendar, e.g. KZ"`
+	// Dir holds calendar files to use instead of the bundled ones.
+	Dir string `yaml:"dir" toml:"dir" env:"CALENDAR_DIR" flag:"calendar-dir" usage:"Directory of calendar files, the bundled ones when empty"`
+}
+
+// LifecycleConfig is set in config files only.
+type LifecycleConfig struct {
+	// Transitions lists the statuses a task may move to from each status, the built-in table when empty.
+	Transitions map[string][]string `yaml:"transitions" toml:"transitions"`
+}
+
+// PipelineConfig picks the commands the middlewares of the command pipeline apply to, by command type name, e.g.
+// FindTodosCommand. It is set in config files only.
+type PipelineConfig struct {
+	// SlowCommand is the duration from which a command is logged as slow.
+	SlowCommand time.Duration `yaml:"slow_command" toml:"slow_command" validate:"gt=0"`
+	// Untimed commands, e.g. bulk ones, are slow by nature.
+	Untimed []string `yaml:"untimed" toml:"untimed"`
+	// Unaudited commands, e.g. queries, change nothing.
+	Unaudited []string `yaml:"unaudited" toml:"unaudited"`
+	// Forbidden commands are refused with ErrForbidden, e.g. the writes of a read-only instance. None by default.
+	Forbidden []string    `yaml:"forbidden" toml:"forbidden"`
+	Retry     RetryConfig `yaml:"retry" toml:"retry"`
+}
+
+// RetryConfig retries Commands on transient errors, so they have to be safe to run again.
+type RetryConfig struct {
+	Commands []string `yaml:"commands" toml:"commands"`
+	// Attempts counts the first run too.
+	Attempts int `yaml:"attempts" toml:"attempts" validate:"gte=1"`
+	// Backoff is the delay before the first retry; it doubles with every further one.
+	Backoff time.Duration `yaml:"backoff" toml:"backoff" validate:"gte=0"`
+}
+
+// Location loads TimeZone, which Validate has checked; UTC when it can't be loaded.
+func (cfg CalendarConfig) Location() *time.Location {
+	loc, err := time.LoadLocation(cfg.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+func Default() Config {
+	return Config{
+		HTTP: HTTPConfig{
+			Port:            "8080",
+			APIVersion:      "v1",
+			Timeout:         20 * time.Second,
+			GracefulTimeout: 21 * time.Second,
+			ShutdownTimeout: 20 * time.Second,
+			Validation:      "off",
+		},
+		Mongo: MongoConfig{
+			Collection: "todos",
+			Storage:    "documents",
+		},
+		Log: LogConfig{
+			Level: "debug",
+		},
+		Health: HealthConfig{
+			Timeout:        2 * time.Second,
+			StartupTimeout: 5 * time.Minute,
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			ServiceName: "service-todo",
+		},
+		Jobs: JobsConfig{
+			Workers: 2,
+			Lease:   time.Minute,
+		},
+		ReadModel: ReadModelConfig{
+			Wait: 5 * time.Second,
+		},
+		Calendar: CalendarConfig{
+			TimeZone: "UTC",
+		},
+		Pipeline: PipelineConfig{
+			SlowCommand: time.Second,
+			Untimed:     []string{"ImportTodosCommand", "RescheduleTodosCommand"},
+			Unaudited:   []string{"FindTodoCommand", "FindTodosCommand", "FindTodoListCommand", "FindTodoListsCommand"},
+			Retry: RetryConfig{
+				Commands: []string{"FindTodoCommand", "FindTodosCommand", "UpdateTodoCommand", "UpdateTodoStatusCommand", "FindTodoListCommand", "FindTodoListsCommand"},
+				Attempts: 3,
+				Backoff:  50 * time.Millisecond,
+			},
+		},
+	}
+}
+
+// Field describes one setting that can come from the environment or a flag.
+type Field struct {
+	Env    string
+	Flag   string
+	Usage  string
+	Secret bool
+	value  reflect.Value
+}
+
+// Fields lists the settings of cfg; set values through the returned fields change cfg.
+func Fields(cfg *Config) []Field {
+	var fields []Field
+	var walk func(value reflect.Value)
+	walk = func(value reflect.Value) {
+		for i := 0; i < value.NumField(); i++ {
+			structField := value.Type().Field(i)
+			if structField.Type.Kind() == reflect.Struct && structField.Type != reflect.TypeOf(time.Duration(0)) {
+				walk(value.Field(i))
+				continue
+			}
+			if structField.Tag.Get("env") == "" {
+				continue
+			}
+			fields = append(fields, Field{
+				Env:    structField.Tag.Get("env"),
+				Flag:   structField.Tag.Get("flag"),
+				Usage:  structField.Tag.Get("usage"),
+				Secret: structField.Tag.Get("secret") == "true",
+				value:  value.Field(i),
+			})
+		}
+	}
+	walk(reflect.ValueOf(cfg).Elem())
+	return fields
+}
+
+func (field Field) Set(raw string) error {
+	switch field.value.Interface().(type) {
+	case time.Duration:
+		duration, err := time.ParseDuration(raw)
+		if err != nil {
+			// Bare numbers are seconds, as the old configuration expected.
+			seconds, convErr := strconv.Atoi(raw)
+			if convErr != nil {
+				return fmt.Errorf("%s: %w", field.Env, err)
+			}
+			duration = time.Duration(seconds) * time.Second
+		}
+		field.value.SetInt(int64(duration))
+	case int:
+		number, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("%s: %w", field.Env, err)
+		}
+		field.value.SetInt(int64(number))
+	default:
+		field.value.SetString(raw)
+	}
+	return nil
+}
+
+// Load applies environ (KEY=VALUE pairs), file and flags (by flag name) over the defaults and validates the result.
+// A .yaml, .yml or .toml file is decoded by its format, anything else is read as dotenv.
+func Load(file string, environ []string, flags map[string]string) (*Config, error) {
+	cfg := Default()
+	fields := Fields(&cfg)
+
+	env := make(map[string]string, len(environ))
+	for _, pair := range environ {
+		if key, value, found := strings.Cut(pair, "="); found {
+			env[key] = value
+		}
+	}
+	if err := applyEnv(fields, env); err != nil {
+		return nil, err
+	}
+	if file != "" {
+		if err := loadFile(&cfg, fields, file); err != nil {
+			return nil, fmt.Errorf("config file %s: %w", file, err)
+		}
+	}
+	for _, field := range fields {
+		if value, found := flags[field.Flag]; found {
+			if err := field.Set(value); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if cfg.Mongo.Password == "" && cfg.Mongo.PasswordFile != "" {
+		secret, err := readSecret(cfg.Mongo.PasswordFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Mongo.Password = secret
+	}
+	if err := Validate(&cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+func loadFile(cfg *Config, fields []Field, file string) error {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		return yaml.Unmarshal(data, cfg)
+	case ".toml":
+		meta, err := toml.DecodeFile(file, cfg)
+		if err != nil {
+			return err
+		}
+		return checkTOMLDurations(cfg, meta)
+	default:
+		env, err := godotenv.Read(file)
+		if err != nil {
+			return err
+		}
+		return applyEnv(fields, env)
+	}
+}
+
+// checkTOMLDurations rejects durations given as bare integers, which TOML reads as nanoseconds while the environment
+// and flags read them as seconds. YAML rejects them by itself.
+func checkTOMLDurations(cfg *Config, meta toml.MetaData) error {
+	for _, key := range meta.Keys() {
+		if meta.Type(key...) != "Integer" {
+			continue
+		}
+		field, found := tomlField(reflect.ValueOf(cfg).Elem(), key)
+		if found && field.Type() == reflect.TypeOf(time.Duration(0)) {
+			return fmt.Errorf("%s: duration %d needs a unit, e.g. \"%ds\"", key, field.Int(), field.Int())
+		}
+	}
+	return nil
+}
+
+// tomlField finds the struct field of value that key was decoded into.
+func tomlField(value reflect.Value, key toml.Key) (reflect.Value, bool) {
+	for _, name := range key {
+		if value.Kind() != reflect.Struct {
+			return reflect.Value{}, false
+		}
+		structValue := value
+		value = reflect.Value{}
+		for i := 0; i < structValue.NumField(); i++ {
+			if tag, _, _ := strings.Cut(structValue.Type().Field(i).Tag.Get("toml"), ","); tag == name {
+				value = structValue.Field(i)
+				break
+			}
+		}
+		if !value.IsValid() {
+			return reflect.Value{}, false
+		}
+	}
+	return value, true
+}
+
+// applyEnv sets every field found in env, reading KEY_FILE when KEY itself is absent.
+func applyEnv(fields []Field, env map[string]string) error {
+	for _, field := range fields {
+		value, found := env[field.Env]
+		if !found {
+			path, isFile := env[field.Env+FileSuffix]
+			if !isFile {
+				continue
+			}
+			secret, err := readSecret(path)
+			if err != nil {
+				return fmt.Errorf("%s%s: %w", field.Env, FileSuffix, err)
+			}
+			value = secret
+		}
+		if err := field.Set(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readSecret(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+func Validate(cfg *Config) error {
+	validate := validator.New()
+	// Report settings by the variable operators set.
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		if env := field.Tag.Get("env"); env != "" {
+			return env
+		}
+		return field.Name
+	})
+	err := validate.Struct(cfg)
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return err
+	}
+	messages := make([]string, 0, len(validationErrors))
+	for _, fieldErr := range validationErrors {
+		message := fieldErr.Field() + " failed " + fieldErr.Tag()
+		if fieldErr.Param() != "" {
+			message += "=" + fieldErr.Param()
+		}
+		messages = append(messages, message)
+	}
+	return errors.New("invalid config: " + strings.Join(messages, ", "))
+}
+
+// Redacted returns a copy safe to print: secrets and the password of the Mongo URI are masked. Of a URI that doesn't
+// parse the whole user info is masked.
+func (cfg Config) Redacted() Config {
+	for _, field := range Fields(&cfg) {
+		if field.Secret && field.value.String() != "" {
+			field.value.SetString(redacted)
+		}
+	}
+	uri, err := url.Parse(cfg.Mongo.URI)
+	switch {
+	case err != nil:
+		cfg.Mongo.URI = redactUserInfo(cfg.Mongo.URI)
+	case uri.User != nil:
+		if _, hasPassword := uri.User.Password(); hasPassword {
+			uri.User = url.UserPassword(uri.User.Username(), redacted)
+			cfg.Mongo.URI = uri.String()
+		}
+	}
+	return cfg
+}
+
+// redactUserInfo masks everything between the scheme and the last @ of uri, which a password may contain too.
+func redactUserInfo(uri string) string {
+	at := strings.LastIndex(uri, "@")
+	if at < 0 {
+		return uri
+	}
+	start := 0
+	if scheme := strings.Index(uri, "://"); scheme >= 0 && scheme < at {
+		start = scheme + len("://")
+	}
+	return uri[:start] + redacted + uri[at:]
+}
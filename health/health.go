@@ -0,0 +1,152 @@
+// Package health serves liveness and readiness probes for orchestrators.
+package health
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	httpLib "github.com/kas2000/http"
+)
+
+const (
+	StateStarting = "starting"
+	StateReady    = "ready"
+	StateStopping = "stopping"
+
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// Check reports whether a dependency is usable; it must respect ctx.
+type Check func(ctx context.Context) error
+
+type CheckResult struct {
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+type Report struct {
+	State string `json:"state"`
+	// Step is the shutdown step in progress while stopping.
+	Step   string                 `json:"step,omitempty"`
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// Health is ready only between SetReady and SetStopping and while every check passes.
+type Health struct {
+	timeout time.Duration
+
+	mu     sync.RWMutex
+	state  string
+	step   string
+	checks map[string]Check
+}
+
+func New(timeout time.Duration) *Health {
+	return &Health{
+		timeout: timeout,
+		state:   StateStarting,
+		checks:  make(map[string]Check),
+	}
+}
+
+func (health *Health) AddCheck(name string, check Check) {
+	health.mu.Lock()
+	defer health.mu.Unlock()
+	health.checks[name] = check
+}
+
+// SetReady marks the end of startup, e.g. after migrations.
+func (health *Health) SetReady() {
+	health.setState(StateReady)
+}
+
+// SetStopping fails readiness for the rest of the process so that no new traffic arrives during shutdown.
+func (health *Health) SetStopping() {
+	health.setState(StateStopping)
+}
+
+// SetShutdownStep fails readiness like SetStopping and reports step as the shutdown progresses.
+func (health *Health) SetShutdownStep(step string) {
+	health.mu.Lock()
+	defer health.mu.Unlock()
+	health.state = StateStopping
+	health.step = step
+}
+
+func (health *Health) setState(state string) {
+	health.mu.Lock()
+	defer health.mu.Unlock()
+	if health.state != StateStopping {
+		health.state = state
+	}
+}
+
+// Check runs every check in parallel, each bounded by the timeout.
+func (health *Health) Check(ctx context.Context) Report {
+	health.mu.RLock()
+	report := Report{State: health.state, Step: health.step, Status: StatusUp, Checks: make(map[string]CheckResult, len(health.checks))}
+	names := make([]string, 0, len(health.checks))
+	for name := range health.checks {
+		names = append(names, name)
+	}
+	checks := health.checks
+	health.mu.RUnlock()
+	sort.Strings(names)
+
+	results := make([]CheckResult, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, health.timeout)
+			defer cancel()
+			started := time.Now()
+			err := check(checkCtx)
+			results[i] = CheckResult{Status: StatusUp, DurationMs: time.Since(started).Milliseconds()}
+			if err != nil {
+				results[i].Status = StatusDown
+				results[i].Error = err.Error()
+			}
+		}(i, checks[name])
+	}
+	wg.Wait()
+
+	for i, name := range names {
+		report.Checks[name] = results[i]
+		if results[i].Status != StatusUp {
+			report.Status = StatusDown
+		}
+	}
+	if report.State != StateReady {
+		report.Status = StatusDown
+	}
+	return report
+}
+
+// Liveness answers as long as the process serves requests; dependencies don't affect it.
+func (health *Health) Liveness() httpLib.Endpoint {
+	return func(w http.ResponseWriter, r *http.Request) httpLib.Response {
+		health.mu.RLock()
+		report := Report{State: health.state, Step: health.step, Status: StatusUp}
+		health.mu.RUnlock()
+		return httpLib.NewResponse(http.StatusOK, report, nil)
+	}
+}
+
+func (health *Health) Readiness() httpLib.Endpoint {
+	return func(w http.ResponseWriter, r *http.Request) httpLib.Response {
+		report := health.Check(r.Context())
+		status := http.StatusOK
+		if report.Status != StatusUp {
+			status = http.StatusServiceUnavailable
+		}
+		return httpLib.NewResponse(status, report, map[string]string{"Cache-Control": "no-store"})
+	}
+}
@@ -0,0 +1,64 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadiness(t *testing.T) {
+	health := New(50 * time.Millisecond)
+	var mongoErr error
+	health.AddCheck("mongo", func(ctx context.Context) error {
+		return mongoErr
+	})
+	health.AddCheck("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	readiness := func() (int, Report) {
+		req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+		resp := health.Readiness()(httptest.NewRecorder(), req)
+		return resp.StatusCode(), resp.Response().(Report)
+	}
+
+	status, report := readiness()
+	require.Equal(t, http.StatusServiceUnavailable, status, "не готов до окончания запуска")
+	require.Equal(t, StateStarting, report.State)
+	require.Equal(t, StatusDown, report.Checks["slow"].Status)
+	require.Equal(t, context.DeadlineExceeded.Error(), report.Checks["slow"].Error)
+
+	health.AddCheck("slow", func(ctx context.Context) error { return nil })
+	health.SetReady()
+	status, report = readiness()
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, StatusUp, report.Status)
+	require.Equal(t, StatusUp, report.Checks["mongo"].Status)
+
+	mongoErr = errors.New("server selection timeout")
+	status, report = readiness()
+	require.Equal(t, http.StatusServiceUnavailable, status, "зависимость недоступна")
+	require.Equal(t, CheckResult{Status: StatusDown, Error: "server selection timeout"}, report.Checks["mongo"])
+
+	mongoErr = nil
+	health.SetStopping()
+	health.SetReady()
+	status, report = readiness()
+	require.Equal(t, http.StatusServiceUnavailable, status, "остановка необратима")
+	require.Equal(t, StateStopping, report.State)
+
+	health.SetShutdownStep("http")
+	status, report = readiness()
+	require.Equal(t, http.StatusServiceUnavailable, status)
+	require.Equal(t, "http", report.Step)
+
+	resp := health.Liveness()(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	require.Equal(t, http.StatusOK, resp.StatusCode())
+	require.Equal(t, Report{State: StateStopping, Step: "http", Status: StatusUp}, resp.Response())
+}
@@ -0,0 +1,66 @@
+// Package httpstatus finds out the status of responses, including those of endpoints that write them directly.
+package httpstatus
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+
+	httpLib "github.com/kas2000/http"
+)
+
+// Recorder keeps the status of endpoints that write directly, and still lets them flush and hijack.
+type Recorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func NewRecorder(w http.ResponseWriter) *Recorder {
+	return &Recorder{ResponseWriter: w}
+}
+
+// Of returns the status of response or, for streaming endpoints that return nil, the one they wrote.
+func (recorder *Recorder) Of(response httpLib.Response) int {
+	switch {
+	case response != nil:
+		return response.StatusCode()
+	case recorder.status != 0:
+		return recorder.status
+	default:
+		return http.StatusOK
+	}
+}
+
+func (recorder *Recorder) WriteHeader(status int) {
+	if recorder.status == 0 {
+		recorder.status = status
+	}
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func (recorder *Recorder) Write(data []byte) (int, error) {
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+	return recorder.ResponseWriter.Write(data)
+}
+
+func (recorder *Recorder) Flush() {
+	if flusher, ok := recorder.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (recorder *Recorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := recorder.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer doesn't support hijacking")
+	}
+	recorder.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+func (recorder *Recorder) Unwrap() http.ResponseWriter {
+	return recorder.ResponseWriter
+}
@@ -2,125 +2,349 @@ package main
 
 import (
 	"context"
-	"errors"
+	"fmt"
 	"github.com/go-playground/validator/v10"
-	"github.com/joho/godotenv"
 	command "github.com/kas2000/commandlib"
 	httpLib "github.com/kas2000/http"
 	"github.com/kas2000/logger"
+	"github.com/kas2000/service-todo/calendar"
+	"github.com/kas2000/service-todo/health"
+	"github.com/kas2000/service-todo/metrics"
+	"github.com/kas2000/service-todo/requestlog"
+	"github.com/kas2000/service-todo/shutdown"
+	"github.com/kas2000/service-todo/config"
 	"github.com/kas2000/service-todo/todo"
+	"github.com/kas2000/service-todo/todopb"
+	"github.com/kas2000/service-todo/tracing"
 	"github.com/urfave/cli/v2"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"net"
 	"os"
+	"slices"
+	"sync"
 	"time"
+	// Zone names have to load in images without a zoneinfo database.
+	_ "time/tzdata"
 )
 
 var (
-	port      = ""
-	dbUri     = ""
-	dbName    = ""
-	env       = ""
-	urlPrefix = ""
+	env = ""
 
-	flags = []cli.Flag{
+	flags = append([]cli.Flag{
 		&cli.StringFlag{
 			Name:        "config",
 			Aliases:     []string{"c"},
-			Usage:       "Load configuration from `FILE`",
-			Required:    true,
+			Usage:       "Load configuration from a YAML, TOML or dotenv `FILE`",
 			Destination: &env,
 		},
-	}
+	}, configFlags()...)
 )
 
-func parseEnv() error {
-	err := godotenv.Overload(env)
-	if err != nil {
-		return err
-	}
-	port = os.Getenv("PORT")
-	if port == "" {
-		return errors.New("invalid port")
+// configFlags exposes every setting of config.Config as a flag.
+func configFlags() []cli.Flag {
+	var configFlags []cli.Flag
+	for _, field := range config.Fields(&config.Config{}) {
+		configFlags = append(configFlags, &cli.StringFlag{
+			Name:  field.Flag,
+			Usage: field.Usage + " [$" + field.Env + "]",
+		})
 	}
-	dbUri = os.Getenv("DB_URI")
-	if dbUri == "" {
-		return errors.New("invalid db uri")
-	}
-	dbName = os.Getenv("DB_NAME")
-	if dbName == "" {
-		return errors.New("invalid db name")
+	return configFlags
+}
+
+// loadConfig layers the environment, the config file and the flags set on the command line.
+func loadConfig(c *cli.Context) (*config.Config, error) {
+	setFlags := make(map[string]string)
+	for _, field := range config.Fields(&config.Config{}) {
+		if c.IsSet(field.Flag) {
+			setFlags[field.Flag] = c.String(field.Flag)
+		}
 	}
+	return config.Load(env, os.Environ(), setFlags)
+}
 
-	urlPrefix = os.Getenv("URL_PREFIX")
+// newTodoRepo returns the configured task storage; with events the collection of tasks is their projection.
+func newTodoRepo(mongoDB *mongo.Database, cfg config.MongoConfig) todo.TodoRepository {
+	todoRepo := todo.NewTodoRepo(mongoDB, cfg.Collection)
+	if cfg.Storage == "events" {
+		return todo.NewEventSourcedRepo(todoRepo, todo.NewEventStore(mongoDB))
+	}
+	return todoRepo
+}
 
-	return nil
+// connectMongo opens the configured database and traces its commands. The driver connects lazily, so this doesn't
+// wait for the server.
+func connectMongo(cfg config.MongoConfig) (*mongo.Client, *mongo.Database, error) {
+	clientOptions := options.Client().ApplyURI(cfg.URI).SetMonitor(otelmongo.NewMonitor())
+	if cfg.Username != "" || cfg.Password != "" {
+		credential := options.Credential{}
+		if clientOptions.Auth != nil {
+			credential = *clientOptions.Auth
+		}
+		if cfg.Username != "" {
+			credential.Username = cfg.Username
+		}
+		if cfg.Password != "" {
+			credential.Password = cfg.Password
+			credential.PasswordSet = true
+		}
+		clientOptions.SetAuth(credential)
+	}
+	mongoClient, err := mongo.Connect(context.TODO(), clientOptions)
+	if err != nil {
+		return nil, nil, err
+	}
+	return mongoClient, mongoClient.Database(cfg.Database), nil
 }
 
 func main() {
 	app := &cli.App{
 		Name:      "Region Test Case",
 		Usage:     "service-todo",
-		UsageText: "go run main.go/service-todo --config FILE",
+		UsageText: "service-todo [--config FILE] [flags] [command]",
 		Flags:     flags,
 		Action:    run,
+		Commands:  append([]*cli.Command{todoCommand, configCommand}, adminCommands...),
 	}
 
 	if err := app.Run(os.Args); err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 }
 
-func run(*cli.Context) error {
-	log, _ := logger.New("debug")
+func run(c *cli.Context) error {
+	cfg, err := loadConfig(c)
+	if err != nil {
+		return err
+	}
+	log, err := logger.New(cfg.Log.Level)
+	if err != nil {
+		return err
+	}
 	validate := validator.New()
+	businessCalendar, err := loadCalendar(cfg.Calendar)
+	if err != nil {
+		return fmt.Errorf("couldn't load calendar: %w", err)
+	}
+	transitions, err := todo.NewTransitions(cfg.Lifecycle.Transitions)
+	if err != nil {
+		return fmt.Errorf("couldn't load lifecycle: %w", err)
+	}
+	// Everything started below is stopped by the shutdown at the end, in reverse order of dependence.
+	signalled, stopSignals := shutdown.SignalContext(c.Context)
+	defer stopSignals()
 
-	if err := parseEnv(); err != nil {
-		log.Fatal("Error parsing .env file: " + err.Error())
+	shutdownTracing, err := tracing.Setup(c.Context, cfg.Tracing)
+	if err != nil {
+		return err
 	}
 
-	mongoClient, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(dbUri))
+	mongoClient, mongoDB, err := connectMongo(cfg.Mongo)
 	if err != nil {
 		log.Fatal("couldn't connect to mongodb: " + err.Error())
 	}
-	defer func() {
-		if err := mongoClient.Disconnect(context.TODO()); err != nil {
-			log.Fatal(err.Error())
-		}
-	}()
-	mongoDB := mongoClient.Database(dbName)
-	collNames, _ := mongoDB.ListCollectionNames(context.TODO(), bson.M{})
-	collectionsNames := make(map[string]int)
-	for _, collName := range collNames {
-		collectionsNames[collName]++
-	}
 
 	serverConfig := httpLib.Config{
 		IsGatewayServer: false,
 		PublicKey:       nil,
-		Port:            port,
-		ShutdownTimeout: time.Second * 20,
-		GracefulTimeout: time.Second * 21,
-		ApiVersion:      "v1",
-		Timeout:         time.Second * 20,
+		Port:            cfg.HTTP.Port,
+		ShutdownTimeout: seconds(cfg.HTTP.ShutdownTimeout),
+		GracefulTimeout: seconds(cfg.HTTP.GracefulTimeout),
+		ApiVersion:      cfg.HTTP.APIVersion,
+		Timeout:         seconds(cfg.HTTP.Timeout),
 		Logger:          log,
 	}
-	server := httpLib.NewServer(serverConfig)
-	if err != nil {
-		log.Fatal("couldn't instantiate server: " + err.Error())
+	appMetrics := metrics.New()
+	httpServer := shutdown.NewServer(serverConfig)
+	// The last decorator runs innermost: requests are measured, traced, then logged with their trace ID.
+	server := requestlog.NewServer(tracing.NewServer(metrics.NewServer(httpServer, appMetrics)), log)
+	server.Handle("GET", "/metrics", appMetrics.Endpoint())
+
+	todoRepo := newTodoRepo(mongoDB, cfg.Mongo)
+	probes := health.New(cfg.Health.Timeout)
+	probes.AddCheck("mongo", todoRepo.Ping)
+	server.Handle("GET", "/healthz", probes.Liveness())
+	server.Handle("GET", "/readyz", probes.Readiness())
+
+	if err := appMetrics.Register(metrics.NewTaskCollector(todoRepo, cfg.Health.Timeout)); err != nil {
+		log.Fatal("couldn't register task metrics: " + err.Error())
 	}
 
-	todoRepo, err := todo.NewTodoRepo(collectionsNames, mongoDB)
+	changeLog := todo.NewChangeLog(mongoDB)
+	todoRepo = todo.NewChangeLoggingRepo(metrics.NewRepository(todoRepo, appMetrics), changeLog, log)
+	// Queries are served from the read model, which the projector keeps up to date from the change log.
+	views := todo.NewTodoViews(mongoDB, businessCalendar)
+	projector := todo.NewTodoProjector(changeLog, views, todoRepo, log)
+	service := tracing.NewService(todo.NewCalendarService(
+		todo.NewReadModelService(todo.NewServiceWithTransitions(todoRepo, todo.NewTodoListRepo(mongoDB), log, transitions), views, projector, cfg.ReadModel.Wait), businessCalendar))
+	// Shutdown waits for the commands in flight, from any transport or job.
+	drain := todo.NewCommandDrain()
+	pipeline := cfg.Pipeline
+	if err := todo.CheckCommandNames(slices.Concat(pipeline.Untimed, pipeline.Unaudited, pipeline.Forbidden, pipeline.Retry.Commands)...); err != nil {
+		log.Fatal("invalid command pipeline: " + err.Error())
+	}
+	todoCh := todo.NewCommandPipeline(command.NewCommandHandler(service),
+		todo.DrainCommands(drain),
+		tracing.NewCommandHandler,
+		func(next command.CommandHandler) command.CommandHandler { return metrics.NewCommandHandler(next, appMetrics) },
+		todo.LogCommands(log),
+		todo.ExceptCommands(todo.TimeCommands(log, pipeline.SlowCommand), pipeline.Untimed...),
+		todo.RecoverCommands(log),
+		todo.ForCommands(todo.AuthorizeCommands(todo.DenyAll), pipeline.Forbidden...),
+		todo.ExceptCommands(todo.AuditCommands(todo.NewLogAuditor(log)), pipeline.Unaudited...),
+		todo.ValidateCommands(validate),
+		todo.ForCommands(todo.RetryCommands(todo.RetryPolicy{Attempts: pipeline.Retry.Attempts, Backoff: pipeline.Retry.Backoff}),
+			pipeline.Retry.Commands...),
+	)
+	jobs := todo.NewJobQueue(todo.NewJobStore(mongoDB), todoCh, log, "todo-service", cfg.Jobs.Workers, cfg.Jobs.Lease)
+	zones := todo.TimeZones{Default: cfg.Calendar.Location(), Profile: todo.TokenTimeZone}
+	todoHttp := todo.NewTodoHttp(log, todoCh, validate, "todo-service").WithAPIVersion(cfg.HTTP.APIVersion).WithJobs(jobs).WithTimeZones(zones)
+	todoGraphQL, err := todo.NewTodoGraphQL(log, todoCh, validate, "todo-service")
 	if err != nil {
-		log.Fatal("couldn't initialize maintenance repository: " + err.Error())
+		log.Fatal("couldn't build graphql schema: " + err.Error())
+	}
+	todoStream := todo.NewTodoStream(log, changeLog, "todo-service").WithCalendar(businessCalendar)
+	todoController := todo.NewTodoController(&server, todoHttp, todoGraphQL, todoStream, cfg.HTTP.URLPrefix).WithValidation(cfg.HTTP.Validation)
+	if err := todoController.Bind(); err != nil {
+		log.Fatal("couldn't describe http routes: " + err.Error())
+	}
+
+	var grpcServer *grpc.Server
+	if cfg.GRPC.Port != "" {
+		listener, err := net.Listen("tcp", "0.0.0.0:"+cfg.GRPC.Port)
+		if err != nil {
+			log.Fatal("couldn't listen on grpc port: " + err.Error())
+		}
+		todoGrpc := todo.NewTodoGrpc(log, todoCh, validate, "todo-service").WithTimeZones(zones)
+		// As over HTTP: the span comes first, then metrics, the request ID and log, and recovery closest to the handler.
+		grpcServer = grpc.NewServer(
+			grpc.StatsHandler(otelgrpc.NewServerHandler()),
+			grpc.ChainUnaryInterceptor(
+				metrics.UnaryServerInterceptor(appMetrics),
+				requestlog.UnaryServerInterceptor(log),
+				todoGrpc.UnaryRecovery(),
+			),
+			grpc.ChainStreamInterceptor(
+				metrics.StreamServerInterceptor(appMetrics),
+				requestlog.StreamServerInterceptor(log),
+				todoGrpc.StreamRecovery(),
+			),
+		)
+		todopb.RegisterTodoServiceServer(grpcServer, todoGrpc)
+		go func() {
+			log.Info("gRPC server started on port: " + cfg.GRPC.Port)
+			if err := grpcServer.Serve(listener); err != nil {
+				log.Fatal("grpc server stopped: " + err.Error())
+			}
+		}()
+	}
+
+	// Jobs and the projector stop with the service; interrupted jobs are requeued or failed for the next start.
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	var workers sync.WaitGroup
+
+	// Routes are all registered before serving; readiness stays down until the migrations are done.
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		ctx, cancel := context.WithTimeout(jobsCtx, cfg.Health.StartupTimeout)
+		defer cancel()
+		if err := todo.Migrate(ctx, mongoDB, cfg.Mongo.Collection); err != nil {
+			if jobsCtx.Err() != nil {
+				return
+			}
+			log.Fatal("couldn't migrate database: " + err.Error())
+		}
+		// Listings are empty until the read model is first built, so readiness waits for it.
+		if _, err := projector.Build(ctx); err != nil {
+			if jobsCtx.Err() != nil {
+				return
+			}
+			log.Fatal("couldn't build the read model: " + err.Error())
+		}
+		probes.SetReady()
+		log.Info("service is ready")
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			projector.Run(jobsCtx)
+		}()
+		jobs.Run(jobsCtx)
+	}()
+
+	go server.ListenAndServe()
+	<-signalled.Done()
+
+	// Readiness fails from the first step on. Streams never end by themselves, so they are closed before the
+	// HTTP server waits for the requests in progress.
+	stopping := shutdown.New(log, probes.SetShutdownStep)
+	stopping.Add("streams", 0, func(ctx context.Context) error {
+		todoStream.Close()
+		return nil
+	})
+	stopping.Add("http", cfg.HTTP.GracefulTimeout, httpServer.Shutdown)
+	if grpcServer != nil {
+		stopping.Add("grpc", cfg.HTTP.GracefulTimeout, stopGrpc(grpcServer))
+	}
+	stopping.Add("workers", 0, func(ctx context.Context) error {
+		stopJobs()
+		return shutdown.Wait(waitDone(&workers))(ctx)
+	})
+	stopping.Add("commands", 0, drain.Close)
+	stopping.Add("traces", 0, shutdownTracing)
+	stopping.Add("mongo", 0, mongoClient.Disconnect)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.HTTP.GracefulTimeout+cfg.HTTP.ShutdownTimeout)
+	defer cancel()
+	err = stopping.Run(ctx)
+	// Syncing fails on terminals and pipes, which have nothing to flush.
+	if syncer, ok := log.(interface{ Sync() error }); ok {
+		syncer.Sync()
+	}
+	return err
+}
+
+// stopGrpc stops grpcServer after the calls in progress, or at once when ctx is done first.
+func stopGrpc(grpcServer *grpc.Server) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		if err := shutdown.Wait(stopped)(ctx); err != nil {
+			grpcServer.Stop()
+			return err
+		}
+		return nil
 	}
-	service := todo.NewService(todoRepo, log)
-	todoCh := command.NewCommandHandler(service)
-	todoHttp := todo.NewTodoHttp(log, todoCh, validate, "todo-service")
-	todoController := todo.NewTodoController(&server, todoHttp, urlPrefix)
-	todoController.Bind()
+}
+
+// loadCalendar reads the calendar of the configured region from the bundled files or from cfg.Dir.
+func loadCalendar(cfg config.CalendarConfig) (*calendar.Calendar, error) {
+	files := calendar.Regions()
+	if cfg.Dir != "" {
+		files = os.DirFS(cfg.Dir)
+	}
+	return calendar.Load(files, cfg.Region)
+}
+
+// waitDone returns a channel closed when wg is done.
+func waitDone(wg *sync.WaitGroup) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	return done
+}
 
-	server.ListenAndServe()
-	return nil
+// seconds converts d for httpLib.Config, whose durations are counted in seconds.
+func seconds(d time.Duration) time.Duration {
+	return d / time.Second
 }
@@ -0,0 +1,30 @@
+package metrics
+
+import (
+	"time"
+
+	command "github.com/kas2000/commandlib"
+	"github.com/kas2000/service-todo/todo"
+)
+
+type commandHandler struct {
+	next    command.CommandHandler
+	metrics *Metrics
+}
+
+// NewCommandHandler counts and times commands by their type name, e.g. CreateTodoCommand.
+func NewCommandHandler(next command.CommandHandler, metrics *Metrics) command.CommandHandler {
+	return &commandHandler{next: next, metrics: metrics}
+}
+
+func (handler *commandHandler) ExecuteCommand(cmd command.Command) (interface{}, error) {
+	name := todo.CommandName(cmd)
+	started := time.Now()
+	result, err := handler.next.ExecuteCommand(cmd)
+	handler.metrics.commandDuration.WithLabelValues(name).Observe(time.Since(started).Seconds())
+	handler.metrics.commands.WithLabelValues(name).Inc()
+	if err != nil {
+		handler.metrics.commandErrors.WithLabelValues(name, errorLabel(err)).Inc()
+	}
+	return result, err
+}
@@ -0,0 +1,31 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor records every gRPC call under its full method name and status code.
+func UnaryServerInterceptor(metrics *Metrics) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		started := time.Now()
+		resp, err := handler(ctx, req)
+		metrics.grpcRequests.WithLabelValues(info.FullMethod, status.Code(err).String()).Inc()
+		metrics.grpcDuration.WithLabelValues(info.FullMethod).Observe(time.Since(started).Seconds())
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streaming calls, timed until the stream ends.
+func StreamServerInterceptor(metrics *Metrics) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		started := time.Now()
+		err := handler(srv, ss)
+		metrics.grpcRequests.WithLabelValues(info.FullMethod, status.Code(err).String()).Inc()
+		metrics.grpcDuration.WithLabelValues(info.FullMethod).Observe(time.Since(started).Seconds())
+		return err
+	}
+}
@@ -0,0 +1,123 @@
+// Package metrics exposes Prometheus metrics of the HTTP routes, gRPC methods, commands, repository and tasks.
+package metrics
+
+import (
+	"net/http"
+
+	httpLib "github.com/kas2000/http"
+	"github.com/kas2000/service-todo/todo"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "todo"
+
+type Metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests *prometheus.CounterVec
+	httpDuration *prometheus.HistogramVec
+
+	grpcRequests *prometheus.CounterVec
+	grpcDuration *prometheus.HistogramVec
+
+	commands        *prometheus.CounterVec
+	commandErrors   *prometheus.CounterVec
+	commandDuration *prometheus.HistogramVec
+
+	repositoryDuration *prometheus.HistogramVec
+	repositoryErrors   *prometheus.CounterVec
+}
+
+func New() *Metrics {
+	metrics := &Metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by route template and status code.",
+		}, []string{"method", "route", "code"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by route template.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		grpcRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "grpc_requests_total",
+			Help:      "gRPC calls by method and status code.",
+		}, []string{"method", "code"}),
+		grpcDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "grpc_request_duration_seconds",
+			Help:      "gRPC call latency by method.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method"}),
+		commands: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "commands_total",
+			Help:      "Executed commands by type.",
+		}, []string{"command"}),
+		commandErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "command_errors_total",
+			Help:      "Failed commands by type and error.",
+		}, []string{"command", "error"}),
+		commandDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "command_duration_seconds",
+			Help:      "Command execution latency by type.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"command"}),
+		repositoryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "repository_operation_duration_seconds",
+			Help:      "TodoRepository operation latency.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"operation"}),
+		repositoryErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "repository_operation_errors_total",
+			Help:      "Failed TodoRepository operations by error.",
+		}, []string{"operation", "error"}),
+	}
+	metrics.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		metrics.httpRequests,
+		metrics.httpDuration,
+		metrics.grpcRequests,
+		metrics.grpcDuration,
+		metrics.commands,
+		metrics.commandErrors,
+		metrics.commandDuration,
+		metrics.repositoryDuration,
+		metrics.repositoryErrors,
+	)
+	return metrics
+}
+
+// Register adds a collector, such as NewTaskCollector, to the exposed metrics.
+func (metrics *Metrics) Register(collector prometheus.Collector) error {
+	return metrics.registry.Register(collector)
+}
+
+// Endpoint serves the metrics in the Prometheus text format. It writes the response itself.
+func (metrics *Metrics) Endpoint() httpLib.Endpoint {
+	handler := promhttp.HandlerFor(metrics.registry, promhttp.HandlerOpts{
+		// A failing task count must not hide the other metrics.
+		ErrorHandling: promhttp.ContinueOnError,
+	})
+	return func(w http.ResponseWriter, r *http.Request) httpLib.Response {
+		handler.ServeHTTP(w, r)
+		return nil
+	}
+}
+
+// errorLabel keeps the label cardinality bounded by the error registry; unregistered errors are internal.
+func errorLabel(err error) string {
+	def, _ := todo.LookupError(err)
+	return def.Slug
+}
@@ -0,0 +1,115 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	command "github.com/kas2000/commandlib"
+	httpLib "github.com/kas2000/http"
+	"github.com/kas2000/service-todo/todo"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// routeServer keeps the wrapped endpoints by "METHOD path" instead of serving them.
+type routeServer map[string]httpLib.Endpoint
+
+func (routes routeServer) ListenAndServe() {}
+
+func (routes routeServer) Handle(method string, path string, final httpLib.Endpoint) {
+	routes[method+" "+path] = final
+}
+
+type stubRepository struct {
+	todo.TodoRepository
+	counts map[string]int64
+	err    error
+}
+
+func (repository *stubRepository) FindByID(ctx context.Context, id primitive.ObjectID) (*todo.Todo, error) {
+	return nil, repository.err
+}
+
+func (repository *stubRepository) CountByStatus(ctx context.Context) (map[string]int64, error) {
+	return repository.counts, repository.err
+}
+
+type stubCommand struct {
+	err error
+}
+
+func (cmd *stubCommand) Execute(svc interface{}) (interface{}, error) {
+	return nil, cmd.err
+}
+
+func TestMetrics(t *testing.T) {
+	metrics := New()
+	routes := routeServer{}
+	server := NewServer(routes, metrics)
+	server.Handle("GET", "/api/todo-list/tasks/{id}", func(w http.ResponseWriter, r *http.Request) httpLib.Response {
+		return httpLib.NewResponse(http.StatusNotFound, nil, nil)
+	})
+	server.Handle("GET", "/api/todo-list/tasks/stream", func(w http.ResponseWriter, r *http.Request) httpLib.Response {
+		_, ok := w.(http.Flusher)
+		require.True(t, ok, "стриминг должен сохранять Flusher")
+		w.WriteHeader(http.StatusOK)
+		return nil
+	})
+	routes["GET /api/todo-list/tasks/{id}"](httptest.NewRecorder(), httptest.NewRequest("GET", "/api/todo-list/tasks/1", nil))
+	routes["GET /api/todo-list/tasks/{id}"](httptest.NewRecorder(), httptest.NewRequest("GET", "/api/todo-list/tasks/2", nil))
+	routes["GET /api/todo-list/tasks/stream"](httptest.NewRecorder(), httptest.NewRequest("GET", "/api/todo-list/tasks/stream", nil))
+	require.Equal(t, 2.0, testutil.ToFloat64(metrics.httpRequests.WithLabelValues("GET", "/api/todo-list/tasks/{id}", "404")))
+	require.Equal(t, 1.0, testutil.ToFloat64(metrics.httpRequests.WithLabelValues("GET", "/api/todo-list/tasks/stream", "200")))
+
+	interceptor := UnaryServerInterceptor(metrics)
+	info := &grpc.UnaryServerInfo{FullMethod: "/todo.v1.TodoService/GetTodo"}
+	interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.NotFound, "todo not found")
+	})
+	interceptor(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &struct{}{}, nil
+	})
+	require.Equal(t, 1.0, testutil.ToFloat64(metrics.grpcRequests.WithLabelValues("/todo.v1.TodoService/GetTodo", "NotFound")))
+	require.Equal(t, 1.0, testutil.ToFloat64(metrics.grpcRequests.WithLabelValues("/todo.v1.TodoService/GetTodo", "OK")))
+
+	handler := NewCommandHandler(command.NewCommandHandler(nil), metrics)
+	handler.ExecuteCommand(&stubCommand{})
+	handler.ExecuteCommand(&stubCommand{err: todo.ErrTodoNotFound})
+	handler.ExecuteCommand(&stubCommand{err: errors.New("connection reset")})
+	require.Equal(t, 3.0, testutil.ToFloat64(metrics.commands.WithLabelValues("stubCommand")))
+	require.Equal(t, 1.0, testutil.ToFloat64(metrics.commandErrors.WithLabelValues("stubCommand", "todo-not-found")))
+	require.Equal(t, 1.0, testutil.ToFloat64(metrics.commandErrors.WithLabelValues("stubCommand", "internal-error")))
+
+	stub := &stubRepository{err: todo.ErrTodoNotFound}
+	NewRepository(stub, metrics).FindByID(context.Background(), primitive.NewObjectID())
+	require.Equal(t, 1.0, testutil.ToFloat64(metrics.repositoryErrors.WithLabelValues("find_by_id", "todo-not-found")))
+
+	stub.err = nil
+	stub.counts = map[string]int64{todo.StatusTodo: 3}
+	require.NoError(t, metrics.Register(NewTaskCollector(stub, time.Second)))
+	resp := httptest.NewRecorder()
+	require.Nil(t, metrics.Endpoint()(resp, httptest.NewRequest("GET", "/metrics", nil)))
+	body := resp.Body.String()
+	require.Contains(t, body, `todo_tasks{status="TODO"} 3`)
+	require.Contains(t, body, `todo_tasks{status="DONE"} 0`)
+	require.Contains(t, body, `todo_tasks{status="BLOCKED"} 0`)
+	require.Contains(t, body, `todo_http_request_duration_seconds_count{method="GET",route="/api/todo-list/tasks/{id}"} 2`)
+	require.Contains(t, body, `todo_grpc_request_duration_seconds_count{method="/todo.v1.TodoService/GetTodo"} 2`)
+	require.Contains(t, body, `todo_repository_operation_duration_seconds_count{operation="find_by_id"} 1`)
+
+	stub.err = errors.New("server selection timeout")
+	resp = httptest.NewRecorder()
+	metrics.Endpoint()(resp, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.False(t, strings.Contains(resp.Body.String(), "todo_tasks{"), "без счётчика задач, но с остальными метриками")
+	require.Contains(t, resp.Body.String(), "todo_commands_total")
+}
@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/kas2000/service-todo/todo"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type repository struct {
+	next    todo.TodoRepository
+	metrics *Metrics
+}
+
+// NewRepository times every TodoRepository operation and counts its errors.
+func NewRepository(next todo.TodoRepository, metrics *Metrics) todo.TodoRepository {
+	return &repository{next: next, metrics: metrics}
+}
+
+func (r *repository) observe(operation string, started time.Time, err error) {
+	r.metrics.repositoryDuration.WithLabelValues(operation).Observe(time.Since(started).Seconds())
+	if err != nil {
+		r.metrics.repositoryErrors.WithLabelValues(operation, errorLabel(err)).Inc()
+	}
+}
+
+func (r *repository) Create(ctx context.Context, task *todo.Todo) (*todo.Todo, error) {
+	started := time.Now()
+	result, err := r.next.Create(ctx, task)
+	r.observe("create", started, err)
+	return result, err
+}
+
+func (r *repository) FindByID(ctx context.Context, id primitive.ObjectID) (*todo.Todo, error) {
+	started := time.Now()
+	result, err := r.next.FindByID(ctx, id)
+	r.observe("find_by_id", started, err)
+	return result, err
+}
+
+func (r *repository) FindAll(ctx context.Context, pointers todo.TodoPointers) ([]*todo.Todo, error) {
+	started := time.Now()
+	result, err := r.next.FindAll(ctx, pointers)
+	r.observe("find_all", started, err)
+	return result, err
+}
+
+func (r *repository) FindEach(ctx context.Context, pointers todo.TodoPointers, fn func(todos []*todo.Todo) error) error {
+	started := time.Now()
+	err := r.next.FindEach(ctx, pointers, fn)
+	r.observe("find_each", started, err)
+	return err
+}
+
+func (r *repository) Update(ctx context.Context, upd todo.TodoPointers) error {
+	started := time.Now()
+	err := r.next.Update(ctx, upd)
+	r.observe("update", started, err)
+	return err
+}
+
+func (r *repository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	started := time.Now()
+	err := r.next.Delete(ctx, id)
+	r.observe("delete", started, err)
+	return err
+}
+
+func (r *repository) Restore(ctx context.Context, task *todo.Todo) error {
+	started := time.Now()
+	err := r.next.Restore(ctx, task)
+	r.observe("restore", started, err)
+	return err
+}
+
+func (r *repository) EnsureIndexes(ctx context.Context) error {
+	started := time.Now()
+	err := r.next.EnsureIndexes(ctx)
+	r.observe("ensure_indexes", started, err)
+	return err
+}
+
+func (r *repository) Ping(ctx context.Context) error {
+	started := time.Now()
+	err := r.next.Ping(ctx)
+	r.observe("ping", started, err)
+	return err
+}
+
+func (r *repository) CountByStatus(ctx context.Context) (map[string]int64, error) {
+	started := time.Now()
+	counts, err := r.next.CountByStatus(ctx)
+	r.observe("count_by_status", started, err)
+	return counts, err
+}
+
+// taskCollector counts tasks by status on every scrape.
+type taskCollector struct {
+	repository todo.TodoRepository
+	timeout    time.Duration
+	tasks      *prometheus.Desc
+}
+
+func NewTaskCollector(repository todo.TodoRepository, timeout time.Duration) prometheus.Collector {
+	return &taskCollector{
+		repository: repository,
+		timeout:    timeout,
+		tasks:      prometheus.NewDesc(namespace+"_tasks", "Tasks by status.", []string{"status"}, nil),
+	}
+}
+
+func (collector *taskCollector) Describe(descs chan<- *prometheus.Desc) {
+	descs <- collector.tasks
+}
+
+func (collector *taskCollector) Collect(metrics chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), collector.timeout)
+	defer cancel()
+	counts, err := collector.repository.CountByStatus(ctx)
+	if err != nil {
+		metrics <- prometheus.NewInvalidMetric(collector.tasks, err)
+		return
+	}
+	for _, status := range todo.Statuses {
+		if _, found := counts[status]; !found {
+			counts[status] = 0
+		}
+	}
+	for status, count := range counts {
+		metrics <- prometheus.MustNewConstMetric(collector.tasks, prometheus.GaugeValue, float64(count), status)
+	}
+}
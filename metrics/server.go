@@ -0,0 +1,32 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	httpLib "github.com/kas2000/http"
+	"github.com/kas2000/service-todo/httpstatus"
+)
+
+type server struct {
+	httpLib.Server
+	metrics *Metrics
+}
+
+// NewServer records every endpoint registered through Handle under its route template, not the concrete path.
+func NewServer(next httpLib.Server, metrics *Metrics) httpLib.Server {
+	return &server{Server: next, metrics: metrics}
+}
+
+func (s *server) Handle(method string, path string, final httpLib.Endpoint) {
+	s.Server.Handle(method, path, func(w http.ResponseWriter, r *http.Request) httpLib.Response {
+		started := time.Now()
+		recorder := httpstatus.NewRecorder(w)
+		response := final(recorder, r)
+		code := recorder.Of(response)
+		s.metrics.httpRequests.WithLabelValues(method, path, strconv.Itoa(code)).Inc()
+		s.metrics.httpDuration.WithLabelValues(method, path).Observe(time.Since(started).Seconds())
+		return response
+	})
+}
@@ -0,0 +1,91 @@
+package requestlog
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/kas2000/logger"
+	"github.com/kas2000/service-todo/todo"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// requestIDKey is todo.RequestIDHeader as gRPC metadata, whose keys are lowercase.
+var requestIDKey = strings.ToLower(todo.RequestIDHeader)
+
+// UnaryServerInterceptor does for gRPC calls what NewServer does for HTTP requests, with the request ID in the
+// x-request-id metadata of the call and of the response header.
+func UnaryServerInterceptor(log logger.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		started := time.Now()
+		ctx, id, callLog := begin(ctx, log)
+		grpc.SetHeader(ctx, metadata.Pairs(requestIDKey, id))
+		resp, err := handler(ctx, req)
+		finish(callLog, info.FullMethod, started, err)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streaming calls, logged once the stream ends.
+func StreamServerInterceptor(log logger.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		started := time.Now()
+		ctx, id, callLog := begin(ss.Context(), log)
+		ss.SetHeader(metadata.Pairs(requestIDKey, id))
+		err := handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+		finish(callLog, info.FullMethod, started, err)
+		return err
+	}
+}
+
+// begin takes the request ID from the metadata of the call or generates one and puts a logger tagged with it into
+// the call context.
+func begin(ctx context.Context, log logger.Logger) (context.Context, string, logger.Logger) {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(requestIDKey); len(ids) > 0 {
+			id = ids[0]
+		}
+	}
+	if !valid(id) {
+		id = newRequestID()
+	}
+
+	fields := []zap.Field{zap.String("request_id", id)}
+	if span := trace.SpanContextFromContext(ctx); span.HasTraceID() {
+		fields = append(fields, zap.String("trace_id", span.TraceID().String()))
+	}
+	log = todo.WithFields(log, fields...)
+	return todo.ContextWithRequestID(todo.ContextWithLogger(ctx, log), id), id, log
+}
+
+// finish writes the access log line of a call, with the registry code of its error.
+func finish(log logger.Logger, method string, started time.Time, err error) {
+	st := status.Convert(err)
+	entry := []zap.Field{
+		zap.String("method", method),
+		zap.String("code", st.Code().String()),
+		zap.Duration("latency", time.Since(started)),
+	}
+	for _, detail := range st.Details() {
+		if errorInfo, ok := detail.(*errdetails.ErrorInfo); ok {
+			entry = append(entry, zap.String("error_code", errorInfo.Metadata["code"]))
+		}
+	}
+	log.Info("request", entry...)
+}
+
+// contextStream hands the handler a stream with the call context of begin.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (stream *contextStream) Context() context.Context {
+	return stream.ctx
+}
@@ -0,0 +1,88 @@
+// Package requestlog gives every HTTP request and gRPC call an ID and a logger tagged with it, and writes one access
+// log line per request.
+package requestlog
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	httpLib "github.com/kas2000/http"
+	"github.com/kas2000/logger"
+	"github.com/kas2000/service-todo/httpstatus"
+	"github.com/kas2000/service-todo/todo"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// maxRequestIDLength bounds IDs taken from callers, which end up in every log line of the request.
+const maxRequestIDLength = 128
+
+type server struct {
+	httpLib.Server
+	log logger.Logger
+}
+
+// NewServer takes the request ID from the X-Request-ID header or generates one, returns it in the response and
+// puts a logger tagged with it into the request context, see todo.LoggerFromContext.
+func NewServer(next httpLib.Server, log logger.Logger) httpLib.Server {
+	return &server{Server: next, log: log}
+}
+
+func (s *server) Handle(method string, path string, final httpLib.Endpoint) {
+	s.Server.Handle(method, path, func(w http.ResponseWriter, r *http.Request) httpLib.Response {
+		started := time.Now()
+		id := r.Header.Get(todo.RequestIDHeader)
+		if !valid(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(todo.RequestIDHeader, id)
+
+		fields := []zap.Field{zap.String("request_id", id)}
+		if span := trace.SpanContextFromContext(r.Context()); span.HasTraceID() {
+			fields = append(fields, zap.String("trace_id", span.TraceID().String()))
+		}
+		log := todo.WithFields(s.log, fields...)
+		ctx := todo.ContextWithRequestID(todo.ContextWithLogger(r.Context(), log), id)
+
+		recorder := httpstatus.NewRecorder(w)
+		response := final(recorder, r.WithContext(ctx))
+
+		entry := []zap.Field{
+			zap.String("method", method),
+			zap.String("route", path),
+			zap.String("path", r.URL.Path),
+			zap.Int("status", recorder.Of(response)),
+			zap.Duration("latency", time.Since(started)),
+		}
+		if response != nil {
+			if problem, ok := response.Response().(*todo.Problem); ok {
+				entry = append(entry, zap.String("error_code", problem.Code))
+			}
+		}
+		log.Info("request", entry...)
+		return response
+	})
+}
+
+// valid accepts caller IDs of printable ASCII only, so they can't forge log lines.
+func valid(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return time.Now().UTC().Format("20060102T150405.000000000")
+	}
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,155 @@
+package requestlog
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	httpLib "github.com/kas2000/http"
+	"github.com/kas2000/service-todo/todo"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// routeServer keeps the wrapped endpoints by "METHOD path" instead of serving them.
+type routeServer map[string]httpLib.Endpoint
+
+func (routes routeServer) ListenAndServe() {}
+
+func (routes routeServer) Handle(method string, path string, final httpLib.Endpoint) {
+	routes[method+" "+path] = final
+}
+
+func TestRequestLog(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	routes := routeServer{}
+	NewServer(routes, zap.New(core)).Handle("GET", "/api/todo-list/tasks/{id}", func(w http.ResponseWriter, r *http.Request) httpLib.Response {
+		todo.LoggerFromContext(r.Context(), nil).Debug("loading todo")
+		problem := todo.NewProblem(todo.ErrTodoNotFound, "todo-service", r.URL.Path)
+		return httpLib.NewResponse(problem.Status, problem, nil)
+	})
+
+	tests := []struct {
+		title    string
+		header   string
+		expected string
+	}{
+		{title: "ID из заголовка сохраняется", header: "req-42", expected: "req-42"},
+		{title: "Без заголовка ID генерируется"},
+		{title: "ID с переводом строки заменяется", header: "req\nforged"},
+	}
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			logs.TakeAll()
+			req := httptest.NewRequest("GET", "/api/todo-list/tasks/1", nil)
+			if test.header != "" {
+				req.Header.Set(todo.RequestIDHeader, test.header)
+			}
+			resp := httptest.NewRecorder()
+			routes["GET /api/todo-list/tasks/{id}"](resp, req)
+
+			id := resp.Header().Get(todo.RequestIDHeader)
+			if test.expected != "" {
+				require.Equal(t, test.expected, id)
+			} else {
+				require.Len(t, id, 32)
+			}
+
+			entries := logs.TakeAll()
+			require.Len(t, entries, 2)
+			require.Equal(t, "loading todo", entries[0].Message)
+			require.Equal(t, id, entries[0].ContextMap()["request_id"], "логгер запроса помечен его ID")
+
+			access := entries[1].ContextMap()
+			require.Equal(t, "request", entries[1].Message)
+			require.Equal(t, id, access["request_id"])
+			require.Equal(t, "GET", access["method"])
+			require.Equal(t, "/api/todo-list/tasks/{id}", access["route"])
+			require.EqualValues(t, http.StatusNotFound, access["status"])
+			require.Equal(t, "todo-service.1101", access["error_code"])
+			require.Contains(t, access, "latency")
+		})
+	}
+}
+
+// headerStream keeps the response header a gRPC handler sets.
+type headerStream struct {
+	header metadata.MD
+}
+
+func (stream *headerStream) Method() string { return "/todo.v1.TodoService/GetTodo" }
+
+func (stream *headerStream) SetHeader(md metadata.MD) error {
+	stream.header = metadata.Join(stream.header, md)
+	return nil
+}
+
+func (stream *headerStream) SendHeader(md metadata.MD) error { return stream.SetHeader(md) }
+
+func (stream *headerStream) SetTrailer(md metadata.MD) error { return nil }
+
+func TestGrpcRequestLog(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	interceptor := UnaryServerInterceptor(zap.New(core))
+	info := &grpc.UnaryServerInfo{FullMethod: "/todo.v1.TodoService/GetTodo"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		todo.LoggerFromContext(ctx, nil).Debug("loading todo")
+		st, _ := status.New(codes.NotFound, "todo not found").WithDetails(&errdetails.ErrorInfo{
+			Reason:   "todo-not-found",
+			Metadata: map[string]string{"code": "todo-service.1101"},
+		})
+		return nil, st.Err()
+	}
+
+	tests := []struct {
+		title    string
+		metadata string
+		expected string
+	}{
+		{title: "ID из метаданных сохраняется", metadata: "req-42", expected: "req-42"},
+		{title: "Без метаданных ID генерируется"},
+		{title: "ID с переводом строки заменяется", metadata: "req\nforged"},
+	}
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			logs.TakeAll()
+			stream := &headerStream{}
+			ctx := grpc.NewContextWithServerTransportStream(context.Background(), stream)
+			if test.metadata != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("x-request-id", test.metadata))
+			}
+			_, err := interceptor(ctx, nil, info, handler)
+			require.Equal(t, codes.NotFound, status.Code(err))
+
+			ids := stream.header.Get("x-request-id")
+			require.Len(t, ids, 1)
+			id := ids[0]
+			if test.expected != "" {
+				require.Equal(t, test.expected, id)
+			} else {
+				require.Len(t, id, 32)
+			}
+
+			entries := logs.TakeAll()
+			require.Len(t, entries, 2)
+			require.Equal(t, "loading todo", entries[0].Message)
+			require.Equal(t, id, entries[0].ContextMap()["request_id"], "логгер вызова помечен его ID")
+
+			access := entries[1].ContextMap()
+			require.Equal(t, "request", entries[1].Message)
+			require.Equal(t, id, access["request_id"])
+			require.Equal(t, "/todo.v1.TodoService/GetTodo", access["method"])
+			require.Equal(t, "NotFound", access["code"])
+			require.Equal(t, "todo-service.1101", access["error_code"])
+			require.Contains(t, access, "latency")
+		})
+	}
+}
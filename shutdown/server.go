@@ -0,0 +1,73 @@
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	httpLib "github.com/kas2000/http"
+	"github.com/kas2000/logger"
+)
+
+// Server routes and wraps endpoints like httpLib.NewServer, but doesn't stop by itself on SIGINT: Shutdown stops
+// it, so that it is one step of the shutdown of the service.
+type Server struct {
+	router *mux.Router
+	srv    *http.Server
+	log    logger.Logger
+	cfg    httpLib.Config
+}
+
+// NewServer reads the durations of cfg in seconds, like httpLib. GracefulTimeout and ShutdownTimeout are left to
+// the caller of Shutdown.
+func NewServer(cfg httpLib.Config) *Server {
+	router := mux.NewRouter().StrictSlash(true)
+	return &Server{
+		router: router,
+		log:    cfg.Logger,
+		cfg:    cfg,
+		srv: &http.Server{
+			Addr:         "0.0.0.0:" + cfg.Port,
+			WriteTimeout: time.Second * cfg.Timeout,
+			ReadTimeout:  time.Second * cfg.Timeout,
+			Handler:      router,
+		},
+	}
+}
+
+func (s *Server) Handle(method string, path string, final httpLib.Endpoint) {
+	if s.cfg.IsGatewayServer {
+		final = httpLib.JWT(final, s.cfg.PublicKey)
+	}
+	s.router.HandleFunc(path, httpLib.Json(httpLib.Logging(final, s.log))).Methods(method)
+}
+
+// ListenAndServe serves until Shutdown. It ends the process if the port can't be listened on.
+func (s *Server) ListenAndServe() {
+	listener, err := net.Listen("tcp", s.srv.Addr)
+	if err != nil {
+		s.log.Fatal("couldn't listen on http port: " + err.Error())
+	}
+	s.Serve(listener)
+}
+
+// Serve accepts connections on listener until Shutdown.
+func (s *Server) Serve(listener net.Listener) {
+	s.log.Info("Server started on port: " + s.cfg.Port)
+	if err := s.srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		s.log.Fatal("http server stopped: " + err.Error())
+	}
+}
+
+// Shutdown stops accepting connections and waits for the requests in progress. When ctx is done first, the
+// remaining connections are closed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.srv.Shutdown(ctx)
+	if err != nil {
+		s.srv.Close()
+	}
+	return err
+}
@@ -0,0 +1,102 @@
+// Package shutdown stops the parts of the service one after another, each within a deadline, and serves HTTP
+// until it is told to stop.
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/kas2000/logger"
+	"go.uber.org/zap"
+)
+
+// Step stops one part of the service. It must give up when ctx is done.
+type Step struct {
+	Name string
+	// Timeout bounds the step on top of the deadline of Run; zero leaves only the latter.
+	Timeout time.Duration
+	Stop    func(ctx context.Context) error
+}
+
+// Shutdown runs its steps in the order they were added.
+type Shutdown struct {
+	log      logger.Logger
+	steps    []Step
+	progress func(step string)
+}
+
+// New reports every step to progress before running it, e.g. to show it in readiness.
+func New(log logger.Logger, progress func(step string)) *Shutdown {
+	return &Shutdown{log: log, progress: progress}
+}
+
+func (shutdown *Shutdown) Add(name string, timeout time.Duration, stop func(ctx context.Context) error) {
+	shutdown.steps = append(shutdown.steps, Step{Name: name, Timeout: timeout, Stop: stop})
+}
+
+// Run runs every step, even after one fails or runs out of time, since the later ones may still release resources.
+// It returns the errors of the steps.
+func (shutdown *Shutdown) Run(ctx context.Context) error {
+	started := time.Now()
+	shutdown.log.Info("shutting down", zap.Int("steps", len(shutdown.steps)))
+	var errs []error
+	for _, step := range shutdown.steps {
+		if shutdown.progress != nil {
+			shutdown.progress(step.Name)
+		}
+		if err := shutdown.run(ctx, step); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", step.Name, err))
+		}
+	}
+	err := errors.Join(errs...)
+	if err != nil {
+		shutdown.log.Warn("shutdown finished with errors", zap.Duration("duration", time.Since(started)), zap.Error(err))
+	} else {
+		shutdown.log.Info("shutdown finished", zap.Duration("duration", time.Since(started)))
+	}
+	return err
+}
+
+func (shutdown *Shutdown) run(ctx context.Context, step Step) error {
+	if step.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, step.Timeout)
+		defer cancel()
+	}
+	started := time.Now()
+	shutdown.log.Info("stopping "+step.Name, zap.String("step", step.Name))
+	if err := step.Stop(ctx); err != nil {
+		shutdown.log.Warn("couldn't stop "+step.Name, zap.String("step", step.Name), zap.Duration("duration", time.Since(started)), zap.Error(err))
+		return err
+	}
+	shutdown.log.Info("stopped "+step.Name, zap.String("step", step.Name), zap.Duration("duration", time.Since(started)))
+	return nil
+}
+
+// SignalContext is done on SIGTERM or SIGINT. After that a second signal terminates the process right away.
+func SignalContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		<-ctx.Done()
+		// The default handling of the signals is restored, so a second one ends the process.
+		stop()
+	}()
+	return ctx, stop
+}
+
+// Wait returns a step that waits for done to be closed, e.g. for a worker goroutine to return.
+func Wait(done <-chan struct{}) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		select {
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	httpLib "github.com/kas2000/http"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestShutdown(t *testing.T) {
+	var progress, stopped []string
+	stopping := New(zap.NewNop(), func(step string) { progress = append(progress, step) })
+	stopping.Add("http", 0, func(ctx context.Context) error {
+		stopped = append(stopped, "http")
+		return nil
+	})
+	stopping.Add("workers", 10*time.Millisecond, func(ctx context.Context) error {
+		<-ctx.Done()
+		stopped = append(stopped, "workers")
+		return ctx.Err()
+	})
+	failure := errors.New("connection reset")
+	stopping.Add("mongo", 0, func(ctx context.Context) error {
+		require.NoError(t, ctx.Err(), "таймаут шага не переходит на следующие")
+		stopped = append(stopped, "mongo")
+		return failure
+	})
+
+	err := stopping.Run(context.Background())
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.ErrorIs(t, err, failure)
+	require.Equal(t, []string{"http", "workers", "mongo"}, progress)
+	require.Equal(t, []string{"http", "workers", "mongo"}, stopped)
+}
+
+func TestServer(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	server := NewServer(httpLib.Config{Logger: zap.NewNop(), Timeout: 5})
+	server.Handle("GET", "/slow", func(w http.ResponseWriter, r *http.Request) httpLib.Response {
+		close(started)
+		<-release
+		return httpLib.NewResponse(http.StatusOK, "done", nil)
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go server.Serve(listener)
+	url := "http://" + listener.Addr().String()
+
+	type result struct {
+		body string
+		err  error
+	}
+	results := make(chan result)
+	go func() {
+		resp, err := http.Get(url + "/slow")
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		results <- result{body: string(body), err: err}
+	}()
+	<-started
+
+	stopped := make(chan error)
+	go func() {
+		stopped <- server.Shutdown(context.Background())
+	}()
+	require.Eventually(t, func() bool {
+		_, err := net.Dial("tcp", listener.Addr().String())
+		return err != nil
+	}, time.Second, 5*time.Millisecond, "новые соединения не принимаются")
+	select {
+	case <-stopped:
+		t.Fatal("остановка не дождалась запроса")
+	default:
+	}
+
+	close(release)
+	require.NoError(t, <-stopped)
+	finished := <-results
+	require.NoError(t, finished.err)
+	require.Equal(t, "\"done\"\n", finished.body)
+}
@@ -1,37 +1,120 @@
 package todo
 
 import (
+	"context"
+	"errors"
 	"github.com/kas2000/logger"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+	"slices"
+	"strconv"
 	"time"
 	"unicode/utf8"
 )
 
+const (
+	titleMaxLength = 200
+	dateLayout     = "2006-01-02"
+)
+
 type Service interface {
 	TodoService
+	TodoListService
 }
 
 type service struct {
-	todoRepo TodoRepository
-	log      logger.Logger
+	todoRepo    TodoRepository
+	listRepo    TodoListRepository
+	log         logger.Logger
+	transitions Transitions
+}
+
+// NewService returns a service working on the tasks of the list of each request, see ContextWithList.
+func NewService(todoRepo TodoRepository, listRepo TodoListRepository, log logger.Logger) Service {
+	return NewServiceWithTransitions(todoRepo, listRepo, log, DefaultTransitions)
+}
+
+// NewServiceWithTransitions returns a service whose tasks follow the lifecycle of transitions, see NewTransitions.
+func NewServiceWithTransitions(todoRepo TodoRepository, listRepo TodoListRepository, log logger.Logger, transitions Transitions) Service {
+	return &service{todoRepo: todoRepo, listRepo: listRepo, log: log, transitions: transitions}
+}
+
+// logger returns the logger of the current request, tagged with its request ID.
+func (service *service) logger(ctx context.Context) logger.Logger {
+	return LoggerFromContext(ctx, service.log)
+}
+
+// validateTodo applies the domain checks shared by create and update and returns the parsed activeAt.
+func validateTodo(title string, activeAt string) (time.Time, error) {
+	if utf8.RuneCountInString(title) > titleMaxLength {
+		return time.Time{}, NewValidationError(ErrTitleLengthLimitExceeded, FieldError{
+			Field: "title",
+			Rule:  "max",
+			Param: strconv.Itoa(titleMaxLength),
+		})
+	}
+	parsed, err := time.Parse(dateLayout, activeAt)
+	if err != nil {
+		return time.Time{}, NewValidationError(ErrInvalidDateFormat, FieldError{
+			Field: "activeAt",
+			Rule:  "datetime",
+			Param: dateLayout,
+		})
+	}
+	return parsed, nil
 }
 
-func NewService(todoRepo TodoRepository, log logger.Logger) Service {
-	return &service{todoRepo: todoRepo, log: log}
+// todo returns the task id of the list of ctx; tasks of other lists aren't found.
+func (service *service) todo(ctx context.Context, id primitive.ObjectID) (*Todo, error) {
+	todo, err := service.todoRepo.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if todo.ListID != ListFromContext(ctx) {
+		return nil, ErrTodoNotFound
+	}
+	return todo, nil
 }
 
-func (service *service) CreateTodo(createTodo *CreateTodoDTO) (*GetTodoDTO, error) {
-	if utf8.RuneCountInString(createTodo.Title) > 200 {
-		return nil, ErrTitleLengthLimitExceeded
+// openList fails unless the list id exists and takes new tasks.
+func (service *service) openList(ctx context.Context, id primitive.ObjectID) error {
+	list, err := service.listRepo.FindByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if list.Deleting {
+		return ErrTodoListNotFound
 	}
-	activeAt, err := time.Parse("2006-01-02", createTodo.ActiveAt)
+	if list.Archived {
+		return ErrTodoListArchived
+	}
+	return nil
+}
+
+// stillOpen checks the list id again once a task has been put in it, as the list may have been archived or marked
+// for deletion since openList. DeleteTodoList marks the list before it checks that the list is empty, so either it
+// sees the task or this sees the mark. The default list is always open.
+func (service *service) stillOpen(ctx context.Context, id primitive.ObjectID) error {
+	if id == DefaultListID {
+		return nil
+	}
+	return service.openList(ctx, id)
+}
+
+func (service *service) CreateTodo(ctx context.Context, createTodo *CreateTodoDTO) (*TodoDTO, error) {
+	activeAt, err := validateTodo(createTodo.Title, createTodo.ActiveAt)
 	if err != nil {
-		return nil, ErrInvalidDateFormat
+		return nil, err
+	}
+	listID := ListFromContext(ctx)
+	if err := service.openList(ctx, listID); err != nil {
+		return nil, err
 	}
 
-	result, err := service.todoRepo.Create(&Todo{
+	result, err := service.todoRepo.Create(ctx, &Todo{
+		ListID:    listID,
 		Title:     createTodo.Title,
-		Status:    StatusActive,
+		Status:    StatusTodo,
 		ActiveAt:  activeAt,
 		CreatedAt: time.Time{},
 		UpdatedAt: nil,
@@ -39,83 +122,275 @@ func (service *service) CreateTodo(createTodo *CreateTodoDTO) (*GetTodoDTO, erro
 	if err != nil {
 		return nil, err
 	}
+	if err := service.stillOpen(ctx, listID); err != nil {
+		if undoErr := service.todoRepo.Delete(ctx, result.ID); undoErr != nil {
+			return nil, undoErr
+		}
+		return nil, err
+	}
+	service.logger(ctx).Debug("todo created", zap.String("todo_id", result.ID.Hex()))
 
-	return &GetTodoDTO{
-		Title:    result.Title,
-		ActiveAt: ToDateString(result.ActiveAt),
-	}, nil
+	return NewTodoDTO(result), nil
 }
 
-func (service *service) FindTodo(id primitive.ObjectID) (*GetTodoDTO, error) {
-	result, err := service.todoRepo.FindByID(id)
+func (service *service) FindTodo(ctx context.Context, id primitive.ObjectID) (*TodoDTO, error) {
+	result, err := service.todo(ctx, id)
 	if err != nil {
 		return nil, err
 	}
-	return &GetTodoDTO{
-		Title:    result.Title,
-		ActiveAt: ToDateString(result.ActiveAt),
-	}, nil
+	return NewTodoDTO(result), nil
 }
 
-func (service *service) FindTodos(pointers TodoPointers) ([]*GetTodoDTO, error) {
-	var todos []*Todo
-	var err error
-
-	switch *pointers.Status {
-	case StatusDone:
-		todos, err = service.todoRepo.FindAll(pointers)
-		if err != nil {
-			return nil, err
-		}
-	case StatusActive:
-		comparisonOperator := ComparisonOperatorLTE
-		today := time.Now().UTC()
-		todos, err = service.todoRepo.FindAll(TodoPointers{
-			Status: pointers.Status,
-			ActiveAt: &ActiveAtPointers{
-				ComparisonOperator: &comparisonOperator,
-				ActiveAt:           &today,
-			},
-		})
-		if err != nil {
-			return nil, err
-		}
+func (service *service) FindTodos(ctx context.Context, pointers TodoPointers) ([]*TodoDTO, error) {
+	pointers, err := statusFilter(ctx, pointers)
+	if err != nil {
+		return nil, err
+	}
+	todos, err := service.todoRepo.FindAll(ctx, inList(ctx, pointers))
+	if err != nil {
+		return nil, err
 	}
 
-	result := make([]*GetTodoDTO, 0, len(todos))
+	result := make([]*TodoDTO, 0, len(todos))
 	for _, todo := range todos {
-		switch todo.ActiveAt.Weekday() {
-		case time.Saturday, time.Sunday:
-			todo.Title = "ВЫХОДНОЙ - " + todo.Title
-		}
-		result = append(result, &GetTodoDTO{
-			Title:    todo.Title,
-			ActiveAt: ToDateString(todo.ActiveAt),
-		})
+		result = append(result, NewTodoDTO(todo))
 	}
 
-	return result, err
+	return result, nil
 }
 
-func (service *service) UpdateTodo(upd UpdateTodoDTO) error {
-	if utf8.RuneCountInString(upd.Title) > 200 {
-		return ErrTitleLengthLimitExceeded
-	}
-	activeAt, err := time.Parse("2006-01-02", upd.ActiveAt)
+func (service *service) UpdateTodo(ctx context.Context, upd UpdateTodoDTO) error {
+	activeAt, err := validateTodo(upd.Title, upd.ActiveAt)
 	if err != nil {
-		return ErrInvalidDateFormat
+		return err
 	}
-	return service.todoRepo.Update(TodoPointers{
+	if _, err := service.todo(ctx, upd.ID); err != nil {
+		return err
+	}
+	err = service.todoRepo.Update(ctx, TodoPointers{
 		ID:       &upd.ID,
 		Title:    &upd.Title,
 		ActiveAt: &ActiveAtPointers{ActiveAt: &activeAt},
 	})
+	if err != nil {
+		return err
+	}
+	service.logger(ctx).Debug("todo updated", zap.String("todo_id", upd.ID.Hex()))
+	return nil
+}
+
+// UpdateTodoStatus moves a task along the lifecycle. Moving it to the status it is in changes nothing.
+func (service *service) UpdateTodoStatus(ctx context.Context, transition TransitionTodoDTO) error {
+	todo, err := service.todo(ctx, transition.ID)
+	if err != nil {
+		return err
+	}
+	if todo.Status == transition.Status {
+		return nil
+	}
+	if !transition.FromAnyOpen || !slices.Contains(OpenStatuses, upgradeStatus(todo.Status)) {
+		if err := service.transitions.Check(todo.Status, transition.Status); err != nil {
+			return err
+		}
+	}
+	if err := service.todoRepo.Update(ctx, TodoPointers{ID: &transition.ID, Status: &transition.Status}); err != nil {
+		return err
+	}
+	service.logger(ctx).Debug("todo status updated", zap.String("todo_id", transition.ID.Hex()),
+		zap.String("from", todo.Status), zap.String("status", transition.Status))
+	return nil
+}
+
+func (service *service) DeleteTodo(ctx context.Context, id primitive.ObjectID) error {
+	if _, err := service.todo(ctx, id); err != nil {
+		return err
+	}
+	if err := service.todoRepo.Delete(ctx, id); err != nil {
+		return err
+	}
+	service.logger(ctx).Debug("todo deleted", zap.String("todo_id", id.Hex()))
+	return nil
 }
 
-func (service *service) UpdateTodoStatus(upd TodoPointers) error {
-	return service.todoRepo.Update(upd)
+// bulkError records a registered failure of one item; other errors abort the whole operation.
+func bulkError(result *BulkResult, item int, todoID string, err error) error {
+	def, registered := LookupError(err)
+	if !registered {
+		return err
+	}
+	result.Failed++
+	result.Errors = append(result.Errors, BulkError{Item: item, TodoID: todoID, Code: def.Slug, Detail: err.Error()})
+	return nil
+}
+
+func (service *service) ImportTodos(ctx context.Context, todos []CreateTodoDTO) (*BulkResult, error) {
+	result := &BulkResult{Total: len(todos)}
+	for i := range todos {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		_, err := service.CreateTodo(ctx, &todos[i])
+		switch {
+		case err == nil:
+			result.Succeeded++
+		case errors.Is(err, ErrTodoAlreadyExists):
+			result.Skipped++
+		default:
+			if err := bulkError(result, i, "", err); err != nil {
+				return result, err
+			}
+		}
+		ReportProgress(ctx, i+1, len(todos))
+	}
+	service.logger(ctx).Debug("todos imported", zap.Int("succeeded", result.Succeeded), zap.Int("skipped", result.Skipped), zap.Int("failed", result.Failed))
+	return result, nil
+}
+
+func (service *service) RescheduleTodos(ctx context.Context, reschedule RescheduleTodosDTO) (*BulkResult, error) {
+	from, err := time.Parse(dateLayout, reschedule.From)
+	if err != nil {
+		return nil, NewValidationError(ErrInvalidDateFormat, FieldError{Field: "from", Rule: "datetime", Param: dateLayout})
+	}
+	to, err := time.Parse(dateLayout, reschedule.To)
+	if err != nil {
+		return nil, NewValidationError(ErrInvalidDateFormat, FieldError{Field: "to", Rule: "datetime", Param: dateLayout})
+	}
+	if to.Before(from) {
+		return nil, NewValidationError(ErrValidationFailed, FieldError{Field: "to", Rule: "gtefield", Param: "from"})
+	}
+
+	gte := ComparisonOperatorGTE
+	todos, err := service.todoRepo.FindAll(ctx, inList(ctx, TodoPointers{
+		Statuses: OpenStatuses,
+		ActiveAt: &ActiveAtPointers{ComparisonOperator: &gte, ActiveAt: &from},
+		DueBy:    &to,
+		Sort:     []SortField{{Field: "activeAt"}},
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	result := &BulkResult{Total: len(todos)}
+	for i, todo := range todos {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		activeAt := todo.ActiveAt.AddDate(0, 0, reschedule.Days)
+		err := service.todoRepo.Update(ctx, TodoPointers{ID: &todo.ID, ActiveAt: &ActiveAtPointers{ActiveAt: &activeAt}})
+		switch {
+		case err == nil:
+			result.Succeeded++
+		case errors.Is(err, ErrTodoNotFound):
+			result.Skipped++
+		default:
+			if err := bulkError(result, i, todo.ID.Hex(), err); err != nil {
+				return result, err
+			}
+		}
+		ReportProgress(ctx, i+1, len(todos))
+	}
+	service.logger(ctx).Debug("todos rescheduled", zap.Int("succeeded", result.Succeeded), zap.Int("failed", result.Failed))
+	return result, nil
+}
+
+// MoveTodo moves a task of the list of ctx to another list. Moving it to its own list changes nothing.
+func (service *service) MoveTodo(ctx context.Context, move MoveTodoDTO) error {
+	todo, err := service.todo(ctx, move.ID)
+	if err != nil {
+		return err
+	}
+	if todo.ListID == move.ListID {
+		return nil
+	}
+	if err := service.openList(ctx, move.ListID); err != nil {
+		return err
+	}
+	if err := service.todoRepo.Update(ctx, TodoPointers{ID: &move.ID, ListID: &move.ListID}); err != nil {
+		return err
+	}
+	if err := service.stillOpen(ctx, move.ListID); err != nil {
+		if undoErr := service.todoRepo.Update(ctx, TodoPointers{ID: &move.ID, ListID: &todo.ListID}); undoErr != nil {
+			return undoErr
+		}
+		return err
+	}
+	service.logger(ctx).Debug("todo moved", zap.String("todo_id", move.ID.Hex()),
+		zap.String("from", todo.ListID.Hex()), zap.String("list_id", move.ListID.Hex()))
+	return nil
 }
 
-func (service *service) DeleteTodo(id primitive.ObjectID) error {
-	return service.todoRepo.Delete(id)
+func (service *service) CreateTodoList(ctx context.Context, createList *CreateTodoListDTO) (*TodoListDTO, error) {
+	result, err := service.listRepo.Create(ctx, &TodoList{Name: createList.Name})
+	if err != nil {
+		return nil, err
+	}
+	service.logger(ctx).Debug("todo list created", zap.String("list_id", result.ID.Hex()))
+	return NewTodoListDTO(result), nil
+}
+
+func (service *service) FindTodoList(ctx context.Context, id primitive.ObjectID) (*TodoListDTO, error) {
+	result, err := service.listRepo.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return NewTodoListDTO(result), nil
+}
+
+func (service *service) FindTodoLists(ctx context.Context) ([]*TodoListDTO, error) {
+	lists, err := service.listRepo.FindAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*TodoListDTO, 0, len(lists))
+	for _, list := range lists {
+		result = append(result, NewTodoListDTO(list))
+	}
+	return result, nil
+}
+
+func (service *service) RenameTodoList(ctx context.Context, rename RenameTodoListDTO) error {
+	if err := service.listRepo.Update(ctx, TodoListPointers{ID: rename.ID, Name: &rename.Name}); err != nil {
+		return err
+	}
+	service.logger(ctx).Debug("todo list renamed", zap.String("list_id", rename.ID.Hex()))
+	return nil
+}
+
+func (service *service) ArchiveTodoList(ctx context.Context, id primitive.ObjectID, archived bool) error {
+	if id == DefaultListID {
+		return ErrDefaultTodoList
+	}
+	if err := service.listRepo.Update(ctx, TodoListPointers{ID: id, Archived: &archived}); err != nil {
+		return err
+	}
+	service.logger(ctx).Debug("todo list archived", zap.String("list_id", id.Hex()), zap.Bool("archived", archived))
+	return nil
+}
+
+func (service *service) DeleteTodoList(ctx context.Context, id primitive.ObjectID) error {
+	if id == DefaultListID {
+		return ErrDefaultTodoList
+	}
+	// The mark keeps tasks out of the list while it is checked, see stillOpen. A delete stopped before it is done
+	// leaves the list marked, which the next delete of it finishes or undoes.
+	deleting := true
+	if err := service.listRepo.Update(ctx, TodoListPointers{ID: id, Deleting: &deleting}); err != nil {
+		return err
+	}
+	todos, err := service.todoRepo.FindAll(ctx, TodoPointers{ListID: &id, Fields: []string{"id"}, Limit: 1})
+	if err == nil && len(todos) > 0 {
+		err = ErrTodoListNotEmpty
+	}
+	if err != nil {
+		deleting = false
+		if undoErr := service.listRepo.Update(ctx, TodoListPointers{ID: id, Deleting: &deleting}); undoErr != nil {
+			service.logger(ctx).Warn("couldn't unmark todo list", zap.String("list_id", id.Hex()), zap.Error(undoErr))
+		}
+		return err
+	}
+	if err := service.listRepo.Delete(ctx, id); err != nil {
+		return err
+	}
+	service.logger(ctx).Debug("todo list deleted", zap.String("list_id", id.Hex()))
+	return nil
 }
@@ -1,14 +1,18 @@
 package todo
 
 import (
+	"context"
+	"github.com/kas2000/service-todo/calendar"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"strconv"
+	"strings"
 	"time"
 	"errors"
 )
 
 type Todo struct {
 	ID        primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	ListID    primitive.ObjectID `json:"listId" bson:"list_id"`
 	Title     string             `json:"title" bson:"title"`
 	Status    string             `json:"status" bson:"status"`
 	ActiveAt  time.Time          `json:"activeAt" bson:"active_at"`
@@ -16,29 +20,89 @@ type Todo struct {
 	UpdatedAt *time.Time         `json:"updated_at" bson:"updated_at"`
 }
 
+// TodoPointers selects or changes tasks. Statuses keeps tasks in any of the given statuses; ListID keeps the tasks
+// of a list or, in an update, moves the task to it.
 type TodoPointers struct {
 	ID        *primitive.ObjectID
+	ListID    *primitive.ObjectID
 	Title     *string
 	Status    *string
+	Statuses  []string
 	ActiveAt  *ActiveAtPointers
 	CreatedAt *time.Time
 	UpdatedAt *time.Time
+	// DueBy keeps tasks active on or before the given time, on top of any ActiveAt comparison.
+	DueBy  *time.Time
+	Sort   []SortField
+	Fields []string
+	// Limit and Offset page through listings. A zero Limit returns every task.
+	Limit  int64
+	Offset int64
+}
+
+// SortField orders listings by an API field name from SortableFields.
+type SortField struct {
+	Field      string
+	Descending bool
 }
 
 type GetTodoDTO struct {
 	Title    string `json:"title"`
-	ActiveAt string `json:"activeAt"`
+	ActiveAt string `json:"activeAt" format:"date"`
+}
+
+type TodoDTO struct {
+	ID        string           `json:"id"`
+	ListID    string           `json:"listId"`
+	Title     string           `json:"title"`
+	Status    string           `json:"status"`
+	ActiveAt  string           `json:"activeAt" format:"date"`
+	DayType   calendar.DayType `json:"dayType,omitempty" enum:"WORKDAY,WEEKEND,HOLIDAY"`
+	CreatedAt time.Time        `json:"createdAt"`
+	UpdatedAt *time.Time       `json:"updatedAt,omitempty"`
 }
 
 type CreateTodoDTO struct {
 	Title    string `json:"title" validate:"required"`
-	ActiveAt string `json:"activeAt" validate:"required"`
+	ActiveAt string `json:"activeAt" validate:"required" format:"date"`
 }
 
 type UpdateTodoDTO struct {
 	ID       primitive.ObjectID `json:"id"`
 	Title    string             `json:"title" validate:"required"`
-	ActiveAt string             `json:"activeAt" validate:"required"`
+	ActiveAt string             `json:"activeAt" validate:"required" format:"date"`
+}
+
+// TransitionTodoDTO moves a task to Status, if the lifecycle allows it from the status the task is in. FromAnyOpen
+// lets any open task move regardless, for the v1 done endpoint whose clients only know open and done tasks.
+type TransitionTodoDTO struct {
+	ID          primitive.ObjectID `json:"id"`
+	Status      string             `json:"status" validate:"required" enum:"TODO,IN_PROGRESS,BLOCKED,DONE,CANCELLED"`
+	FromAnyOpen bool               `json:"-"`
+}
+
+// RescheduleTodosDTO moves active tasks due between From and To, both inclusive, by Days.
+type RescheduleTodosDTO struct {
+	From string `json:"from" validate:"required" format:"date"`
+	To   string `json:"to" validate:"required" format:"date"`
+	Days int    `json:"days" validate:"required"`
+}
+
+// BulkResult sums up an operation over many tasks. Skipped tasks already were in the requested state.
+type BulkResult struct {
+	Total     int         `json:"total"`
+	Succeeded int         `json:"succeeded"`
+	Skipped   int         `json:"skipped"`
+	Failed    int         `json:"failed"`
+	Errors    []BulkError `json:"errors,omitempty"`
+}
+
+// BulkError is the failure of one item, given by its index in the request or by task id.
+type BulkError struct {
+	Item   int    `json:"item"`
+	TodoID string `json:"todoId,omitempty"`
+	Code   string `json:"code"`
+	Detail string `json:"detail"`
 }
 
 type ActiveAtPointers struct {
@@ -47,25 +111,45 @@ type ActiveAtPointers struct {
 }
 
 type TodoRepository interface {
-	Create(todo *Todo) (*Todo, error)
-	FindByID(id primitive.ObjectID) (*Todo, error)
-	FindAll(pointers TodoPointers) ([]*Todo, error)
-	Update(upd TodoPointers) error
-	Delete(id primitive.ObjectID) error
+	Create(ctx context.Context, todo *Todo) (*Todo, error)
+	FindByID(ctx context.Context, id primitive.ObjectID) (*Todo, error)
+	FindAll(ctx context.Context, pointers TodoPointers) ([]*Todo, error)
+	// FindEach calls fn with the tasks matching pointers a batch at a time, so they are never all in memory.
+	FindEach(ctx context.Context, pointers TodoPointers, fn func(todos []*Todo) error) error
+	Update(ctx context.Context, upd TodoPointers) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	// Restore writes todo as is, keeping its id and timestamps. Used by the admin commands.
+	Restore(ctx context.Context, todo *Todo) error
+	EnsureIndexes(ctx context.Context) error
+	// Ping checks that the backing database is reachable.
+	Ping(ctx context.Context) error
+	CountByStatus(ctx context.Context) (map[string]int64, error)
 }
 
 type TodoService interface {
-	CreateTodo(todo *CreateTodoDTO) (*GetTodoDTO, error)
-	FindTodo(id primitive.ObjectID) (*GetTodoDTO, error)
-	FindTodos(pointers TodoPointers) ([]*GetTodoDTO, error)
-	UpdateTodo(upd UpdateTodoDTO) error
-	UpdateTodoStatus(upd TodoPointers) error
-	DeleteTodo(id primitive.ObjectID) error
+	CreateTodo(ctx context.Context, todo *CreateTodoDTO) (*TodoDTO, error)
+	FindTodo(ctx context.Context, id primitive.ObjectID) (*TodoDTO, error)
+	FindTodos(ctx context.Context, pointers TodoPointers) ([]*TodoDTO, error)
+	UpdateTodo(ctx context.Context, upd UpdateTodoDTO) error
+	UpdateTodoStatus(ctx context.Context, transition TransitionTodoDTO) error
+	DeleteTodo(ctx context.Context, id primitive.ObjectID) error
+	// ImportTodos and RescheduleTodos work through many tasks and report their progress, see ReportProgress.
+	ImportTodos(ctx context.Context, todos []CreateTodoDTO) (*BulkResult, error)
+	RescheduleTodos(ctx context.Context, reschedule RescheduleTodosDTO) (*BulkResult, error)
 }
 
 const (
+	APIVersion1 = "v1"
+	APIVersion2 = "v2"
+
+	StatusTodo       = "TODO"
+	StatusInProgress = "IN_PROGRESS"
+	StatusBlocked    = "BLOCKED"
+	StatusDone       = "DONE"
+	StatusCancelled  = "CANCELLED"
+	// StatusActive is the status open tasks had before TODO. As a listing filter it stands for the open tasks
+	// due by today.
 	StatusActive = "ACTIVE"
-	StatusDone   = "DONE"
 
 	ComparisonOperatorEQ  = "EQ"
 	ComparisonOperatorGT  = "GT"
@@ -81,6 +165,38 @@ var (
 	ErrNothingToUpdate           = errors.New("nothing to update.")
 	ErrTitleLengthLimitExceeded  = errors.New("title length limit exceeded.")
 	ErrInvalidDateFormat         = errors.New("invalid date format.")
+	ErrInvalidRequestBody        = errors.New("invalid request body.")
+	ErrInvalidTodoID             = errors.New("invalid todo id.")
+	ErrValidationFailed          = errors.New("validation failed.")
+	ErrInternal                  = errors.New("internal error.")
+	ErrInvalidSortField          = errors.New("invalid sort field.")
+	ErrInvalidProjectionField    = errors.New("invalid projection field.")
+	ErrInvalidTimeZone           = errors.New("invalid time zone.")
+	ErrInvalidTransition         = errors.New("invalid status transition.")
+	ErrInvalidTodoListID         = errors.New("invalid todo list id.")
+	ErrMutationNotAllowed        = errors.New("graphql mutations must be sent with POST.")
+	ErrTodoListNotFound          = errors.New("todo list not found.")
+	ErrTodoListAlreadyExists     = errors.New("todo list already exists.")
+	ErrTodoListArchived          = errors.New("todo list is archived.")
+	ErrTodoListNotEmpty          = errors.New("todo list is not empty.")
+	ErrDefaultTodoList           = errors.New("the default todo list can't be archived or deleted.")
+	ErrForbidden                 = errors.New("forbidden.")
+	ErrJobNotFound               = errors.New("job not found.")
+	ErrJobInterrupted            = errors.New("job interrupted.")
+	ErrReadModelBehind           = errors.New("read model is behind the requested consistency token.")
+	ErrShuttingDown              = errors.New("service is shutting down.")
+)
+
+var (
+	// Statuses lists the statuses of the lifecycle; OpenStatuses are the ones of tasks still to be done.
+	Statuses     = []string{StatusTodo, StatusInProgress, StatusBlocked, StatusDone, StatusCancelled}
+	OpenStatuses = []string{StatusTodo, StatusInProgress, StatusBlocked}
+	// StatusFilters are the values of the status filter of listings and of the change stream.
+	StatusFilters = append([]string{StatusActive}, Statuses...)
+
+	// SortableFields and ProjectableFields are the API field names accepted by the sort and fields parameters.
+	SortableFields    = []string{"title", "status", "activeAt", "createdAt", "updatedAt"}
+	ProjectableFields = []string{"id", "listId", "title", "status", "activeAt", "dayType", "createdAt", "updatedAt"}
 )
 
 func ToDateString(date time.Time) string {
@@ -96,4 +212,105 @@ func ToDateString(date time.Time) string {
 	day = day + strconv.Itoa(date.Day())
 	dateString := strconv.Itoa(date.Year()) + "-" + month + "-" + day
 	return dateString
-}
\ No newline at end of file
+}
+
+func NewTodoDTO(todo *Todo) *TodoDTO {
+	return &TodoDTO{
+		ID:        todo.ID.Hex(),
+		ListID:    todo.ListID.Hex(),
+		Title:     todo.Title,
+		Status:    todo.Status,
+		ActiveAt:  ToDateString(todo.ActiveAt),
+		CreatedAt: todo.CreatedAt,
+		UpdatedAt: todo.UpdatedAt,
+	}
+}
+
+// NewGetTodoDTO converts a task to the v1 response shape kept for existing consumers.
+func NewGetTodoDTO(todo *TodoDTO) *GetTodoDTO {
+	return &GetTodoDTO{
+		Title:    todo.Title,
+		ActiveAt: todo.ActiveAt,
+	}
+}
+
+// ParseSort parses a comma-separated list such as "activeAt,-title"; a leading "-" sorts descending.
+func ParseSort(sort string) ([]SortField, error) {
+	var result []SortField
+	for _, field := range strings.Split(sort, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		sortField := SortField{Field: strings.TrimPrefix(field, "-"), Descending: strings.HasPrefix(field, "-")}
+		if !containsField(SortableFields, sortField.Field) {
+			return nil, NewValidationError(ErrInvalidSortField, FieldError{
+				Field: "sort",
+				Rule:  "oneof",
+				Param: strings.Join(SortableFields, " "),
+			})
+		}
+		result = append(result, sortField)
+	}
+	return result, nil
+}
+
+// ParseFields parses a comma-separated projection such as "id,title,status".
+func ParseFields(fields string) ([]string, error) {
+	var result []string
+	for _, field := range strings.Split(fields, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if !containsField(ProjectableFields, field) {
+			return nil, NewValidationError(ErrInvalidProjectionField, FieldError{
+				Field: "fields",
+				Rule:  "oneof",
+				Param: strings.Join(ProjectableFields, " "),
+			})
+		}
+		if !containsField(result, field) {
+			result = append(result, field)
+		}
+	}
+	return result, nil
+}
+
+func containsField(fields []string, field string) bool {
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Project keeps only the requested fields of the task. An empty projection keeps all of them.
+func (todo *TodoDTO) Project(fields []string) interface{} {
+	if len(fields) == 0 {
+		return todo
+	}
+	result := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		switch field {
+		case "id":
+			result[field] = todo.ID
+		case "listId":
+			result[field] = todo.ListID
+		case "title":
+			result[field] = todo.Title
+		case "status":
+			result[field] = todo.Status
+		case "activeAt":
+			result[field] = todo.ActiveAt
+		case "dayType":
+			result[field] = todo.DayType
+		case "createdAt":
+			result[field] = todo.CreatedAt
+		case "updatedAt":
+			result[field] = todo.UpdatedAt
+		}
+	}
+	return result
+}
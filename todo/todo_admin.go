@@ -0,0 +1,217 @@
+package todo
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	seedActions = []string{"Купить", "Позвонить", "Написать", "Проверить", "Оплатить", "Забрать", "Подготовить", "Обсудить"}
+	seedObjects = []string{"книгу", "молоко", "отчёт", "счёт за свет", "посылку", "презентацию", "маме", "договор", "билеты", "код ревью"}
+)
+
+// Seed creates count random tasks within a month around today in the time zone of ctx. Collisions with existing tasks are skipped.
+func Seed(ctx context.Context, repository TodoRepository, count int, random *rand.Rand) (int, error) {
+	today := Today(ctx)
+	created := 0
+	for attempt := 0; created < count && attempt < count*10; attempt++ {
+		status := StatusTodo
+		if random.Intn(3) == 0 {
+			status = StatusDone
+		}
+		todo := &Todo{
+			ListID:   DefaultListID,
+			Title:    seedActions[random.Intn(len(seedActions))] + " " + seedObjects[random.Intn(len(seedObjects))],
+			Status:   status,
+			ActiveAt: today.AddDate(0, 0, random.Intn(61)-30),
+		}
+		if _, err := repository.Create(ctx, todo); err != nil {
+			if errors.Is(err, ErrTodoAlreadyExists) {
+				continue
+			}
+			return created, err
+		}
+		created++
+	}
+	return created, nil
+}
+
+// eachTodo calls fn with every task a batch at a time, in the order of their ids, which is the order they were
+// created in.
+func eachTodo(ctx context.Context, repository TodoRepository, fn func(todos []*Todo) error) error {
+	return repository.FindEach(ctx, TodoPointers{Sort: []SortField{{Field: "id"}}}, fn)
+}
+
+// Export writes every task as one JSON document per line, oldest first.
+func Export(ctx context.Context, repository TodoRepository, w io.Writer) (int, error) {
+	encoder := json.NewEncoder(w)
+	exported := 0
+	err := eachTodo(ctx, repository, func(todos []*Todo) error {
+		for _, todo := range todos {
+			if err := encoder.Encode(todo); err != nil {
+				return err
+			}
+			exported++
+		}
+		return nil
+	})
+	return exported, err
+}
+
+type ImportResult struct {
+	Imported int
+	// Skipped counts tasks that collide with another task's title and active date.
+	Skipped int
+}
+
+// Import restores tasks written by Export, keeping their ids. The whole input is validated before anything is written.
+func Import(ctx context.Context, repository TodoRepository, r io.Reader) (ImportResult, error) {
+	var result ImportResult
+	var todos []*Todo
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var todo Todo
+		if err := json.Unmarshal(scanner.Bytes(), &todo); err != nil {
+			return result, fmt.Errorf("line %d: %w", line, err)
+		}
+		if todo.Title == "" {
+			return result, fmt.Errorf("line %d: empty title", line)
+		}
+		// Exports of older versions have ACTIVE tasks.
+		todo.Status = upgradeStatus(todo.Status)
+		if !slices.Contains(Statuses, todo.Status) {
+			return result, fmt.Errorf("line %d: unknown status %q", line, todo.Status)
+		}
+		// Tasks exported before there were lists belong to the default one.
+		if todo.ListID.IsZero() {
+			todo.ListID = DefaultListID
+		}
+		if todo.ID.IsZero() {
+			todo.ID = primitive.NewObjectID()
+		}
+		if todo.CreatedAt.IsZero() {
+			todo.CreatedAt = todo.ID.Timestamp().UTC()
+		}
+		todos = append(todos, &todo)
+	}
+	if err := scanner.Err(); err != nil {
+		return result, err
+	}
+
+	for _, todo := range todos {
+		if err := repository.Restore(ctx, todo); err != nil {
+			if errors.Is(err, ErrTodoAlreadyExists) {
+				result.Skipped++
+				continue
+			}
+			return result, err
+		}
+		result.Imported++
+	}
+	return result, nil
+}
+
+const (
+	ProblemUnknownStatus    = "unknown status"
+	ProblemMissingCreatedAt = "missing created_at"
+	ProblemDuplicate        = "duplicate title and active_at in a list"
+)
+
+type DoctorFinding struct {
+	TodoID  primitive.ObjectID
+	Problem string
+	// Fix describes what was or, without fixing, would be done.
+	Fix string
+}
+
+// Doctor finds tasks with an unknown status, a missing created_at or a duplicate (list_id, title, active_at) and,
+// with fix, repairs them and recreates the unique index. Of duplicates the oldest task is kept.
+func Doctor(ctx context.Context, repository TodoRepository, fix bool) ([]DoctorFinding, error) {
+	var findings []DoctorFinding
+	// Of every task only its id and creation time are kept, to pick the duplicates.
+	groups := make(map[string][]*Todo)
+	err := eachTodo(ctx, repository, func(todos []*Todo) error {
+		for _, todo := range todos {
+			changed := false
+			if !slices.Contains(Statuses, todo.Status) {
+				status := upgradeStatus(strings.ToUpper(strings.TrimSpace(todo.Status)))
+				if !slices.Contains(Statuses, status) {
+					status = StatusTodo
+				}
+				findings = append(findings, DoctorFinding{
+					TodoID:  todo.ID,
+					Problem: fmt.Sprintf("%s %q", ProblemUnknownStatus, todo.Status),
+					Fix:     "set status " + status,
+				})
+				todo.Status = status
+				changed = true
+			}
+			if todo.CreatedAt.IsZero() {
+				todo.CreatedAt = todo.ID.Timestamp().UTC()
+				findings = append(findings, DoctorFinding{
+					TodoID:  todo.ID,
+					Problem: ProblemMissingCreatedAt,
+					Fix:     "set created_at from id " + todo.CreatedAt.Format(time.RFC3339),
+				})
+				changed = true
+			}
+			if changed && fix {
+				if err := repository.Restore(ctx, todo); err != nil {
+					return err
+				}
+			}
+			key := todo.ListID.Hex() + "\x00" + todo.Title + "\x00" + todo.ActiveAt.UTC().Format(time.RFC3339Nano)
+			groups[key] = append(groups[key], &Todo{ID: todo.ID, CreatedAt: todo.CreatedAt})
+		}
+		return nil
+	})
+	if err != nil {
+		return findings, err
+	}
+
+	var duplicates []DoctorFinding
+	for _, group := range groups {
+		if len(group) < 2 {
+			continue
+		}
+		sort.Slice(group, func(i, j int) bool {
+			return group[i].CreatedAt.Before(group[j].CreatedAt)
+		})
+		for _, todo := range group[1:] {
+			duplicates = append(duplicates, DoctorFinding{
+				TodoID:  todo.ID,
+				Problem: ProblemDuplicate,
+				Fix:     "delete, keeping " + group[0].ID.Hex(),
+			})
+		}
+	}
+	sort.Slice(duplicates, func(i, j int) bool {
+		return duplicates[i].TodoID.Hex() < duplicates[j].TodoID.Hex()
+	})
+	findings = append(findings, duplicates...)
+
+	if !fix {
+		return findings, nil
+	}
+	for _, duplicate := range duplicates {
+		if err := repository.Delete(ctx, duplicate.TodoID); err != nil && !errors.Is(err, ErrTodoNotFound) {
+			return findings, err
+		}
+	}
+	return findings, repository.EnsureIndexes(ctx)
+}
@@ -0,0 +1,238 @@
+package todo
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryTodoRepo keeps tasks in a map and enforces the (list_id, title, active_at) rule once indexed is set.
+type memoryTodoRepo struct {
+	todos   map[primitive.ObjectID]*Todo
+	indexed bool
+}
+
+func newMemoryTodoRepo(todos ...*Todo) *memoryTodoRepo {
+	repository := &memoryTodoRepo{todos: make(map[primitive.ObjectID]*Todo)}
+	for _, todo := range todos {
+		// Migrate puts tasks stored without a list in the default one.
+		if todo.ListID.IsZero() {
+			todo.ListID = DefaultListID
+		}
+		repository.todos[todo.ID] = todo
+	}
+	return repository
+}
+
+func (repository *memoryTodoRepo) conflicts(todo *Todo) bool {
+	if !repository.indexed {
+		return false
+	}
+	for _, existing := range repository.todos {
+		if existing.ID != todo.ID && existing.ListID == todo.ListID && existing.Title == todo.Title && existing.ActiveAt.Equal(todo.ActiveAt) {
+			return true
+		}
+	}
+	return false
+}
+
+func (repository *memoryTodoRepo) Create(ctx context.Context, todo *Todo) (*Todo, error) {
+	todo.ID = primitive.NewObjectID()
+	todo.CreatedAt = time.Now().UTC()
+	return todo, repository.Restore(ctx, todo)
+}
+
+func (repository *memoryTodoRepo) FindByID(ctx context.Context, id primitive.ObjectID) (*Todo, error) {
+	todo, found := repository.todos[id]
+	if !found {
+		return nil, ErrTodoNotFound
+	}
+	return todo, nil
+}
+
+func (repository *memoryTodoRepo) FindAll(ctx context.Context, pointers TodoPointers) ([]*Todo, error) {
+	todos := make([]*Todo, 0, len(repository.todos))
+	for _, todo := range repository.todos {
+		if pointers.ListID != nil && todo.ListID != *pointers.ListID {
+			continue
+		}
+		if pointers.Status != nil && todo.Status != *pointers.Status {
+			continue
+		}
+		if pointers.Statuses != nil && !slices.Contains(pointers.Statuses, todo.Status) {
+			continue
+		}
+		if pointers.DueBy != nil && todo.ActiveAt.After(*pointers.DueBy) {
+			continue
+		}
+		copied := *todo
+		todos = append(todos, &copied)
+	}
+	sort.Slice(todos, func(i, j int) bool {
+		return todos[i].ID.Hex() < todos[j].ID.Hex()
+	})
+	return todos, nil
+}
+
+func (repository *memoryTodoRepo) FindEach(ctx context.Context, pointers TodoPointers, fn func(todos []*Todo) error) error {
+	todos, err := repository.FindAll(ctx, pointers)
+	if err != nil || len(todos) == 0 {
+		return err
+	}
+	return fn(todos)
+}
+
+func (repository *memoryTodoRepo) Update(ctx context.Context, upd TodoPointers) error {
+	if upd.ListID == nil && upd.Title == nil && upd.Status == nil && upd.ActiveAt == nil {
+		return ErrNothingToUpdate
+	}
+	existing, found := repository.todos[*upd.ID]
+	if !found {
+		return ErrTodoNotFound
+	}
+	updated := *existing
+	if upd.ListID != nil {
+		updated.ListID = *upd.ListID
+	}
+	if upd.Title != nil {
+		updated.Title = *upd.Title
+	}
+	if upd.Status != nil {
+		updated.Status = *upd.Status
+	}
+	if upd.ActiveAt != nil {
+		updated.ActiveAt = *upd.ActiveAt.ActiveAt
+	}
+	updatedAt := time.Now().UTC()
+	updated.UpdatedAt = &updatedAt
+	return repository.Restore(ctx, &updated)
+}
+
+func (repository *memoryTodoRepo) Delete(ctx context.Context, id primitive.ObjectID) error {
+	if _, found := repository.todos[id]; !found {
+		return ErrTodoNotFound
+	}
+	delete(repository.todos, id)
+	return nil
+}
+
+func (repository *memoryTodoRepo) Restore(ctx context.Context, todo *Todo) error {
+	if repository.conflicts(todo) {
+		return ErrTodoAlreadyExists
+	}
+	copied := *todo
+	repository.todos[todo.ID] = &copied
+	return nil
+}
+
+func (repository *memoryTodoRepo) EnsureIndexes(ctx context.Context) error {
+	repository.indexed = true
+	for _, todo := range repository.todos {
+		if repository.conflicts(todo) {
+			return ErrTodoAlreadyExists
+		}
+	}
+	return nil
+}
+
+func (repository *memoryTodoRepo) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (repository *memoryTodoRepo) CountByStatus(ctx context.Context) (map[string]int64, error) {
+	counts := make(map[string]int64)
+	for _, todo := range repository.todos {
+		counts[todo.Status]++
+	}
+	return counts, nil
+}
+
+func TestDoctor(t *testing.T) {
+	activeAt := time.Date(2023, 8, 4, 0, 0, 0, 0, time.UTC)
+	createdAt := time.Date(2023, 8, 1, 0, 0, 0, 0, time.UTC)
+	first, _ := primitive.ObjectIDFromHex("64da1f106083a1acd4d8f111")
+	second, _ := primitive.ObjectIDFromHex("64da1f106083a1acd4d8f112")
+	third, _ := primitive.ObjectIDFromHex("64da1f106083a1acd4d8f113")
+	fourth, _ := primitive.ObjectIDFromHex("64da1f106083a1acd4d8f114")
+	newRepo := func() *memoryTodoRepo {
+		return newMemoryTodoRepo(
+			&Todo{ID: first, Title: "Купить книгу", Status: StatusTodo, ActiveAt: activeAt, CreatedAt: createdAt},
+			&Todo{ID: second, Title: "Купить книгу", Status: StatusDone, ActiveAt: activeAt, CreatedAt: createdAt.Add(time.Hour)},
+			&Todo{ID: third, Title: "Позвонить маме", Status: "done", ActiveAt: activeAt, CreatedAt: createdAt},
+			&Todo{ID: fourth, Title: "Оплатить счёт", Status: "PENDING", ActiveAt: activeAt},
+		)
+	}
+
+	repository := newRepo()
+	findings, err := Doctor(context.Background(), repository, false)
+	require.NoError(t, err)
+	require.Len(t, findings, 4)
+	require.Equal(t, third, findings[0].TodoID)
+	require.Equal(t, "set status DONE", findings[0].Fix)
+	require.Equal(t, fourth, findings[1].TodoID)
+	require.Equal(t, "set status TODO", findings[1].Fix)
+	require.Equal(t, ProblemMissingCreatedAt, findings[2].Problem)
+	require.Equal(t, second, findings[3].TodoID)
+	require.Equal(t, ProblemDuplicate, findings[3].Problem)
+	require.Equal(t, newRepo().todos, repository.todos, "без --fix ничего не меняется")
+
+	findings, err = Doctor(context.Background(), repository, true)
+	require.NoError(t, err)
+	require.Len(t, findings, 4)
+	require.True(t, repository.indexed)
+	require.Len(t, repository.todos, 3)
+	require.NotContains(t, repository.todos, second)
+	require.Equal(t, StatusDone, repository.todos[third].Status)
+	require.Equal(t, StatusTodo, repository.todos[fourth].Status)
+	require.Equal(t, fourth.Timestamp().UTC(), repository.todos[fourth].CreatedAt)
+
+	findings, err = Doctor(context.Background(), repository, false)
+	require.NoError(t, err)
+	require.Empty(t, findings)
+}
+
+func TestExportImport(t *testing.T) {
+	source := newMemoryTodoRepo()
+	created, err := Seed(context.Background(), source, 20, rand.New(rand.NewSource(1)))
+	require.NoError(t, err)
+	require.Equal(t, 20, created)
+
+	var exported bytes.Buffer
+	count, err := Export(context.Background(), source, &exported)
+	require.NoError(t, err)
+	require.Equal(t, 20, count)
+	require.Equal(t, 20, strings.Count(exported.String(), "\n"))
+
+	target := newMemoryTodoRepo()
+	target.indexed = true
+	result, err := Import(context.Background(), target, bytes.NewReader(exported.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, ImportResult{Imported: 20}, result)
+	for id, todo := range source.todos {
+		require.Equal(t, todo.Title, target.todos[id].Title)
+		require.True(t, todo.CreatedAt.Equal(target.todos[id].CreatedAt))
+		require.True(t, todo.ActiveAt.Equal(target.todos[id].ActiveAt))
+	}
+
+	legacy, _ := primitive.ObjectIDFromHex("64da1f106083a1acd4d8f111")
+	result, err = Import(context.Background(), target, strings.NewReader(`{"id":"64da1f106083a1acd4d8f111","title":"Купить книгу","status":"ACTIVE","activeAt":"2023-08-04T00:00:00Z"}
+{"title":"Купить книгу","status":"TODO","activeAt":"2023-08-04T00:00:00Z"}
+`))
+	require.NoError(t, err)
+	require.Equal(t, ImportResult{Imported: 1, Skipped: 1}, result)
+	require.Equal(t, StatusTodo, target.todos[legacy].Status, "ACTIVE из старых выгрузок становится TODO")
+
+	_, err = Import(context.Background(), target, strings.NewReader(`{"title":"Позвонить маме","status":"ACTIVE","activeAt":"2023-08-04T00:00:00Z"}
+{"title":"Оплатить счёт","status":"LATER","activeAt":"2023-08-04T00:00:00Z"}
+`))
+	require.EqualError(t, err, `line 2: unknown status "LATER"`)
+	require.Len(t, target.todos, 21, "ошибочный файл не импортируется частично")
+}
@@ -0,0 +1,98 @@
+package todo
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/kas2000/service-todo/calendar"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// BusinessCalendar tells working days from days off, e.g. *calendar.Calendar.
+type BusinessCalendar interface {
+	DayType(date time.Time) calendar.DayType
+	// Version changes whenever the day type of some date does.
+	Version() string
+}
+
+// dayTypePrefixes mark the titles of tasks on days off in v1 listings, which have no dayType.
+var dayTypePrefixes = map[string]map[string]string{
+	"en": {
+		"day-" + string(calendar.Weekend): "WEEKEND - ",
+		"day-" + string(calendar.Holiday): "HOLIDAY - ",
+	},
+	"ru": {
+		"day-" + string(calendar.Weekend): "ВЫХОДНОЙ - ",
+		"day-" + string(calendar.Holiday): "ПРАЗДНИК - ",
+	},
+}
+
+// withDayType sets the day type of todo by its date in cal, unless the read model already has.
+func withDayType(todo *TodoDTO, cal BusinessCalendar) *TodoDTO {
+	if todo.DayType != "" {
+		return todo
+	}
+	if activeAt, err := time.Parse(dateLayout, todo.ActiveAt); err == nil {
+		todo.DayType = cal.DayType(activeAt)
+	}
+	return todo
+}
+
+// calendarService sets the day type of the tasks it returns that don't come with one from the read model.
+type calendarService struct {
+	Service
+	calendar BusinessCalendar
+}
+
+func NewCalendarService(next Service, cal BusinessCalendar) Service {
+	return &calendarService{Service: next, calendar: cal}
+}
+
+func (service *calendarService) CreateTodo(ctx context.Context, createTodo *CreateTodoDTO) (*TodoDTO, error) {
+	todo, err := service.Service.CreateTodo(ctx, createTodo)
+	if err != nil {
+		return nil, err
+	}
+	return withDayType(todo, service.calendar), nil
+}
+
+func (service *calendarService) FindTodo(ctx context.Context, id primitive.ObjectID) (*TodoDTO, error) {
+	todo, err := service.Service.FindTodo(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return withDayType(todo, service.calendar), nil
+}
+
+func (service *calendarService) FindTodos(ctx context.Context, pointers TodoPointers) ([]*TodoDTO, error) {
+	// v1 listings mark the titles of tasks on days off, which takes their day types.
+	if containsField(pointers.Fields, "title") && !containsField(pointers.Fields, "dayType") {
+		pointers.Fields = append(append([]string{}, pointers.Fields...), "dayType")
+	}
+	todos, err := service.Service.FindTodos(ctx, pointers)
+	if err != nil {
+		return nil, err
+	}
+	for _, todo := range todos {
+		withDayType(todo, service.calendar)
+	}
+	return todos, nil
+}
+
+// dayTypePrefix returns the mark of a v1 listing title for dayType in the language of the request. Without an
+// Accept-Language header it is Russian, as it was before the mark was translated.
+func (factory *TodoHttp) dayTypePrefix(r *http.Request, dayType calendar.DayType) string {
+	if factory.uni == nil || dayType == "" || dayType == calendar.Workday {
+		return ""
+	}
+	trans, _ := factory.uni.GetTranslator("ru")
+	if r.Header.Get("Accept-Language") != "" {
+		trans = factory.translator(r)
+	}
+	prefix, err := trans.T("day-" + string(dayType))
+	if err != nil {
+		return ""
+	}
+	return prefix
+}
@@ -0,0 +1,100 @@
+package todo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/kas2000/service-todo/calendar"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+func TestCalendarService(t *testing.T) {
+	kz, err := calendar.Load(fstest.MapFS{"kz.yaml": {Data: []byte(`
+region: KZ
+holidays:
+  "2023-08-30": День Конституции
+workdays:
+  "2023-08-26": вместо 28 августа
+`)}}, "KZ")
+	require.NoError(t, err)
+	date := func(value string) time.Time {
+		parsed, _ := time.Parse(dateLayout, value)
+		return parsed
+	}
+	repository := newMemoryTodoRepo(
+		&Todo{ID: primitive.NewObjectID(), Title: "Купить книгу", Status: StatusDone, ActiveAt: date("2023-08-25")},
+		&Todo{ID: primitive.NewObjectID(), Title: "Позвонить маме", Status: StatusDone, ActiveAt: date("2023-08-26")},
+		&Todo{ID: primitive.NewObjectID(), Title: "Оплатить счёт", Status: StatusDone, ActiveAt: date("2023-08-27")},
+		&Todo{ID: primitive.NewObjectID(), Title: "Забрать посылку", Status: StatusDone, ActiveAt: date("2023-08-30")},
+	)
+	service := NewCalendarService(NewService(repository, newMemoryTodoListRepo(), zap.NewNop()), kz)
+	done := StatusDone
+
+	todos, err := service.FindTodos(context.Background(), TodoPointers{Status: &done})
+	require.NoError(t, err)
+	dayTypes := map[string]calendar.DayType{}
+	for _, todo := range todos {
+		dayTypes[todo.Title] = todo.DayType
+	}
+	require.Equal(t, map[string]calendar.DayType{
+		"Купить книгу":    calendar.Workday,
+		"Позвонить маме":  calendar.Workday,
+		"Оплатить счёт":   calendar.Weekend,
+		"Забрать посылку": calendar.Holiday,
+	}, dayTypes, "название задачи не меняется")
+
+	for id := range repository.todos {
+		todo, err := service.FindTodo(context.Background(), id)
+		require.NoError(t, err)
+		require.Equal(t, dayTypes[todo.Title], todo.DayType)
+	}
+}
+
+func TestDayTypePrefix(t *testing.T) {
+	weekend := &TodoDTO{ID: "64cd1d5c2b7f3a1e9c0d4e5f", Title: "Купить книгу", Status: StatusTodo, ActiveAt: "2023-08-05", DayType: calendar.Weekend}
+	workday := &TodoDTO{ID: "64cd1d5c2b7f3a1e9c0d4e60", Title: "Позвонить маме", Status: StatusTodo, ActiveAt: "2023-08-07", DayType: calendar.Workday}
+	todoHttp := NewTodoHttp(zap.NewNop(), &stubCommandHandler{resp: []*TodoDTO{weekend, workday}}, validator.New(), "todo-service")
+
+	testCases := []struct {
+		title          string
+		apiVersion     string
+		acceptLanguage string
+		expected       interface{}
+	}{
+		{
+			title:      "v1 по умолчанию на русском",
+			apiVersion: APIVersion1,
+			expected:   []*GetTodoDTO{{Title: "ВЫХОДНОЙ - Купить книгу", ActiveAt: "2023-08-05"}, {Title: "Позвонить маме", ActiveAt: "2023-08-07"}},
+		},
+		{
+			title:          "v1 на английском",
+			apiVersion:     APIVersion1,
+			acceptLanguage: "en-US,en;q=0.9",
+			expected:       []*GetTodoDTO{{Title: "WEEKEND - Купить книгу", ActiveAt: "2023-08-05"}, {Title: "Позвонить маме", ActiveAt: "2023-08-07"}},
+		},
+		{
+			title:      "v2 отдаёт dayType вместо пометки",
+			apiVersion: APIVersion2,
+			expected:   []*TodoDTO{weekend, workday},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.title, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/todo-list/tasks", nil)
+			if tc.acceptLanguage != "" {
+				req.Header.Set("Accept-Language", tc.acceptLanguage)
+			}
+			resp := todoHttp.WithAPIVersion(tc.apiVersion).FindTodos()(httptest.NewRecorder(), req)
+			require.Equal(t, http.StatusOK, resp.StatusCode())
+			require.Equal(t, tc.expected, resp.Response())
+		})
+	}
+}
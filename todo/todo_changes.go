@@ -0,0 +1,354 @@
+package todo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kas2000/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+const (
+	ChangeCreated = "created"
+	ChangeUpdated = "updated"
+	ChangeDone    = "done"
+	ChangeDeleted = "deleted"
+
+	changeLogCollection = "todo_changes"
+	changeLogSizeBytes  = 64 << 20
+	changeLogRetryWait  = time.Second
+	// changeLogGapWait is how long a change is awaited once later ones have been read. Writers append right after
+	// taking a seq, so a change missing for longer was lost by its writer.
+	changeLogGapWait = 10 * time.Second
+	// changeLogAppendAttempts bounds the appends of one change, all with the same seq.
+	changeLogAppendAttempts = 3
+	changeLogAppendBackoff  = 50 * time.Millisecond
+)
+
+// ErrChangeLogGap reports a change that was never appended although later ones were.
+var ErrChangeLogGap = errors.New("todo change log has a gap")
+
+// TodoChange is one entry of the change log. Seq orders entries across all service instances.
+type TodoChange struct {
+	Seq        int64              `json:"seq" bson:"seq"`
+	Type       string             `json:"type" bson:"type"`
+	TodoID     primitive.ObjectID `json:"todoId" bson:"todo_id"`
+	Status     string             `json:"-" bson:"status"`
+	Todo       *Todo              `json:"-" bson:"todo"`
+	OccurredAt time.Time          `json:"occurredAt" bson:"occurred_at"`
+}
+
+type ChangeFilter struct {
+	// After skips changes up to and including this sequence number.
+	After int64
+	// Statuses keeps the changes leaving a task in one of them.
+	Statuses []string
+	// ListID keeps the changes leaving a task in the list, all changes when nil.
+	ListID *primitive.ObjectID
+	// StopAtGap makes Tail fail with ErrChangeLogGap on a lost change instead of skipping it.
+	StopAtGap bool
+}
+
+func (filter ChangeFilter) matches(change *TodoChange) bool {
+	if filter.ListID != nil && (change.Todo == nil || listOf(change.Todo) != *filter.ListID) {
+		return false
+	}
+	if filter.Statuses == nil {
+		return true
+	}
+	for _, status := range filter.Statuses {
+		if change.Status == status {
+			return true
+		}
+	}
+	return false
+}
+
+type ChangeLog interface {
+	// Append takes the next seq for change, unless it already has one from a failed append, and writes it.
+	Append(ctx context.Context, change *TodoChange) error
+	// Tail calls fn for every change matching the filter in the order of their seq until ctx is done or fn fails.
+	Tail(ctx context.Context, filter ChangeFilter, fn func(change *TodoChange) error) error
+	// Latest returns the sequence number of the last change appended, 0 if there is none.
+	Latest(ctx context.Context) (int64, error)
+}
+
+type changeLog struct {
+	collection *mongo.Collection
+	counters   *mongo.Collection
+}
+
+// NewChangeLog stores changes in a capped collection so every instance can tail the same log.
+// The collection is created by Migrate.
+func NewChangeLog(db *mongo.Database) ChangeLog {
+	return &changeLog{
+		collection: db.Collection(changeLogCollection),
+		counters:   db.Collection("counters"),
+	}
+}
+
+func (changes *changeLog) nextSeq(ctx context.Context) (int64, error) {
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	err := changes.counters.FindOneAndUpdate(ctx,
+		bson.D{{Key: "_id", Value: "todo_changes"}},
+		bson.D{{Key: "$inc", Value: bson.D{{Key: "seq", Value: int64(1)}}}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	return counter.Seq, err
+}
+
+func (changes *changeLog) Latest(ctx context.Context) (int64, error) {
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	err := changes.counters.FindOne(ctx, bson.D{{Key: "_id", Value: "todo_changes"}}).Decode(&counter)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, nil
+	}
+	return counter.Seq, err
+}
+
+func (changes *changeLog) Append(ctx context.Context, change *TodoChange) error {
+	if change.Seq == 0 {
+		seq, err := changes.nextSeq(ctx)
+		if err != nil {
+			return err
+		}
+		change.Seq = seq
+		change.OccurredAt = time.Now().UTC()
+	}
+	_, err := changes.collection.InsertOne(ctx, change)
+	if mongo.IsDuplicateKeyError(err) {
+		// An earlier attempt was written although it failed.
+		return nil
+	}
+	return err
+}
+
+func (changes *changeLog) Tail(ctx context.Context, filter ChangeFilter, fn func(change *TodoChange) error) error {
+	return tailChanges(ctx, filter, changeLogGapWait, changes.find, fn)
+}
+
+// find opens a tailable cursor on the changes after seq, in the order they were written.
+func (changes *changeLog) find(ctx context.Context, after int64) (changeCursor, error) {
+	query := bson.D{{Key: "seq", Value: bson.D{{Key: "$gt", Value: after}}}}
+	opts := options.Find().SetCursorType(options.TailableAwait).SetMaxAwaitTime(changeLogRetryWait)
+	return changes.collection.Find(ctx, query, opts)
+}
+
+// changeCursor is the part of *mongo.Cursor that tailChanges reads with.
+type changeCursor interface {
+	TryNext(ctx context.Context) bool
+	Decode(val interface{}) error
+	Err() error
+	ID() int64
+	Close(ctx context.Context) error
+}
+
+// tailChanges calls fn for the changes after filter.After in the order of their seq. Writers take a seq before they
+// write their change, so two of them can write in the opposite order: a change read early is held back until the
+// changes before it have been read, and a change missing for longer than gapWait is taken for lost.
+func tailChanges(ctx context.Context, filter ChangeFilter, gapWait time.Duration, find func(ctx context.Context, after int64) (changeCursor, error), fn func(change *TodoChange) error) error {
+	after := filter.After
+	pending := make(map[int64]*TodoChange)
+	var missing int64
+	var missingSince time.Time
+
+	deliver := func() error {
+		for {
+			change, found := pending[after+1]
+			if !found {
+				return nil
+			}
+			delete(pending, after+1)
+			after = change.Seq
+			if filter.matches(change) {
+				if err := fn(change); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	checkGap := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		if missing != after+1 {
+			missing, missingSince = after+1, time.Now()
+			return nil
+		}
+		if time.Since(missingSince) < gapWait {
+			return nil
+		}
+		if filter.StopAtGap {
+			return fmt.Errorf("%w: change %d is missing", ErrChangeLogGap, missing)
+		}
+		next := int64(0)
+		for seq := range pending {
+			if next == 0 || seq < next {
+				next = seq
+			}
+		}
+		after = next - 1
+		return deliver()
+	}
+
+	for {
+		cursor, err := find(ctx, after)
+		if err != nil {
+			return err
+		}
+		for err == nil {
+			if cursor.TryNext(ctx) {
+				var change TodoChange
+				if err = cursor.Decode(&change); err == nil && change.Seq > after {
+					pending[change.Seq] = &change
+					err = deliver()
+				}
+				continue
+			}
+			if err = cursor.Err(); err != nil || cursor.ID() == 0 {
+				break
+			}
+			err = checkGap()
+		}
+		cursor.Close(context.TODO())
+		if err != nil {
+			return err
+		}
+		// A tailable cursor dies when nothing matched yet; wait and query again from the last change delivered.
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(changeLogRetryWait):
+		}
+		if err := checkGap(); err != nil {
+			return err
+		}
+	}
+}
+
+// changeLoggingRepo appends a change for every successful write of the wrapped repository.
+type changeLoggingRepo struct {
+	TodoRepository
+	changes ChangeLog
+	log     logger.Logger
+}
+
+func NewChangeLoggingRepo(todoRepo TodoRepository, changes ChangeLog, log logger.Logger) TodoRepository {
+	return &changeLoggingRepo{TodoRepository: todoRepo, changes: changes, log: log}
+}
+
+// append logs the change of a write that is already committed, so it never fails the write. The change is retried
+// with the seq of the first attempt, so a failed attempt leaves no gap, and once the write has to return it is retried
+// in the background. A change that is never appended after taking a seq leaves a gap, which makes the projector
+// rebuild the read model from the tasks, which hold the write.
+func (repository *changeLoggingRepo) append(ctx context.Context, changeType string, todo *Todo) {
+	// The background retries must not see the caller change the task.
+	copied := *todo
+	change := &TodoChange{
+		Type:   changeType,
+		TodoID: todo.ID,
+		Status: todo.Status,
+		Todo:   &copied,
+	}
+	var err error
+	for attempt := 1; attempt <= changeLogAppendAttempts; attempt++ {
+		if err = repository.changes.Append(ctx, change); err == nil {
+			recordConsistencyToken(ctx, change.Seq)
+			return
+		}
+		if attempt < changeLogAppendAttempts && !sleep(ctx, changeLogAppendBackoff*time.Duration(attempt)) {
+			break
+		}
+	}
+	LoggerFromContext(ctx, repository.log).Warn("couldn't append todo change, retrying in the background",
+		zap.String("type", changeType), zap.String("todo_id", todo.ID.Hex()), zap.Error(err))
+	go repository.appendLater(context.WithoutCancel(ctx), change)
+}
+
+// appendLater retries change until it is appended or, once it has a seq, the projector has taken it for lost.
+func (repository *changeLoggingRepo) appendLater(ctx context.Context, change *TodoChange) {
+	log := LoggerFromContext(ctx, repository.log)
+	for attempt := changeLogAppendAttempts; ; attempt++ {
+		sleep(ctx, min(changeLogAppendBackoff*time.Duration(attempt), changeLogRetryWait))
+		if change.Seq != 0 && time.Since(change.OccurredAt) > changeLogGapWait {
+			log.Warn("gave up on todo change, the read model is rebuilt at its gap",
+				zap.Int64("seq", change.Seq), zap.String("todo_id", change.TodoID.Hex()))
+			return
+		}
+		if err := repository.changes.Append(ctx, change); err == nil {
+			log.Info("appended todo change late", zap.Int64("seq", change.Seq), zap.String("todo_id", change.TodoID.Hex()))
+			return
+		}
+	}
+}
+
+// sleep waits for d and reports whether ctx was still running by then.
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+func (repository *changeLoggingRepo) Create(ctx context.Context, todo *Todo) (*Todo, error) {
+	result, err := repository.TodoRepository.Create(ctx, todo)
+	if err != nil {
+		return nil, err
+	}
+	repository.append(ctx, ChangeCreated, result)
+	return result, nil
+}
+
+func (repository *changeLoggingRepo) Update(ctx context.Context, upd TodoPointers) error {
+	if err := repository.TodoRepository.Update(ctx, upd); err != nil {
+		return err
+	}
+	todo, err := repository.TodoRepository.FindByID(ctx, *upd.ID)
+	if err != nil {
+		return err
+	}
+	changeType := ChangeUpdated
+	if upd.Status != nil && *upd.Status == StatusDone {
+		changeType = ChangeDone
+	}
+	repository.append(ctx, changeType, todo)
+	return nil
+}
+
+func (repository *changeLoggingRepo) Delete(ctx context.Context, id primitive.ObjectID) error {
+	todo, err := repository.TodoRepository.FindByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := repository.TodoRepository.Delete(ctx, id); err != nil {
+		return err
+	}
+	repository.append(ctx, ChangeDeleted, todo)
+	return nil
+}
+
+func (repository *changeLoggingRepo) Restore(ctx context.Context, todo *Todo) error {
+	changeType := ChangeUpdated
+	if _, err := repository.TodoRepository.FindByID(ctx, todo.ID); errors.Is(err, ErrTodoNotFound) {
+		changeType = ChangeCreated
+	} else if err != nil {
+		return err
+	}
+	if err := repository.TodoRepository.Restore(ctx, todo); err != nil {
+		return err
+	}
+	repository.append(ctx, changeType, todo)
+	return nil
+}
@@ -1,49 +1,80 @@
 package todo
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CommandContext carries the request context through commandlib, whose Execute has no context parameter.
+// Command handler decorators may replace it, e.g. with a context holding their span.
+type CommandContext struct {
+	ctx context.Context
+}
+
+func NewCommandContext(ctx context.Context) CommandContext {
+	return CommandContext{ctx: ctx}
+}
+
+func (cmd *CommandContext) Context() context.Context {
+	if cmd.ctx == nil {
+		return context.Background()
+	}
+	return cmd.ctx
+}
+
+func (cmd *CommandContext) SetContext(ctx context.Context) {
+	cmd.ctx = ctx
+}
 
 type CreateTodoCommand struct {
+	CommandContext
 	*CreateTodoDTO
 }
 
 func (cmd *CreateTodoCommand) Execute(svc interface{}) (interface{}, error) {
-	return svc.(Service).CreateTodo(cmd.CreateTodoDTO)
+	return svc.(Service).CreateTodo(cmd.Context(), cmd.CreateTodoDTO)
 }
 
 type FindTodoCommand struct {
+	CommandContext
 	ID primitive.ObjectID
 }
 
 func (cmd *FindTodoCommand) Execute(svc interface{}) (interface{}, error) {
-	return svc.(Service).FindTodo(cmd.ID)
+	return svc.(Service).FindTodo(cmd.Context(), cmd.ID)
 }
 
 type FindTodosCommand struct {
+	CommandContext
 	TodoPointers
 }
 
 func (cmd *FindTodosCommand) Execute(svc interface{}) (interface{}, error) {
-	return svc.(Service).FindTodos(cmd.TodoPointers)
+	return svc.(Service).FindTodos(cmd.Context(), cmd.TodoPointers)
 }
 
 type DeleteTodoCommand struct {
+	CommandContext
 	ID primitive.ObjectID
 }
 
 func (cmd *DeleteTodoCommand) Execute(svc interface{}) (interface{}, error) {
-	err := svc.(Service).DeleteTodo(cmd.ID)
+	err := svc.(Service).DeleteTodo(cmd.Context(), cmd.ID)
 	if err != nil {
 		return nil, err
 	}
 	return nil, nil
 }
 
+// UpdateTodoStatusCommand moves a task to any status the lifecycle allows, see Transitions.
 type UpdateTodoStatusCommand struct {
-	TodoPointers
+	CommandContext
+	TransitionTodoDTO
 }
 
 func (cmd *UpdateTodoStatusCommand) Execute(svc interface{}) (interface{}, error) {
-	err := svc.(Service).UpdateTodoStatus(cmd.TodoPointers)
+	err := svc.(Service).UpdateTodoStatus(cmd.Context(), cmd.TransitionTodoDTO)
 	if err != nil {
 		return nil, err
 	}
@@ -51,13 +82,124 @@ func (cmd *UpdateTodoStatusCommand) Execute(svc interface{}) (interface{}, error
 }
 
 type UpdateTodoCommand struct {
+	CommandContext
 	UpdateTodoDTO
 }
 
 func (cmd *UpdateTodoCommand) Execute(svc interface{}) (interface{}, error) {
-	err := svc.(Service).UpdateTodo(cmd.UpdateTodoDTO)
+	err := svc.(Service).UpdateTodo(cmd.Context(), cmd.UpdateTodoDTO)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// ImportTodosCommand creates many tasks; running it again skips the ones already created, so it can resume.
+type ImportTodosCommand struct {
+	CommandContext
+	Todos []CreateTodoDTO `json:"todos" validate:"required,min=1,dive"`
+}
+
+func (cmd *ImportTodosCommand) Execute(svc interface{}) (interface{}, error) {
+	return svc.(Service).ImportTodos(cmd.Context(), cmd.Todos)
+}
+
+func (cmd *ImportTodosCommand) Resumable() bool {
+	return true
+}
+
+// RescheduleTodosCommand moves tasks by a number of days. It isn't resumable: tasks moved before an interruption
+// would move twice.
+type RescheduleTodosCommand struct {
+	CommandContext
+	RescheduleTodosDTO
+}
+
+func (cmd *RescheduleTodosCommand) Execute(svc interface{}) (interface{}, error) {
+	return svc.(Service).RescheduleTodos(cmd.Context(), cmd.RescheduleTodosDTO)
+}
+
+func (cmd *RescheduleTodosCommand) Resumable() bool {
+	return false
+}
+
+// MoveTodoCommand moves a task of the list of its context to another list.
+type MoveTodoCommand struct {
+	CommandContext
+	MoveTodoDTO
+}
+
+func (cmd *MoveTodoCommand) Execute(svc interface{}) (interface{}, error) {
+	err := svc.(Service).MoveTodo(cmd.Context(), cmd.MoveTodoDTO)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+type CreateTodoListCommand struct {
+	CommandContext
+	*CreateTodoListDTO
+}
+
+func (cmd *CreateTodoListCommand) Execute(svc interface{}) (interface{}, error) {
+	return svc.(Service).CreateTodoList(cmd.Context(), cmd.CreateTodoListDTO)
+}
+
+type FindTodoListCommand struct {
+	CommandContext
+	ID primitive.ObjectID
+}
+
+func (cmd *FindTodoListCommand) Execute(svc interface{}) (interface{}, error) {
+	return svc.(Service).FindTodoList(cmd.Context(), cmd.ID)
+}
+
+type FindTodoListsCommand struct {
+	CommandContext
+}
+
+func (cmd *FindTodoListsCommand) Execute(svc interface{}) (interface{}, error) {
+	return svc.(Service).FindTodoLists(cmd.Context())
+}
+
+type RenameTodoListCommand struct {
+	CommandContext
+	RenameTodoListDTO
+}
+
+func (cmd *RenameTodoListCommand) Execute(svc interface{}) (interface{}, error) {
+	err := svc.(Service).RenameTodoList(cmd.Context(), cmd.RenameTodoListDTO)
 	if err != nil {
 		return nil, err
 	}
 	return nil, nil
-}
\ No newline at end of file
+}
+
+// ArchiveTodoListCommand archives a list or, with Archived false, restores it.
+type ArchiveTodoListCommand struct {
+	CommandContext
+	ID       primitive.ObjectID
+	Archived bool
+}
+
+func (cmd *ArchiveTodoListCommand) Execute(svc interface{}) (interface{}, error) {
+	err := svc.(Service).ArchiveTodoList(cmd.Context(), cmd.ID, cmd.Archived)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+type DeleteTodoListCommand struct {
+	CommandContext
+	ID primitive.ObjectID
+}
+
+func (cmd *DeleteTodoListCommand) Execute(svc interface{}) (interface{}, error) {
+	err := svc.(Service).DeleteTodoList(cmd.Context(), cmd.ID)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
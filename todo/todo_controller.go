@@ -1,27 +1,321 @@
 package todo
 
-import "github.com/kas2000/http"
+import (
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	httpLib "github.com/kas2000/http"
+)
+
+// Route is an endpoint together with its description in the OpenAPI document.
+type Route struct {
+	Method   string
+	Path     string
+	Endpoint httpLib.Endpoint
+	Doc      OperationDoc
+}
 
 type todoController struct {
-	server *http.Server
-	http   *TodoHttp
-	prefix string
+	server  *httpLib.Server
+	http    *TodoHttp
+	graphql *TodoGraphQL
+	stream  *TodoStream
+	prefix  string
+	openapi *openapi3.T
+	// validation is one of ValidateNothing, ValidateRequests and ValidateAll.
+	validation string
 }
 
-func NewTodoController(server *http.Server, http *TodoHttp, prefix string) *todoController {
+func NewTodoController(server *httpLib.Server, http *TodoHttp, graphql *TodoGraphQL, stream *TodoStream, prefix string) *todoController {
 	return &todoController{
-		server: server,
-		http:   http,
-		prefix: prefix,
+		server:     server,
+		http:       http,
+		graphql:    graphql,
+		stream:     stream,
+		prefix:     prefix,
+		validation: ValidateNothing,
 	}
 }
 
-func (tc *todoController) Bind() {
+// WithValidation checks requests, and responses with ValidateAll, against the OpenAPI document.
+func (tc *todoController) WithValidation(validation string) *todoController {
+	tc.validation = validation
+	return tc
+}
+
+// Bind registers the routes and describes them in the document served at /openapi.json. It fails when a route
+// isn't documented.
+func (tc *todoController) Bind() error {
+	routes := tc.Routes()
+	doc, err := NewOpenAPI(tc.prefix, routes)
+	if err != nil {
+		return err
+	}
+	tc.openapi = doc
+
 	srvr := *tc.server
-	srvr.Handle("POST", tc.prefix+"/todo-list/tasks", tc.http.CreateTodo())
-	srvr.Handle("GET", tc.prefix+"/todo-list/tasks", tc.http.FindTodos())
-	srvr.Handle("GET", tc.prefix+"/todo-list/tasks/{id}", tc.http.FindTodo("id"))
-	srvr.Handle("PUT", tc.prefix+"/todo-list/tasks/{id}", tc.http.UpdateTodo("id"))
-	srvr.Handle("PUT", tc.prefix+"/todo-list/tasks/{id}/done", tc.http.SetTodoStatusDone("id"))
-	srvr.Handle("DELETE", tc.prefix+"/todo-list/tasks/{id}", tc.http.DeleteTodo("id"))
+	for _, route := range routes {
+		endpoint := route.Endpoint
+		if tc.validation != ValidateNothing {
+			endpoint = tc.validateRoute(doc, route, tc.validation == ValidateAll)
+		}
+		srvr.Handle(route.Method, tc.prefix+route.Path, endpoint)
+	}
+	return nil
+}
+
+// Routes lists the routes relative to the prefix. Unversioned routes serve the version of the given TodoHttp, v1
+// unless configured.
+func (tc *todoController) Routes() []Route {
+	var routes []Route
+	routes = append(routes, tc.taskRoutes("", legacyTaskListPath, tc.http)...)
+	routes = append(routes, tc.taskRoutes(APIVersion1, legacyTaskListPath, tc.http.WithAPIVersion(APIVersion1))...)
+	routes = append(routes, tc.taskRoutes(APIVersion2, legacyTaskListPath, tc.http.WithAPIVersion(APIVersion2))...)
+	// Lists are new in v2, so they have no unversioned or v1 routes.
+	routes = append(routes, tc.listRoutes(APIVersion2, tc.http.WithAPIVersion(APIVersion2))...)
+	routes = append(routes, tc.taskRoutes(APIVersion2, taskListPath, tc.http.WithAPIVersion(APIVersion2))...)
+
+	graphqlDoc := OperationDoc{
+		Summary:   "Run a GraphQL query or mutation",
+		Tag:       "graphql",
+		Body:      graphqlRequest{},
+		Responses: []ResponseDoc{{Status: http.StatusOK, Body: map[string]interface{}{}}},
+	}
+	graphqlPost, graphqlGet := graphqlDoc, graphqlDoc
+	graphqlPost.ID, graphqlPost.BodyRequired = "postGraphQL", true
+	graphqlGet.ID, graphqlGet.Body = "getGraphQL", nil
+	graphqlGet.Summary, graphqlGet.Errors = "Run a GraphQL query", []error{ErrMutationNotAllowed}
+	graphqlGet.Query = []ParameterDoc{
+		{Name: "query", Required: true},
+		{Name: "operationName"},
+		{Name: "variables", Description: "JSON object"},
+	}
+
+	return append(routes,
+		zonedRoute(tc.http, Route{Method: "POST", Path: "/graphql", Endpoint: tc.graphql.Endpoint(), Doc: graphqlPost}),
+		zonedRoute(tc.http, Route{Method: "GET", Path: "/graphql", Endpoint: tc.graphql.Endpoint(), Doc: graphqlGet}),
+		Route{Method: "GET", Path: "/openapi.json", Endpoint: tc.OpenAPIEndpoint(), Doc: OperationDoc{
+			ID: "getOpenAPI", Summary: "This document", Tag: "docs",
+			Responses: []ResponseDoc{{Status: http.StatusOK, Body: map[string]interface{}{}}},
+		}},
+		Route{Method: "GET", Path: "/docs", Endpoint: OpenAPIDocsEndpoint(), Doc: OperationDoc{
+			ID: "getDocs", Summary: "Page rendering this document", Tag: "docs",
+			Responses: []ResponseDoc{{Status: http.StatusOK, Body: "", ContentType: "text/html"}},
+		}},
+		Route{Method: "GET", Path: "/docs/swagger-ui.css", Endpoint: OpenAPIDocsAssetEndpoint("swagger-ui.css"), Doc: OperationDoc{
+			ID: "getDocsStyles", Summary: "Styles of the documentation page", Tag: "docs",
+			Responses: []ResponseDoc{{Status: http.StatusOK, Body: "", ContentType: "text/css"}},
+		}},
+		Route{Method: "GET", Path: "/docs/swagger-ui-bundle.js", Endpoint: OpenAPIDocsAssetEndpoint("swagger-ui-bundle.js"), Doc: OperationDoc{
+			ID: "getDocsScript", Summary: "Script of the documentation page", Tag: "docs",
+			Responses: []ResponseDoc{{Status: http.StatusOK, Body: "", ContentType: "text/javascript"}},
+		}},
+	)
+}
+
+// taskRoutes lists the routes of the tasks of list, legacyTaskListPath for the default list or taskListPath for
+// the one of the listId path parameter.
+func (tc *todoController) taskRoutes(version string, list string, todoHttp *TodoHttp) []Route {
+	prefix, suffix := "", ""
+	if version != "" {
+		prefix, suffix = "/"+version, strings.ToUpper(version[:1])+version[1:]
+	}
+	legacy := list == legacyTaskListPath
+	if !legacy {
+		suffix = "InList" + suffix
+	}
+	prefix += list
+	var todo, todos interface{} = TodoDTO{}, []TodoDTO{}
+	if todoHttp.apiVersion == APIVersion1 {
+		todo, todos = GetTodoDTO{}, []GetTodoDTO{}
+	}
+	created := ResponseDoc{Status: http.StatusCreated, Body: todo, Headers: []string{"Location", ConsistencyTokenHeader}}
+	if todoHttp.apiVersion == APIVersion1 {
+		created = ResponseDoc{Status: http.StatusNoContent, Headers: []string{ConsistencyTokenHeader}}
+	}
+	written := ResponseDoc{Status: http.StatusNoContent, Headers: []string{ConsistencyTokenHeader}}
+	consistencyToken := ParameterDoc{Name: ConsistencyTokenHeader, Description: "Waits until the writes that returned the token are visible"}
+	status := ParameterDoc{Name: "status", Description: "ACTIVE stands for the open tasks due by today", Enum: append([]string{}, StatusFilters...)}
+	for _, filter := range StatusFilters {
+		status.Enum = append(status.Enum, strings.ToLower(filter))
+	}
+	accepted := ResponseDoc{Status: http.StatusAccepted, Body: JobDTO{}, Headers: []string{"Location"}}
+
+	routes := []Route{{
+		Method: "POST", Path: prefix + "/tasks", Endpoint: todoHttp.CreateTodo(), Doc: OperationDoc{
+			ID: "createTodo" + suffix, Summary: "Create a task", Tag: "tasks",
+			Body: CreateTodoDTO{}, BodyRequired: true,
+			Responses: []ResponseDoc{created},
+			Errors:    []error{ErrInvalidRequestBody, ErrValidationFailed, ErrTitleLengthLimitExceeded, ErrInvalidDateFormat, ErrTodoAlreadyExists, ErrTodoListNotFound, ErrTodoListArchived},
+		},
+	}}
+	if todoHttp.jobs != nil {
+		routes = append(routes, Route{
+			Method: "POST", Path: prefix + "/tasks/import", Endpoint: todoHttp.ImportTodos(), Doc: OperationDoc{
+				ID: "importTodos" + suffix, Summary: "Create many tasks in a background job", Tag: "jobs",
+				Body: ImportTodosCommand{}, BodyRequired: true,
+				Responses: []ResponseDoc{accepted},
+				Errors:    []error{ErrInvalidRequestBody, ErrValidationFailed},
+			},
+		}, Route{
+			Method: "POST", Path: prefix + "/tasks/reschedule", Endpoint: todoHttp.RescheduleTodos(), Doc: OperationDoc{
+				ID: "rescheduleTodos" + suffix, Summary: "Move active tasks by a number of days in a background job", Tag: "jobs",
+				Body: RescheduleTodosDTO{}, BodyRequired: true,
+				Responses: []ResponseDoc{accepted},
+				Errors:    []error{ErrInvalidRequestBody, ErrValidationFailed},
+			},
+		}, Route{
+			Method: "GET", Path: prefix + "/jobs/{id}", Endpoint: todoHttp.FindJob("id"), Doc: OperationDoc{
+				ID: "findJob" + suffix, Summary: "Get a job", Tag: "jobs",
+				Responses: []ResponseDoc{{Status: http.StatusOK, Body: JobDTO{}}},
+				Errors:    []error{ErrJobNotFound},
+			},
+		})
+	}
+	routes = append(routes, Route{
+		Method: "GET", Path: prefix + "/tasks", Endpoint: todoHttp.FindTodos(), Doc: OperationDoc{
+			ID: "findTodos" + suffix, Summary: "List tasks, active ones by default", Tag: "tasks",
+			Query: []ParameterDoc{
+				status,
+				{Name: "title", Description: "Exact title"},
+				{Name: "sort", Description: "Comma-separated fields, descending with a leading minus: " + strings.Join(SortableFields, ", ")},
+				{Name: "fields", Description: "Comma-separated fields to return: " + strings.Join(ProjectableFields, ", ")},
+			},
+			Headers:   []ParameterDoc{consistencyToken},
+			Responses: []ResponseDoc{{Status: http.StatusOK, Body: todos}},
+			Errors:    []error{ErrInvalidSortField, ErrInvalidProjectionField, ErrValidationFailed, ErrReadModelBehind},
+		},
+	})
+	routes = append(routes, Route{
+		// Registered before {id} so that "stream" is not taken for a task id.
+		Method: "GET", Path: prefix + "/tasks/stream", Endpoint: tc.stream.Stream(), Doc: OperationDoc{
+			ID: "streamTodos" + suffix, Summary: "Follow task changes over Server-Sent Events or a WebSocket", Tag: "tasks",
+			Query:     []ParameterDoc{status, {Name: "lastEventId", Description: "Resumes after the given change"}},
+			Headers:   []ParameterDoc{{Name: "Last-Event-ID", Description: "Resumes after the given change"}},
+			Responses: []ResponseDoc{{Status: http.StatusOK, Body: TodoChangeEvent{}, ContentType: "text/event-stream"}},
+			Errors:    []error{ErrValidationFailed},
+		},
+	})
+	routes = append(routes, Route{
+		Method: "GET", Path: prefix + "/tasks/{id}", Endpoint: todoHttp.FindTodo("id"), Doc: OperationDoc{
+			ID: "findTodo" + suffix, Summary: "Get a task", Tag: "tasks",
+			Headers:   []ParameterDoc{consistencyToken},
+			Responses: []ResponseDoc{{Status: http.StatusOK, Body: todo}},
+			Errors:    []error{ErrInvalidTodoID, ErrTodoNotFound, ErrReadModelBehind},
+		},
+	}, Route{
+		Method: "PUT", Path: prefix + "/tasks/{id}", Endpoint: todoHttp.UpdateTodo("id"), Doc: OperationDoc{
+			ID: "updateTodo" + suffix, Summary: "Change the title and date of a task", Tag: "tasks",
+			Body: UpdateTodoDTO{}, BodyRequired: true,
+			Responses: []ResponseDoc{written},
+			Errors:    []error{ErrInvalidTodoID, ErrInvalidRequestBody, ErrValidationFailed, ErrTitleLengthLimitExceeded, ErrInvalidDateFormat, ErrTodoNotFound, ErrTodoAlreadyExists},
+		},
+	}, Route{
+		Method: "PUT", Path: prefix + "/tasks/{id}/done", Endpoint: todoHttp.SetTodoStatusDone("id"), Doc: OperationDoc{
+			ID: "setTodoStatusDone" + suffix, Summary: "Mark a task done", Tag: "tasks",
+			Responses: []ResponseDoc{written},
+			Errors:    []error{ErrInvalidTodoID, ErrTodoNotFound, ErrInvalidTransition},
+		},
+	}, Route{
+		Method: "POST", Path: prefix + "/tasks/{id}/transitions", Endpoint: todoHttp.TransitionTodo("id"), Doc: OperationDoc{
+			ID: "transitionTodo" + suffix, Summary: "Move a task to another status", Tag: "tasks",
+			Body: TransitionTodoDTO{}, BodyRequired: true,
+			Responses: []ResponseDoc{written},
+			Errors:    []error{ErrInvalidTodoID, ErrInvalidRequestBody, ErrValidationFailed, ErrTodoNotFound, ErrInvalidTransition},
+		},
+	}, Route{
+		Method: "POST", Path: prefix + "/tasks/{id}/move", Endpoint: todoHttp.MoveTodo("id"), Doc: OperationDoc{
+			ID: "moveTodo" + suffix, Summary: "Move a task to another list", Tag: "tasks",
+			Body: MoveTodoDTO{}, BodyRequired: true,
+			Responses: []ResponseDoc{written},
+			Errors:    []error{ErrInvalidTodoID, ErrInvalidRequestBody, ErrValidationFailed, ErrTodoNotFound, ErrTodoListNotFound, ErrTodoListArchived, ErrTodoAlreadyExists},
+		},
+	}, Route{
+		Method: "DELETE", Path: prefix + "/tasks/{id}", Endpoint: todoHttp.DeleteTodo("id"), Doc: OperationDoc{
+			ID: "deleteTodo" + suffix, Summary: "Delete a task", Tag: "tasks",
+			Responses: []ResponseDoc{written},
+			Errors:    []error{ErrInvalidTodoID, ErrTodoNotFound},
+		},
+	})
+	for i := range routes {
+		if !legacy {
+			routes[i] = listedRoute(todoHttp, routes[i])
+		}
+		routes[i] = zonedRoute(todoHttp, routes[i])
+	}
+	return routes
+}
+
+// listRoutes lists the routes managing the lists themselves.
+func (tc *todoController) listRoutes(version string, todoHttp *TodoHttp) []Route {
+	prefix, suffix := "/"+version+"/todo-lists", strings.ToUpper(version[:1])+version[1:]
+	item := prefix + "/{" + listIDParameter + "}"
+	written := ResponseDoc{Status: http.StatusNoContent}
+	return []Route{{
+		Method: "POST", Path: prefix, Endpoint: todoHttp.CreateTodoList(), Doc: OperationDoc{
+			ID: "createTodoList" + suffix, Summary: "Create a list", Tag: "lists",
+			Body: CreateTodoListDTO{}, BodyRequired: true,
+			Responses: []ResponseDoc{{Status: http.StatusCreated, Body: TodoListDTO{}, Headers: []string{"Location"}}},
+			Errors:    []error{ErrInvalidRequestBody, ErrValidationFailed, ErrTodoListAlreadyExists},
+		},
+	}, {
+		Method: "GET", Path: prefix, Endpoint: todoHttp.FindTodoLists(), Doc: OperationDoc{
+			ID: "findTodoLists" + suffix, Summary: "List the lists, archived ones included", Tag: "lists",
+			Responses: []ResponseDoc{{Status: http.StatusOK, Body: []TodoListDTO{}}},
+		},
+	}, {
+		Method: "GET", Path: item, Endpoint: todoHttp.FindTodoList(listIDParameter), Doc: OperationDoc{
+			ID: "findTodoList" + suffix, Summary: "Get a list", Tag: "lists",
+			Responses: []ResponseDoc{{Status: http.StatusOK, Body: TodoListDTO{}}},
+			Errors:    []error{ErrInvalidTodoListID, ErrTodoListNotFound},
+		},
+	}, {
+		Method: "PUT", Path: item, Endpoint: todoHttp.RenameTodoList(listIDParameter), Doc: OperationDoc{
+			ID: "renameTodoList" + suffix, Summary: "Rename a list", Tag: "lists",
+			Body: RenameTodoListDTO{}, BodyRequired: true,
+			Responses: []ResponseDoc{written},
+			Errors:    []error{ErrInvalidTodoListID, ErrInvalidRequestBody, ErrValidationFailed, ErrTodoListNotFound, ErrTodoListAlreadyExists},
+		},
+	}, {
+		Method: "PUT", Path: item + "/archived", Endpoint: todoHttp.ArchiveTodoList(listIDParameter, true), Doc: OperationDoc{
+			ID: "archiveTodoList" + suffix, Summary: "Archive a list", Tag: "lists",
+			Responses: []ResponseDoc{written},
+			Errors:    []error{ErrInvalidTodoListID, ErrTodoListNotFound, ErrDefaultTodoList},
+		},
+	}, {
+		Method: "DELETE", Path: item + "/archived", Endpoint: todoHttp.ArchiveTodoList(listIDParameter, false), Doc: OperationDoc{
+			ID: "unarchiveTodoList" + suffix, Summary: "Restore an archived list", Tag: "lists",
+			Responses: []ResponseDoc{written},
+			Errors:    []error{ErrInvalidTodoListID, ErrTodoListNotFound, ErrDefaultTodoList},
+		},
+	}, {
+		Method: "DELETE", Path: item, Endpoint: todoHttp.DeleteTodoList(listIDParameter), Doc: OperationDoc{
+			ID: "deleteTodoList" + suffix, Summary: "Delete an empty list", Tag: "lists",
+			Responses: []ResponseDoc{written},
+			Errors:    []error{ErrInvalidTodoListID, ErrTodoListNotFound, ErrTodoListNotEmpty, ErrDefaultTodoList},
+		},
+	}}
+}
+
+// listedRoute runs route on the tasks of the list of its listId path parameter.
+func listedRoute(todoHttp *TodoHttp, route Route) Route {
+	route.Endpoint = todoHttp.listed(route.Endpoint)
+	route.Doc.Errors = append(route.Doc.Errors, ErrInvalidTodoListID)
+	return route
+}
+
+// zonedRoute runs route in the time zone of the request and documents the header naming it.
+func zonedRoute(todoHttp *TodoHttp, route Route) Route {
+	route.Endpoint = todoHttp.zoned(route.Endpoint)
+	route.Doc.Headers = append(route.Doc.Headers, ParameterDoc{Name: TimeZoneHeader, Description: "IANA time zone in which today is reckoned, e.g. Asia/Almaty"})
+	route.Doc.Errors = append(route.Doc.Errors, ErrInvalidTimeZone)
+	return route
+}
+
+// OpenAPIEndpoint serves the document built by Bind.
+func (tc *todoController) OpenAPIEndpoint() httpLib.Endpoint {
+	return func(w http.ResponseWriter, r *http.Request) httpLib.Response {
+		return httpLib.NewResponse(http.StatusOK, tc.openapi, nil)
+	}
 }
@@ -0,0 +1,147 @@
+package todo
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-playground/validator/v10"
+	httpLib "github.com/kas2000/http"
+)
+
+const (
+	ProblemContentType = "application/problem+json"
+	problemTypePrefix  = "urn:todo-service:problem:"
+)
+
+// Problem is an RFC 7807 problem details body. Code and Errors are extension members.
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Code     string       `json:"code"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// ValidationError carries field-level details for a domain error, ErrValidationFailed by default.
+type ValidationError struct {
+	Err    error
+	Fields []FieldError
+}
+
+func NewValidationError(err error, fields ...FieldError) *ValidationError {
+	if err == nil {
+		err = ErrValidationFailed
+	}
+	return &ValidationError{Err: err, Fields: fields}
+}
+
+func (e *ValidationError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+func newValidationErrorFromValidator(errs validator.ValidationErrors) *ValidationError {
+	fields := make([]FieldError, 0, len(errs))
+	for _, fe := range errs {
+		fields = append(fields, FieldError{
+			Field: fe.Field(),
+			Rule:  fe.Tag(),
+			Param: fe.Param(),
+		})
+	}
+	return NewValidationError(ErrValidationFailed, fields...)
+}
+
+// ErrorDefinition describes how a domain error is exposed to clients. Codes are stable and never reused.
+type ErrorDefinition struct {
+	Err    error
+	Code   int
+	Status int
+	Slug   string
+	Title  string
+}
+
+func (def ErrorDefinition) Type() string {
+	return problemTypePrefix + def.Slug
+}
+
+var internalErrorDefinition = ErrorDefinition{Err: ErrInternal, Code: 1500, Status: http.StatusInternalServerError, Slug: "internal-error", Title: "Internal server error"}
+
+var errorRegistry = []ErrorDefinition{
+	{Err: ErrInvalidRequestBody, Code: 1001, Status: http.StatusBadRequest, Slug: "invalid-request-body", Title: "Invalid request body"},
+	{Err: ErrInvalidTodoID, Code: 1002, Status: http.StatusBadRequest, Slug: "invalid-todo-id", Title: "Invalid todo id"},
+	{Err: ErrValidationFailed, Code: 1003, Status: http.StatusBadRequest, Slug: "validation-failed", Title: "Validation failed"},
+	{Err: ErrTitleLengthLimitExceeded, Code: 1004, Status: http.StatusBadRequest, Slug: "title-length-limit-exceeded", Title: "Title length limit exceeded"},
+	{Err: ErrInvalidDateFormat, Code: 1005, Status: http.StatusBadRequest, Slug: "invalid-date-format", Title: "Invalid date format"},
+	{Err: ErrUnknownComparisonOperator, Code: 1006, Status: http.StatusBadRequest, Slug: "unknown-comparison-operator", Title: "Unknown comparison operator"},
+	{Err: ErrNothingToUpdate, Code: 1007, Status: http.StatusBadRequest, Slug: "nothing-to-update", Title: "Nothing to update"},
+	{Err: ErrInvalidSortField, Code: 1008, Status: http.StatusBadRequest, Slug: "invalid-sort-field", Title: "Invalid sort field"},
+	{Err: ErrInvalidProjectionField, Code: 1009, Status: http.StatusBadRequest, Slug: "invalid-projection-field", Title: "Invalid projection field"},
+	{Err: ErrInvalidTimeZone, Code: 1010, Status: http.StatusBadRequest, Slug: "invalid-time-zone", Title: "Invalid time zone"},
+	{Err: ErrInvalidTodoListID, Code: 1011, Status: http.StatusBadRequest, Slug: "invalid-todo-list-id", Title: "Invalid todo list id"},
+	{Err: ErrMutationNotAllowed, Code: 1012, Status: http.StatusMethodNotAllowed, Slug: "mutation-not-allowed", Title: "Mutation not allowed"},
+	{Err: ErrTodoNotFound, Code: 1101, Status: http.StatusNotFound, Slug: "todo-not-found", Title: "Todo not found"},
+	{Err: ErrTodoAlreadyExists, Code: 1102, Status: http.StatusConflict, Slug: "todo-already-exists", Title: "Todo already exists"},
+	{Err: ErrInvalidTransition, Code: 1103, Status: http.StatusUnprocessableEntity, Slug: "invalid-transition", Title: "Invalid status transition"},
+	{Err: ErrTodoListNotFound, Code: 1104, Status: http.StatusNotFound, Slug: "todo-list-not-found", Title: "Todo list not found"},
+	{Err: ErrTodoListAlreadyExists, Code: 1105, Status: http.StatusConflict, Slug: "todo-list-already-exists", Title: "Todo list already exists"},
+	{Err: ErrTodoListArchived, Code: 1106, Status: http.StatusConflict, Slug: "todo-list-archived", Title: "Todo list archived"},
+	{Err: ErrTodoListNotEmpty, Code: 1107, Status: http.StatusConflict, Slug: "todo-list-not-empty", Title: "Todo list not empty"},
+	{Err: ErrDefaultTodoList, Code: 1108, Status: http.StatusConflict, Slug: "default-todo-list", Title: "Default todo list"},
+	{Err: ErrForbidden, Code: 1201, Status: http.StatusForbidden, Slug: "forbidden", Title: "Forbidden"},
+	{Err: ErrJobNotFound, Code: 1301, Status: http.StatusNotFound, Slug: "job-not-found", Title: "Job not found"},
+	{Err: ErrJobInterrupted, Code: 1302, Status: http.StatusServiceUnavailable, Slug: "job-interrupted", Title: "Job interrupted"},
+	{Err: ErrReadModelBehind, Code: 1401, Status: http.StatusServiceUnavailable, Slug: "read-model-behind", Title: "Read model behind"},
+	{Err: ErrShuttingDown, Code: 1501, Status: http.StatusServiceUnavailable, Slug: "shutting-down", Title: "Service shutting down"},
+	internalErrorDefinition,
+}
+
+// LookupError returns the registered definition for err, falling back to the internal error definition.
+func LookupError(err error) (ErrorDefinition, bool) {
+	for _, def := range errorRegistry {
+		if errors.Is(err, def.Err) {
+			return def, true
+		}
+	}
+	return internalErrorDefinition, false
+}
+
+// NewProblem builds the problem details for err. Unregistered errors are reported as internal errors
+// without exposing their message.
+func NewProblem(err error, systemName string, instance string) *Problem {
+	def, registered := LookupError(err)
+	problem := &Problem{
+		Type:     def.Type(),
+		Title:    def.Title,
+		Status:   def.Status,
+		Detail:   def.Err.Error(),
+		Instance: instance,
+		Code:     systemName + "." + strconv.Itoa(def.Code),
+	}
+	if registered {
+		problem.Detail = err.Error()
+	}
+
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		problem.Errors = validationErr.Fields
+	}
+	return problem
+}
+
+func newProblemResponse(problem *Problem) httpLib.Response {
+	return httpLib.NewResponse(problem.Status, problem, map[string]string{"Content-Type": ProblemContentType})
+}
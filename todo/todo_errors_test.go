@@ -0,0 +1,154 @@
+package todo
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/kas2000/logger"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorRegistry(t *testing.T) {
+	codes := make(map[int]error)
+	slugs := make(map[string]error)
+	for _, def := range errorRegistry {
+		_, found := codes[def.Code]
+		require.False(t, found, "code %d is reused", def.Code)
+		codes[def.Code] = def.Err
+
+		_, found = slugs[def.Slug]
+		require.False(t, found, "slug %s is reused", def.Slug)
+		slugs[def.Slug] = def.Err
+	}
+}
+
+func TestNewProblem(t *testing.T) {
+	testCases := []struct {
+		title          string
+		err            error
+		expectedStatus int
+		expectedCode   string
+		expectedDetail string
+		expectedFields int
+	}{
+		{
+			title:          "Зарегистрированная ошибка",
+			err:            ErrTodoNotFound,
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   "todo-service.1101",
+			expectedDetail: ErrTodoNotFound.Error(),
+		},
+		{
+			title:          "Обёрнутая ошибка",
+			err:            fmt.Errorf("%w: unexpected EOF", ErrInvalidRequestBody),
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "todo-service.1001",
+			expectedDetail: "invalid request body.: unexpected EOF",
+		},
+		{
+			title:          "Ошибка валидации с полями",
+			err:            NewValidationError(nil, FieldError{Field: "title", Rule: "required"}),
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   "todo-service.1003",
+			expectedDetail: ErrValidationFailed.Error(),
+			expectedFields: 1,
+		},
+		{
+			title:          "Незарегистрированная ошибка не раскрывается",
+			err:            errors.New("connection refused"),
+			expectedStatus: http.StatusInternalServerError,
+			expectedCode:   "todo-service.1500",
+			expectedDetail: ErrInternal.Error(),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.title, func(t *testing.T) {
+			problem := NewProblem(tc.err, "todo-service", "/api/todo-list/tasks")
+			require.Equal(t, tc.expectedStatus, problem.Status)
+			require.Equal(t, tc.expectedCode, problem.Code)
+			require.Equal(t, tc.expectedDetail, problem.Detail)
+			require.Equal(t, "/api/todo-list/tasks", problem.Instance)
+			require.Len(t, problem.Errors, tc.expectedFields)
+		})
+	}
+}
+
+func TestLocalizedFieldErrors(t *testing.T) {
+	log, _ := logger.New("debug")
+	todoHttp := NewTodoHttp(log, nil, validator.New(), "todo-service")
+
+	testCases := []struct {
+		title           string
+		acceptLanguage  string
+		err             func() error
+		expectedField   string
+		expectedRule    string
+		expectedMessage string
+	}{
+		{
+			title:          "Ошибка валидатора на английском",
+			acceptLanguage: "en-US,en;q=0.9",
+			err: func() error {
+				return todoHttp.validate.Struct(CreateTodoDTO{ActiveAt: "2023-08-04"})
+			},
+			expectedField:   "title",
+			expectedRule:    "required",
+			expectedMessage: "title is a required field",
+		},
+		{
+			title:          "Ошибка валидатора на русском",
+			acceptLanguage: "ru-RU,ru;q=0.9,en;q=0.8",
+			err: func() error {
+				return todoHttp.validate.Struct(CreateTodoDTO{ActiveAt: "2023-08-04"})
+			},
+			expectedField:   "title",
+			expectedRule:    "required",
+			expectedMessage: "title обязательное поле",
+		},
+		{
+			title:          "Доменная ошибка на русском",
+			acceptLanguage: "ru",
+			err: func() error {
+				_, err := validateTodo("Купить книгу", "2023-13-04")
+				return err
+			},
+			expectedField:   "activeAt",
+			expectedRule:    "datetime",
+			expectedMessage: "activeAt не соответствует формату 2006-01-02",
+		},
+		{
+			title:          "Неизвестный язык",
+			acceptLanguage: "kk",
+			err: func() error {
+				_, err := validateTodo(strings.Repeat("а", 201), "2023-08-04")
+				return err
+			},
+			expectedField:   "title",
+			expectedRule:    "max",
+			expectedMessage: "title must be a maximum of 200 characters in length",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.title, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, "/api/todo-list/tasks", nil)
+			require.NoError(t, err)
+			req.Header.Set("Accept-Language", tc.acceptLanguage)
+
+			retData := todoHttp.problem(req, tc.err())
+			require.Equal(t, http.StatusBadRequest, retData.StatusCode())
+			require.Equal(t, ProblemContentType, retData.GetHeader("Content-Type"))
+
+			problem := retData.Response().(*Problem)
+			require.Len(t, problem.Errors, 1)
+			require.Equal(t, tc.expectedField, problem.Errors[0].Field)
+			require.Equal(t, tc.expectedRule, problem.Errors[0].Rule)
+			require.Equal(t, tc.expectedMessage, problem.Errors[0].Message)
+		})
+	}
+}
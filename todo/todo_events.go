@@ -0,0 +1,439 @@
+package todo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	EventCreated      = "Created"
+	EventTitleChanged = "TitleChanged"
+	EventRescheduled  = "Rescheduled"
+	EventCompleted    = "Completed"
+	EventCancelled    = "Cancelled"
+	EventReopened     = "Reopened"
+	EventDeleted      = "Deleted"
+	EventMoved        = "Moved"
+	// EventStatusChanged moves an open task to another open status, e.g. IN_PROGRESS.
+	EventStatusChanged = "StatusChanged"
+	// EventRestored sets the whole task as given, as the admin import and doctor do.
+	EventRestored = "Restored"
+
+	eventCollection    = "todo_events"
+	snapshotCollection = "todo_snapshots"
+	// snapshotEvery is the number of events of a task after which its state is snapshotted.
+	snapshotEvery = 20
+)
+
+var ErrEventVersionConflict = errors.New("todo was changed concurrently")
+
+// TodoEvent is one change of a task. Version numbers the events of a task from 1 without gaps.
+type TodoEvent struct {
+	ID       primitive.ObjectID  `bson:"_id"`
+	TodoID   primitive.ObjectID  `bson:"todo_id"`
+	Version  int                 `bson:"version"`
+	Type     string              `bson:"type"`
+	ListID   *primitive.ObjectID `bson:"list_id,omitempty"`
+	Title    *string             `bson:"title,omitempty"`
+	Status   *string             `bson:"status,omitempty"`
+	ActiveAt *time.Time          `bson:"active_at,omitempty"`
+	// CreatedAt and UpdatedAt are set by Created and Restored events.
+	CreatedAt  *time.Time `bson:"created_at,omitempty"`
+	UpdatedAt  *time.Time `bson:"updated_at,omitempty"`
+	OccurredAt time.Time  `bson:"occurred_at"`
+}
+
+// Apply returns the task after event; nil stands for a deleted or not yet created task.
+func (event *TodoEvent) Apply(todo *Todo) *Todo {
+	switch event.Type {
+	case EventCreated, EventRestored:
+		// Tasks created before there were lists are in the default one.
+		listID := DefaultListID
+		if event.ListID != nil {
+			listID = *event.ListID
+		}
+		return &Todo{ID: event.TodoID, ListID: listID, Title: *event.Title, Status: upgradeStatus(*event.Status), ActiveAt: *event.ActiveAt, CreatedAt: *event.CreatedAt, UpdatedAt: event.UpdatedAt}
+	case EventDeleted:
+		return nil
+	}
+	if todo == nil {
+		return nil
+	}
+	changed := *todo
+	switch event.Type {
+	case EventTitleChanged:
+		changed.Title = *event.Title
+	case EventRescheduled:
+		changed.ActiveAt = *event.ActiveAt
+	case EventMoved:
+		changed.ListID = *event.ListID
+	case EventCompleted, EventCancelled, EventReopened, EventStatusChanged:
+		changed.Status = upgradeStatus(*event.Status)
+	}
+	occurredAt := event.OccurredAt
+	changed.UpdatedAt = &occurredAt
+	return &changed
+}
+
+// statusEvent names the event of a task moving from one status to another.
+func statusEvent(from, to string) string {
+	switch {
+	case to == StatusDone:
+		return EventCompleted
+	case to == StatusCancelled:
+		return EventCancelled
+	case from == StatusDone || from == StatusCancelled:
+		return EventReopened
+	default:
+		return EventStatusChanged
+	}
+}
+
+// TodoSnapshot is the state of a task after Version events; Todo is nil once the task is deleted.
+type TodoSnapshot struct {
+	TodoID  primitive.ObjectID `bson:"_id"`
+	Version int                `bson:"version"`
+	Todo    *Todo              `bson:"todo"`
+}
+
+type EventStore interface {
+	// Append stores the events of one task, failing with ErrEventVersionConflict if any version is taken.
+	Append(ctx context.Context, events ...*TodoEvent) error
+	// Version returns the version of the last event of the task, 0 if it has none.
+	Version(ctx context.Context, todoID primitive.ObjectID) (int, error)
+	// Load returns the events of the task after the given version, oldest first.
+	Load(ctx context.Context, todoID primitive.ObjectID, after int) ([]*TodoEvent, error)
+	// TodoIDs lists every task with events.
+	TodoIDs(ctx context.Context) ([]primitive.ObjectID, error)
+	SaveSnapshot(ctx context.Context, snapshot *TodoSnapshot) error
+	// LoadSnapshot returns the latest snapshot of the task or nil.
+	LoadSnapshot(ctx context.Context, todoID primitive.ObjectID) (*TodoSnapshot, error)
+}
+
+type eventStore struct {
+	events    *mongo.Collection
+	snapshots *mongo.Collection
+}
+
+// NewEventStore keeps events and snapshots in collections created by Migrate.
+func NewEventStore(db *mongo.Database) EventStore {
+	return &eventStore{events: db.Collection(eventCollection), snapshots: db.Collection(snapshotCollection)}
+}
+
+func (store *eventStore) Append(ctx context.Context, events ...*TodoEvent) error {
+	documents := make([]interface{}, 0, len(events))
+	for _, event := range events {
+		documents = append(documents, event)
+	}
+	_, err := store.events.InsertMany(ctx, documents)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrEventVersionConflict
+	}
+	return err
+}
+
+func (store *eventStore) Version(ctx context.Context, todoID primitive.ObjectID) (int, error) {
+	var last TodoEvent
+	opts := options.FindOne().SetSort(bson.D{{Key: "version", Value: -1}})
+	err := store.events.FindOne(ctx, bson.D{{Key: "todo_id", Value: todoID}}, opts).Decode(&last)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, nil
+	}
+	return last.Version, err
+}
+
+func (store *eventStore) Load(ctx context.Context, todoID primitive.ObjectID, after int) ([]*TodoEvent, error) {
+	filter := bson.D{{Key: "todo_id", Value: todoID}, {Key: "version", Value: bson.D{{Key: "$gt", Value: after}}}}
+	cursor, err := store.events.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "version", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	var events []*TodoEvent
+	return events, cursor.All(ctx, &events)
+}
+
+func (store *eventStore) TodoIDs(ctx context.Context) ([]primitive.ObjectID, error) {
+	values, err := store.events.Distinct(ctx, "todo_id", bson.D{})
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]primitive.ObjectID, 0, len(values))
+	for _, value := range values {
+		ids = append(ids, value.(primitive.ObjectID))
+	}
+	return ids, nil
+}
+
+func (store *eventStore) SaveSnapshot(ctx context.Context, snapshot *TodoSnapshot) error {
+	_, err := store.snapshots.ReplaceOne(ctx, bson.D{{Key: "_id", Value: snapshot.TodoID}}, snapshot, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (store *eventStore) LoadSnapshot(ctx context.Context, todoID primitive.ObjectID) (*TodoSnapshot, error) {
+	var snapshot TodoSnapshot
+	err := store.snapshots.FindOne(ctx, bson.D{{Key: "_id", Value: todoID}}).Decode(&snapshot)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// LoadTodo replays the task from its latest snapshot. It returns the task, nil if it was deleted, and its version.
+func LoadTodo(ctx context.Context, events EventStore, todoID primitive.ObjectID) (*Todo, int, error) {
+	snapshot, err := events.LoadSnapshot(ctx, todoID)
+	if err != nil {
+		return nil, 0, err
+	}
+	var todo *Todo
+	version := 0
+	if snapshot != nil {
+		todo, version = snapshot.Todo, snapshot.Version
+	}
+	tail, err := events.Load(ctx, todoID, version)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, event := range tail {
+		todo = event.Apply(todo)
+		version = event.Version
+	}
+	return todo, version, nil
+}
+
+// eventSourcedRepo records every change as events and keeps the given repository as their projection.
+// Reads are served by the projection.
+type eventSourcedRepo struct {
+	TodoRepository
+	events EventStore
+}
+
+// NewEventSourcedRepo wraps projection so that its documents can be rebuilt from events, see RebuildProjection.
+// The projection is written first, since its unique index decides whether a change is allowed, and is replayed
+// from the events if the change's events can't be stored.
+func NewEventSourcedRepo(projection TodoRepository, events EventStore) TodoRepository {
+	return &eventSourcedRepo{TodoRepository: projection, events: events}
+}
+
+func (repository *eventSourcedRepo) newEvent(todoID primitive.ObjectID, eventType string) *TodoEvent {
+	return &TodoEvent{ID: primitive.NewObjectID(), TodoID: todoID, Type: eventType, OccurredAt: time.Now().UTC()}
+}
+
+func stateEvent(event *TodoEvent, todo *Todo) *TodoEvent {
+	listID, title, status, activeAt, createdAt := todo.ListID, todo.Title, todo.Status, todo.ActiveAt, todo.CreatedAt
+	event.ListID, event.Title, event.Status, event.ActiveAt, event.CreatedAt = &listID, &title, &status, &activeAt, &createdAt
+	if todo.UpdatedAt != nil {
+		updatedAt := *todo.UpdatedAt
+		event.UpdatedAt = &updatedAt
+	}
+	return event
+}
+
+// record appends events after version and snapshots the task when a multiple of snapshotEvery is crossed.
+// When the events can't be stored the projection is reconciled with the ones that were, previous being the task
+// before the change.
+func (repository *eventSourcedRepo) record(ctx context.Context, version int, state *Todo, previous *Todo, events ...*TodoEvent) error {
+	for i, event := range events {
+		event.Version = version + i + 1
+	}
+	if err := repository.events.Append(ctx, events...); err != nil {
+		if reconcileErr := repository.reconcile(ctx, events[0].TodoID, previous); reconcileErr != nil {
+			return fmt.Errorf("%w; reconciling the projection failed too: %v", err, reconcileErr)
+		}
+		return err
+	}
+	last := version + len(events)
+	if last/snapshotEvery > version/snapshotEvery {
+		// A missing snapshot only makes replay slower.
+		_ = repository.events.SaveSnapshot(ctx, &TodoSnapshot{TodoID: events[0].TodoID, Version: last, Todo: state})
+	}
+	return nil
+}
+
+// reconcile writes the replayed task to the projection. On ErrEventVersionConflict a concurrent writer's events are
+// in the log, so restoring previous would lose its change. A task without events, written before event sourcing,
+// gets previous back.
+func (repository *eventSourcedRepo) reconcile(ctx context.Context, todoID primitive.ObjectID, previous *Todo) error {
+	todo, version, err := LoadTodo(ctx, repository.events, todoID)
+	if err != nil {
+		return err
+	}
+	if version == 0 {
+		todo = previous
+	}
+	if todo == nil {
+		if err := repository.TodoRepository.Delete(ctx, todoID); err != nil && !errors.Is(err, ErrTodoNotFound) {
+			return err
+		}
+		return nil
+	}
+	return repository.TodoRepository.Restore(ctx, todo)
+}
+
+func (repository *eventSourcedRepo) Create(ctx context.Context, todo *Todo) (*Todo, error) {
+	created, err := repository.TodoRepository.Create(ctx, todo)
+	if err != nil {
+		return nil, err
+	}
+	event := stateEvent(repository.newEvent(created.ID, EventCreated), created)
+	if err := repository.record(ctx, 0, created, nil, event); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+func (repository *eventSourcedRepo) Update(ctx context.Context, upd TodoPointers) error {
+	current, err := repository.TodoRepository.FindByID(ctx, *upd.ID)
+	if err != nil {
+		return err
+	}
+	version, err := repository.events.Version(ctx, *upd.ID)
+	if err != nil {
+		return err
+	}
+	if err := repository.TodoRepository.Update(ctx, upd); err != nil {
+		return err
+	}
+
+	var events []*TodoEvent
+	if upd.ListID != nil && *upd.ListID != current.ListID {
+		event := repository.newEvent(current.ID, EventMoved)
+		event.ListID = upd.ListID
+		events = append(events, event)
+	}
+	if upd.Title != nil && *upd.Title != current.Title {
+		event := repository.newEvent(current.ID, EventTitleChanged)
+		event.Title = upd.Title
+		events = append(events, event)
+	}
+	if upd.ActiveAt != nil && !upd.ActiveAt.ActiveAt.Equal(current.ActiveAt) {
+		event := repository.newEvent(current.ID, EventRescheduled)
+		event.ActiveAt = upd.ActiveAt.ActiveAt
+		events = append(events, event)
+	}
+	if upd.Status != nil && *upd.Status != current.Status {
+		event := repository.newEvent(current.ID, statusEvent(current.Status, *upd.Status))
+		event.Status = upd.Status
+		events = append(events, event)
+	}
+	if len(events) == 0 {
+		return nil
+	}
+	// The events take the projection's update time so that a rebuilt task is identical.
+	updated, err := repository.TodoRepository.FindByID(ctx, current.ID)
+	if err != nil {
+		return err
+	}
+	for _, event := range events {
+		if updated.UpdatedAt != nil {
+			event.OccurredAt = *updated.UpdatedAt
+		}
+	}
+	state := current
+	for _, event := range events {
+		state = event.Apply(state)
+	}
+	return repository.record(ctx, version, state, current, events...)
+}
+
+func (repository *eventSourcedRepo) Delete(ctx context.Context, id primitive.ObjectID) error {
+	current, err := repository.TodoRepository.FindByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	version, err := repository.events.Version(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := repository.TodoRepository.Delete(ctx, id); err != nil {
+		return err
+	}
+	return repository.record(ctx, version, nil, current, repository.newEvent(id, EventDeleted))
+}
+
+func (repository *eventSourcedRepo) Restore(ctx context.Context, todo *Todo) error {
+	previous, err := repository.TodoRepository.FindByID(ctx, todo.ID)
+	if err != nil && !errors.Is(err, ErrTodoNotFound) {
+		return err
+	}
+	version, err := repository.events.Version(ctx, todo.ID)
+	if err != nil {
+		return err
+	}
+	if err := repository.TodoRepository.Restore(ctx, todo); err != nil {
+		return err
+	}
+	event := stateEvent(repository.newEvent(todo.ID, EventRestored), todo)
+	return repository.record(ctx, version, event.Apply(nil), previous, event)
+}
+
+type RebuildResult struct {
+	// Backfilled counts tasks without events that got a Restored event recording their current state.
+	Backfilled int
+	Restored   int
+	Deleted    int
+	// Orphaned counts tasks of the projection without events, which are kept unless backfilled.
+	Orphaned int
+}
+
+// RebuildProjection writes the replayed state of every task with events to projection and removes the deleted
+// ones. With backfill, tasks written before event sourcing was enabled first get an event of their current state.
+func RebuildProjection(ctx context.Context, events EventStore, projection TodoRepository, backfill bool) (RebuildResult, error) {
+	var result RebuildResult
+	err := eachTodo(ctx, projection, func(todos []*Todo) error {
+		for _, todo := range todos {
+			version, err := events.Version(ctx, todo.ID)
+			if err != nil {
+				return err
+			}
+			if version > 0 {
+				continue
+			}
+			if !backfill {
+				result.Orphaned++
+				continue
+			}
+			event := stateEvent(&TodoEvent{ID: primitive.NewObjectID(), TodoID: todo.ID, Version: 1, Type: EventRestored, OccurredAt: time.Now().UTC()}, todo)
+			if err := events.Append(ctx, event); err != nil {
+				return err
+			}
+			result.Backfilled++
+		}
+		return nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	ids, err := events.TodoIDs(ctx)
+	if err != nil {
+		return result, err
+	}
+	for _, id := range ids {
+		todo, _, err := LoadTodo(ctx, events, id)
+		if err != nil {
+			return result, err
+		}
+		if todo == nil {
+			if err := projection.Delete(ctx, id); err != nil && !errors.Is(err, ErrTodoNotFound) {
+				return result, err
+			}
+			result.Deleted++
+			continue
+		}
+		if err := projection.Restore(ctx, todo); err != nil {
+			return result, fmt.Errorf("todo %s: %w", id.Hex(), err)
+		}
+		result.Restored++
+	}
+	return result, nil
+}
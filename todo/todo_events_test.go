@@ -0,0 +1,224 @@
+package todo
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type memoryEventStore struct {
+	events    map[primitive.ObjectID][]*TodoEvent
+	snapshots map[primitive.ObjectID]*TodoSnapshot
+	appendErr error
+}
+
+func newMemoryEventStore() *memoryEventStore {
+	return &memoryEventStore{events: make(map[primitive.ObjectID][]*TodoEvent), snapshots: make(map[primitive.ObjectID]*TodoSnapshot)}
+}
+
+func (store *memoryEventStore) Append(ctx context.Context, events ...*TodoEvent) error {
+	if store.appendErr != nil {
+		return store.appendErr
+	}
+	for _, event := range events {
+		if event.Version != len(store.events[event.TodoID])+1 {
+			return ErrEventVersionConflict
+		}
+		copied := *event
+		store.events[event.TodoID] = append(store.events[event.TodoID], &copied)
+	}
+	return nil
+}
+
+func (store *memoryEventStore) Version(ctx context.Context, todoID primitive.ObjectID) (int, error) {
+	return len(store.events[todoID]), nil
+}
+
+func (store *memoryEventStore) Load(ctx context.Context, todoID primitive.ObjectID, after int) ([]*TodoEvent, error) {
+	return store.events[todoID][after:], nil
+}
+
+func (store *memoryEventStore) TodoIDs(ctx context.Context) ([]primitive.ObjectID, error) {
+	ids := make([]primitive.ObjectID, 0, len(store.events))
+	for id := range store.events {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Hex() < ids[j].Hex() })
+	return ids, nil
+}
+
+func (store *memoryEventStore) SaveSnapshot(ctx context.Context, snapshot *TodoSnapshot) error {
+	store.snapshots[snapshot.TodoID] = snapshot
+	return nil
+}
+
+func (store *memoryEventStore) LoadSnapshot(ctx context.Context, todoID primitive.ObjectID) (*TodoSnapshot, error) {
+	return store.snapshots[todoID], nil
+}
+
+// racingEventStore runs before ahead of the first append, standing in for a concurrent writer.
+type racingEventStore struct {
+	*memoryEventStore
+	before func()
+}
+
+func (store *racingEventStore) Append(ctx context.Context, events ...*TodoEvent) error {
+	if before := store.before; before != nil {
+		store.before = nil
+		before()
+	}
+	return store.memoryEventStore.Append(ctx, events...)
+}
+
+func eventTypes(events []*TodoEvent) []string {
+	types := make([]string, 0, len(events))
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func TestEventSourcedRepo(t *testing.T) {
+	ctx := context.Background()
+	activeAt := time.Date(2023, 8, 4, 0, 0, 0, 0, time.UTC)
+	newRepo := func() (*memoryTodoRepo, *memoryEventStore, TodoRepository) {
+		projection := newMemoryTodoRepo()
+		projection.indexed = true
+		events := newMemoryEventStore()
+		return projection, events, NewEventSourcedRepo(projection, events)
+	}
+	stringPointer := func(value string) *string { return &value }
+
+	t.Run("Каждое изменение записывается отдельным событием", func(t *testing.T) {
+		_, events, repository := newRepo()
+		created, err := repository.Create(ctx, &Todo{Title: "Купить книгу", Status: StatusTodo, ActiveAt: activeAt})
+		require.NoError(t, err)
+
+		rescheduled := activeAt.AddDate(0, 0, 1)
+		require.NoError(t, repository.Update(ctx, TodoPointers{ID: &created.ID, Title: stringPointer("Купить две книги"), ActiveAt: &ActiveAtPointers{ActiveAt: &rescheduled}}))
+		// An unchanged title is not an event.
+		require.NoError(t, repository.Update(ctx, TodoPointers{ID: &created.ID, Status: stringPointer(StatusInProgress)}))
+		require.NoError(t, repository.Update(ctx, TodoPointers{ID: &created.ID, Title: stringPointer("Купить две книги"), Status: stringPointer(StatusDone)}))
+		require.NoError(t, repository.Update(ctx, TodoPointers{ID: &created.ID, Status: stringPointer(StatusTodo)}))
+		require.NoError(t, repository.Update(ctx, TodoPointers{ID: &created.ID, Status: stringPointer(StatusCancelled)}))
+		listID := primitive.NewObjectID()
+		require.NoError(t, repository.Update(ctx, TodoPointers{ID: &created.ID, ListID: &listID}))
+		moved, _, err := LoadTodo(ctx, events, created.ID)
+		require.NoError(t, err)
+		require.Equal(t, listID, moved.ListID)
+		require.NoError(t, repository.Delete(ctx, created.ID))
+
+		recorded := events.events[created.ID]
+		require.Equal(t, []string{EventCreated, EventTitleChanged, EventRescheduled, EventStatusChanged, EventCompleted, EventReopened, EventCancelled, EventMoved, EventDeleted}, eventTypes(recorded))
+		for i, event := range recorded {
+			require.Equal(t, i+1, event.Version)
+		}
+		todo, version, err := LoadTodo(ctx, events, created.ID)
+		require.NoError(t, err)
+		require.Nil(t, todo)
+		require.Equal(t, 9, version)
+	})
+
+	t.Run("Проекция восстанавливается из событий", func(t *testing.T) {
+		projection, events, repository := newRepo()
+		first, err := repository.Create(ctx, &Todo{Title: "Купить книгу", Status: StatusTodo, ActiveAt: activeAt})
+		require.NoError(t, err)
+		second, err := repository.Create(ctx, &Todo{Title: "Позвонить маме", Status: StatusTodo, ActiveAt: activeAt})
+		require.NoError(t, err)
+		third, err := repository.Create(ctx, &Todo{Title: "Оплатить счёт", Status: StatusTodo, ActiveAt: activeAt})
+		require.NoError(t, err)
+		require.NoError(t, repository.Update(ctx, TodoPointers{ID: &first.ID, Status: stringPointer(StatusDone)}))
+		require.NoError(t, repository.Delete(ctx, second.ID))
+		require.NoError(t, repository.Restore(ctx, &Todo{ID: third.ID, Title: "Оплатить два счёта", Status: StatusTodo, ActiveAt: activeAt, CreatedAt: third.CreatedAt}))
+		want, err := projection.FindAll(ctx, TodoPointers{})
+		require.NoError(t, err)
+
+		rebuilt := newMemoryTodoRepo(&Todo{ID: second.ID, Title: "Позвонить маме", Status: StatusTodo, ActiveAt: activeAt})
+		result, err := RebuildProjection(ctx, events, rebuilt, false)
+		require.NoError(t, err)
+		require.Equal(t, RebuildResult{Restored: 2, Deleted: 1}, result)
+		got, err := rebuilt.FindAll(ctx, TodoPointers{})
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+	})
+
+	t.Run("Снимок сокращает воспроизведение", func(t *testing.T) {
+		projection, events, repository := newRepo()
+		created, err := repository.Create(ctx, &Todo{Title: "Купить книгу", Status: StatusTodo, ActiveAt: activeAt})
+		require.NoError(t, err)
+		for i := 0; i < snapshotEvery; i++ {
+			rescheduled := activeAt.AddDate(0, 0, i+1)
+			require.NoError(t, repository.Update(ctx, TodoPointers{ID: &created.ID, ActiveAt: &ActiveAtPointers{ActiveAt: &rescheduled}}))
+		}
+
+		snapshot := events.snapshots[created.ID]
+		require.NotNil(t, snapshot)
+		require.Equal(t, snapshotEvery, snapshot.Version)
+		// Replay must start from the snapshot, so events before it are no longer needed.
+		events.events[created.ID] = append(make([]*TodoEvent, snapshotEvery), events.events[created.ID][snapshotEvery:]...)
+		todo, version, err := LoadTodo(ctx, events, created.ID)
+		require.NoError(t, err)
+		require.Equal(t, snapshotEvery+1, version)
+		want, err := projection.FindByID(ctx, created.ID)
+		require.NoError(t, err)
+		require.Equal(t, want, todo)
+	})
+
+	t.Run("Изменение откатывается, если событие не записано", func(t *testing.T) {
+		projection, events, repository := newRepo()
+		created, err := repository.Create(ctx, &Todo{Title: "Купить книгу", Status: StatusTodo, ActiveAt: activeAt})
+		require.NoError(t, err)
+		before := *projection.todos[created.ID]
+
+		events.appendErr = errors.New("event store unavailable")
+		require.ErrorIs(t, repository.Update(ctx, TodoPointers{ID: &created.ID, Title: stringPointer("Купить две книги")}), events.appendErr)
+		require.Equal(t, before, *projection.todos[created.ID])
+		require.ErrorIs(t, repository.Delete(ctx, created.ID), events.appendErr)
+		require.Equal(t, before, *projection.todos[created.ID])
+		_, err = repository.Create(ctx, &Todo{Title: "Позвонить маме", Status: StatusTodo, ActiveAt: activeAt})
+		require.ErrorIs(t, err, events.appendErr)
+		require.Len(t, projection.todos, 1)
+		require.Len(t, events.events[created.ID], 1)
+	})
+
+	t.Run("При конфликте проекция воспроизводится из событий", func(t *testing.T) {
+		projection, events, repository := newRepo()
+		created, err := repository.Create(ctx, &Todo{Title: "Купить книгу", Status: StatusTodo, ActiveAt: activeAt})
+		require.NoError(t, err)
+
+		// Another instance renames the task between our version read and our append.
+		racing := &racingEventStore{memoryEventStore: events, before: func() {
+			concurrent := &TodoEvent{ID: primitive.NewObjectID(), TodoID: created.ID, Version: 2, Type: EventTitleChanged, Title: stringPointer("Купить журнал"), OccurredAt: time.Now().UTC()}
+			require.NoError(t, events.Append(ctx, concurrent))
+		}}
+		repository = NewEventSourcedRepo(projection, racing)
+		err = repository.Update(ctx, TodoPointers{ID: &created.ID, Status: stringPointer(StatusDone)})
+		require.ErrorIs(t, err, ErrEventVersionConflict)
+		replayed, _, err := LoadTodo(ctx, events, created.ID)
+		require.NoError(t, err)
+		require.Equal(t, replayed, projection.todos[created.ID])
+		require.Equal(t, "Купить журнал", projection.todos[created.ID].Title)
+		require.Equal(t, StatusTodo, projection.todos[created.ID].Status)
+	})
+
+	t.Run("Задачи без событий дописываются только с backfill", func(t *testing.T) {
+		existing := &Todo{ID: primitive.NewObjectID(), Title: "Купить книгу", Status: StatusTodo, ActiveAt: activeAt, CreatedAt: activeAt}
+		projection := newMemoryTodoRepo(existing)
+		events := newMemoryEventStore()
+
+		result, err := RebuildProjection(ctx, events, projection, false)
+		require.NoError(t, err)
+		require.Equal(t, RebuildResult{Orphaned: 1}, result)
+
+		result, err = RebuildProjection(ctx, events, projection, true)
+		require.NoError(t, err)
+		require.Equal(t, RebuildResult{Backfilled: 1, Restored: 1}, result)
+		require.Equal(t, []string{EventRestored}, eventTypes(events.events[existing.ID]))
+		require.Equal(t, existing.Title, projection.todos[existing.ID].Title)
+	})
+}
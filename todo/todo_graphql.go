@@ -0,0 +1,500 @@
+package todo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	command "github.com/kas2000/commandlib"
+	httpLib "github.com/kas2000/http"
+	"github.com/kas2000/logger"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+const (
+	graphqlDefaultLimit = 50
+	graphqlMaxLimit     = 500
+)
+
+// TodoGraphQL serves a GraphQL schema whose types are derived from the DTOs, dispatching the same commands
+// as TodoHttp.
+type TodoGraphQL struct {
+	log        logger.Logger
+	ch         command.CommandHandler
+	validate   *validator.Validate
+	systemName string
+	schema     graphql.Schema
+}
+
+type graphqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// graphqlError exposes the problem details of a domain error as GraphQL error extensions.
+type graphqlError struct {
+	problem *Problem
+}
+
+func (e *graphqlError) Error() string {
+	return e.problem.Detail
+}
+
+func (e *graphqlError) Extensions() map[string]interface{} {
+	extensions := map[string]interface{}{
+		"code":   e.problem.Code,
+		"type":   e.problem.Type,
+		"status": e.problem.Status,
+	}
+	if len(e.problem.Errors) > 0 {
+		extensions["errors"] = e.problem.Errors
+	}
+	return extensions
+}
+
+var comparisonOperatorType = graphql.NewEnum(graphql.EnumConfig{
+	Name: "ComparisonOperator",
+	Values: graphql.EnumValueConfigMap{
+		ComparisonOperatorEQ:  &graphql.EnumValueConfig{Value: ComparisonOperatorEQ},
+		ComparisonOperatorGT:  &graphql.EnumValueConfig{Value: ComparisonOperatorGT},
+		ComparisonOperatorGTE: &graphql.EnumValueConfig{Value: ComparisonOperatorGTE},
+		ComparisonOperatorLT:  &graphql.EnumValueConfig{Value: ComparisonOperatorLT},
+		ComparisonOperatorLTE: &graphql.EnumValueConfig{Value: ComparisonOperatorLTE},
+	},
+})
+
+var activeAtFilterType = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name: "ActiveAtFilter",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"operator": &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(comparisonOperatorType)},
+		"date":     &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String), Description: "Format: YYYY-MM-DD"},
+	},
+})
+
+func NewTodoGraphQL(log logger.Logger, ch command.CommandHandler, validate *validator.Validate, systemName string) (*TodoGraphQL, error) {
+	validate.RegisterTagNameFunc(jsonFieldName)
+	factory := &TodoGraphQL{
+		log:        log,
+		ch:         ch,
+		validate:   validate,
+		systemName: systemName,
+	}
+
+	todoType := graphqlObject("Todo", TodoDTO{})
+	todoPageType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "TodoPage",
+		Fields: graphql.Fields{
+			"items":       &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(todoType)))},
+			"hasNextPage": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+		},
+	})
+	idArgs := graphql.FieldConfigArgument{
+		"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+	}
+
+	queries := graphql.Fields{
+		"todo": &graphql.Field{
+			Type:    todoType,
+			Args:    idArgs,
+			Resolve: factory.resolveTodo,
+		},
+		"todos": &graphql.Field{
+			Type: graphql.NewNonNull(todoPageType),
+			Args: graphql.FieldConfigArgument{
+				"status":   &graphql.ArgumentConfig{Type: graphql.String, DefaultValue: StatusActive},
+				"title":    &graphql.ArgumentConfig{Type: graphql.String},
+				"activeAt": &graphql.ArgumentConfig{Type: activeAtFilterType},
+				"sort":     &graphql.ArgumentConfig{Type: graphql.String, Description: "Same syntax as the REST sort parameter, e.g. \"activeAt,-title\"."},
+				"limit":    &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: graphqlDefaultLimit},
+				"offset":   &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0},
+			},
+			Resolve: factory.resolveTodos,
+		},
+	}
+	mutations := graphql.Fields{
+		"createTodo": &graphql.Field{
+			Type:    graphql.NewNonNull(todoType),
+			Args:    graphqlArgs(CreateTodoDTO{}),
+			Resolve: factory.resolveCreateTodo,
+		},
+		"updateTodo": &graphql.Field{
+			Type:    graphql.NewNonNull(todoType),
+			Args:    graphqlArgs(UpdateTodoDTO{}),
+			Resolve: factory.resolveUpdateTodo,
+		},
+		"markTodoDone": &graphql.Field{
+			Type:    graphql.NewNonNull(todoType),
+			Args:    idArgs,
+			Resolve: factory.resolveMarkTodoDone,
+		},
+		"transitionTodo": &graphql.Field{
+			Type:    graphql.NewNonNull(todoType),
+			Args:    graphqlArgs(TransitionTodoDTO{}),
+			Resolve: factory.resolveTransitionTodo,
+		},
+		"deleteTodo": &graphql.Field{
+			Type:    graphql.NewNonNull(graphql.Boolean),
+			Args:    idArgs,
+			Resolve: factory.resolveDeleteTodo,
+		},
+	}
+	// Every field works on the tasks of one list, given by listId.
+	for _, fields := range []graphql.Fields{queries, mutations} {
+		for _, field := range fields {
+			args := maps.Clone(field.Args)
+			args["listId"] = &graphql.ArgumentConfig{Type: graphql.ID, Description: "List of the tasks, the default list when omitted."}
+			field.Args = args
+			field.Resolve = factory.listed(field.Resolve)
+		}
+	}
+
+	schema, err := graphql.NewSchema(graphql.SchemaConfig{
+		Query:    graphql.NewObject(graphql.ObjectConfig{Name: "Query", Fields: queries}),
+		Mutation: graphql.NewObject(graphql.ObjectConfig{Name: "Mutation", Fields: mutations}),
+	})
+	if err != nil {
+		return nil, err
+	}
+	factory.schema = schema
+	return factory, nil
+}
+
+// graphqlObject builds an object type from the json tags of v so the schema follows the DTO.
+func graphqlObject(name string, v interface{}) *graphql.Object {
+	fields := graphql.Fields{}
+	t := reflect.TypeOf(v)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Tag.Get("json") == "-" {
+			continue
+		}
+		fields[jsonFieldName(field)] = &graphql.Field{Type: graphqlType(field)}
+	}
+	return graphql.NewObject(graphql.ObjectConfig{Name: name, Fields: fields})
+}
+
+// graphqlArgs builds mutation arguments from the json and validate tags of v.
+func graphqlArgs(v interface{}) graphql.FieldConfigArgument {
+	args := graphql.FieldConfigArgument{}
+	t := reflect.TypeOf(v)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Tag.Get("json") == "-" {
+			continue
+		}
+		args[jsonFieldName(field)] = &graphql.ArgumentConfig{Type: graphqlType(field)}
+	}
+	return args
+}
+
+func graphqlType(field reflect.StructField) graphql.Output {
+	var output graphql.Output
+	nullable := field.Type.Kind() == reflect.Ptr
+	fieldType := field.Type
+	if nullable {
+		fieldType = fieldType.Elem()
+	}
+	switch {
+	case fieldType == reflect.TypeOf(primitive.ObjectID{}) || jsonFieldName(field) == "id":
+		output = graphql.ID
+	case fieldType == reflect.TypeOf(time.Time{}):
+		output = graphql.DateTime
+	case fieldType.Kind() == reflect.Bool:
+		output = graphql.Boolean
+	case fieldType.Kind() == reflect.Int, fieldType.Kind() == reflect.Int64:
+		output = graphql.Int
+	default:
+		output = graphql.String
+	}
+	if nullable || (strings.Contains(field.Tag.Get("json"), "omitempty") && !strings.Contains(field.Tag.Get("validate"), "required")) {
+		return output
+	}
+	return graphql.NewNonNull(output)
+}
+
+// decodeArgs maps resolved arguments onto a DTO through its json tags.
+func decodeArgs(args map[string]interface{}, v interface{}) error {
+	body, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return ErrInvalidRequestBody
+	}
+	return nil
+}
+
+func (factory *TodoGraphQL) error(ctx context.Context, err error) error {
+	if _, registered := LookupError(err); !registered {
+		LoggerFromContext(ctx, factory.log).Warn("unhandled error", zap.Error(err))
+	}
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		err = newValidationErrorFromValidator(validationErrs)
+	}
+	return &graphqlError{problem: NewProblem(err, factory.systemName, "")}
+}
+
+// listed runs resolve on the tasks of the list of the listId argument, which it takes out of the arguments.
+func (factory *TodoGraphQL) listed(resolve graphql.FieldResolveFn) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		if listID, found := p.Args["listId"].(string); found {
+			id, err := primitive.ObjectIDFromHex(listID)
+			if err != nil {
+				return nil, factory.error(p.Context, ErrInvalidTodoListID)
+			}
+			p.Context = ContextWithList(p.Context, id)
+			p.Args = maps.Clone(p.Args)
+			delete(p.Args, "listId")
+		}
+		return resolve(p)
+	}
+}
+
+func (factory *TodoGraphQL) todoID(p graphql.ResolveParams) (primitive.ObjectID, error) {
+	id, _ := p.Args["id"].(string)
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return primitive.NilObjectID, ErrInvalidTodoID
+	}
+	return objID, nil
+}
+
+func (factory *TodoGraphQL) findTodo(ctx context.Context, id primitive.ObjectID) (interface{}, error) {
+	resp, err := factory.ch.ExecuteCommand(&FindTodoCommand{CommandContext: NewCommandContext(ctx), ID: id})
+	if err != nil {
+		return nil, factory.error(ctx, err)
+	}
+	return resp, nil
+}
+
+// findWritten reads the task back once the read model has the writes recorded in ctx, so a mutation returns the
+// task as it left it.
+func (factory *TodoGraphQL) findWritten(ctx context.Context, id primitive.ObjectID) (interface{}, error) {
+	return factory.findTodo(ContextWithConsistencyToken(ctx, RecordedConsistencyToken(ctx)), id)
+}
+
+func (factory *TodoGraphQL) resolveTodo(p graphql.ResolveParams) (interface{}, error) {
+	objID, err := factory.todoID(p)
+	if err != nil {
+		return nil, factory.error(p.Context, err)
+	}
+	resp, err := factory.ch.ExecuteCommand(&FindTodoCommand{CommandContext: NewCommandContext(p.Context), ID: objID})
+	if errors.Is(err, ErrTodoNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, factory.error(p.Context, err)
+	}
+	return resp, nil
+}
+
+func (factory *TodoGraphQL) resolveTodos(p graphql.ResolveParams) (interface{}, error) {
+	var pointers TodoPointers
+	status := strings.ToUpper(p.Args["status"].(string))
+	pointers.Status = &status
+	if title, found := p.Args["title"].(string); found {
+		pointers.Title = &title
+	}
+	if filter, found := p.Args["activeAt"].(map[string]interface{}); found {
+		operator := filter["operator"].(string)
+		activeAt, err := time.Parse(dateLayout, filter["date"].(string))
+		if err != nil {
+			return nil, factory.error(p.Context, NewValidationError(ErrInvalidDateFormat, FieldError{
+				Field: "activeAt.date",
+				Rule:  "datetime",
+				Param: dateLayout,
+			}))
+		}
+		pointers.ActiveAt = &ActiveAtPointers{ComparisonOperator: &operator, ActiveAt: &activeAt}
+	}
+	if sort, found := p.Args["sort"].(string); found {
+		parsed, err := ParseSort(sort)
+		if err != nil {
+			return nil, factory.error(p.Context, err)
+		}
+		pointers.Sort = parsed
+	}
+
+	limit, _ := p.Args["limit"].(int)
+	offset, _ := p.Args["offset"].(int)
+	if limit <= 0 || offset < 0 {
+		return nil, factory.error(p.Context, NewValidationError(ErrValidationFailed, FieldError{Field: "limit", Rule: "gt", Param: "0"}))
+	}
+	if limit > graphqlMaxLimit {
+		return nil, factory.error(p.Context, NewValidationError(ErrValidationFailed, FieldError{Field: "limit", Rule: "lte", Param: strconv.Itoa(graphqlMaxLimit)}))
+	}
+	// One extra task tells whether another page exists.
+	pointers.Limit = int64(limit) + 1
+	pointers.Offset = int64(offset)
+
+	resp, err := factory.ch.ExecuteCommand(&FindTodosCommand{CommandContext: NewCommandContext(p.Context), TodoPointers: pointers})
+	if err != nil {
+		return nil, factory.error(p.Context, err)
+	}
+	todos := resp.([]*TodoDTO)
+	hasNextPage := len(todos) > limit
+	if hasNextPage {
+		todos = todos[:limit]
+	}
+	return map[string]interface{}{
+		"items":       todos,
+		"hasNextPage": hasNextPage,
+	}, nil
+}
+
+func (factory *TodoGraphQL) resolveCreateTodo(p graphql.ResolveParams) (interface{}, error) {
+	var todo CreateTodoDTO
+	if err := decodeArgs(p.Args, &todo); err != nil {
+		return nil, factory.error(p.Context, err)
+	}
+	if err := factory.validate.Struct(todo); err != nil {
+		return nil, factory.error(p.Context, err)
+	}
+
+	resp, err := factory.ch.ExecuteCommand(&CreateTodoCommand{CommandContext: NewCommandContext(p.Context), CreateTodoDTO: &todo})
+	if err != nil {
+		return nil, factory.error(p.Context, err)
+	}
+	return resp, nil
+}
+
+func (factory *TodoGraphQL) resolveUpdateTodo(p graphql.ResolveParams) (interface{}, error) {
+	objID, err := factory.todoID(p)
+	if err != nil {
+		return nil, factory.error(p.Context, err)
+	}
+	var upd UpdateTodoDTO
+	args := make(map[string]interface{}, len(p.Args))
+	for key, value := range p.Args {
+		if key != "id" {
+			args[key] = value
+		}
+	}
+	if err := decodeArgs(args, &upd); err != nil {
+		return nil, factory.error(p.Context, err)
+	}
+	upd.ID = objID
+	if err := factory.validate.Struct(upd); err != nil {
+		return nil, factory.error(p.Context, err)
+	}
+
+	ctx := ContextWithConsistencyRecorder(p.Context)
+	if _, err := factory.ch.ExecuteCommand(&UpdateTodoCommand{CommandContext: NewCommandContext(ctx), UpdateTodoDTO: upd}); err != nil {
+		return nil, factory.error(p.Context, err)
+	}
+	return factory.findWritten(ctx, objID)
+}
+
+func (factory *TodoGraphQL) resolveMarkTodoDone(p graphql.ResolveParams) (interface{}, error) {
+	objID, err := factory.todoID(p)
+	if err != nil {
+		return nil, factory.error(p.Context, err)
+	}
+	return factory.transition(p.Context, TransitionTodoDTO{ID: objID, Status: StatusDone})
+}
+
+func (factory *TodoGraphQL) resolveTransitionTodo(p graphql.ResolveParams) (interface{}, error) {
+	objID, err := factory.todoID(p)
+	if err != nil {
+		return nil, factory.error(p.Context, err)
+	}
+	transition := TransitionTodoDTO{ID: objID, Status: strings.ToUpper(p.Args["status"].(string))}
+	if err := factory.validate.Struct(transition); err != nil {
+		return nil, factory.error(p.Context, err)
+	}
+	return factory.transition(p.Context, transition)
+}
+
+func (factory *TodoGraphQL) transition(ctx context.Context, transition TransitionTodoDTO) (interface{}, error) {
+	written := ContextWithConsistencyRecorder(ctx)
+	cmd := UpdateTodoStatusCommand{CommandContext: NewCommandContext(written), TransitionTodoDTO: transition}
+	if _, err := factory.ch.ExecuteCommand(&cmd); err != nil {
+		return nil, factory.error(ctx, err)
+	}
+	return factory.findWritten(written, transition.ID)
+}
+
+func (factory *TodoGraphQL) resolveDeleteTodo(p graphql.ResolveParams) (interface{}, error) {
+	objID, err := factory.todoID(p)
+	if err != nil {
+		return nil, factory.error(p.Context, err)
+	}
+
+	if _, err := factory.ch.ExecuteCommand(&DeleteTodoCommand{CommandContext: NewCommandContext(p.Context), ID: objID}); err != nil {
+		return nil, factory.error(p.Context, err)
+	}
+	return true, nil
+}
+
+// Execute runs a GraphQL request against the schema.
+func (factory *TodoGraphQL) Execute(ctx context.Context, req graphqlRequest) *graphql.Result {
+	return graphql.Do(graphql.Params{
+		Context:        ctx,
+		Schema:         factory.schema,
+		RequestString:  req.Query,
+		OperationName:  req.OperationName,
+		VariableValues: req.Variables,
+	})
+}
+
+// isMutation tells whether req runs a mutation. A query that doesn't parse is left to execution to report.
+func isMutation(req graphqlRequest) bool {
+	document, err := parser.Parse(parser.ParseParams{Source: req.Query})
+	if err != nil {
+		return false
+	}
+	for _, definition := range document.Definitions {
+		operation, ok := definition.(*ast.OperationDefinition)
+		if !ok || operation.Operation != ast.OperationTypeMutation {
+			continue
+		}
+		if req.OperationName == "" || (operation.Name != nil && operation.Name.Value == req.OperationName) {
+			return true
+		}
+	}
+	return false
+}
+
+// Endpoint accepts POST bodies and GET query strings. GET only runs queries, so that links and prefetches can't
+// write. Execution errors are reported in the result with 200.
+func (factory *TodoGraphQL) Endpoint() httpLib.Endpoint {
+	return func(w http.ResponseWriter, r *http.Request) httpLib.Response {
+		var req graphqlRequest
+		if r.Method == http.MethodGet {
+			req.Query = r.URL.Query().Get("query")
+			req.OperationName = r.URL.Query().Get("operationName")
+			if variables := r.URL.Query().Get("variables"); variables != "" {
+				if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+					return factory.problem(r, ErrInvalidRequestBody)
+				}
+			}
+		} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return factory.problem(r, ErrInvalidRequestBody)
+		}
+		if req.Query == "" {
+			return factory.problem(r, NewValidationError(ErrValidationFailed, FieldError{Field: "query", Rule: "required"}))
+		}
+		if r.Method == http.MethodGet && isMutation(req) {
+			problem := NewProblem(ErrMutationNotAllowed, factory.systemName, r.URL.Path)
+			return httpLib.NewResponse(problem.Status, problem, map[string]string{"Content-Type": ProblemContentType, "Allow": http.MethodPost})
+		}
+		return httpLib.NewResponse(http.StatusOK, factory.Execute(r.Context(), req), nil)
+	}
+}
+
+func (factory *TodoGraphQL) problem(r *http.Request, err error) httpLib.Response {
+	return newProblemResponse(NewProblem(err, factory.systemName, r.URL.Path))
+}
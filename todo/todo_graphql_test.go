@@ -0,0 +1,232 @@
+package todo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/graphql-go/graphql"
+	command "github.com/kas2000/commandlib"
+	"github.com/kas2000/logger"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+func newTestTodoGraphQL(t *testing.T, ch *stubCommandHandler) *TodoGraphQL {
+	log, _ := logger.New("debug")
+	todoGraphQL, err := NewTodoGraphQL(log, ch, validator.New(), "todo-service")
+	require.NoError(t, err)
+	return todoGraphQL
+}
+
+func TestGraphQLSchemaFollowsDTOs(t *testing.T) {
+	todoGraphQL := newTestTodoGraphQL(t, &stubCommandHandler{})
+
+	todoType := todoGraphQL.schema.Type("Todo").(*graphql.Object)
+	dtoType := reflect.TypeOf(TodoDTO{})
+	require.Len(t, todoType.Fields(), dtoType.NumField())
+	for i := 0; i < dtoType.NumField(); i++ {
+		require.Contains(t, todoType.Fields(), jsonFieldName(dtoType.Field(i)))
+	}
+
+	createArgs := map[string]bool{}
+	for _, arg := range todoGraphQL.schema.MutationType().Fields()["createTodo"].Args {
+		createArgs[arg.Name()] = true
+	}
+	require.Equal(t, map[string]bool{"title": true, "activeAt": true, "listId": true}, createArgs)
+}
+
+func TestGraphQLListID(t *testing.T) {
+	list := "64da1f106083a1acd4d8f120"
+	ch := &stubCommandHandler{resp: &TodoDTO{ID: "64da1f106083a1acd4d8f116", ListID: list, Title: "Купить книгу", ActiveAt: "2023-08-04"}}
+	todoGraphQL := newTestTodoGraphQL(t, ch)
+
+	result := todoGraphQL.Execute(context.Background(), graphqlRequest{
+		Query: `mutation { createTodo(listId: "` + list + `", title: "Купить книгу", activeAt: "2023-08-04") { id listId } }`,
+	})
+	require.Empty(t, result.Errors)
+	require.Equal(t, list, result.Data.(map[string]interface{})["createTodo"].(map[string]interface{})["listId"])
+	cmd := ch.executed[0].(*CreateTodoCommand)
+	require.Equal(t, list, ListFromContext(cmd.Context()).Hex())
+	require.Equal(t, "Купить книгу", cmd.Title)
+
+	result = todoGraphQL.Execute(context.Background(), graphqlRequest{Query: `{ todo(id: "64da1f106083a1acd4d8f116") { id } }`})
+	require.Empty(t, result.Errors)
+	require.Equal(t, DefaultListID, ListFromContext(ch.executed[1].(*FindTodoCommand).Context()), "без listId — список по умолчанию")
+
+	result = todoGraphQL.Execute(context.Background(), graphqlRequest{Query: `{ todo(id: "64da1f106083a1acd4d8f116", listId: "work") { id } }`})
+	require.Len(t, result.Errors, 1)
+	require.Contains(t, result.Errors[0].Message, ErrInvalidTodoListID.Error())
+	require.Len(t, ch.executed, 2)
+}
+
+func TestGraphQLTodos(t *testing.T) {
+	ch := &stubCommandHandler{resp: []*TodoDTO{
+		{ID: "64da1f106083a1acd4d8f116", Title: "Купить книгу", Status: StatusTodo, ActiveAt: "2023-08-04", CreatedAt: time.Now()},
+		{ID: "64da1fabd21e112c5bb1c299", Title: "Купить ручку", Status: StatusTodo, ActiveAt: "2023-08-04", CreatedAt: time.Now()},
+	}}
+	todoGraphQL := newTestTodoGraphQL(t, ch)
+
+	body := `{"query":"query($limit: Int) { todos(status: \"done\", title: \"Купить книгу\", activeAt: {operator: GTE, date: \"2023-08-01\"}, sort: \"-activeAt\", limit: $limit) { hasNextPage items { id title } } }","variables":{"limit":1}}`
+	req, err := http.NewRequest(http.MethodPost, "/api/graphql", strings.NewReader(body))
+	require.NoError(t, err)
+
+	retData := todoGraphQL.Endpoint()(httptest.NewRecorder(), req)
+	require.Equal(t, http.StatusOK, retData.StatusCode())
+	result := retData.Response().(*graphql.Result)
+	require.Empty(t, result.Errors)
+	todos := result.Data.(map[string]interface{})["todos"].(map[string]interface{})
+	require.Equal(t, true, todos["hasNextPage"])
+	require.Equal(t, []interface{}{map[string]interface{}{"id": "64da1f106083a1acd4d8f116", "title": "Купить книгу"}}, todos["items"])
+
+	cmd := ch.executed[0].(*FindTodosCommand)
+	require.Equal(t, StatusDone, *cmd.Status)
+	require.Equal(t, "Купить книгу", *cmd.Title)
+	require.Equal(t, ComparisonOperatorGTE, *cmd.ActiveAt.ComparisonOperator)
+	require.Equal(t, []SortField{{Field: "activeAt", Descending: true}}, cmd.Sort)
+	require.Equal(t, int64(2), cmd.Limit)
+}
+
+func TestGraphQLMutationErrors(t *testing.T) {
+	ch := &stubCommandHandler{err: ErrTodoAlreadyExists}
+	todoGraphQL := newTestTodoGraphQL(t, ch)
+
+	result := todoGraphQL.Execute(context.Background(), graphqlRequest{Query: `mutation { createTodo(title: "Купить книгу", activeAt: "2023-08-04") { id } }`})
+	require.Len(t, result.Errors, 1)
+	require.Equal(t, "todo-service.1102", result.Errors[0].Extensions["code"])
+	require.Equal(t, http.StatusConflict, result.Errors[0].Extensions["status"])
+
+	result = todoGraphQL.Execute(context.Background(), graphqlRequest{Query: `mutation { deleteTodo(id: "not-an-id") }`})
+	require.Len(t, result.Errors, 1)
+	require.Equal(t, "todo-service.1002", result.Errors[0].Extensions["code"])
+	require.Len(t, ch.executed, 1)
+}
+
+// slowTodoViews applies every change late, like a projector that is behind the writes.
+type slowTodoViews struct {
+	*memoryTodoViews
+}
+
+func (views slowTodoViews) Apply(ctx context.Context, change *TodoChange) error {
+	time.Sleep(50 * time.Millisecond)
+	return views.memoryTodoViews.Apply(ctx, change)
+}
+
+func TestGraphQLMutationReturnsItsWrite(t *testing.T) {
+	ctx := context.Background()
+	existing := &Todo{ID: primitive.NewObjectID(), Title: "Позвонить маме", Status: StatusTodo, ActiveAt: time.Date(2023, 8, 7, 0, 0, 0, 0, time.UTC)}
+	changes := &racingChangeLog{gapWait: time.Second}
+	todos := NewChangeLoggingRepo(newMemoryTodoRepo(existing), changes, zap.NewNop())
+	views := slowTodoViews{newMemoryTodoViews()}
+	projector := NewTodoProjector(changes, views, todos, zap.NewNop())
+	_, err := projector.Build(ctx)
+	require.NoError(t, err)
+	runCtx, cancel := context.WithCancel(ctx)
+	stopped := make(chan struct{})
+	go func() {
+		projector.Run(runCtx)
+		close(stopped)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+	service := NewReadModelService(NewService(todos, newMemoryTodoListRepo(), zap.NewNop()), views, projector, 5*time.Second)
+	todoGraphQL, err := NewTodoGraphQL(zap.NewNop(), command.NewCommandHandler(service), validator.New(), "todo-service")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		title    string
+		query    string
+		field    string
+		expected map[string]interface{}
+	}{
+		{
+			title:    "Изменение задачи",
+			query:    `mutation { updateTodo(id: "` + existing.ID.Hex() + `", title: "Позвонить папе", activeAt: "2023-08-07") { title status } }`,
+			field:    "updateTodo",
+			expected: map[string]interface{}{"title": "Позвонить папе", "status": StatusTodo},
+		},
+		{
+			title:    "Смена статуса",
+			query:    `mutation { transitionTodo(id: "` + existing.ID.Hex() + `", status: "IN_PROGRESS") { title status } }`,
+			field:    "transitionTodo",
+			expected: map[string]interface{}{"title": "Позвонить папе", "status": StatusInProgress},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.title, func(t *testing.T) {
+			result := todoGraphQL.Execute(ctx, graphqlRequest{Query: tc.query})
+			require.Empty(t, result.Errors)
+			require.Equal(t, tc.expected, result.Data.(map[string]interface{})[tc.field])
+		})
+	}
+}
+
+func TestGraphQLOverGet(t *testing.T) {
+	id := "64da1f106083a1acd4d8f116"
+	testCases := []struct {
+		title         string
+		query         string
+		operationName string
+		wantStatus    int
+		wantCode      string
+	}{
+		{title: "Запрос", query: `{ todo(id: "` + id + `") { id } }`, wantStatus: http.StatusOK},
+		{title: "Мутация", query: `mutation { deleteTodo(id: "` + id + `") }`, wantStatus: http.StatusMethodNotAllowed, wantCode: "todo-service.1012"},
+		{
+			title:         "Мутация, выбранная по имени",
+			query:         `query Find { todo(id: "` + id + `") { id } } mutation Delete { deleteTodo(id: "` + id + `") }`,
+			operationName: "Delete",
+			wantStatus:    http.StatusMethodNotAllowed,
+			wantCode:      "todo-service.1012",
+		},
+		{
+			title:         "Запрос из документа с мутацией",
+			query:         `query Find { todo(id: "` + id + `") { id } } mutation Delete { deleteTodo(id: "` + id + `") }`,
+			operationName: "Find",
+			wantStatus:    http.StatusOK,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.title, func(t *testing.T) {
+			ch := &stubCommandHandler{resp: &TodoDTO{ID: id}}
+			todoGraphQL := newTestTodoGraphQL(t, ch)
+			query := url.Values{"query": {tc.query}}
+			if tc.operationName != "" {
+				query.Set("operationName", tc.operationName)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/api/graphql?"+query.Encode(), nil)
+
+			resp := todoGraphQL.Endpoint()(httptest.NewRecorder(), req)
+			require.Equal(t, tc.wantStatus, resp.StatusCode())
+			if tc.wantCode == "" {
+				return
+			}
+			require.Equal(t, tc.wantCode, resp.Response().(*Problem).Code)
+			require.Equal(t, http.MethodPost, resp.Headers()["Allow"])
+			require.Empty(t, ch.executed)
+		})
+	}
+}
+
+func TestGraphQLLimit(t *testing.T) {
+	ch := &stubCommandHandler{resp: []*TodoDTO{}}
+	todoGraphQL := newTestTodoGraphQL(t, ch)
+
+	result := todoGraphQL.Execute(context.Background(), graphqlRequest{Query: `{ todos(limit: 501) { hasNextPage } }`})
+	require.Len(t, result.Errors, 1)
+	require.Equal(t, "todo-service.1003", result.Errors[0].Extensions["code"])
+	require.Empty(t, ch.executed)
+
+	result = todoGraphQL.Execute(context.Background(), graphqlRequest{Query: `{ todos(limit: 500) { hasNextPage } }`})
+	require.Empty(t, result.Errors)
+	require.Equal(t, int64(501), ch.executed[0].(*FindTodosCommand).Limit)
+}
@@ -0,0 +1,358 @@
+package todo
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	command "github.com/kas2000/commandlib"
+	"github.com/kas2000/logger"
+	"github.com/kas2000/service-todo/todopb"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcCodes maps the HTTP statuses used in the error registry to gRPC codes.
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:          codes.InvalidArgument,
+	http.StatusNotFound:            codes.NotFound,
+	http.StatusConflict:            codes.AlreadyExists,
+	http.StatusUnprocessableEntity: codes.FailedPrecondition,
+	http.StatusForbidden:           codes.PermissionDenied,
+	http.StatusInternalServerError: codes.Internal,
+	http.StatusServiceUnavailable:  codes.Unavailable,
+}
+
+// TodoGrpc serves todopb.TodoService by dispatching the same commands as TodoHttp.
+type TodoGrpc struct {
+	todopb.UnimplementedTodoServiceServer
+
+	log        logger.Logger
+	ch         command.CommandHandler
+	validate   *validator.Validate
+	systemName string
+	zones      TimeZones
+}
+
+func NewTodoGrpc(log logger.Logger, ch command.CommandHandler, validate *validator.Validate, systemName string) *TodoGrpc {
+	validate.RegisterTagNameFunc(jsonFieldName)
+	return &TodoGrpc{
+		log:        log,
+		ch:         ch,
+		validate:   validate,
+		systemName: systemName,
+	}
+}
+
+// WithTimeZones reckons dates in the time zone named by the time-zone metadata of a call, else as zones picks.
+func (factory *TodoGrpc) WithTimeZones(zones TimeZones) *TodoGrpc {
+	factory.zones = zones
+	return factory
+}
+
+// zoned returns ctx in the time zone of the call.
+func (factory *TodoGrpc) zoned(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	first := func(key string) string {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	loc, err := factory.zones.Resolve(first(TimeZoneHeader), first("authorization"))
+	if err != nil {
+		return ctx, err
+	}
+	return ContextWithLocation(ctx, loc), nil
+}
+
+// UnaryRecovery turns a panicking call into an internal error instead of crashing the service, like
+// RecoverCommands does for commands.
+func (factory *TodoGrpc) UnaryRecovery() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				resp, err = nil, factory.recovered(ctx, info.FullMethod, recovered)
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// StreamRecovery is UnaryRecovery for streaming calls.
+func (factory *TodoGrpc) StreamRecovery() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				err = factory.recovered(ss.Context(), info.FullMethod, recovered)
+			}
+		}()
+		return handler(srv, ss)
+	}
+}
+
+// recovered logs a panic with its stack and reports it as ErrInternal, which status doesn't log again.
+func (factory *TodoGrpc) recovered(ctx context.Context, method string, recovered interface{}) error {
+	LoggerFromContext(ctx, factory.log).Warn("grpc call panicked",
+		zap.String("method", method), zap.Any("panic", recovered), zap.Stack("stack"))
+	return factory.status(ErrInternal)
+}
+
+// status converts err to a gRPC status carrying the registry code and any field violations.
+func (factory *TodoGrpc) status(err error) error {
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		err = newValidationErrorFromValidator(validationErrs)
+	}
+	def, registered := LookupError(err)
+	message := def.Err.Error()
+	if registered {
+		message = err.Error()
+	} else if !errors.Is(err, ErrInternal) {
+		factory.log.Warn("unhandled error", zap.Error(err))
+	}
+
+	code, found := grpcCodes[def.Status]
+	if !found {
+		code = codes.Unknown
+	}
+	st := status.New(code, message)
+
+	details := []protoadapt.MessageV1{&errdetails.ErrorInfo{
+		Reason:   def.Slug,
+		Domain:   factory.systemName,
+		Metadata: map[string]string{"code": factory.systemName + "." + strconv.Itoa(def.Code)},
+	}}
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		badRequest := &errdetails.BadRequest{}
+		for _, fe := range validationErr.Fields {
+			badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       fe.Field,
+				Description: strings.TrimSpace(fe.Rule + " " + fe.Param),
+			})
+		}
+		details = append(details, badRequest)
+	}
+	if withDetails, err := st.WithDetails(details...); err == nil {
+		st = withDetails
+	}
+	return st.Err()
+}
+
+func (factory *TodoGrpc) todoID(id string) (primitive.ObjectID, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return primitive.NilObjectID, ErrInvalidTodoID
+	}
+	return objID, nil
+}
+
+// listed returns ctx on the tasks of the list listID, the default list when empty.
+func (factory *TodoGrpc) listed(ctx context.Context, listID string) (context.Context, error) {
+	if listID == "" {
+		return ctx, nil
+	}
+	id, err := primitive.ObjectIDFromHex(listID)
+	if err != nil {
+		return ctx, ErrInvalidTodoListID
+	}
+	return ContextWithList(ctx, id), nil
+}
+
+func (factory *TodoGrpc) CreateTodo(ctx context.Context, req *todopb.CreateTodoRequest) (*todopb.Todo, error) {
+	todo := CreateTodoDTO{
+		Title:    req.GetTitle(),
+		ActiveAt: req.GetActiveAt(),
+	}
+	if err := factory.validate.Struct(todo); err != nil {
+		return nil, factory.status(err)
+	}
+	ctx, err := factory.listed(ctx, req.GetListId())
+	if err != nil {
+		return nil, factory.status(err)
+	}
+
+	resp, err := factory.ch.ExecuteCommand(&CreateTodoCommand{CommandContext: NewCommandContext(ctx), CreateTodoDTO: &todo})
+	if err != nil {
+		return nil, factory.status(err)
+	}
+	return newProtoTodo(resp.(*TodoDTO)), nil
+}
+
+func (factory *TodoGrpc) GetTodo(ctx context.Context, req *todopb.GetTodoRequest) (*todopb.Todo, error) {
+	objID, err := factory.todoID(req.GetId())
+	if err != nil {
+		return nil, factory.status(err)
+	}
+	ctx, err = factory.listed(ctx, req.GetListId())
+	if err != nil {
+		return nil, factory.status(err)
+	}
+
+	resp, err := factory.ch.ExecuteCommand(&FindTodoCommand{CommandContext: NewCommandContext(ctx), ID: objID})
+	if err != nil {
+		return nil, factory.status(err)
+	}
+	return newProtoTodo(resp.(*TodoDTO)), nil
+}
+
+func (factory *TodoGrpc) findTodos(ctx context.Context, req *todopb.ListTodosRequest) ([]*TodoDTO, error) {
+	var pointers TodoPointers
+	todoStatus := StatusActive
+	if req.GetStatus() != "" {
+		todoStatus = strings.ToUpper(req.GetStatus())
+	}
+	pointers.Status = &todoStatus
+	if req.Title != nil {
+		title := req.GetTitle()
+		pointers.Title = &title
+	}
+	sort, err := ParseSort(req.GetSort())
+	if err != nil {
+		return nil, err
+	}
+	pointers.Sort = sort
+	ctx, err = factory.zoned(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx, err = factory.listed(ctx, req.GetListId())
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := factory.ch.ExecuteCommand(&FindTodosCommand{CommandContext: NewCommandContext(ctx), TodoPointers: pointers})
+	if err != nil {
+		return nil, err
+	}
+	return resp.([]*TodoDTO), nil
+}
+
+func (factory *TodoGrpc) ListTodos(ctx context.Context, req *todopb.ListTodosRequest) (*todopb.ListTodosResponse, error) {
+	todos, err := factory.findTodos(ctx, req)
+	if err != nil {
+		return nil, factory.status(err)
+	}
+	result := &todopb.ListTodosResponse{Todos: make([]*todopb.Todo, 0, len(todos))}
+	for _, todo := range todos {
+		result.Todos = append(result.Todos, newProtoTodo(todo))
+	}
+	return result, nil
+}
+
+func (factory *TodoGrpc) StreamTodos(req *todopb.ListTodosRequest, stream todopb.TodoService_StreamTodosServer) error {
+	todos, err := factory.findTodos(stream.Context(), req)
+	if err != nil {
+		return factory.status(err)
+	}
+	for _, todo := range todos {
+		if err := stream.Send(newProtoTodo(todo)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (factory *TodoGrpc) UpdateTodo(ctx context.Context, req *todopb.UpdateTodoRequest) (*emptypb.Empty, error) {
+	objID, err := factory.todoID(req.GetId())
+	if err != nil {
+		return nil, factory.status(err)
+	}
+	ctx, err = factory.listed(ctx, req.GetListId())
+	if err != nil {
+		return nil, factory.status(err)
+	}
+	upd := UpdateTodoDTO{
+		ID:       objID,
+		Title:    req.GetTitle(),
+		ActiveAt: req.GetActiveAt(),
+	}
+	if err := factory.validate.Struct(upd); err != nil {
+		return nil, factory.status(err)
+	}
+
+	if _, err := factory.ch.ExecuteCommand(&UpdateTodoCommand{CommandContext: NewCommandContext(ctx), UpdateTodoDTO: upd}); err != nil {
+		return nil, factory.status(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (factory *TodoGrpc) MarkTodoDone(ctx context.Context, req *todopb.MarkTodoDoneRequest) (*emptypb.Empty, error) {
+	objID, err := factory.todoID(req.GetId())
+	if err != nil {
+		return nil, factory.status(err)
+	}
+	ctx, err = factory.listed(ctx, req.GetListId())
+	if err != nil {
+		return nil, factory.status(err)
+	}
+	return factory.transition(ctx, TransitionTodoDTO{ID: objID, Status: StatusDone})
+}
+
+func (factory *TodoGrpc) TransitionTodo(ctx context.Context, req *todopb.TransitionTodoRequest) (*emptypb.Empty, error) {
+	objID, err := factory.todoID(req.GetId())
+	if err != nil {
+		return nil, factory.status(err)
+	}
+	ctx, err = factory.listed(ctx, req.GetListId())
+	if err != nil {
+		return nil, factory.status(err)
+	}
+	transition := TransitionTodoDTO{ID: objID, Status: strings.ToUpper(req.GetStatus())}
+	if err := factory.validate.Struct(transition); err != nil {
+		return nil, factory.status(err)
+	}
+	return factory.transition(ctx, transition)
+}
+
+func (factory *TodoGrpc) transition(ctx context.Context, transition TransitionTodoDTO) (*emptypb.Empty, error) {
+	cmd := UpdateTodoStatusCommand{CommandContext: NewCommandContext(ctx), TransitionTodoDTO: transition}
+	if _, err := factory.ch.ExecuteCommand(&cmd); err != nil {
+		return nil, factory.status(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (factory *TodoGrpc) DeleteTodo(ctx context.Context, req *todopb.DeleteTodoRequest) (*emptypb.Empty, error) {
+	objID, err := factory.todoID(req.GetId())
+	if err != nil {
+		return nil, factory.status(err)
+	}
+	ctx, err = factory.listed(ctx, req.GetListId())
+	if err != nil {
+		return nil, factory.status(err)
+	}
+
+	if _, err := factory.ch.ExecuteCommand(&DeleteTodoCommand{CommandContext: NewCommandContext(ctx), ID: objID}); err != nil {
+		return nil, factory.status(err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func newProtoTodo(todo *TodoDTO) *todopb.Todo {
+	result := &todopb.Todo{
+		Id:        todo.ID,
+		ListId:    todo.ListID,
+		Title:     todo.Title,
+		Status:    todo.Status,
+		ActiveAt:  todo.ActiveAt,
+		DayType:   string(todo.DayType),
+		CreatedAt: timestamppb.New(todo.CreatedAt),
+	}
+	if todo.UpdatedAt != nil {
+		result.UpdatedAt = timestamppb.New(*todo.UpdatedAt)
+	}
+	return result
+}
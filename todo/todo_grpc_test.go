@@ -0,0 +1,133 @@
+package todo
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	command "github.com/kas2000/commandlib"
+	"github.com/kas2000/logger"
+	"github.com/kas2000/service-todo/todopb"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+type stubCommandHandler struct {
+	resp     interface{}
+	err      error
+	executed []command.Command
+}
+
+func (ch *stubCommandHandler) ExecuteCommand(cmd command.Command) (interface{}, error) {
+	ch.executed = append(ch.executed, cmd)
+	return ch.resp, ch.err
+}
+
+func newTestGrpcClient(t *testing.T, ch command.CommandHandler) todopb.TodoServiceClient {
+	log, _ := logger.New("debug")
+	listener := bufconn.Listen(1024 * 1024)
+	todoGrpc := NewTodoGrpc(log, ch, validator.New(), "todo-service")
+	grpcServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(todoGrpc.UnaryRecovery()),
+		grpc.ChainStreamInterceptor(todoGrpc.StreamRecovery()),
+	)
+	todopb.RegisterTodoServiceServer(grpcServer, todoGrpc)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return todopb.NewTodoServiceClient(conn)
+}
+
+func TestGrpcErrors(t *testing.T) {
+	ch := &stubCommandHandler{err: ErrTodoNotFound}
+	client := newTestGrpcClient(t, ch)
+
+	_, err := client.GetTodo(context.Background(), &todopb.GetTodoRequest{Id: "64da1f106083a1acd4d8f117"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = client.GetTodo(context.Background(), &todopb.GetTodoRequest{Id: "not-an-id"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.CreateTodo(context.Background(), &todopb.CreateTodoRequest{ActiveAt: "2023-08-04"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	var violations []*errdetails.BadRequest_FieldViolation
+	for _, detail := range status.Convert(err).Details() {
+		if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+			violations = badRequest.FieldViolations
+		}
+	}
+	require.Len(t, violations, 1)
+	require.Equal(t, "title", violations[0].Field)
+
+	_, err = client.DeleteTodo(context.Background(), &todopb.DeleteTodoRequest{Id: "64da1f106083a1acd4d8f117", ListId: "work"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Only the lookup with a valid id reached the command handler.
+	require.Len(t, ch.executed, 1)
+}
+
+// panickingCommandHandler panics on every command.
+type panickingCommandHandler struct{}
+
+func (ch panickingCommandHandler) ExecuteCommand(cmd command.Command) (interface{}, error) {
+	panic("boom")
+}
+
+func TestGrpcRecovery(t *testing.T) {
+	client := newTestGrpcClient(t, panickingCommandHandler{})
+
+	_, err := client.GetTodo(context.Background(), &todopb.GetTodoRequest{Id: "64da1f106083a1acd4d8f117"})
+	require.Equal(t, codes.Internal, status.Code(err))
+	require.Equal(t, "internal error.", status.Convert(err).Message())
+
+	stream, err := client.StreamTodos(context.Background(), &todopb.ListTodosRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.Internal, status.Code(err))
+
+	_, err = client.GetTodo(context.Background(), &todopb.GetTodoRequest{Id: "64da1f106083a1acd4d8f117"})
+	require.Equal(t, codes.Internal, status.Code(err), "сервер продолжает работать после паники")
+}
+
+func TestGrpcStreamTodos(t *testing.T) {
+	ch := &stubCommandHandler{resp: []*TodoDTO{
+		{ID: "64da1f106083a1acd4d8f116", ListID: "64da1f106083a1acd4d8f120", Title: "Купить книгу", Status: StatusTodo, ActiveAt: "2023-08-04", CreatedAt: time.Now()},
+		{ID: "64da1fabd21e112c5bb1c299", ListID: "64da1f106083a1acd4d8f120", Title: "Купить ручку", Status: StatusTodo, ActiveAt: "2023-08-04", CreatedAt: time.Now()},
+	}}
+	client := newTestGrpcClient(t, ch)
+
+	stream, err := client.StreamTodos(context.Background(), &todopb.ListTodosRequest{Sort: "-title", ListId: "64da1f106083a1acd4d8f120"})
+	require.NoError(t, err)
+	var titles []string
+	for {
+		todo, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		titles = append(titles, todo.Title)
+		require.Equal(t, "64da1f106083a1acd4d8f120", todo.ListId)
+	}
+	require.Equal(t, []string{"Купить книгу", "Купить ручку"}, titles)
+
+	cmd := ch.executed[0].(*FindTodosCommand)
+	require.Equal(t, StatusActive, *cmd.Status)
+	require.Equal(t, []SortField{{Field: "title", Descending: true}}, cmd.Sort)
+	require.Equal(t, "64da1f106083a1acd4d8f120", ListFromContext(cmd.Context()).Hex())
+}
@@ -1,14 +1,20 @@
 package todo
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	ut "github.com/go-playground/universal-translator"
 	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
 	httpLib "github.com/kas2000/http"
 	"github.com/kas2000/logger"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
 	"io/ioutil"
 	"net/http"
+	"reflect"
+	"strconv"
 	"strings"
 )
 import command "github.com/kas2000/commandlib"
@@ -18,179 +24,305 @@ type TodoHttp struct {
 	ch         command.CommandHandler
 	validate   *validator.Validate
 	systemName string
+	apiVersion string
+	uni        *ut.UniversalTranslator
+	jobs       *JobQueue
+	zones      TimeZones
 }
 
 func NewTodoHttp(log logger.Logger, ch command.CommandHandler, validate *validator.Validate, systemName string) *TodoHttp {
+	validate.RegisterTagNameFunc(jsonFieldName)
+	uni, err := NewUniversalTranslator(validate)
+	if err != nil {
+		log.Warn("couldn't register validation translations: " + err.Error())
+	}
 	return &TodoHttp{
 		log:        log,
 		ch:         ch,
 		validate:   validate,
 		systemName: systemName,
+		apiVersion: APIVersion1,
+		uni:        uni,
 	}
 }
 
-func (factory *TodoHttp) CreateTodo() httpLib.Endpoint {
-	return func(w http.ResponseWriter, r *http.Request) httpLib.Response {
-		body, err := ioutil.ReadAll(r.Body)
-		if err != nil {
-			return httpLib.BadRequest(100, "Error reading request body: "+err.Error(), factory.systemName)
+// WithAPIVersion returns a copy of the factory whose endpoints render responses in the given API version.
+func (factory *TodoHttp) WithAPIVersion(apiVersion string) *TodoHttp {
+	versioned := *factory
+	versioned.apiVersion = apiVersion
+	return &versioned
+}
+
+// WithJobs returns a copy of the factory that runs bulk operations as jobs of the given queue.
+func (factory *TodoHttp) WithJobs(jobs *JobQueue) *TodoHttp {
+	withJobs := *factory
+	withJobs.jobs = jobs
+	return &withJobs
+}
+
+// WithTimeZones returns a copy of the factory that reckons dates in the time zone zones picks for each request.
+func (factory *TodoHttp) WithTimeZones(zones TimeZones) *TodoHttp {
+	zoned := *factory
+	zoned.zones = zones
+	return &zoned
+}
+
+func (factory *TodoHttp) renderTodo(todo *TodoDTO) interface{} {
+	if factory.apiVersion == APIVersion1 {
+		return NewGetTodoDTO(todo)
+	}
+	return todo
+}
+
+// renderTodos renders a listing. v1 has no dayType, so it marks the titles of tasks on days off instead.
+func (factory *TodoHttp) renderTodos(r *http.Request, todos []*TodoDTO, fields []string) interface{} {
+	if factory.apiVersion == APIVersion1 {
+		result := make([]*GetTodoDTO, 0, len(todos))
+		for _, todo := range todos {
+			listed := NewGetTodoDTO(todo)
+			listed.Title = factory.dayTypePrefix(r, todo.DayType) + listed.Title
+			result = append(result, listed)
 		}
+		return result
+	}
+	if len(fields) == 0 {
+		return todos
+	}
+	result := make([]interface{}, 0, len(todos))
+	for _, todo := range todos {
+		result = append(result, todo.Project(fields))
+	}
+	return result
+}
+
+// problem renders err as application/problem+json. Errors missing from the registry are logged and hidden.
+func (factory *TodoHttp) problem(r *http.Request, err error) httpLib.Response {
+	if _, registered := LookupError(err); !registered {
+		LoggerFromContext(r.Context(), factory.log).Warn("unhandled error", zap.String("path", r.URL.Path), zap.Error(err))
+	}
+	return newProblemResponse(NewProblem(factory.translateValidationError(r, err), factory.systemName, r.URL.Path))
+}
+
+func (factory *TodoHttp) todoID(r *http.Request, idParameter string) (primitive.ObjectID, error) {
+	id, found := mux.Vars(r)[idParameter]
+	if !found {
+		return primitive.NilObjectID, ErrInvalidTodoID
+	}
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return primitive.NilObjectID, ErrInvalidTodoID
+	}
+	return objID, nil
+}
 
+func (factory *TodoHttp) decode(r *http.Request, v interface{}) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRequestBody, err)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRequestBody, err)
+	}
+	return nil
+}
+
+// readContext makes a query wait for the writes of the consistency token sent with it.
+func (factory *TodoHttp) readContext(r *http.Request) (context.Context, error) {
+	token, err := ParseConsistencyToken(r.Header.Get(ConsistencyTokenHeader))
+	if err != nil || token == 0 {
+		return r.Context(), err
+	}
+	return ContextWithConsistencyToken(r.Context(), token), nil
+}
+
+// consistencyHeaders adds the consistency token of the writes made with ctx to headers.
+func consistencyHeaders(ctx context.Context, headers map[string]string) map[string]string {
+	token := RecordedConsistencyToken(ctx)
+	if token == 0 {
+		return headers
+	}
+	if headers == nil {
+		headers = make(map[string]string, 1)
+	}
+	headers[ConsistencyTokenHeader] = strconv.FormatInt(token, 10)
+	return headers
+}
+
+func jsonFieldName(field reflect.StructField) string {
+	name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+	if name == "-" || name == "" {
+		return field.Name
+	}
+	return name
+}
+
+func (factory *TodoHttp) CreateTodo() httpLib.Endpoint {
+	return func(w http.ResponseWriter, r *http.Request) httpLib.Response {
 		var todo CreateTodoDTO
-		err = json.Unmarshal(body, &todo)
-		if err != nil {
-			return httpLib.BadRequest(110, "Error unmarshalling: "+err.Error(), factory.systemName)
+		if err := factory.decode(r, &todo); err != nil {
+			return factory.problem(r, err)
 		}
 
-		err = factory.validate.Struct(todo)
-		if err != nil {
-			return httpLib.BadRequest(120, err.Error(), factory.systemName)
+		if err := factory.validate.Struct(todo); err != nil {
+			return factory.problem(r, err)
 		}
 
+		ctx := ContextWithConsistencyRecorder(r.Context())
 		cmd := CreateTodoCommand{
-			CreateTodoDTO: &todo,
+			CommandContext: NewCommandContext(ctx),
+			CreateTodoDTO:  &todo,
 		}
 
 		resp, err := factory.ch.ExecuteCommand(&cmd)
 		if err != nil {
-			switch err {
-			case ErrTodoAlreadyExists, ErrTitleLengthLimitExceeded, ErrInvalidDateFormat:
-				return httpLib.NotFound(130, err.Error(), factory.systemName) //Почему в тз написано возвращаем 404? Разве не 500 должна быть?
-			default:
-				return httpLib.InternalServer(150, err.Error(), factory.systemName)
-			}
+			return factory.problem(r, err)
 		}
-		return httpLib.NewResponse(http.StatusNoContent, resp, nil) //Почему в тз написано возвращаем 204?
-		//return httpLib.NewResponse(http.StatusCreated, resp, nil) Разве не 201 должна быть?
+		created := resp.(*TodoDTO)
+		if factory.apiVersion == APIVersion1 {
+			return httpLib.NewResponse(http.StatusNoContent, factory.renderTodo(created), consistencyHeaders(ctx, nil)) //Почему в тз написано возвращаем 204?
+		}
+		return httpLib.NewResponse(http.StatusCreated, factory.renderTodo(created), consistencyHeaders(ctx, map[string]string{
+			"Location": strings.TrimSuffix(r.URL.Path, "/") + "/" + created.ID,
+		}))
 	}
 }
 
 func (factory *TodoHttp) UpdateTodo(idParameter string) httpLib.Endpoint {
 	return func(w http.ResponseWriter, r *http.Request) httpLib.Response {
-		vars := mux.Vars(r)
-		id, found := vars[idParameter]
-		if !found {
-			return httpLib.BadRequest(140, "no subject id.", factory.systemName)
-		}
-		objID, err := primitive.ObjectIDFromHex(id)
-		if err != nil {
-			return httpLib.BadRequest(150, err.Error(), factory.systemName)
-		}
-		body, err := ioutil.ReadAll(r.Body)
+		objID, err := factory.todoID(r, idParameter)
 		if err != nil {
-			return httpLib.BadRequest(160, "Error reading request body: "+err.Error(), factory.systemName)
+			return factory.problem(r, err)
 		}
 		var upd UpdateTodoDTO
-		err = json.Unmarshal(body, &upd)
-		if err != nil {
-			return httpLib.BadRequest(170, "Error unmarshalling: "+err.Error(), factory.systemName)
+		if err := factory.decode(r, &upd); err != nil {
+			return factory.problem(r, err)
 		}
 		upd.ID = objID
 
-		err = factory.validate.Struct(upd)
-		if err != nil {
-			return httpLib.BadRequest(180, err.Error(), factory.systemName)
+		if err := factory.validate.Struct(upd); err != nil {
+			return factory.problem(r, err)
 		}
 
+		ctx := ContextWithConsistencyRecorder(r.Context())
 		cmd := UpdateTodoCommand{
-			UpdateTodoDTO: upd,
+			CommandContext: NewCommandContext(ctx),
+			UpdateTodoDTO:  upd,
 		}
 		resp, err := factory.ch.ExecuteCommand(&cmd)
 		if err != nil {
-			switch err {
-			case ErrTodoNotFound:
-				return httpLib.NotFound(190, err.Error(), factory.systemName)
-			case ErrTodoAlreadyExists, ErrTitleLengthLimitExceeded, ErrInvalidDateFormat:
-				return httpLib.BadRequest(200, err.Error(), factory.systemName)
-			}
-			return httpLib.InternalServer(210, err.Error(), factory.systemName)
+			return factory.problem(r, err)
 		}
-		return httpLib.NewResponse(http.StatusNoContent, resp, nil) //Почему в тз написано возвращаем 204?
+		return httpLib.NewResponse(http.StatusNoContent, resp, consistencyHeaders(ctx, nil)) //Почему в тз написано возвращаем 204?
 	}
 }
 
 func (factory *TodoHttp) SetTodoStatusDone(idParameter string) httpLib.Endpoint {
 	return func(w http.ResponseWriter, r *http.Request) httpLib.Response {
-		vars := mux.Vars(r)
-		id, found := vars[idParameter]
-		if !found {
-			return httpLib.BadRequest(140, "no subject id.", factory.systemName)
+		objID, err := factory.todoID(r, idParameter)
+		if err != nil {
+			return factory.problem(r, err)
 		}
-		objID, err := primitive.ObjectIDFromHex(id)
+		// v1 marked any open task done, before there was a lifecycle.
+		return factory.transition(r, TransitionTodoDTO{ID: objID, Status: StatusDone, FromAnyOpen: factory.apiVersion == APIVersion1})
+	}
+}
+
+// TransitionTodo moves a task to the status of the request body.
+func (factory *TodoHttp) TransitionTodo(idParameter string) httpLib.Endpoint {
+	return func(w http.ResponseWriter, r *http.Request) httpLib.Response {
+		objID, err := factory.todoID(r, idParameter)
 		if err != nil {
-			return httpLib.BadRequest(150, err.Error(), factory.systemName)
+			return factory.problem(r, err)
 		}
 
-		status := StatusDone
-		cmd := UpdateTodoStatusCommand{
-			TodoPointers: TodoPointers{
-				ID:     &objID,
-				Status: &status,
-			},
+		var transition TransitionTodoDTO
+		if err := factory.decode(r, &transition); err != nil {
+			return factory.problem(r, err)
 		}
+		transition.ID = objID
+		transition.Status = strings.ToUpper(transition.Status)
+		if err := factory.validate.Struct(transition); err != nil {
+			return factory.problem(r, err)
+		}
+		return factory.transition(r, transition)
+	}
+}
+
+func (factory *TodoHttp) transition(r *http.Request, transition TransitionTodoDTO) httpLib.Response {
+	ctx := ContextWithConsistencyRecorder(r.Context())
+	cmd := UpdateTodoStatusCommand{
+		CommandContext:    NewCommandContext(ctx),
+		TransitionTodoDTO: transition,
+	}
+	resp, err := factory.ch.ExecuteCommand(&cmd)
+	if err != nil {
+		return factory.problem(r, err)
+	}
+	return httpLib.NewResponse(http.StatusNoContent, resp, consistencyHeaders(ctx, nil))
+}
+
+// MoveTodo moves a task to the list of the request body.
+func (factory *TodoHttp) MoveTodo(idParameter string) httpLib.Endpoint {
+	return func(w http.ResponseWriter, r *http.Request) httpLib.Response {
+		objID, err := factory.todoID(r, idParameter)
+		if err != nil {
+			return factory.problem(r, err)
+		}
+		var move MoveTodoDTO
+		if err := factory.decode(r, &move); err != nil {
+			return factory.problem(r, err)
+		}
+		move.ID = objID
+		if err := factory.validate.Struct(move); err != nil {
+			return factory.problem(r, err)
+		}
+
+		ctx := ContextWithConsistencyRecorder(r.Context())
+		cmd := MoveTodoCommand{CommandContext: NewCommandContext(ctx), MoveTodoDTO: move}
 		resp, err := factory.ch.ExecuteCommand(&cmd)
 		if err != nil {
-			switch err {
-			case ErrTodoNotFound:
-				return httpLib.NotFound(190, err.Error(), factory.systemName)
-			}
-			return httpLib.InternalServer(200, err.Error(), factory.systemName)
+			return factory.problem(r, err)
 		}
-		return httpLib.NewResponse(http.StatusNoContent, resp, nil) //Почему в тз написано возвращаем 204?
+		return httpLib.NewResponse(http.StatusNoContent, resp, consistencyHeaders(ctx, nil))
 	}
 }
 
 func (factory *TodoHttp) DeleteTodo(idParameter string) httpLib.Endpoint {
 	return func(w http.ResponseWriter, r *http.Request) httpLib.Response {
-		vars := mux.Vars(r)
-		id, found := vars[idParameter]
-		if !found {
-			return httpLib.BadRequest(210, "no subject id", factory.systemName)
-		}
-
-		objID, err := primitive.ObjectIDFromHex(id)
+		objID, err := factory.todoID(r, idParameter)
 		if err != nil {
-			return httpLib.BadRequest(220, err.Error(), factory.systemName)
+			return factory.problem(r, err)
 		}
 
-		cmd := DeleteTodoCommand{ID: objID}
+		ctx := ContextWithConsistencyRecorder(r.Context())
+		cmd := DeleteTodoCommand{CommandContext: NewCommandContext(ctx), ID: objID}
 
 		resp, err := factory.ch.ExecuteCommand(&cmd)
 		if err != nil {
-			switch err {
-			case ErrTodoNotFound:
-				return httpLib.NotFound(230, err.Error(), factory.systemName)
-			}
-			return httpLib.InternalServer(240, err.Error(), factory.systemName)
+			return factory.problem(r, err)
 		}
-		return httpLib.NewResponse(http.StatusNoContent, resp, nil)
+		return httpLib.NewResponse(http.StatusNoContent, resp, consistencyHeaders(ctx, nil))
 	}
 }
 
 func (factory *TodoHttp) FindTodo(idParameter string) httpLib.Endpoint {
 	return func(w http.ResponseWriter, r *http.Request) httpLib.Response {
-		vars := mux.Vars(r)
-		id, found := vars[idParameter]
-		if !found {
-			return httpLib.BadRequest(250, "No subject id", factory.systemName)
+		objID, err := factory.todoID(r, idParameter)
+		if err != nil {
+			return factory.problem(r, err)
 		}
 
-		objID, err := primitive.ObjectIDFromHex(id)
+		ctx, err := factory.readContext(r)
 		if err != nil {
-			return httpLib.BadRequest(260, err.Error(), factory.systemName)
+			return factory.problem(r, err)
 		}
-
-		cmd := FindTodoCommand{ID: objID}
+		cmd := FindTodoCommand{CommandContext: NewCommandContext(ctx), ID: objID}
 
 		resp, err := factory.ch.ExecuteCommand(&cmd)
 		if err != nil {
-			switch err {
-			case ErrTodoNotFound:
-				return httpLib.NotFound(270, err.Error(), factory.systemName)
-			}
-			return httpLib.InternalServer(280, err.Error(), factory.systemName)
+			return factory.problem(r, err)
 		}
-		return httpLib.NewResponse(http.StatusOK, resp, nil)
+		return httpLib.NewResponse(http.StatusOK, factory.renderTodo(resp.(*TodoDTO)), nil)
 	}
 }
 
@@ -210,14 +342,189 @@ func (factory *TodoHttp) FindTodos() httpLib.Endpoint {
 			pointers.Title = &title
 		}
 
+		sort, err := ParseSort(r.URL.Query().Get("sort"))
+		if err != nil {
+			return factory.problem(r, err)
+		}
+		pointers.Sort = sort
+
+		fields, err := ParseFields(r.URL.Query().Get("fields"))
+		if err != nil {
+			return factory.problem(r, err)
+		}
+		pointers.Fields = fields
+
+		ctx, err := factory.readContext(r)
+		if err != nil {
+			return factory.problem(r, err)
+		}
 		cmd := FindTodosCommand{
-			TodoPointers: pointers,
+			CommandContext: NewCommandContext(ctx),
+			TodoPointers:   pointers,
+		}
+
+		resp, err := factory.ch.ExecuteCommand(&cmd)
+		if err != nil {
+			return factory.problem(r, err)
+		}
+		return httpLib.NewResponse(http.StatusOK, factory.renderTodos(r, resp.([]*TodoDTO), fields), nil)
+	}
+}
+
+// submit queues cmd as a job and answers 202 Accepted with the job URL, which replaces suffix of the request path.
+func (factory *TodoHttp) submit(r *http.Request, suffix string, cmd JobCommand) httpLib.Response {
+	job, err := factory.jobs.Submit(r.Context(), cmd)
+	if err != nil {
+		return factory.problem(r, err)
+	}
+	return httpLib.NewResponse(http.StatusAccepted, NewJobDTO(job), map[string]string{
+		"Location": strings.TrimSuffix(r.URL.Path, suffix) + "/jobs/" + job.ID.Hex(),
+	})
+}
+
+func (factory *TodoHttp) ImportTodos() httpLib.Endpoint {
+	return func(w http.ResponseWriter, r *http.Request) httpLib.Response {
+		var cmd ImportTodosCommand
+		if err := factory.decode(r, &cmd); err != nil {
+			return factory.problem(r, err)
+		}
+		if err := factory.validate.Struct(cmd); err != nil {
+			return factory.problem(r, err)
+		}
+		return factory.submit(r, "/tasks/import", &cmd)
+	}
+}
+
+func (factory *TodoHttp) RescheduleTodos() httpLib.Endpoint {
+	return func(w http.ResponseWriter, r *http.Request) httpLib.Response {
+		var reschedule RescheduleTodosDTO
+		if err := factory.decode(r, &reschedule); err != nil {
+			return factory.problem(r, err)
+		}
+		if err := factory.validate.Struct(reschedule); err != nil {
+			return factory.problem(r, err)
+		}
+		return factory.submit(r, "/tasks/reschedule", &RescheduleTodosCommand{RescheduleTodosDTO: reschedule})
+	}
+}
+
+func (factory *TodoHttp) FindJob(idParameter string) httpLib.Endpoint {
+	return func(w http.ResponseWriter, r *http.Request) httpLib.Response {
+		objID, err := primitive.ObjectIDFromHex(mux.Vars(r)[idParameter])
+		if err != nil {
+			return factory.problem(r, ErrJobNotFound)
+		}
+		job, err := factory.jobs.Get(r.Context(), objID)
+		if err != nil {
+			return factory.problem(r, err)
+		}
+		// Jobs are found under the list they were submitted to.
+		if job.list() != ListFromContext(r.Context()) {
+			return factory.problem(r, ErrJobNotFound)
+		}
+		return httpLib.NewResponse(http.StatusOK, NewJobDTO(job), nil)
+	}
+}
+
+func (factory *TodoHttp) CreateTodoList() httpLib.Endpoint {
+	return func(w http.ResponseWriter, r *http.Request) httpLib.Response {
+		var list CreateTodoListDTO
+		if err := factory.decode(r, &list); err != nil {
+			return factory.problem(r, err)
+		}
+		if err := factory.validate.Struct(list); err != nil {
+			return factory.problem(r, err)
 		}
 
+		cmd := CreateTodoListCommand{CommandContext: NewCommandContext(r.Context()), CreateTodoListDTO: &list}
 		resp, err := factory.ch.ExecuteCommand(&cmd)
 		if err != nil {
-			return httpLib.InternalServer(290, err.Error(), factory.systemName)
+			return factory.problem(r, err)
+		}
+		created := resp.(*TodoListDTO)
+		return httpLib.NewResponse(http.StatusCreated, created, map[string]string{
+			"Location": strings.TrimSuffix(r.URL.Path, "/") + "/" + created.ID,
+		})
+	}
+}
+
+func (factory *TodoHttp) FindTodoLists() httpLib.Endpoint {
+	return func(w http.ResponseWriter, r *http.Request) httpLib.Response {
+		cmd := FindTodoListsCommand{CommandContext: NewCommandContext(r.Context())}
+		resp, err := factory.ch.ExecuteCommand(&cmd)
+		if err != nil {
+			return factory.problem(r, err)
 		}
 		return httpLib.NewResponse(http.StatusOK, resp, nil)
 	}
-}
\ No newline at end of file
+}
+
+func (factory *TodoHttp) FindTodoList(idParameter string) httpLib.Endpoint {
+	return func(w http.ResponseWriter, r *http.Request) httpLib.Response {
+		objID, err := factory.listID(r, idParameter)
+		if err != nil {
+			return factory.problem(r, err)
+		}
+		cmd := FindTodoListCommand{CommandContext: NewCommandContext(r.Context()), ID: objID}
+		resp, err := factory.ch.ExecuteCommand(&cmd)
+		if err != nil {
+			return factory.problem(r, err)
+		}
+		return httpLib.NewResponse(http.StatusOK, resp, nil)
+	}
+}
+
+func (factory *TodoHttp) RenameTodoList(idParameter string) httpLib.Endpoint {
+	return func(w http.ResponseWriter, r *http.Request) httpLib.Response {
+		objID, err := factory.listID(r, idParameter)
+		if err != nil {
+			return factory.problem(r, err)
+		}
+		var rename RenameTodoListDTO
+		if err := factory.decode(r, &rename); err != nil {
+			return factory.problem(r, err)
+		}
+		rename.ID = objID
+		if err := factory.validate.Struct(rename); err != nil {
+			return factory.problem(r, err)
+		}
+
+		cmd := RenameTodoListCommand{CommandContext: NewCommandContext(r.Context()), RenameTodoListDTO: rename}
+		resp, err := factory.ch.ExecuteCommand(&cmd)
+		if err != nil {
+			return factory.problem(r, err)
+		}
+		return httpLib.NewResponse(http.StatusNoContent, resp, nil)
+	}
+}
+
+// ArchiveTodoList archives the list or, with archived false, restores it.
+func (factory *TodoHttp) ArchiveTodoList(idParameter string, archived bool) httpLib.Endpoint {
+	return func(w http.ResponseWriter, r *http.Request) httpLib.Response {
+		objID, err := factory.listID(r, idParameter)
+		if err != nil {
+			return factory.problem(r, err)
+		}
+		cmd := ArchiveTodoListCommand{CommandContext: NewCommandContext(r.Context()), ID: objID, Archived: archived}
+		resp, err := factory.ch.ExecuteCommand(&cmd)
+		if err != nil {
+			return factory.problem(r, err)
+		}
+		return httpLib.NewResponse(http.StatusNoContent, resp, nil)
+	}
+}
+
+func (factory *TodoHttp) DeleteTodoList(idParameter string) httpLib.Endpoint {
+	return func(w http.ResponseWriter, r *http.Request) httpLib.Response {
+		objID, err := factory.listID(r, idParameter)
+		if err != nil {
+			return factory.problem(r, err)
+		}
+		cmd := DeleteTodoListCommand{CommandContext: NewCommandContext(r.Context()), ID: objID}
+		resp, err := factory.ch.ExecuteCommand(&cmd)
+		if err != nil {
+			return factory.problem(r, err)
+		}
+		return httpLib.NewResponse(http.StatusNoContent, resp, nil)
+	}
+}
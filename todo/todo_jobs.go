@@ -0,0 +1,471 @@
+package todo
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	command "github.com/kas2000/commandlib"
+	"github.com/kas2000/logger"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+const (
+	JobQueued    = "QUEUED"
+	JobRunning   = "RUNNING"
+	JobSucceeded = "SUCCEEDED"
+	JobFailed    = "FAILED"
+)
+
+const (
+	jobCollection = "jobs"
+	// jobPollInterval bounds how long a job submitted on another instance waits for a worker here.
+	jobPollInterval = 2 * time.Second
+	// jobProgressInterval throttles progress writes; finishing a job always writes the final progress.
+	jobProgressInterval = time.Second
+	// jobMaxAttempts stops resuming a job that keeps taking its worker down.
+	jobMaxAttempts = 3
+)
+
+// ErrJobLeaseLost reports a write for an attempt of a job that is no longer running it: its lease expired and the job
+// was recovered or claimed again.
+var ErrJobLeaseLost = errors.New("job lease lost")
+
+// Job is a command run in the background. Its payload is the command as JSON, so jobs survive restarts.
+type Job struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	Command    string             `bson:"command"`
+	Payload    string             `bson:"payload"`
+	Status     string             `bson:"status"`
+	Progress   JobProgress        `bson:"progress"`
+	Result     string             `bson:"result,omitempty"`
+	Error      *Problem           `bson:"error,omitempty"`
+	Attempts   int                `bson:"attempts"`
+	RequestID  string             `bson:"request_id,omitempty"`
+	ListID     primitive.ObjectID `bson:"list_id,omitempty"`
+	CreatedAt  time.Time          `bson:"created_at"`
+	StartedAt  *time.Time         `bson:"started_at,omitempty"`
+	FinishedAt *time.Time         `bson:"finished_at,omitempty"`
+	// LeaseUntil is when a running job is considered abandoned unless its worker reports progress.
+	LeaseUntil time.Time `bson:"lease_until"`
+}
+
+// list returns the list the job works on. Jobs submitted before there were lists have none and work on the default
+// list.
+func (job *Job) list() primitive.ObjectID {
+	if job.ListID.IsZero() {
+		return DefaultListID
+	}
+	return job.ListID
+}
+
+type JobDTO struct {
+	ID         string          `json:"id"`
+	Command    string          `json:"command"`
+	Status     string          `json:"status"`
+	Progress   JobProgress     `json:"progress"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	Error      *Problem        `json:"error,omitempty"`
+	Attempts   int             `json:"attempts"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	StartedAt  *time.Time      `json:"startedAt,omitempty"`
+	FinishedAt *time.Time      `json:"finishedAt,omitempty"`
+}
+
+func NewJobDTO(job *Job) *JobDTO {
+	dto := &JobDTO{
+		ID:         job.ID.Hex(),
+		Command:    job.Command,
+		Status:     job.Status,
+		Progress:   job.Progress,
+		Error:      job.Error,
+		Attempts:   job.Attempts,
+		CreatedAt:  job.CreatedAt,
+		StartedAt:  job.StartedAt,
+		FinishedAt: job.FinishedAt,
+	}
+	if job.Result != "" {
+		dto.Result = json.RawMessage(job.Result)
+	}
+	return dto
+}
+
+type JobProgress struct {
+	Done  int `json:"done" bson:"done"`
+	Total int `json:"total" bson:"total"`
+}
+
+// JobCommand is a command that can run as a job. Resumable ones are run again from the start after an
+// interruption, the others fail with ErrJobInterrupted.
+type JobCommand interface {
+	command.Command
+	SetContext(ctx context.Context)
+	Resumable() bool
+}
+
+type JobStore interface {
+	Create(ctx context.Context, job *Job) error
+	Get(ctx context.Context, id primitive.ObjectID) (*Job, error)
+	// Claim marks the oldest queued job running until leaseUntil and returns it, or nil when the queue is empty.
+	Claim(ctx context.Context, leaseUntil time.Time) (*Job, error)
+	// Heartbeat records the progress of the attempt of a running job and extends its lease. It fails with
+	// ErrJobLeaseLost when the job isn't running that attempt any more.
+	Heartbeat(ctx context.Context, id primitive.ObjectID, attempt int, progress JobProgress, leaseUntil time.Time) error
+	// Save writes the status, progress, result and error of job if it is still running the attempt job.Attempts,
+	// and fails with ErrJobLeaseLost otherwise.
+	Save(ctx context.Context, job *Job) error
+	// Abandoned returns the running jobs whose lease expired before now.
+	Abandoned(ctx context.Context, now time.Time) ([]*Job, error)
+}
+
+type jobStore struct {
+	collection *mongo.Collection
+}
+
+func NewJobStore(db *mongo.Database) JobStore {
+	return &jobStore{collection: db.Collection(jobCollection)}
+}
+
+func (store *jobStore) Create(ctx context.Context, job *Job) error {
+	_, err := store.collection.InsertOne(ctx, job)
+	return err
+}
+
+func (store *jobStore) Get(ctx context.Context, id primitive.ObjectID) (*Job, error) {
+	var job Job
+	err := store.collection.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (store *jobStore) Claim(ctx context.Context, leaseUntil time.Time) (*Job, error) {
+	now := time.Now().UTC()
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.D{{Key: "created_at", Value: 1}}).
+		SetReturnDocument(options.After)
+	var job Job
+	err := store.collection.FindOneAndUpdate(ctx,
+		bson.D{{Key: "status", Value: JobQueued}},
+		bson.D{
+			{Key: "$set", Value: bson.D{{Key: "status", Value: JobRunning}, {Key: "started_at", Value: now}, {Key: "lease_until", Value: leaseUntil}}},
+			{Key: "$inc", Value: bson.D{{Key: "attempts", Value: 1}}},
+		},
+		opts,
+	).Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// owned matches the job id while it is running attempt.
+func owned(id primitive.ObjectID, attempt int) bson.D {
+	return bson.D{{Key: "_id", Value: id}, {Key: "status", Value: JobRunning}, {Key: "attempts", Value: attempt}}
+}
+
+func (store *jobStore) Heartbeat(ctx context.Context, id primitive.ObjectID, attempt int, progress JobProgress, leaseUntil time.Time) error {
+	result, err := store.collection.UpdateOne(ctx,
+		owned(id, attempt),
+		bson.D{{Key: "$set", Value: bson.D{{Key: "progress", Value: progress}, {Key: "lease_until", Value: leaseUntil}}}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrJobLeaseLost
+	}
+	return nil
+}
+
+func (store *jobStore) Save(ctx context.Context, job *Job) error {
+	result, err := store.collection.ReplaceOne(ctx, owned(job.ID, job.Attempts), job)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrJobLeaseLost
+	}
+	return nil
+}
+
+func (store *jobStore) Abandoned(ctx context.Context, now time.Time) ([]*Job, error) {
+	cursor, err := store.collection.Find(ctx, bson.D{
+		{Key: "status", Value: JobRunning},
+		{Key: "lease_until", Value: bson.D{{Key: "$lt", Value: now}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var jobs []*Job
+	if err := cursor.All(ctx, &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+type progressKey struct{}
+
+// ReportProgress records that done of total items of the current job are processed. Outside of jobs it does nothing.
+func ReportProgress(ctx context.Context, done int, total int) {
+	if report, ok := ctx.Value(progressKey{}).(func(JobProgress)); ok {
+		report(JobProgress{Done: done, Total: total})
+	}
+}
+
+// JobQueue runs JobCommands through the command handler on a bounded pool of workers.
+type JobQueue struct {
+	store      JobStore
+	ch         command.CommandHandler
+	log        logger.Logger
+	systemName string
+	workers    int
+	lease      time.Duration
+	commands   map[string]func() JobCommand
+	wake       chan struct{}
+}
+
+func NewJobQueue(store JobStore, ch command.CommandHandler, log logger.Logger, systemName string, workers int, lease time.Duration) *JobQueue {
+	queue := &JobQueue{
+		store:      store,
+		ch:         ch,
+		log:        log,
+		systemName: systemName,
+		workers:    workers,
+		lease:      lease,
+		commands:   make(map[string]func() JobCommand),
+		wake:       make(chan struct{}, workers),
+	}
+	queue.Register(func() JobCommand { return &ImportTodosCommand{} })
+	queue.Register(func() JobCommand { return &RescheduleTodosCommand{} })
+	return queue
+}
+
+// Register makes the command type built by newCommand runnable as a job.
+func (queue *JobQueue) Register(newCommand func() JobCommand) {
+	queue.commands[CommandName(newCommand())] = newCommand
+}
+
+// Submit stores cmd as a queued job and returns it; a free worker picks it up.
+func (queue *JobQueue) Submit(ctx context.Context, cmd JobCommand) (*Job, error) {
+	name := CommandName(cmd)
+	if _, registered := queue.commands[name]; !registered {
+		return nil, fmt.Errorf("command %s is not registered for jobs", name)
+	}
+	payload, err := json.Marshal(cmd)
+	if err != nil {
+		return nil, err
+	}
+	job := &Job{
+		ID:        primitive.NewObjectID(),
+		Command:   name,
+		Payload:   string(payload),
+		Status:    JobQueued,
+		RequestID: RequestIDFromContext(ctx),
+		ListID:    ListFromContext(ctx),
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := queue.store.Create(ctx, job); err != nil {
+		return nil, err
+	}
+	select {
+	case queue.wake <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+func (queue *JobQueue) Get(ctx context.Context, id primitive.ObjectID) (*Job, error) {
+	return queue.store.Get(ctx, id)
+}
+
+// Run works the queue until ctx is done. It returns once the running jobs have stopped; interrupted jobs are
+// requeued or failed as if their instance had died.
+func (queue *JobQueue) Run(ctx context.Context) {
+	var workers sync.WaitGroup
+	for i := 0; i < queue.workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			queue.work(ctx)
+		}()
+	}
+	workers.Wait()
+}
+
+// recover requeues the resumable jobs whose lease expired, on this instance or another one, and fails the others.
+func (queue *JobQueue) recover(ctx context.Context) error {
+	jobs, err := queue.store.Abandoned(ctx, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		queue.interrupted(ctx, job)
+	}
+	return nil
+}
+
+func (queue *JobQueue) interrupted(ctx context.Context, job *Job) {
+	newCommand, registered := queue.commands[job.Command]
+	if registered && newCommand().Resumable() && job.Attempts < jobMaxAttempts {
+		job.Status = JobQueued
+		job.Progress = JobProgress{}
+	} else {
+		finishedAt := time.Now().UTC()
+		job.Status = JobFailed
+		job.FinishedAt = &finishedAt
+		job.Error = NewProblem(ErrJobInterrupted, queue.systemName, "")
+	}
+	if err := queue.store.Save(ctx, job); err != nil {
+		// Another worker recovering the job at the same time got there first.
+		if !errors.Is(err, ErrJobLeaseLost) {
+			queue.log.Warn("couldn't save interrupted job", zap.String("job_id", job.ID.Hex()), zap.Error(err))
+		}
+		return
+	}
+	queue.log.Info("interrupted job "+job.Status, zap.String("job_id", job.ID.Hex()), zap.String("command", job.Command))
+}
+
+func (queue *JobQueue) work(ctx context.Context) {
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+	for ctx.Err() == nil {
+		// Workers that die while running a job stop extending its lease, so every poll looks for expired ones.
+		if err := queue.recover(ctx); err != nil && ctx.Err() == nil {
+			queue.log.Warn("couldn't recover abandoned jobs", zap.Error(err))
+		}
+		job, err := queue.store.Claim(ctx, time.Now().UTC().Add(queue.lease))
+		if err != nil && ctx.Err() == nil {
+			queue.log.Warn("couldn't claim job", zap.Error(err))
+		}
+		if job != nil {
+			queue.execute(ctx, job)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+		case <-queue.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (queue *JobQueue) execute(ctx context.Context, job *Job) {
+	log := WithFields(queue.log, zap.String("job_id", job.ID.Hex()), zap.String("command", job.Command), zap.String("request_id", job.RequestID))
+	// State is written even after ctx is done, to hand the job over cleanly.
+	storeCtx := context.WithoutCancel(ctx)
+
+	newCommand, registered := queue.commands[job.Command]
+	if !registered {
+		queue.finish(storeCtx, log, job, nil, fmt.Errorf("command %s is not registered for jobs", job.Command))
+		return
+	}
+	cmd := newCommand()
+	if err := json.Unmarshal([]byte(job.Payload), cmd); err != nil {
+		queue.finish(storeCtx, log, job, nil, err)
+		return
+	}
+
+	// The command stops when the job is taken over, which happens when a heartbeat was missed for a whole lease.
+	runCtx, lost := context.WithCancelCause(ctx)
+	defer lost(nil)
+	var mu sync.Mutex
+	var lastWrite time.Time
+	heartbeat := func(progress JobProgress) {
+		err := queue.store.Heartbeat(storeCtx, job.ID, job.Attempts, progress, time.Now().UTC().Add(queue.lease))
+		if errors.Is(err, ErrJobLeaseLost) {
+			lost(err)
+		} else if err != nil {
+			log.Warn("couldn't extend job lease", zap.Error(err))
+		}
+	}
+	report := func(progress JobProgress) {
+		mu.Lock()
+		defer mu.Unlock()
+		job.Progress = progress
+		if time.Since(lastWrite) < jobProgressInterval {
+			return
+		}
+		lastWrite = time.Now()
+		heartbeat(progress)
+	}
+	// Long steps without progress still keep the lease.
+	heartbeats, stopHeartbeats := context.WithCancel(runCtx)
+	defer stopHeartbeats()
+	go func() {
+		ticker := time.NewTicker(queue.lease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-heartbeats.Done():
+				return
+			case <-ticker.C:
+				mu.Lock()
+				progress := job.Progress
+				mu.Unlock()
+				heartbeat(progress)
+			}
+		}
+	}()
+
+	cmdCtx := ContextWithLogger(ContextWithRequestID(runCtx, job.RequestID), log)
+	cmdCtx = ContextWithList(cmdCtx, job.list())
+	cmd.SetContext(context.WithValue(cmdCtx, progressKey{}, report))
+	log.Info("job started", zap.Int("attempt", job.Attempts))
+	result, err := queue.ch.ExecuteCommand(cmd)
+	stopHeartbeats()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if errors.Is(context.Cause(runCtx), ErrJobLeaseLost) {
+		log.Warn("job lease lost, its result is dropped")
+		return
+	}
+	if ctx.Err() != nil {
+		queue.interrupted(storeCtx, job)
+		return
+	}
+	queue.finish(storeCtx, log, job, result, err)
+}
+
+func (queue *JobQueue) finish(ctx context.Context, log logger.Logger, job *Job, result interface{}, err error) {
+	finishedAt := time.Now().UTC()
+	job.FinishedAt = &finishedAt
+	if err == nil && result != nil {
+		var data []byte
+		data, err = json.Marshal(result)
+		job.Result = string(data)
+	}
+	if err != nil {
+		job.Status = JobFailed
+		job.Error = NewProblem(err, queue.systemName, "")
+		if _, registered := LookupError(err); !registered {
+			log.Warn("job failed", zap.Error(err))
+		}
+	} else {
+		job.Status = JobSucceeded
+	}
+	if err := queue.store.Save(ctx, job); err != nil {
+		if errors.Is(err, ErrJobLeaseLost) {
+			log.Warn("job lease lost, its result is dropped")
+			return
+		}
+		log.Warn("couldn't save finished job", zap.Error(err))
+		return
+	}
+	log.Info("job finished", zap.String("status", job.Status))
+}
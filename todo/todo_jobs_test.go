@@ -0,0 +1,293 @@
+package todo
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	command "github.com/kas2000/commandlib"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+type memoryJobStore struct {
+	mu   sync.Mutex
+	jobs map[primitive.ObjectID]*Job
+}
+
+func newMemoryJobStore(jobs ...*Job) *memoryJobStore {
+	store := &memoryJobStore{jobs: make(map[primitive.ObjectID]*Job)}
+	for _, job := range jobs {
+		store.jobs[job.ID] = job
+	}
+	return store
+}
+
+func (store *memoryJobStore) Create(ctx context.Context, job *Job) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	copied := *job
+	store.jobs[job.ID] = &copied
+	return nil
+}
+
+func (store *memoryJobStore) owned(id primitive.ObjectID, attempt int) (*Job, bool) {
+	job, found := store.jobs[id]
+	return job, found && job.Status == JobRunning && job.Attempts == attempt
+}
+
+func (store *memoryJobStore) Get(ctx context.Context, id primitive.ObjectID) (*Job, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	job, found := store.jobs[id]
+	if !found {
+		return nil, ErrJobNotFound
+	}
+	copied := *job
+	return &copied, nil
+}
+
+func (store *memoryJobStore) Claim(ctx context.Context, leaseUntil time.Time) (*Job, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	var queued []*Job
+	for _, job := range store.jobs {
+		if job.Status == JobQueued {
+			queued = append(queued, job)
+		}
+	}
+	if len(queued) == 0 {
+		return nil, nil
+	}
+	sort.Slice(queued, func(i, j int) bool { return queued[i].CreatedAt.Before(queued[j].CreatedAt) })
+	startedAt := time.Now().UTC()
+	job := queued[0]
+	job.Status = JobRunning
+	job.StartedAt = &startedAt
+	job.LeaseUntil = leaseUntil
+	job.Attempts++
+	copied := *job
+	return &copied, nil
+}
+
+func (store *memoryJobStore) Heartbeat(ctx context.Context, id primitive.ObjectID, attempt int, progress JobProgress, leaseUntil time.Time) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	job, owned := store.owned(id, attempt)
+	if !owned {
+		return ErrJobLeaseLost
+	}
+	job.Progress = progress
+	job.LeaseUntil = leaseUntil
+	return nil
+}
+
+func (store *memoryJobStore) Save(ctx context.Context, job *Job) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if _, owned := store.owned(job.ID, job.Attempts); !owned {
+		return ErrJobLeaseLost
+	}
+	copied := *job
+	store.jobs[job.ID] = &copied
+	return nil
+}
+
+func (store *memoryJobStore) Abandoned(ctx context.Context, now time.Time) ([]*Job, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	var jobs []*Job
+	for _, job := range store.jobs {
+		if job.Status == JobRunning && job.LeaseUntil.Before(now) {
+			copied := *job
+			jobs = append(jobs, &copied)
+		}
+	}
+	return jobs, nil
+}
+
+// waitJob polls the store until the job with id is finished.
+func waitJob(t *testing.T, store JobStore, id primitive.ObjectID) *Job {
+	var job *Job
+	require.Eventually(t, func() bool {
+		var err error
+		job, err = store.Get(context.Background(), id)
+		require.NoError(t, err)
+		return job.Status == JobSucceeded || job.Status == JobFailed
+	}, 5*time.Second, 10*time.Millisecond)
+	return job
+}
+
+func TestJobQueue(t *testing.T) {
+	repository := newMemoryTodoRepo()
+	repository.indexed = true
+	list := &TodoList{ID: primitive.NewObjectID(), Name: "Работа"}
+	ch := command.NewCommandHandler(NewService(repository, newMemoryTodoListRepo(list), zap.NewNop()))
+
+	abandonedAt := time.Now().UTC().Add(-time.Hour)
+	interruptedImport := &Job{
+		ID: primitive.NewObjectID(), Command: "ImportTodosCommand", Status: JobRunning, Attempts: 1,
+		Payload:   `{"todos":[{"title":"Позвонить маме","activeAt":"2023-08-05"}]}`,
+		CreatedAt: abandonedAt, StartedAt: &abandonedAt, LeaseUntil: abandonedAt,
+	}
+	interruptedReschedule := &Job{
+		ID: primitive.NewObjectID(), Command: "RescheduleTodosCommand", Status: JobRunning, Attempts: 1,
+		Payload:   `{"from":"2023-08-01","to":"2023-08-31","days":7}`,
+		CreatedAt: abandonedAt, StartedAt: &abandonedAt, LeaseUntil: abandonedAt,
+	}
+	store := newMemoryJobStore(interruptedImport, interruptedReschedule)
+	queue := NewJobQueue(store, ch, zap.NewNop(), "todo-service", 1, time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		queue.Run(ctx)
+		close(stopped)
+	}()
+
+	t.Run("Возобновляемое задание после перезапуска выполняется заново", func(t *testing.T) {
+		job := waitJob(t, store, interruptedImport.ID)
+		require.Equal(t, JobSucceeded, job.Status)
+		require.Equal(t, 2, job.Attempts)
+	})
+
+	t.Run("Невозобновляемое задание после перезапуска завершается ошибкой", func(t *testing.T) {
+		job := waitJob(t, store, interruptedReschedule.ID)
+		require.Equal(t, JobFailed, job.Status)
+		require.Equal(t, "todo-service.1302", job.Error.Code)
+	})
+
+	t.Run("Импорт через HTTP возвращает 202 и ссылку на задание", func(t *testing.T) {
+		todoHttp := NewTodoHttp(zap.NewNop(), ch, validator.New(), "todo-service").WithJobs(queue)
+		body := `{"todos":[{"title":"Купить книгу","activeAt":"2023-08-04"},{"title":"Купить книгу","activeAt":"2023-08-04"},{"title":"Оплатить счёт","activeAt":"2023-08-06"}]}`
+		resp := todoHttp.ImportTodos()(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/todo-list/tasks/import", bytes.NewBufferString(body)))
+		require.Equal(t, http.StatusAccepted, resp.StatusCode())
+		accepted := resp.Response().(*JobDTO)
+		require.Equal(t, JobQueued, accepted.Status)
+		require.Equal(t, "/api/todo-list/jobs/"+accepted.ID, resp.Headers()["Location"])
+
+		id, err := primitive.ObjectIDFromHex(accepted.ID)
+		require.NoError(t, err)
+		waitJob(t, store, id)
+		req := mux.SetURLVars(httptest.NewRequest("GET", "/api/todo-list/jobs/"+accepted.ID, nil), map[string]string{"id": accepted.ID})
+		resp = todoHttp.FindJob("id")(httptest.NewRecorder(), req)
+		require.Equal(t, http.StatusOK, resp.StatusCode())
+		job := resp.Response().(*JobDTO)
+		require.Equal(t, JobSucceeded, job.Status)
+		require.Equal(t, JobProgress{Done: 3, Total: 3}, job.Progress)
+		var result BulkResult
+		require.NoError(t, json.Unmarshal(job.Result, &result))
+		require.Equal(t, BulkResult{Total: 3, Succeeded: 2, Skipped: 1}, result)
+
+		req = mux.SetURLVars(httptest.NewRequest("GET", "/api/todo-list/jobs/unknown", nil), map[string]string{"id": "unknown"})
+		require.Equal(t, http.StatusNotFound, todoHttp.FindJob("id")(httptest.NewRecorder(), req).StatusCode())
+
+		resp = todoHttp.ImportTodos()(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/todo-list/tasks/import", bytes.NewBufferString(`{"todos":[]}`)))
+		require.Equal(t, http.StatusBadRequest, resp.StatusCode())
+	})
+
+	t.Run("Импорт в список выполняется в нём и находится только в нём", func(t *testing.T) {
+		todoHttp := NewTodoHttp(zap.NewNop(), ch, validator.New(), "todo-service").WithJobs(queue)
+		path := "/api/todo-list/lists/" + list.ID.Hex()
+		req := httptest.NewRequest("POST", path+"/tasks/import", bytes.NewBufferString(`{"todos":[{"title":"Написать отчёт","activeAt":"2023-08-07"}]}`))
+		resp := todoHttp.ImportTodos()(httptest.NewRecorder(), req.WithContext(ContextWithList(req.Context(), list.ID)))
+		require.Equal(t, http.StatusAccepted, resp.StatusCode())
+		accepted := resp.Response().(*JobDTO)
+		require.Equal(t, path+"/jobs/"+accepted.ID, resp.Headers()["Location"])
+
+		id, err := primitive.ObjectIDFromHex(accepted.ID)
+		require.NoError(t, err)
+		require.Equal(t, JobSucceeded, waitJob(t, store, id).Status)
+		imported, err := repository.FindAll(context.Background(), TodoPointers{ListID: &list.ID})
+		require.NoError(t, err)
+		require.Len(t, imported, 1)
+		require.Equal(t, "Написать отчёт", imported[0].Title)
+
+		req = mux.SetURLVars(httptest.NewRequest("GET", path+"/jobs/"+accepted.ID, nil), map[string]string{"id": accepted.ID})
+		require.Equal(t, http.StatusOK, todoHttp.FindJob("id")(httptest.NewRecorder(), req.WithContext(ContextWithList(req.Context(), list.ID))).StatusCode())
+		req = mux.SetURLVars(httptest.NewRequest("GET", "/api/todo-list/jobs/"+accepted.ID, nil), map[string]string{"id": accepted.ID})
+		require.Equal(t, http.StatusNotFound, todoHttp.FindJob("id")(httptest.NewRecorder(), req).StatusCode())
+	})
+
+	t.Run("Задание, чей работник умер, возобновляется без перезапуска", func(t *testing.T) {
+		expiredAt := time.Now().UTC().Add(-time.Second)
+		abandoned := &Job{
+			ID: primitive.NewObjectID(), Command: "ImportTodosCommand", Status: JobRunning, Attempts: 1,
+			Payload:   `{"todos":[{"title":"Полить цветы","activeAt":"2023-08-05"}]}`,
+			CreatedAt: expiredAt, StartedAt: &expiredAt, LeaseUntil: expiredAt,
+		}
+		require.NoError(t, store.Create(context.Background(), abandoned))
+		queue.wake <- struct{}{}
+		job := waitJob(t, store, abandoned.ID)
+		require.Equal(t, JobSucceeded, job.Status)
+		require.Equal(t, 2, job.Attempts)
+	})
+
+	cancel()
+	<-stopped
+}
+
+// blockingCommandHandler holds every command until release is closed.
+type blockingCommandHandler struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (ch *blockingCommandHandler) ExecuteCommand(cmd command.Command) (interface{}, error) {
+	ch.started <- struct{}{}
+	<-ch.release
+	return BulkResult{Total: 1, Succeeded: 1}, nil
+}
+
+// savingJobStore reports the result of every Save.
+type savingJobStore struct {
+	*memoryJobStore
+	saved chan error
+}
+
+func (store savingJobStore) Save(ctx context.Context, job *Job) error {
+	err := store.memoryJobStore.Save(ctx, job)
+	store.saved <- err
+	return err
+}
+
+func TestJobLeaseLost(t *testing.T) {
+	store := savingJobStore{memoryJobStore: newMemoryJobStore(), saved: make(chan error, 1)}
+	ch := &blockingCommandHandler{started: make(chan struct{}), release: make(chan struct{})}
+	queue := NewJobQueue(store, ch, zap.NewNop(), "todo-service", 1, time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		queue.Run(ctx)
+		close(stopped)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+
+	submitted, err := queue.Submit(context.Background(), &ImportTodosCommand{Todos: []CreateTodoDTO{{Title: "Купить книгу", ActiveAt: "2023-08-04"}}})
+	require.NoError(t, err)
+	<-ch.started
+	// The lease expired and another worker claimed the job again.
+	store.mu.Lock()
+	store.jobs[submitted.ID].Attempts++
+	store.mu.Unlock()
+	close(ch.release)
+
+	require.ErrorIs(t, <-store.saved, ErrJobLeaseLost)
+	job, err := store.Get(context.Background(), submitted.ID)
+	require.NoError(t, err)
+	require.Equal(t, JobRunning, job.Status)
+	require.Equal(t, 2, job.Attempts)
+	require.Empty(t, job.Result)
+}
@@ -0,0 +1,81 @@
+package todo
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Transitions is the table of the lifecycle: the statuses a task may move to from each status. A status missing
+// from the table is final.
+type Transitions map[string][]string
+
+// DefaultTransitions lets open tasks move freely between each other and to DONE or CANCELLED, except that a
+// blocked task is unblocked before it is done. Done and cancelled tasks can be reopened.
+var DefaultTransitions = Transitions{
+	StatusTodo:       {StatusInProgress, StatusBlocked, StatusDone, StatusCancelled},
+	StatusInProgress: {StatusTodo, StatusBlocked, StatusDone, StatusCancelled},
+	StatusBlocked:    {StatusTodo, StatusInProgress, StatusCancelled},
+	StatusDone:       {StatusTodo},
+	StatusCancelled:  {StatusTodo},
+}
+
+// NewTransitions checks a table read from the configuration; an empty one is DefaultTransitions.
+func NewTransitions(table map[string][]string) (Transitions, error) {
+	if len(table) == 0 {
+		return DefaultTransitions, nil
+	}
+	transitions := make(Transitions, len(table))
+	for from, targets := range table {
+		from = strings.ToUpper(from)
+		if !slices.Contains(Statuses, from) {
+			return nil, fmt.Errorf("transitions: unknown status %q", from)
+		}
+		for _, to := range targets {
+			to = strings.ToUpper(to)
+			if !slices.Contains(Statuses, to) {
+				return nil, fmt.Errorf("transitions from %s: unknown status %q", from, to)
+			}
+			transitions[from] = append(transitions[from], to)
+		}
+	}
+	return transitions, nil
+}
+
+// Check fails unless a task in status from may move to status to. A legacy ACTIVE task counts as TODO.
+func (transitions Transitions) Check(from, to string) error {
+	if !slices.Contains(Statuses, to) {
+		return NewValidationError(ErrValidationFailed, FieldError{Field: "status", Rule: "oneof", Param: strings.Join(Statuses, " ")})
+	}
+	allowed := transitions[upgradeStatus(from)]
+	if !slices.Contains(allowed, to) {
+		return NewValidationError(fmt.Errorf("%w: %s to %s", ErrInvalidTransition, upgradeStatus(from), to),
+			FieldError{Field: "status", Rule: "transition", Param: strings.Join(allowed, " ")})
+	}
+	return nil
+}
+
+// upgradeStatus returns the status of the lifecycle that status stands for, TODO for the legacy ACTIVE.
+func upgradeStatus(status string) string {
+	if status == StatusActive {
+		return StatusTodo
+	}
+	return status
+}
+
+// statusFilter resolves the status filter of a listing. ACTIVE selects the open tasks due by today in the time
+// zone of ctx, any other status the tasks in it whatever their date.
+func statusFilter(ctx context.Context, pointers TodoPointers) (TodoPointers, error) {
+	if pointers.Status == nil {
+		return pointers, nil
+	}
+	switch status := *pointers.Status; {
+	case status == StatusActive:
+		today := Today(ctx)
+		pointers.Status, pointers.Statuses, pointers.DueBy = nil, OpenStatuses, &today
+	case !slices.Contains(Statuses, status):
+		return pointers, NewValidationError(ErrValidationFailed, FieldError{Field: "status", Rule: "oneof", Param: strings.Join(StatusFilters, " ")})
+	}
+	return pointers, nil
+}
@@ -0,0 +1,167 @@
+package todo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	command "github.com/kas2000/commandlib"
+	httpLib "github.com/kas2000/http"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+func TestTransitions(t *testing.T) {
+	doneIsFinal, err := NewTransitions(map[string][]string{
+		"todo": {"in_progress", "done"},
+		"DONE": {},
+	})
+	require.NoError(t, err)
+
+	testCases := []struct {
+		title       string
+		transitions Transitions
+		from        string
+		to          string
+		expectedErr error
+	}{
+		{title: "Взять в работу", transitions: DefaultTransitions, from: StatusTodo, to: StatusInProgress},
+		{title: "Заблокировать", transitions: DefaultTransitions, from: StatusInProgress, to: StatusBlocked},
+		{title: "Заблокированную не завершить", transitions: DefaultTransitions, from: StatusBlocked, to: StatusDone, expectedErr: ErrInvalidTransition},
+		{title: "Переоткрыть завершённую", transitions: DefaultTransitions, from: StatusDone, to: StatusTodo},
+		{title: "Восстановить отменённую", transitions: DefaultTransitions, from: StatusCancelled, to: StatusTodo},
+		{title: "Отменённую не взять в работу", transitions: DefaultTransitions, from: StatusCancelled, to: StatusInProgress, expectedErr: ErrInvalidTransition},
+		{title: "Старый статус ACTIVE как TODO", transitions: DefaultTransitions, from: StatusActive, to: StatusInProgress},
+		{title: "Неизвестный статус", transitions: DefaultTransitions, from: StatusTodo, to: "LATER", expectedErr: ErrValidationFailed},
+		{title: "ACTIVE не статус", transitions: DefaultTransitions, from: StatusTodo, to: StatusActive, expectedErr: ErrValidationFailed},
+		{title: "Своя таблица", transitions: doneIsFinal, from: StatusTodo, to: StatusDone},
+		{title: "Завершённая задача окончательна", transitions: doneIsFinal, from: StatusDone, to: StatusTodo, expectedErr: ErrInvalidTransition},
+		{title: "Статус без переходов окончателен", transitions: doneIsFinal, from: StatusBlocked, to: StatusTodo, expectedErr: ErrInvalidTransition},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.title, func(t *testing.T) {
+			err := tc.transitions.Check(tc.from, tc.to)
+			if tc.expectedErr == nil {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, tc.expectedErr)
+		})
+	}
+
+	_, err = NewTransitions(map[string][]string{StatusTodo: {"LATER"}})
+	require.EqualError(t, err, `transitions from TODO: unknown status "LATER"`)
+	_, err = NewTransitions(map[string][]string{"PENDING": {StatusTodo}})
+	require.EqualError(t, err, `transitions: unknown status "PENDING"`)
+}
+
+func TestUpdateTodoStatus(t *testing.T) {
+	id := primitive.NewObjectID()
+	repository := newMemoryTodoRepo(&Todo{ID: id, Title: "Купить книгу", Status: StatusTodo, ActiveAt: time.Date(2023, 8, 4, 0, 0, 0, 0, time.UTC)})
+	service := NewService(repository, newMemoryTodoListRepo(), zap.NewNop())
+	ctx := context.Background()
+
+	require.NoError(t, service.UpdateTodoStatus(ctx, TransitionTodoDTO{ID: id, Status: StatusBlocked}))
+	require.Equal(t, StatusBlocked, repository.todos[id].Status)
+	updatedAt := repository.todos[id].UpdatedAt
+
+	require.NoError(t, service.UpdateTodoStatus(ctx, TransitionTodoDTO{ID: id, Status: StatusBlocked}))
+	require.Equal(t, updatedAt, repository.todos[id].UpdatedAt, "тот же статус ничего не меняет")
+
+	err := service.UpdateTodoStatus(ctx, TransitionTodoDTO{ID: id, Status: StatusDone})
+	require.ErrorIs(t, err, ErrInvalidTransition)
+	require.EqualError(t, err, "invalid status transition.: BLOCKED to DONE")
+	require.Equal(t, StatusBlocked, repository.todos[id].Status)
+
+	require.NoError(t, service.UpdateTodoStatus(ctx, TransitionTodoDTO{ID: id, Status: StatusDone, FromAnyOpen: true}))
+	require.Equal(t, StatusDone, repository.todos[id].Status)
+
+	repository.todos[id].Status = StatusCancelled
+	err = service.UpdateTodoStatus(ctx, TransitionTodoDTO{ID: id, Status: StatusDone, FromAnyOpen: true})
+	require.ErrorIs(t, err, ErrInvalidTransition, "закрытые задачи следуют жизненному циклу")
+
+	err = service.UpdateTodoStatus(ctx, TransitionTodoDTO{ID: primitive.NewObjectID(), Status: StatusDone})
+	require.ErrorIs(t, err, ErrTodoNotFound)
+}
+
+func TestStatusFilter(t *testing.T) {
+	today := Today(context.Background())
+	tomorrow := today.AddDate(0, 0, 1)
+	repository := newMemoryTodoRepo(
+		&Todo{ID: primitive.NewObjectID(), Title: "Купить книгу", Status: StatusTodo, ActiveAt: today},
+		&Todo{ID: primitive.NewObjectID(), Title: "Позвонить маме", Status: StatusInProgress, ActiveAt: today},
+		&Todo{ID: primitive.NewObjectID(), Title: "Оплатить счёт", Status: StatusBlocked, ActiveAt: tomorrow},
+		&Todo{ID: primitive.NewObjectID(), Title: "Забрать посылку", Status: StatusCancelled, ActiveAt: today},
+	)
+	service := NewService(repository, newMemoryTodoListRepo(), zap.NewNop())
+
+	testCases := []struct {
+		title    string
+		status   string
+		expected []string
+	}{
+		{title: "Открытые задачи на сегодня", status: StatusActive, expected: []string{"Купить книгу", "Позвонить маме"}},
+		{title: "Статус без учёта даты", status: StatusBlocked, expected: []string{"Оплатить счёт"}},
+		{title: "Отменённые", status: StatusCancelled, expected: []string{"Забрать посылку"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.title, func(t *testing.T) {
+			todos, err := service.FindTodos(context.Background(), TodoPointers{Status: &tc.status})
+			require.NoError(t, err)
+			titles := make([]string, 0, len(todos))
+			for _, todo := range todos {
+				titles = append(titles, todo.Title)
+			}
+			require.ElementsMatch(t, tc.expected, titles)
+		})
+	}
+
+	unknown := "LATER"
+	_, err := service.FindTodos(context.Background(), TodoPointers{Status: &unknown})
+	require.ErrorIs(t, err, ErrValidationFailed)
+}
+
+func TestTransitionTodoEndpoint(t *testing.T) {
+	id := primitive.NewObjectID()
+	repository := newMemoryTodoRepo(&Todo{ID: id, Title: "Купить книгу", Status: StatusDone, ActiveAt: time.Date(2023, 8, 4, 0, 0, 0, 0, time.UTC)})
+	todoHttp := NewTodoHttp(zap.NewNop(), command.NewCommandHandler(NewService(repository, newMemoryTodoListRepo(), zap.NewNop())), validator.New(), "todo-service")
+	transition := func(body string) httpLib.Response {
+		req := httptest.NewRequest(http.MethodPost, "/v2/todo-list/tasks/"+id.Hex()+"/transitions", strings.NewReader(body))
+		req = mux.SetURLVars(req, map[string]string{"id": id.Hex()})
+		return todoHttp.TransitionTodo("id")(httptest.NewRecorder(), req)
+	}
+
+	resp := transition(`{"status":"in_progress"}`)
+	require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode(), "из DONE только обратно в TODO")
+	problem := resp.Response().(*Problem)
+	require.Equal(t, "todo-service.1103", problem.Code)
+	require.Equal(t, []FieldError{{Field: "status", Rule: "transition", Param: StatusTodo, Message: "status can only change to one of [TODO]"}}, problem.Errors)
+
+	resp = transition(`{"status":"todo"}`)
+	require.Equal(t, http.StatusNoContent, resp.StatusCode())
+	require.Equal(t, StatusTodo, repository.todos[id].Status)
+
+	resp = transition(`{}`)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode())
+
+	repository.todos[id].Status = StatusBlocked
+	done := func(todoHttp *TodoHttp) httpLib.Response {
+		req := httptest.NewRequest(http.MethodPut, "/todo-list/tasks/"+id.Hex()+"/done", nil)
+		req = mux.SetURLVars(req, map[string]string{"id": id.Hex()})
+		return todoHttp.SetTodoStatusDone("id")(httptest.NewRecorder(), req)
+	}
+	resp = done(todoHttp.WithAPIVersion(APIVersion2))
+	require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode(), "в v2 заблокированную задачу сначала разблокируют")
+	require.Equal(t, StatusBlocked, repository.todos[id].Status)
+	resp = done(todoHttp.WithAPIVersion(APIVersion1))
+	require.Equal(t, http.StatusNoContent, resp.StatusCode(), "v1 завершает любую открытую задачу")
+	require.Equal(t, StatusDone, repository.todos[id].Status)
+}
@@ -0,0 +1,247 @@
+package todo
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	httpLib "github.com/kas2000/http"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	listCollection     = "todo_lists"
+	defaultListName    = "Default"
+	legacyUniqueIndex  = "title_1_active_at_1"
+	listIDParameter    = "listId"
+	legacyTaskListPath = "/todo-list"
+	taskListPath       = "/todo-lists/{" + listIDParameter + "}"
+)
+
+// DefaultListID is the list of the routes under /todo-list and of every task stored before there were lists.
+var DefaultListID = primitive.ObjectID{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}
+
+// TodoList is a named list of tasks. An archived list takes no new tasks, neither created nor moved in, and neither
+// does a list DeleteTodoList has marked Deleting while it checks the list is empty.
+type TodoList struct {
+	ID        primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	Name      string             `json:"name" bson:"name"`
+	Archived  bool               `json:"archived" bson:"archived"`
+	Deleting  bool               `json:"-" bson:"deleting,omitempty"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+	UpdatedAt *time.Time         `json:"updated_at" bson:"updated_at"`
+}
+
+type TodoListDTO struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Archived  bool       `json:"archived"`
+	Default   bool       `json:"default"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UpdatedAt *time.Time `json:"updatedAt,omitempty"`
+}
+
+type CreateTodoListDTO struct {
+	Name string `json:"name" validate:"required,max=100"`
+}
+
+type RenameTodoListDTO struct {
+	ID   primitive.ObjectID `json:"id"`
+	Name string             `json:"name" validate:"required,max=100"`
+}
+
+// MoveTodoDTO moves a task to the list ListID, where its title and date must be free.
+type MoveTodoDTO struct {
+	ID     primitive.ObjectID `json:"id"`
+	ListID primitive.ObjectID `json:"listId" validate:"required"`
+}
+
+// TodoListPointers changes a list; nil fields are kept.
+type TodoListPointers struct {
+	ID       primitive.ObjectID
+	Name     *string
+	Archived *bool
+	Deleting *bool
+}
+
+func NewTodoListDTO(list *TodoList) *TodoListDTO {
+	return &TodoListDTO{
+		ID:        list.ID.Hex(),
+		Name:      list.Name,
+		Archived:  list.Archived,
+		Default:   list.ID == DefaultListID,
+		CreatedAt: list.CreatedAt,
+		UpdatedAt: list.UpdatedAt,
+	}
+}
+
+type TodoListRepository interface {
+	Create(ctx context.Context, list *TodoList) (*TodoList, error)
+	FindByID(ctx context.Context, id primitive.ObjectID) (*TodoList, error)
+	// FindAll returns every list, oldest first.
+	FindAll(ctx context.Context) ([]*TodoList, error)
+	Update(ctx context.Context, upd TodoListPointers) error
+	Delete(ctx context.Context, id primitive.ObjectID) error
+	EnsureIndexes(ctx context.Context) error
+}
+
+type TodoListService interface {
+	CreateTodoList(ctx context.Context, list *CreateTodoListDTO) (*TodoListDTO, error)
+	FindTodoList(ctx context.Context, id primitive.ObjectID) (*TodoListDTO, error)
+	FindTodoLists(ctx context.Context) ([]*TodoListDTO, error)
+	RenameTodoList(ctx context.Context, rename RenameTodoListDTO) error
+	// ArchiveTodoList archives the list or, with archived false, restores it.
+	ArchiveTodoList(ctx context.Context, id primitive.ObjectID, archived bool) error
+	// DeleteTodoList deletes an empty list other than the default one.
+	DeleteTodoList(ctx context.Context, id primitive.ObjectID) error
+	MoveTodo(ctx context.Context, move MoveTodoDTO) error
+}
+
+type todoListRepo struct {
+	collection *mongo.Collection
+}
+
+// NewTodoListRepo keeps lists in a collection created by Migrate, along with the default list.
+func NewTodoListRepo(db *mongo.Database) TodoListRepository {
+	return &todoListRepo{collection: db.Collection(listCollection)}
+}
+
+// EnsureIndexes creates the unique name index.
+func (repository *todoListRepo) EnsureIndexes(ctx context.Context) error {
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "name", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	_, err := repository.collection.Indexes().CreateOne(ctx, index)
+	return err
+}
+
+func (repository *todoListRepo) Create(ctx context.Context, list *TodoList) (*TodoList, error) {
+	list.CreatedAt = time.Now().UTC()
+	result, err := repository.collection.InsertOne(ctx, list)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrTodoListAlreadyExists
+		}
+		return nil, err
+	}
+	list.ID = result.InsertedID.(primitive.ObjectID)
+	return list, nil
+}
+
+func (repository *todoListRepo) FindByID(ctx context.Context, id primitive.ObjectID) (*TodoList, error) {
+	var list TodoList
+	err := repository.collection.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&list)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrTodoListNotFound
+		}
+		return nil, err
+	}
+	return &list, nil
+}
+
+func (repository *todoListRepo) FindAll(ctx context.Context) ([]*TodoList, error) {
+	cursor, err := repository.collection.Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	lists := make([]*TodoList, 0, cursor.RemainingBatchLength())
+	return lists, cursor.All(ctx, &lists)
+}
+
+func (repository *todoListRepo) Update(ctx context.Context, upd TodoListPointers) error {
+	values := bson.D{}
+	if upd.Name != nil {
+		values = append(values, bson.E{Key: "name", Value: *upd.Name})
+	}
+	if upd.Archived != nil {
+		values = append(values, bson.E{Key: "archived", Value: *upd.Archived})
+	}
+	// The deleting mark isn't a change of the list.
+	if len(values) > 0 {
+		values = append(values, bson.E{Key: "updated_at", Value: time.Now().UTC()})
+	}
+	if upd.Deleting != nil {
+		values = append(values, bson.E{Key: "deleting", Value: *upd.Deleting})
+	}
+	if len(values) == 0 {
+		return ErrNothingToUpdate
+	}
+	result := repository.collection.FindOneAndUpdate(ctx, bson.D{{Key: "_id", Value: upd.ID}}, bson.D{{Key: "$set", Value: values}})
+	if result.Err() != nil {
+		if result.Err() == mongo.ErrNoDocuments {
+			return ErrTodoListNotFound
+		}
+		if mongo.IsDuplicateKeyError(result.Err()) {
+			return ErrTodoListAlreadyExists
+		}
+		return result.Err()
+	}
+	return nil
+}
+
+func (repository *todoListRepo) Delete(ctx context.Context, id primitive.ObjectID) error {
+	result := repository.collection.FindOneAndDelete(ctx, bson.D{{Key: "_id", Value: id}})
+	if result.Err() != nil {
+		if result.Err() == mongo.ErrNoDocuments {
+			return ErrTodoListNotFound
+		}
+		return result.Err()
+	}
+	return nil
+}
+
+// listOf returns the list of todo. Tasks logged or stored before there were lists have none and are in the default
+// list.
+func listOf(todo *Todo) primitive.ObjectID {
+	if todo.ListID.IsZero() {
+		return DefaultListID
+	}
+	return todo.ListID
+}
+
+type listKey struct{}
+
+// ContextWithList makes the tasks of the request those of the list id.
+func ContextWithList(ctx context.Context, id primitive.ObjectID) context.Context {
+	return context.WithValue(ctx, listKey{}, id)
+}
+
+// ListFromContext returns the list of the request, the default list when none was given.
+func ListFromContext(ctx context.Context) primitive.ObjectID {
+	if id, ok := ctx.Value(listKey{}).(primitive.ObjectID); ok {
+		return id
+	}
+	return DefaultListID
+}
+
+// inList keeps a listing to the list of ctx.
+func inList(ctx context.Context, pointers TodoPointers) TodoPointers {
+	list := ListFromContext(ctx)
+	pointers.ListID = &list
+	return pointers
+}
+
+// listed runs endpoint on the tasks of the list named by the listId path parameter.
+func (factory *TodoHttp) listed(endpoint httpLib.Endpoint) httpLib.Endpoint {
+	return func(w http.ResponseWriter, r *http.Request) httpLib.Response {
+		id, err := factory.listID(r, listIDParameter)
+		if err != nil {
+			return factory.problem(r, err)
+		}
+		return endpoint(w, r.WithContext(ContextWithList(r.Context(), id)))
+	}
+}
+
+func (factory *TodoHttp) listID(r *http.Request, idParameter string) (primitive.ObjectID, error) {
+	id, err := primitive.ObjectIDFromHex(mux.Vars(r)[idParameter])
+	if err != nil {
+		return primitive.NilObjectID, ErrInvalidTodoListID
+	}
+	return id, nil
+}
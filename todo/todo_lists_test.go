@@ -0,0 +1,311 @@
+package todo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	command "github.com/kas2000/commandlib"
+	httpLib "github.com/kas2000/http"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+// memoryTodoListRepo keeps lists in a map, starting with the default list as Migrate does.
+type memoryTodoListRepo struct {
+	lists map[primitive.ObjectID]*TodoList
+}
+
+func newMemoryTodoListRepo(lists ...*TodoList) *memoryTodoListRepo {
+	repository := &memoryTodoListRepo{lists: map[primitive.ObjectID]*TodoList{
+		DefaultListID: {ID: DefaultListID, Name: defaultListName},
+	}}
+	for _, list := range lists {
+		repository.lists[list.ID] = list
+	}
+	return repository
+}
+
+func (repository *memoryTodoListRepo) named(name string, except primitive.ObjectID) bool {
+	for _, list := range repository.lists {
+		if list.ID != except && list.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (repository *memoryTodoListRepo) Create(ctx context.Context, list *TodoList) (*TodoList, error) {
+	if repository.named(list.Name, primitive.NilObjectID) {
+		return nil, ErrTodoListAlreadyExists
+	}
+	list.ID = primitive.NewObjectID()
+	list.CreatedAt = time.Now().UTC()
+	copied := *list
+	repository.lists[list.ID] = &copied
+	return list, nil
+}
+
+func (repository *memoryTodoListRepo) FindByID(ctx context.Context, id primitive.ObjectID) (*TodoList, error) {
+	list, found := repository.lists[id]
+	if !found {
+		return nil, ErrTodoListNotFound
+	}
+	copied := *list
+	return &copied, nil
+}
+
+func (repository *memoryTodoListRepo) FindAll(ctx context.Context) ([]*TodoList, error) {
+	lists := make([]*TodoList, 0, len(repository.lists))
+	for _, list := range repository.lists {
+		copied := *list
+		lists = append(lists, &copied)
+	}
+	sort.Slice(lists, func(i, j int) bool {
+		return lists[i].ID.Hex() < lists[j].ID.Hex()
+	})
+	return lists, nil
+}
+
+func (repository *memoryTodoListRepo) Update(ctx context.Context, upd TodoListPointers) error {
+	list, found := repository.lists[upd.ID]
+	if !found {
+		return ErrTodoListNotFound
+	}
+	if upd.Name != nil {
+		if repository.named(*upd.Name, upd.ID) {
+			return ErrTodoListAlreadyExists
+		}
+		list.Name = *upd.Name
+	}
+	if upd.Archived != nil {
+		list.Archived = *upd.Archived
+	}
+	if upd.Deleting != nil {
+		list.Deleting = *upd.Deleting
+	}
+	return nil
+}
+
+func (repository *memoryTodoListRepo) Delete(ctx context.Context, id primitive.ObjectID) error {
+	if _, found := repository.lists[id]; !found {
+		return ErrTodoListNotFound
+	}
+	delete(repository.lists, id)
+	return nil
+}
+
+func (repository *memoryTodoListRepo) EnsureIndexes(ctx context.Context) error {
+	return nil
+}
+
+func TestTodoLists(t *testing.T) {
+	ctx := context.Background()
+	todos := newMemoryTodoRepo()
+	todos.indexed = true
+	lists := newMemoryTodoListRepo()
+	service := NewService(todos, lists, zap.NewNop())
+
+	work, err := service.CreateTodoList(ctx, &CreateTodoListDTO{Name: "Работа"})
+	require.NoError(t, err)
+	require.False(t, work.Default)
+	workID, _ := primitive.ObjectIDFromHex(work.ID)
+	_, err = service.CreateTodoList(ctx, &CreateTodoListDTO{Name: "Работа"})
+	require.ErrorIs(t, err, ErrTodoListAlreadyExists)
+
+	require.NoError(t, service.RenameTodoList(ctx, RenameTodoListDTO{ID: workID, Name: "Офис"}))
+	renamed, err := service.FindTodoList(ctx, workID)
+	require.NoError(t, err)
+	require.Equal(t, "Офис", renamed.Name)
+	all, err := service.FindTodoLists(ctx)
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+	require.True(t, all[0].Default, "список по умолчанию создан первым")
+
+	created, err := service.CreateTodo(ContextWithList(ctx, workID), &CreateTodoDTO{Title: "Купить книгу", ActiveAt: "2023-08-04"})
+	require.NoError(t, err)
+	require.Equal(t, work.ID, created.ListID)
+	require.ErrorIs(t, service.DeleteTodoList(ctx, workID), ErrTodoListNotEmpty)
+	require.False(t, lists.lists[workID].Deleting, "неудачное удаление снимает отметку")
+
+	require.NoError(t, service.ArchiveTodoList(ctx, workID, true))
+	_, err = service.CreateTodo(ContextWithList(ctx, workID), &CreateTodoDTO{Title: "Позвонить маме", ActiveAt: "2023-08-04"})
+	require.ErrorIs(t, err, ErrTodoListArchived)
+	require.NoError(t, service.ArchiveTodoList(ctx, workID, false))
+	_, err = service.CreateTodo(ContextWithList(ctx, primitive.NewObjectID()), &CreateTodoDTO{Title: "Позвонить маме", ActiveAt: "2023-08-04"})
+	require.ErrorIs(t, err, ErrTodoListNotFound)
+
+	require.ErrorIs(t, service.ArchiveTodoList(ctx, DefaultListID, true), ErrDefaultTodoList)
+	require.ErrorIs(t, service.DeleteTodoList(ctx, DefaultListID), ErrDefaultTodoList)
+
+	createdID, _ := primitive.ObjectIDFromHex(created.ID)
+	require.NoError(t, service.DeleteTodo(ContextWithList(ctx, workID), createdID))
+	require.NoError(t, service.DeleteTodoList(ctx, workID))
+	_, err = service.FindTodoList(ctx, workID)
+	require.ErrorIs(t, err, ErrTodoListNotFound)
+}
+
+// racingTodoRepo runs before ahead of every write, as a concurrent request would between the checks of the service
+// and its write.
+type racingTodoRepo struct {
+	*memoryTodoRepo
+	before func()
+}
+
+func (repository *racingTodoRepo) Create(ctx context.Context, todo *Todo) (*Todo, error) {
+	repository.before()
+	return repository.memoryTodoRepo.Create(ctx, todo)
+}
+
+func (repository *racingTodoRepo) Update(ctx context.Context, upd TodoPointers) error {
+	repository.before()
+	return repository.memoryTodoRepo.Update(ctx, upd)
+}
+
+func TestTodoListDeleteRace(t *testing.T) {
+	ctx := context.Background()
+	home, work := primitive.NewObjectID(), primitive.NewObjectID()
+	homeTodo := primitive.NewObjectID()
+
+	testCases := []struct {
+		title string
+		write func(service Service) error
+	}{
+		{
+			title: "Задача, созданная во время удаления списка, отменяется",
+			write: func(service Service) error {
+				_, err := service.CreateTodo(ContextWithList(ctx, work), &CreateTodoDTO{Title: "Купить книгу", ActiveAt: "2023-08-04"})
+				return err
+			},
+		},
+		{
+			title: "Задача, перенесённая во время удаления списка, возвращается",
+			write: func(service Service) error {
+				return service.MoveTodo(ContextWithList(ctx, home), MoveTodoDTO{ID: homeTodo, ListID: work})
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.title, func(t *testing.T) {
+			todos := &racingTodoRepo{memoryTodoRepo: newMemoryTodoRepo(&Todo{ID: homeTodo, ListID: home, Title: "Позвонить маме", Status: StatusTodo})}
+			lists := newMemoryTodoListRepo(&TodoList{ID: home, Name: "Дом"}, &TodoList{ID: work, Name: "Работа"})
+			service := NewService(todos, lists, zap.NewNop())
+			deleted := false
+			todos.before = func() {
+				if !deleted {
+					deleted = true
+					require.NoError(t, service.DeleteTodoList(ctx, work))
+				}
+			}
+
+			require.ErrorIs(t, tc.write(service), ErrTodoListNotFound)
+			_, err := lists.FindByID(ctx, work)
+			require.ErrorIs(t, err, ErrTodoListNotFound)
+			for _, todo := range todos.todos {
+				require.NotEqual(t, work, todo.ListID, "в удалённом списке не остаётся задач")
+			}
+			require.Equal(t, home, todos.todos[homeTodo].ListID)
+		})
+	}
+}
+
+func TestListScopedTodos(t *testing.T) {
+	ctx := context.Background()
+	activeAt := time.Date(2023, 8, 4, 0, 0, 0, 0, time.UTC)
+	home, work, archive := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	inbox := primitive.NewObjectID()
+	homeTodo, workTodo := primitive.NewObjectID(), primitive.NewObjectID()
+	todos := newMemoryTodoRepo(
+		&Todo{ID: inbox, Title: "Купить книгу", Status: StatusTodo, ActiveAt: activeAt},
+		&Todo{ID: homeTodo, ListID: home, Title: "Купить книгу", Status: StatusTodo, ActiveAt: activeAt},
+		&Todo{ID: workTodo, ListID: work, Title: "Позвонить маме", Status: StatusTodo, ActiveAt: activeAt},
+	)
+	todos.indexed = true
+	service := NewService(todos, newMemoryTodoListRepo(
+		&TodoList{ID: home, Name: "Дом"},
+		&TodoList{ID: work, Name: "Работа"},
+		&TodoList{ID: archive, Name: "Архив", Archived: true},
+	), zap.NewNop())
+	titles := func(ctx context.Context) []string {
+		found, err := service.FindTodos(ctx, TodoPointers{})
+		require.NoError(t, err)
+		result := make([]string, 0, len(found))
+		for _, todo := range found {
+			result = append(result, todo.Title)
+		}
+		return result
+	}
+
+	require.Equal(t, []string{"Купить книгу"}, titles(ctx), "старые маршруты работают со списком по умолчанию")
+	require.Equal(t, []string{"Позвонить маме"}, titles(ContextWithList(ctx, work)))
+	_, err := service.FindTodo(ContextWithList(ctx, work), homeTodo)
+	require.ErrorIs(t, err, ErrTodoNotFound, "задача другого списка не видна")
+	require.ErrorIs(t, service.DeleteTodo(ctx, homeTodo), ErrTodoNotFound)
+
+	testCases := []struct {
+		title       string
+		from        primitive.ObjectID
+		move        MoveTodoDTO
+		expectedErr error
+	}{
+		{title: "Название и дата заняты в целевом списке", from: home, move: MoveTodoDTO{ID: homeTodo, ListID: DefaultListID}, expectedErr: ErrTodoAlreadyExists},
+		{title: "В архивный список не переносится", from: home, move: MoveTodoDTO{ID: homeTodo, ListID: archive}, expectedErr: ErrTodoListArchived},
+		{title: "Несуществующий список", from: home, move: MoveTodoDTO{ID: homeTodo, ListID: primitive.NewObjectID()}, expectedErr: ErrTodoListNotFound},
+		{title: "Задача не из этого списка", from: work, move: MoveTodoDTO{ID: homeTodo, ListID: DefaultListID}, expectedErr: ErrTodoNotFound},
+		{title: "Перенос в другой список", from: home, move: MoveTodoDTO{ID: homeTodo, ListID: work}},
+		{title: "Перенос в свой же список ничего не меняет", from: work, move: MoveTodoDTO{ID: homeTodo, ListID: work}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.title, func(t *testing.T) {
+			err := service.MoveTodo(ContextWithList(ctx, tc.from), tc.move)
+			if tc.expectedErr == nil {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, tc.expectedErr)
+		})
+	}
+	require.Equal(t, work, todos.todos[homeTodo].ListID)
+	require.ElementsMatch(t, []string{"Купить книгу", "Позвонить маме"}, titles(ContextWithList(ctx, work)))
+	require.Empty(t, titles(ContextWithList(ctx, home)))
+}
+
+func TestTodoListEndpoints(t *testing.T) {
+	todos := newMemoryTodoRepo()
+	todoHttp := NewTodoHttp(zap.NewNop(), command.NewCommandHandler(NewService(todos, newMemoryTodoListRepo(), zap.NewNop())), validator.New(), "todo-service")
+	call := func(endpoint httpLib.Endpoint, method, path, body string, vars map[string]string) httpLib.Response {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		return endpoint(httptest.NewRecorder(), mux.SetURLVars(req, vars))
+	}
+
+	resp := call(todoHttp.CreateTodoList(), http.MethodPost, "/v2/todo-lists", `{"name":"Работа"}`, nil)
+	require.Equal(t, http.StatusCreated, resp.StatusCode())
+	list := resp.Response().(*TodoListDTO)
+	require.Equal(t, "/v2/todo-lists/"+list.ID, resp.Headers()["Location"])
+
+	vars := map[string]string{listIDParameter: list.ID}
+	resp = call(todoHttp.listed(todoHttp.CreateTodo()), http.MethodPost, "/v2/todo-lists/"+list.ID+"/tasks", `{"title":"Купить книгу","activeAt":"2023-08-04"}`, vars)
+	require.Equal(t, http.StatusNoContent, resp.StatusCode())
+	for _, todo := range todos.todos {
+		require.Equal(t, list.ID, todo.ListID.Hex())
+	}
+
+	resp = call(todoHttp.listed(todoHttp.FindTodos()), http.MethodGet, "/v2/todo-lists/work/tasks", "", map[string]string{listIDParameter: "work"})
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode())
+	require.Equal(t, "todo-service.1011", resp.Response().(*Problem).Code)
+
+	resp = call(todoHttp.DeleteTodoList(listIDParameter), http.MethodDelete, "/v2/todo-lists/"+list.ID, "", vars)
+	require.Equal(t, http.StatusConflict, resp.StatusCode())
+	require.Equal(t, "todo-service.1107", resp.Response().(*Problem).Code)
+
+	resp = call(todoHttp.RenameTodoList(listIDParameter), http.MethodPut, "/v2/todo-lists/"+list.ID, `{}`, vars)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode())
+}
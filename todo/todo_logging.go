@@ -0,0 +1,71 @@
+package todo
+
+import (
+	"context"
+
+	"github.com/kas2000/logger"
+	"go.uber.org/zap"
+)
+
+// RequestIDHeader carries the request ID from the caller and back in the response.
+const RequestIDHeader = "X-Request-ID"
+
+type loggerKey struct{}
+
+type requestIDKey struct{}
+
+// ContextWithLogger attaches a request-scoped logger that LoggerFromContext returns further down the request.
+func ContextWithLogger(ctx context.Context, log logger.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, log)
+}
+
+// LoggerFromContext returns the request-scoped logger of ctx, or fallback outside of requests.
+func LoggerFromContext(ctx context.Context, fallback logger.Logger) logger.Logger {
+	if log, ok := ctx.Value(loggerKey{}).(logger.Logger); ok {
+		return log
+	}
+	return fallback
+}
+
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// fieldLogger adds fields to every entry; logger.Logger has no With.
+type fieldLogger struct {
+	next   logger.Logger
+	fields []zap.Field
+}
+
+// WithFields returns a logger adding fields to every entry of log.
+func WithFields(log logger.Logger, fields ...zap.Field) logger.Logger {
+	if zapLogger, ok := log.(*zap.Logger); ok {
+		return zapLogger.With(fields...)
+	}
+	return &fieldLogger{next: log, fields: fields}
+}
+
+func (log *fieldLogger) with(fields []zap.Field) []zap.Field {
+	return append(append(make([]zap.Field, 0, len(log.fields)+len(fields)), log.fields...), fields...)
+}
+
+func (log *fieldLogger) Info(msg string, fields ...zap.Field) {
+	log.next.Info(msg, log.with(fields)...)
+}
+
+func (log *fieldLogger) Warn(msg string, fields ...zap.Field) {
+	log.next.Warn(msg, log.with(fields)...)
+}
+
+func (log *fieldLogger) Fatal(msg string, fields ...zap.Field) {
+	log.next.Fatal(msg, log.with(fields)...)
+}
+
+func (log *fieldLogger) Debug(msg string, fields ...zap.Field) {
+	log.next.Debug(msg, log.with(fields)...)
+}
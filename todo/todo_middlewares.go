@@ -0,0 +1,257 @@
+package todo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	command "github.com/kas2000/commandlib"
+	"github.com/kas2000/logger"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+// LogCommands logs every command with its duration through the request logger. Unregistered errors are warnings.
+func LogCommands(log logger.Logger) CommandMiddleware {
+	return func(next command.CommandHandler) command.CommandHandler {
+		return CommandHandlerFunc(func(cmd command.Command) (interface{}, error) {
+			started := time.Now()
+			result, err := next.ExecuteCommand(cmd)
+			fields := []zap.Field{zap.String("command", CommandName(cmd)), zap.Duration("duration", time.Since(started))}
+			log := LoggerFromContext(commandContext(cmd), log)
+			if _, registered := LookupError(err); err != nil && !registered {
+				log.Warn("command failed", append(fields, zap.Error(err))...)
+			} else {
+				log.Debug("command executed", append(fields, zap.Error(err))...)
+			}
+			return result, err
+		})
+	}
+}
+
+// TimeCommands warns about commands running for slow or longer. Use ForCommands for per-command thresholds.
+func TimeCommands(log logger.Logger, slow time.Duration) CommandMiddleware {
+	return func(next command.CommandHandler) command.CommandHandler {
+		return CommandHandlerFunc(func(cmd command.Command) (interface{}, error) {
+			started := time.Now()
+			result, err := next.ExecuteCommand(cmd)
+			if elapsed := time.Since(started); elapsed >= slow {
+				LoggerFromContext(commandContext(cmd), log).Warn("slow command",
+					zap.String("command", CommandName(cmd)), zap.Duration("duration", elapsed), zap.Duration("threshold", slow))
+			}
+			return result, err
+		})
+	}
+}
+
+// RecoverCommands turns a panicking command into an internal error instead of crashing the service.
+func RecoverCommands(log logger.Logger) CommandMiddleware {
+	return func(next command.CommandHandler) command.CommandHandler {
+		return CommandHandlerFunc(func(cmd command.Command) (result interface{}, err error) {
+			defer func() {
+				if recovered := recover(); recovered != nil {
+					LoggerFromContext(commandContext(cmd), log).Warn("command panicked",
+						zap.String("command", CommandName(cmd)), zap.Any("panic", recovered), zap.Stack("stack"))
+					// Not registered, so the panic is reported as an internal error without details.
+					result, err = nil, fmt.Errorf("command %s panicked: %v", CommandName(cmd), recovered)
+				}
+			}()
+			return next.ExecuteCommand(cmd)
+		})
+	}
+}
+
+// CommandAuthorizer allows the caller of ctx to run cmd or returns an error, usually ErrForbidden.
+type CommandAuthorizer func(ctx context.Context, cmd command.Command) error
+
+// DenyAll refuses every command. Applied to some commands with ForCommands it makes them unavailable.
+func DenyAll(ctx context.Context, cmd command.Command) error {
+	return ErrForbidden
+}
+
+// AuthorizeCommands runs only the commands authorize allows.
+func AuthorizeCommands(authorize CommandAuthorizer) CommandMiddleware {
+	return func(next command.CommandHandler) command.CommandHandler {
+		return CommandHandlerFunc(func(cmd command.Command) (interface{}, error) {
+			if err := authorize(commandContext(cmd), cmd); err != nil {
+				return nil, err
+			}
+			return next.ExecuteCommand(cmd)
+		})
+	}
+}
+
+// ValidateCommands checks the validate tags of commands and their DTOs before running them.
+func ValidateCommands(validate *validator.Validate) CommandMiddleware {
+	return func(next command.CommandHandler) command.CommandHandler {
+		return CommandHandlerFunc(func(cmd command.Command) (interface{}, error) {
+			err := validate.StructCtx(commandContext(cmd), cmd)
+			var validationErrs validator.ValidationErrors
+			if errors.As(err, &validationErrs) {
+				return nil, newValidationErrorFromValidator(validationErrs)
+			}
+			if err != nil {
+				return nil, err
+			}
+			return next.ExecuteCommand(cmd)
+		})
+	}
+}
+
+type RetryPolicy struct {
+	// Attempts counts the first run too.
+	Attempts int
+	// Backoff is the delay before the first retry; it doubles with every further one.
+	Backoff time.Duration
+	// Retryable reports whether err is worth another attempt, IsTransientError by default.
+	Retryable func(err error) bool
+}
+
+// IsTransientError reports network errors, timeouts and errors Mongo labels as retryable.
+func IsTransientError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) {
+		return true
+	}
+	var labeled mongo.LabeledError
+	return errors.As(err, &labeled) &&
+		(labeled.HasErrorLabel("RetryableWriteError") || labeled.HasErrorLabel("TransientTransactionError"))
+}
+
+// RetryCommands runs a command again on transient errors. Only apply it to idempotent commands, see ForCommands.
+func RetryCommands(policy RetryPolicy) CommandMiddleware {
+	if policy.Retryable == nil {
+		policy.Retryable = IsTransientError
+	}
+	return func(next command.CommandHandler) command.CommandHandler {
+		return CommandHandlerFunc(func(cmd command.Command) (interface{}, error) {
+			ctx := commandContext(cmd)
+			backoff := policy.Backoff
+			for attempt := 1; ; attempt++ {
+				result, err := next.ExecuteCommand(cmd)
+				if err == nil || attempt >= policy.Attempts || !policy.Retryable(err) {
+					return result, err
+				}
+				select {
+				case <-ctx.Done():
+					return result, err
+				case <-time.After(backoff):
+				}
+				backoff *= 2
+			}
+		})
+	}
+}
+
+// CommandDrain tracks the commands in flight so that shutdown can wait for them. Once closed it turns new commands
+// away with ErrShuttingDown.
+type CommandDrain struct {
+	mu       sync.Mutex
+	closed   bool
+	inFlight sync.WaitGroup
+}
+
+func NewCommandDrain() *CommandDrain {
+	return &CommandDrain{}
+}
+
+// DrainCommands runs commands through drain. Put it outermost, so that drain waits for the whole pipeline.
+func DrainCommands(drain *CommandDrain) CommandMiddleware {
+	return func(next command.CommandHandler) command.CommandHandler {
+		return CommandHandlerFunc(func(cmd command.Command) (interface{}, error) {
+			if !drain.enter() {
+				return nil, ErrShuttingDown
+			}
+			defer drain.inFlight.Done()
+			return next.ExecuteCommand(cmd)
+		})
+	}
+}
+
+func (drain *CommandDrain) enter() bool {
+	drain.mu.Lock()
+	defer drain.mu.Unlock()
+	if drain.closed {
+		return false
+	}
+	drain.inFlight.Add(1)
+	return true
+}
+
+// Close turns new commands away and waits for the ones in flight, or until ctx is done.
+func (drain *CommandDrain) Close(ctx context.Context) error {
+	drain.mu.Lock()
+	drain.closed = true
+	drain.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		drain.inFlight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// CommandAudit is the record of one executed command.
+type CommandAudit struct {
+	Command   command.Command
+	Name      string
+	RequestID string
+	At        time.Time
+	Duration  time.Duration
+	Err       error
+}
+
+type CommandAuditor interface {
+	Audit(ctx context.Context, audit CommandAudit)
+}
+
+// AuditCommands hands every command with its outcome to auditor, including the denied and failed ones.
+func AuditCommands(auditor CommandAuditor) CommandMiddleware {
+	return func(next command.CommandHandler) command.CommandHandler {
+		return CommandHandlerFunc(func(cmd command.Command) (interface{}, error) {
+			ctx := commandContext(cmd)
+			started := time.Now()
+			result, err := next.ExecuteCommand(cmd)
+			auditor.Audit(ctx, CommandAudit{
+				Command:   cmd,
+				Name:      CommandName(cmd),
+				RequestID: RequestIDFromContext(ctx),
+				At:        started.UTC(),
+				Duration:  time.Since(started),
+				Err:       err,
+			})
+			return result, err
+		})
+	}
+}
+
+type logAuditor struct {
+	log logger.Logger
+}
+
+// NewLogAuditor writes audit records as "audit" log lines with the command payload.
+func NewLogAuditor(log logger.Logger) CommandAuditor {
+	return &logAuditor{log: log}
+}
+
+func (auditor *logAuditor) Audit(ctx context.Context, audit CommandAudit) {
+	auditor.log.Info("audit",
+		zap.String("command", audit.Name),
+		zap.String("request_id", audit.RequestID),
+		zap.Time("at", audit.At),
+		zap.Duration("duration", audit.Duration),
+		zap.Reflect("payload", audit.Command),
+		zap.Error(audit.Err),
+	)
+}
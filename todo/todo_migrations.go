@@ -0,0 +1,171 @@
+package todo
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Migrate creates the collections and indexes of the tasks, the lists, the change log and the jobs that don't exist
+// yet and upgrades tasks stored before the lifecycle or before lists. It is safe to run on every start.
+func Migrate(ctx context.Context, db *mongo.Database, collectionName string) error {
+	names, err := db.ListCollectionNames(ctx, bson.D{})
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool, len(names))
+	for _, name := range names {
+		existing[name] = true
+	}
+
+	if !existing[collectionName] {
+		if err := db.CreateCollection(ctx, collectionName); err != nil {
+			return err
+		}
+		if err := NewTodoRepo(db, collectionName).EnsureIndexes(ctx); err != nil {
+			return err
+		}
+	}
+
+	if !existing[changeLogCollection] {
+		opts := options.CreateCollection().SetCapped(true).SetSizeInBytes(changeLogSizeBytes)
+		if err := db.CreateCollection(ctx, changeLogCollection, opts); err != nil {
+			return err
+		}
+		index := mongo.IndexModel{
+			Keys:    bson.D{{Key: "seq", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		}
+		if _, err := db.Collection(changeLogCollection).Indexes().CreateOne(ctx, index); err != nil {
+			return err
+		}
+	}
+
+	if !existing[jobCollection] {
+		if err := db.CreateCollection(ctx, jobCollection); err != nil {
+			return err
+		}
+		indexes := []mongo.IndexModel{
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "created_at", Value: 1}}},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "lease_until", Value: 1}}},
+		}
+		if _, err := db.Collection(jobCollection).Indexes().CreateMany(ctx, indexes); err != nil {
+			return err
+		}
+	}
+
+	if !existing[eventCollection] {
+		if err := db.CreateCollection(ctx, eventCollection); err != nil {
+			return err
+		}
+		// The unique version per task rejects concurrent appends.
+		index := mongo.IndexModel{
+			Keys:    bson.D{{Key: "todo_id", Value: 1}, {Key: "version", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		}
+		if _, err := db.Collection(eventCollection).Indexes().CreateOne(ctx, index); err != nil {
+			return err
+		}
+	}
+
+	if !existing[viewCollection] {
+		if err := db.CreateCollection(ctx, viewCollection); err != nil {
+			return err
+		}
+	}
+
+	if !existing[snapshotCollection] {
+		if err := db.CreateCollection(ctx, snapshotCollection); err != nil {
+			return err
+		}
+	}
+	if err := upgradeStatuses(ctx, db, collectionName); err != nil {
+		return err
+	}
+	return upgradeLists(ctx, db, collectionName, existing)
+}
+
+// upgradeStatuses renames the ACTIVE status of open tasks to TODO in the tasks, the read model and the snapshots.
+// Events keep ACTIVE and are upgraded as they are applied.
+func upgradeStatuses(ctx context.Context, db *mongo.Database, collectionName string) error {
+	keys := map[string]string{collectionName: "status", viewCollection: "status", snapshotCollection: "todo.status"}
+	for name, key := range keys {
+		filter := bson.D{{Key: key, Value: StatusActive}}
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: key, Value: StatusTodo}}}}
+		if _, err := db.Collection(name).UpdateMany(ctx, filter, update); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upgradeLists creates the lists and the default one, puts the tasks stored before lists in it, makes titles
+// unique per list rather than across all tasks and indexes the listings of the read model by list.
+func upgradeLists(ctx context.Context, db *mongo.Database, collectionName string, existing map[string]bool) error {
+	if !existing[listCollection] {
+		if err := db.CreateCollection(ctx, listCollection); err != nil {
+			return err
+		}
+		if err := NewTodoListRepo(db).EnsureIndexes(ctx); err != nil {
+			return err
+		}
+	}
+	_, err := db.Collection(listCollection).UpdateOne(ctx,
+		bson.D{{Key: "_id", Value: DefaultListID}},
+		bson.D{{Key: "$setOnInsert", Value: bson.D{{Key: "name", Value: defaultListName}, {Key: "archived", Value: false}, {Key: "created_at", Value: time.Now().UTC()}}}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return err
+	}
+
+	unlisted := func(key string) bson.D {
+		return bson.D{{Key: key, Value: bson.D{{Key: "$exists", Value: false}}}}
+	}
+	updates := []struct {
+		collection string
+		filter     bson.D
+		key        string
+	}{
+		{collection: collectionName, filter: unlisted("list_id"), key: "list_id"},
+		{collection: viewCollection, filter: append(unlisted("list_id"), bson.E{Key: "deleted", Value: bson.D{{Key: "$ne", Value: true}}}), key: "list_id"},
+		{collection: snapshotCollection, filter: append(unlisted("todo.list_id"), bson.E{Key: "todo", Value: bson.D{{Key: "$ne", Value: nil}}}), key: "todo.list_id"},
+	}
+	for _, upd := range updates {
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: upd.key, Value: DefaultListID}}}}
+		if _, err := db.Collection(upd.collection).UpdateMany(ctx, upd.filter, update); err != nil {
+			return err
+		}
+	}
+
+	// The index per list is created before the global one is dropped so that titles stay unique throughout.
+	if err := NewTodoRepo(db, collectionName).EnsureIndexes(ctx); err != nil {
+		return err
+	}
+	if err := dropIndexes(ctx, db.Collection(collectionName), legacyUniqueIndex); err != nil {
+		return err
+	}
+	views := &todoViews{collection: db.Collection(viewCollection)}
+	return views.EnsureIndexes(ctx)
+}
+
+// dropIndexes drops those of the named indexes that collection has.
+func dropIndexes(ctx context.Context, collection *mongo.Collection, names ...string) error {
+	indexes, err := collection.Indexes().ListSpecifications(ctx)
+	if err != nil {
+		return err
+	}
+	for _, index := range indexes {
+		if !slices.Contains(names, index.Name) {
+			continue
+		}
+		if _, err := collection.Indexes().DropOne(ctx, index.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
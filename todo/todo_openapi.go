@@ -0,0 +1,393 @@
+package todo
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	httpLib "github.com/kas2000/http"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const openAPIVersion = "1.0.0"
+
+//go:embed openapi.html
+var openAPIDocsPage []byte
+
+// openAPIDocsAssets is swagger-ui 5.18.2, served with the page so it doesn't depend on a CDN.
+//
+//go:embed swagger-ui
+var openAPIDocsAssets embed.FS
+
+var (
+	objectIDType   = reflect.TypeOf(primitive.ObjectID{})
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+	pathParameterPattern = regexp.MustCompile(`{([^}]+)}`)
+)
+
+// OperationDoc describes a route in the OpenAPI document. Body and response bodies are example values that
+// only give their type.
+type OperationDoc struct {
+	ID      string
+	Summary string
+	Tag     string
+	Query   []ParameterDoc
+	Headers []ParameterDoc
+	Body    interface{}
+	// BodyRequired is false for routes that also accept their input as query parameters.
+	BodyRequired bool
+	Responses    []ResponseDoc
+	// Errors are the domain errors the route may answer with, documented as problem details by their status.
+	Errors []error
+}
+
+type ParameterDoc struct {
+	Name        string
+	Description string
+	Enum        []string
+	Required    bool
+}
+
+type ResponseDoc struct {
+	Status      int
+	Description string
+	// Body is nil for responses without content.
+	Body        interface{}
+	ContentType string
+	Headers     []string
+}
+
+// NewOpenAPI describes routes, which are relative to prefix, as an OpenAPI 3 document and validates it.
+func NewOpenAPI(prefix string, routes []Route) (*openapi3.T, error) {
+	server := prefix
+	if server == "" {
+		server = "/"
+	}
+	doc := &openapi3.T{
+		OpenAPI: "3.0.3",
+		Info: &openapi3.Info{
+			Title:       "service-todo",
+			Description: "Tasks of a todo list. Unversioned routes serve the API version the service is configured with.",
+			Version:     openAPIVersion,
+		},
+		Servers:    openapi3.Servers{{URL: server}},
+		Paths:      openapi3.NewPaths(),
+		Components: &openapi3.Components{Schemas: openapi3.Schemas{}},
+	}
+	schemas := doc.Components.Schemas
+	problem := schemaRef(reflect.TypeOf(Problem{}), schemas)
+
+	for _, route := range routes {
+		if route.Doc.ID == "" {
+			return nil, fmt.Errorf("openapi: %s %s isn't documented", route.Method, route.Path)
+		}
+		operation := &openapi3.Operation{
+			OperationID: route.Doc.ID,
+			Summary:     route.Doc.Summary,
+			Responses:   openapi3.NewResponsesWithCapacity(len(route.Doc.Responses) + 1),
+		}
+		if route.Doc.Tag != "" {
+			operation.Tags = []string{route.Doc.Tag}
+		}
+		for _, match := range pathParameterPattern.FindAllStringSubmatch(route.Path, -1) {
+			operation.AddParameter(openapi3.NewPathParameter(match[1]).WithSchema(schemaRef(objectIDType, schemas).Value))
+		}
+		for _, query := range route.Doc.Query {
+			operation.AddParameter(parameter(openapi3.NewQueryParameter(query.Name), query))
+		}
+		for _, header := range route.Doc.Headers {
+			operation.AddParameter(parameter(openapi3.NewHeaderParameter(header.Name), header))
+		}
+		if route.Doc.Body != nil {
+			operation.RequestBody = &openapi3.RequestBodyRef{Value: openapi3.NewRequestBody().
+				WithRequired(route.Doc.BodyRequired).
+				WithJSONSchemaRef(schemaRef(reflect.TypeOf(route.Doc.Body), schemas))}
+		}
+
+		for _, response := range route.Doc.Responses {
+			operation.AddResponse(response.Status, newOpenAPIResponse(response, schemas))
+		}
+		for status, titles := range errorTitles(route.Doc.Errors) {
+			operation.AddResponse(status, openapi3.NewResponse().
+				WithDescription(strings.Join(titles, ", ")).
+				WithContent(openapi3.NewContentWithSchemaRef(problem, []string{ProblemContentType})))
+		}
+		operation.AddResponse(0, openapi3.NewResponse().
+			WithDescription(internalErrorDefinition.Title).
+			WithContent(openapi3.NewContentWithSchemaRef(problem, []string{ProblemContentType})))
+
+		doc.AddOperation(route.Path, route.Method, operation)
+	}
+	return doc, doc.Validate(context.Background())
+}
+
+func parameter(param *openapi3.Parameter, doc ParameterDoc) *openapi3.Parameter {
+	schema := openapi3.NewStringSchema()
+	for _, value := range doc.Enum {
+		schema.Enum = append(schema.Enum, value)
+	}
+	return param.WithDescription(doc.Description).WithRequired(doc.Required || param.In == openapi3.ParameterInPath).WithSchema(schema)
+}
+
+func newOpenAPIResponse(doc ResponseDoc, schemas openapi3.Schemas) *openapi3.Response {
+	description := doc.Description
+	if description == "" {
+		description = http.StatusText(doc.Status)
+	}
+	response := openapi3.NewResponse().WithDescription(description)
+	if doc.Body != nil {
+		contentType := doc.ContentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		response.WithContent(openapi3.NewContentWithSchemaRef(schemaRef(reflect.TypeOf(doc.Body), schemas), []string{contentType}))
+	}
+	for _, header := range doc.Headers {
+		if response.Headers == nil {
+			response.Headers = openapi3.Headers{}
+		}
+		response.Headers[header] = &openapi3.HeaderRef{Value: &openapi3.Header{Parameter: openapi3.Parameter{
+			Schema: openapi3.NewStringSchema().NewRef(),
+		}}}
+	}
+	return response
+}
+
+// errorTitles groups the titles of registered errors by their status, in registry order.
+func errorTitles(errs []error) map[int][]string {
+	titles := make(map[int][]string)
+	for _, err := range errs {
+		def, registered := LookupError(err)
+		if !registered {
+			panic(fmt.Sprintf("openapi: %v is not a registered error", err))
+		}
+		titles[def.Status] = append(titles[def.Status], def.Title)
+	}
+	return titles
+}
+
+// schemaRef describes values of t as JSON encodes them. Named structs are added to schemas and referenced.
+func schemaRef(t reflect.Type, schemas openapi3.Schemas) *openapi3.SchemaRef {
+	nullable := false
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+		nullable = true
+	}
+	switch t {
+	case objectIDType:
+		schema := openapi3.NewStringSchema()
+		schema.Pattern = "^[0-9a-f]{24}$"
+		return schema.NewRef()
+	case timeType:
+		schema := openapi3.NewDateTimeSchema()
+		schema.Nullable = nullable
+		return schema.NewRef()
+	case rawMessageType:
+		return openapi3.NewSchema().NewRef()
+	}
+
+	var schema *openapi3.Schema
+	switch t.Kind() {
+	case reflect.Bool:
+		schema = openapi3.NewBoolSchema()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		schema = openapi3.NewIntegerSchema()
+		if t.Kind() == reflect.Int64 {
+			schema.Format = "int64"
+		}
+	case reflect.Float32, reflect.Float64:
+		schema = openapi3.NewFloat64Schema()
+	case reflect.String:
+		schema = openapi3.NewStringSchema()
+	case reflect.Slice, reflect.Array:
+		schema = openapi3.NewArraySchema()
+		schema.Items = schemaRef(t.Elem(), schemas)
+	case reflect.Map:
+		schema = openapi3.NewObjectSchema()
+		schema.AdditionalProperties = openapi3.AdditionalProperties{Schema: schemaRef(t.Elem(), schemas)}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return structSchema(t, schemas).NewRef()
+		}
+		if _, found := schemas[t.Name()]; !found {
+			// Registered before the fields so that recursive types end in a reference.
+			schemas[t.Name()] = &openapi3.SchemaRef{}
+			schemas[t.Name()].Value = structSchema(t, schemas)
+		}
+		return openapi3.NewSchemaRef("#/components/schemas/"+t.Name(), schemas[t.Name()].Value)
+	default:
+		schema = openapi3.NewSchema()
+	}
+	if nullable {
+		schema.Nullable = true
+	}
+	return schema.NewRef()
+}
+
+// structSchema reads the json tags of t's fields and the required and min rules of their validate tags. A
+// format tag, e.g. format:"date", sets the format of a string field.
+func structSchema(t reflect.Type, schemas openapi3.Schemas) *openapi3.Schema {
+	schema := openapi3.NewObjectSchema()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, tagged := jsonName(field)
+		if field.Anonymous && !tagged {
+			fieldType := field.Type
+			if fieldType.Kind() == reflect.Ptr {
+				fieldType = fieldType.Elem()
+			}
+			if fieldType.Kind() == reflect.Struct {
+				embedded := structSchema(fieldType, schemas)
+				for property, ref := range embedded.Properties {
+					schema.WithPropertyRef(property, ref)
+				}
+				schema.Required = append(schema.Required, embedded.Required...)
+				continue
+			}
+		}
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+
+		property := schemaRef(field.Type, schemas)
+		if property.Ref == "" {
+			applyValidateTag(property.Value, field.Tag.Get("validate"))
+			if format := field.Tag.Get("format"); format != "" {
+				property.Value.Format = format
+			}
+			if enum := field.Tag.Get("enum"); enum != "" {
+				for _, value := range strings.Split(enum, ",") {
+					property.Value.Enum = append(property.Value.Enum, value)
+				}
+			}
+		}
+		schema.WithPropertyRef(name, property)
+		if hasRule(field.Tag.Get("validate"), "required") {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	sort.Strings(schema.Required)
+	return schema
+}
+
+func jsonName(field reflect.StructField) (string, bool) {
+	tag, tagged := field.Tag.Lookup("json")
+	name := strings.SplitN(tag, ",", 2)[0]
+	if name == "" {
+		name = field.Name
+	}
+	return name, tagged
+}
+
+// validateRules returns the rules of a validate tag that apply to the field itself, not to its elements.
+func validateRules(tag string) []string {
+	rules := strings.Split(tag, ",")
+	for i, rule := range rules {
+		if rule == "dive" {
+			return rules[:i]
+		}
+	}
+	return rules
+}
+
+func hasRule(tag string, rule string) bool {
+	for _, r := range validateRules(tag) {
+		if r == rule {
+			return true
+		}
+	}
+	return false
+}
+
+func applyValidateTag(schema *openapi3.Schema, tag string) {
+	for _, rule := range validateRules(tag) {
+		name, param, _ := strings.Cut(rule, "=")
+		switch name {
+		case "min", "max", "len":
+			applySizeRule(schema, name, param)
+		case "oneof":
+			for _, value := range strings.Fields(param) {
+				if schema.Type.Is(openapi3.TypeString) {
+					schema.Enum = append(schema.Enum, value)
+				} else if number, err := strconv.ParseFloat(value, 64); err == nil {
+					schema.Enum = append(schema.Enum, number)
+				}
+			}
+		}
+	}
+}
+
+// applySizeRule bounds the items of an array, the length of a string or the value of a number, as the validator does.
+func applySizeRule(schema *openapi3.Schema, name string, param string) {
+	if schema.Type.Is(openapi3.TypeInteger) || schema.Type.Is(openapi3.TypeNumber) {
+		bound, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return
+		}
+		if name != "max" {
+			schema.Min = &bound
+		}
+		if name != "min" {
+			schema.Max = &bound
+		}
+		return
+	}
+	bound, err := strconv.ParseUint(param, 10, 64)
+	if err != nil {
+		return
+	}
+	if schema.Type.Is(openapi3.TypeArray) {
+		if name != "max" {
+			schema.MinItems = bound
+		}
+		if name != "min" {
+			schema.MaxItems = &bound
+		}
+	} else if schema.Type.Is(openapi3.TypeString) {
+		if name != "max" {
+			schema.MinLength = bound
+		}
+		if name != "min" {
+			schema.MaxLength = &bound
+		}
+	}
+}
+
+// OpenAPIDocsEndpoint serves a page rendering the document next to it, at openapi.json.
+func OpenAPIDocsEndpoint() httpLib.Endpoint {
+	return func(w http.ResponseWriter, r *http.Request) httpLib.Response {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write(openAPIDocsPage)
+		return nil
+	}
+}
+
+// OpenAPIDocsAssetEndpoint serves the swagger-ui file name the docs page loads. It panics on a name that isn't
+// vendored.
+func OpenAPIDocsAssetEndpoint(name string) httpLib.Endpoint {
+	asset, err := openAPIDocsAssets.ReadFile(path.Join("swagger-ui", name))
+	if err != nil {
+		panic(err)
+	}
+	return func(w http.ResponseWriter, r *http.Request) httpLib.Response {
+		w.Header().Set("Content-Type", mime.TypeByExtension(path.Ext(name)))
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		w.WriteHeader(http.StatusOK)
+		w.Write(asset)
+		return nil
+	}
+}
@@ -0,0 +1,227 @@
+package todo
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/go-playground/validator/v10"
+	"github.com/gorilla/mux"
+	httpLib "github.com/kas2000/http"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+var updateOpenAPI = flag.Bool("update", false, "rewrite openapi.json from the routes")
+
+const openAPIFile = "../openapi.json"
+
+// routeServer keeps the endpoints registered through Handle by method and path template.
+type routeServer map[string]httpLib.Endpoint
+
+func (routes routeServer) ListenAndServe() {}
+
+func (routes routeServer) Handle(method string, path string, final httpLib.Endpoint) {
+	routes[method+" "+path] = final
+}
+
+func newTestTodoController(t *testing.T, ch *stubCommandHandler) *todoController {
+	todoGraphQL, err := NewTodoGraphQL(zap.NewNop(), ch, validator.New(), "todo-service")
+	require.NoError(t, err)
+	todoHttp := NewTodoHttp(zap.NewNop(), ch, validator.New(), "todo-service").WithJobs(&JobQueue{})
+	return NewTodoController(nil, todoHttp, todoGraphQL, NewTodoStream(zap.NewNop(), nil, "todo-service"), "")
+}
+
+func TestOpenAPI(t *testing.T) {
+	t.Run("Каждый маршрут описан один раз", func(t *testing.T) {
+		seen := make(map[string]bool)
+		for _, route := range newTestTodoController(t, &stubCommandHandler{}).Routes() {
+			require.NotEmpty(t, route.Doc.ID, "%s %s", route.Method, route.Path)
+			require.False(t, seen[route.Doc.ID], route.Doc.ID)
+			seen[route.Doc.ID] = true
+		}
+	})
+
+	t.Run("Описание совпадает с openapi.json", func(t *testing.T) {
+		routes := newTestTodoController(t, &stubCommandHandler{}).Routes()
+		doc, err := NewOpenAPI("", routes)
+		require.NoError(t, err)
+		generated, err := json.MarshalIndent(doc, "", "  ")
+		require.NoError(t, err)
+		generated = append(generated, '\n')
+
+		if *updateOpenAPI {
+			require.NoError(t, os.WriteFile(openAPIFile, generated, 0644))
+		}
+		committed, err := os.ReadFile(openAPIFile)
+		require.NoError(t, err)
+		require.Equal(t, string(committed), string(generated), "routes changed, run go test ./todo -run TestOpenAPI -update")
+
+		for _, route := range routes {
+			require.NotNil(t, doc.Paths.Find(route.Path).GetOperation(route.Method), "%s %s", route.Method, route.Path)
+		}
+	})
+
+	t.Run("Схема тела следует правилам валидации", func(t *testing.T) {
+		doc, err := NewOpenAPI("", newTestTodoController(t, &stubCommandHandler{}).Routes())
+		require.NoError(t, err)
+		create := doc.Components.Schemas["CreateTodoDTO"].Value
+		require.Equal(t, []string{"activeAt", "title"}, create.Required)
+		require.Equal(t, "date", create.Properties["activeAt"].Value.Format)
+
+		imported := doc.Components.Schemas["ImportTodosCommand"].Value
+		require.Equal(t, []string{"todos"}, imported.Required)
+		require.Equal(t, uint64(1), imported.Properties["todos"].Value.MinItems)
+		require.Equal(t, "#/components/schemas/CreateTodoDTO", imported.Properties["todos"].Value.Items.Ref)
+
+		reschedule := doc.Components.Schemas["RescheduleTodosCommand"]
+		require.Nil(t, reschedule, "commands embedding a DTO are documented by the DTO")
+	})
+
+	t.Run("Границы и перечисления из правил валидации", func(t *testing.T) {
+		var bounded struct {
+			Name   string   `json:"name" validate:"required,max=100"`
+			Code   string   `json:"code" validate:"len=3"`
+			Tags   []string `json:"tags" validate:"min=1,max=5"`
+			Color  string   `json:"color" validate:"oneof=red green"`
+			Rating int      `json:"rating" validate:"min=1,max=5"`
+			Level  int      `json:"level" validate:"oneof=1 2 3"`
+		}
+		schemas := make(openapi3.Schemas)
+		properties := schemaRef(reflect.TypeOf(bounded), schemas).Value.Properties
+
+		require.Equal(t, uint64(100), *properties["name"].Value.MaxLength)
+		require.Equal(t, uint64(3), properties["code"].Value.MinLength)
+		require.Equal(t, uint64(3), *properties["code"].Value.MaxLength)
+		require.Equal(t, uint64(1), properties["tags"].Value.MinItems)
+		require.Equal(t, uint64(5), *properties["tags"].Value.MaxItems)
+		require.Equal(t, []interface{}{"red", "green"}, properties["color"].Value.Enum)
+		require.Equal(t, 1.0, *properties["rating"].Value.Min)
+		require.Equal(t, 5.0, *properties["rating"].Value.Max)
+		require.Equal(t, []interface{}{1.0, 2.0, 3.0}, properties["level"].Value.Enum)
+	})
+
+	t.Run("Страница документации не ходит за пределы сервиса", func(t *testing.T) {
+		require.NotContains(t, string(openAPIDocsPage), "://")
+		for _, asset := range []struct{ path, contentType string }{
+			{"/docs/swagger-ui.css", "text/css"},
+			{"/docs/swagger-ui-bundle.js", "text/javascript"},
+		} {
+			var endpoint httpLib.Endpoint
+			for _, route := range newTestTodoController(t, &stubCommandHandler{}).Routes() {
+				if route.Method == "GET" && route.Path == asset.path {
+					endpoint = route.Endpoint
+				}
+			}
+			require.NotNil(t, endpoint, asset.path)
+			resp := httptest.NewRecorder()
+			require.Nil(t, endpoint(resp, httptest.NewRequest(http.MethodGet, asset.path, nil)))
+			require.Equal(t, http.StatusOK, resp.Code)
+			require.Contains(t, resp.Header().Get("Content-Type"), asset.contentType)
+			require.NotEmpty(t, resp.Body.Bytes())
+		}
+	})
+}
+
+func TestOpenAPIValidation(t *testing.T) {
+	id := "64cd1d5c2b7f3a1e9c0d4e5f"
+	testCases := []struct {
+		title      string
+		validation string
+		method     string
+		route      string
+		vars       map[string]string
+		target     string
+		body       string
+		resp       interface{}
+		wantStatus int
+		wantFields []FieldError
+	}{
+		{
+			title:      "Без проверки запрос уходит в обработчик",
+			validation: ValidateNothing,
+			method:     "GET", route: "/v2/todo-list/tasks", target: "/v2/todo-list/tasks?status=archived",
+			resp:       []*TodoDTO{},
+			wantStatus: http.StatusOK,
+		},
+		{
+			title:      "Некорректный идентификатор в пути",
+			validation: ValidateRequests,
+			method:     "GET", route: "/v2/todo-list/tasks/{id}", vars: map[string]string{"id": "abc"}, target: "/v2/todo-list/tasks/abc",
+			wantStatus: http.StatusBadRequest,
+			wantFields: []FieldError{{Field: "id", Rule: "pattern"}},
+		},
+		{
+			title:      "Статус вне перечисления",
+			validation: ValidateRequests,
+			method:     "GET", route: "/v2/todo-list/tasks", target: "/v2/todo-list/tasks?status=archived",
+			wantStatus: http.StatusBadRequest,
+			wantFields: []FieldError{{Field: "status", Rule: "enum"}},
+		},
+		{
+			title:      "Тело не соответствует схеме",
+			validation: ValidateRequests,
+			method:     "POST", route: "/v2/todo-list/tasks", target: "/v2/todo-list/tasks",
+			body:       `{"activeAt":"05.08.2023"}`,
+			wantStatus: http.StatusBadRequest,
+			wantFields: []FieldError{{Field: "activeAt", Rule: "format"}, {Field: "title", Rule: "required"}},
+		},
+		{
+			title:      "Тело не JSON",
+			validation: ValidateRequests,
+			method:     "POST", route: "/v2/todo-list/tasks", target: "/v2/todo-list/tasks",
+			body:       `{"title":`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			title:      "Корректный запрос",
+			validation: ValidateAll,
+			method:     "POST", route: "/v2/todo-list/tasks", target: "/v2/todo-list/tasks",
+			body:       `{"title":"Купить книгу","activeAt":"2023-08-05"}`,
+			resp:       &TodoDTO{ID: id, Title: "Купить книгу", Status: StatusTodo, ActiveAt: "2023-08-05", CreatedAt: time.Now()},
+			wantStatus: http.StatusCreated,
+		},
+		{
+			title:      "Ответ не соответствует схеме",
+			validation: ValidateAll,
+			method:     "GET", route: "/v2/todo-list/tasks/{id}", vars: map[string]string{"id": id}, target: "/v2/todo-list/tasks/" + id,
+			resp:       &TodoDTO{ID: id, Title: "Купить книгу", Status: StatusTodo, ActiveAt: "05.08.2023", CreatedAt: time.Now()},
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.title, func(t *testing.T) {
+			routes := routeServer{}
+			var server httpLib.Server = routes
+			controller := newTestTodoController(t, &stubCommandHandler{resp: tc.resp})
+			controller.server = &server
+			require.NoError(t, controller.WithValidation(tc.validation).Bind())
+
+			req := httptest.NewRequest(tc.method, tc.target, bytes.NewBufferString(tc.body))
+			if tc.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			resp := routes[tc.method+" "+tc.route](httptest.NewRecorder(), mux.SetURLVars(req, tc.vars))
+			require.Equal(t, tc.wantStatus, resp.StatusCode())
+			if tc.wantFields == nil {
+				return
+			}
+			problem := resp.Response().(*Problem)
+			require.Equal(t, "todo-service.1003", problem.Code)
+			require.Len(t, problem.Errors, len(tc.wantFields))
+			for i, want := range tc.wantFields {
+				require.Equal(t, want.Field, problem.Errors[i].Field)
+				require.Equal(t, want.Rule, problem.Errors[i].Rule)
+				require.NotEmpty(t, problem.Errors[i].Message)
+			}
+		})
+	}
+}
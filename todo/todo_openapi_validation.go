@@ -0,0 +1,133 @@
+package todo
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/gorilla/mux"
+	httpLib "github.com/kas2000/http"
+	"go.uber.org/zap"
+)
+
+// Validation modes of the controller: requests are checked against the OpenAPI document and rejected with
+// ErrValidationFailed; responses are checked only in the development mode ValidateAll.
+const (
+	ValidateNothing  = "off"
+	ValidateRequests = "requests"
+	ValidateAll      = "all"
+)
+
+// validateRoute checks requests to route and, with responses set, its responses against doc. A response
+// that doesn't match is logged and replaced by an internal error, so that the mismatch can't go unnoticed.
+func (tc *todoController) validateRoute(doc *openapi3.T, route Route, responses bool) httpLib.Endpoint {
+	pathItem := doc.Paths.Find(route.Path)
+	docRoute := &routers.Route{Spec: doc, Path: route.Path, PathItem: pathItem, Method: route.Method, Operation: pathItem.GetOperation(route.Method)}
+	options := &openapi3filter.Options{MultiError: true, IncludeResponseStatus: true}
+
+	return func(w http.ResponseWriter, r *http.Request) httpLib.Response {
+		input := &openapi3filter.RequestValidationInput{Request: r, PathParams: mux.Vars(r), Route: docRoute, Options: options}
+		if err := openapi3filter.ValidateRequest(r.Context(), input); err != nil {
+			return tc.http.problem(r, newRequestValidationError(err))
+		}
+
+		response := route.Endpoint(w, r)
+		// Streaming endpoints write the response themselves.
+		if !responses || response == nil {
+			return response
+		}
+		if err := validateResponse(r, input, response); err != nil {
+			LoggerFromContext(r.Context(), tc.http.log).Warn("response doesn't match the openapi document",
+				zap.String("operation", route.Doc.ID), zap.Int("status", response.StatusCode()), zap.Error(err))
+			return tc.http.problem(r, ErrInternal)
+		}
+		return response
+	}
+}
+
+func validateResponse(r *http.Request, input *openapi3filter.RequestValidationInput, response httpLib.Response) error {
+	header := make(http.Header)
+	for name, value := range response.Headers() {
+		header.Set(name, value)
+	}
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", "application/json")
+	}
+	body, err := json.Marshal(response.Response())
+	if err != nil {
+		return err
+	}
+	return openapi3filter.ValidateResponse(r.Context(), &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: input,
+		Status:                 response.StatusCode(),
+		Header:                 header,
+		Body:                   io.NopCloser(bytes.NewReader(body)),
+		Options:                input.Options,
+	})
+}
+
+// newRequestValidationError lists the violations reported by openapi3filter as field errors. Fields of the body
+// are named by their JSON path, e.g. todos.0.title; a body that can't be decoded is ErrInvalidRequestBody.
+func newRequestValidationError(err error) error {
+	var fields []FieldError
+	for _, err := range unwrapMultiError(err) {
+		var requestErr *openapi3filter.RequestError
+		if !errors.As(err, &requestErr) {
+			fields = append(fields, FieldError{Rule: "openapi", Message: err.Error()})
+			continue
+		}
+		var parseErr *openapi3filter.ParseError
+		if requestErr.RequestBody != nil && errors.As(requestErr.Err, &parseErr) {
+			return ErrInvalidRequestBody
+		}
+		field := "body"
+		if requestErr.Parameter != nil {
+			field = requestErr.Parameter.Name
+		}
+		schemaErrs := schemaErrors(requestErr.Err)
+		if len(schemaErrs) == 0 {
+			fields = append(fields, FieldError{Field: field, Rule: "openapi", Message: requestErr.Error()})
+			continue
+		}
+		for _, schemaErr := range schemaErrs {
+			name := field
+			if path := schemaErr.JSONPointer(); len(path) > 0 {
+				name = strings.Join(path, ".")
+			}
+			fields = append(fields, FieldError{Field: name, Rule: schemaErr.SchemaField, Message: schemaErr.Reason})
+		}
+	}
+	return NewValidationError(ErrValidationFailed, fields...)
+}
+
+func unwrapMultiError(err error) []error {
+	multi, ok := err.(openapi3.MultiError)
+	if !ok {
+		return []error{err}
+	}
+	var errs []error
+	for _, err := range multi {
+		errs = append(errs, unwrapMultiError(err)...)
+	}
+	return errs
+}
+
+func schemaErrors(err error) []*openapi3.SchemaError {
+	if err == nil {
+		return nil
+	}
+	var schemaErrs []*openapi3.SchemaError
+	for _, err := range unwrapMultiError(err) {
+		var schemaErr *openapi3.SchemaError
+		if errors.As(err, &schemaErr) {
+			schemaErrs = append(schemaErrs, schemaErr)
+		}
+	}
+	return schemaErrs
+}
@@ -0,0 +1,89 @@
+package todo
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+
+	command "github.com/kas2000/commandlib"
+)
+
+// CommandMiddleware adds shared behaviour, e.g. logging or retries, around the execution of commands.
+type CommandMiddleware func(next command.CommandHandler) command.CommandHandler
+
+// CommandHandlerFunc adapts a function to command.CommandHandler.
+type CommandHandlerFunc func(cmd command.Command) (interface{}, error)
+
+func (f CommandHandlerFunc) ExecuteCommand(cmd command.Command) (interface{}, error) {
+	return f(cmd)
+}
+
+// NewCommandPipeline wraps handler in middlewares, the first of which runs outermost.
+func NewCommandPipeline(handler command.CommandHandler, middlewares ...CommandMiddleware) command.CommandHandler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// ForCommands applies middleware to the named command types only, e.g. "CreateTodoCommand".
+func ForCommands(middleware CommandMiddleware, names ...string) CommandMiddleware {
+	return selectCommands(middleware, names, true)
+}
+
+// ExceptCommands applies middleware to all but the named command types.
+func ExceptCommands(middleware CommandMiddleware, names ...string) CommandMiddleware {
+	return selectCommands(middleware, names, false)
+}
+
+func selectCommands(middleware CommandMiddleware, names []string, selected bool) CommandMiddleware {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[name] = true
+	}
+	return func(next command.CommandHandler) command.CommandHandler {
+		wrapped := middleware(next)
+		return CommandHandlerFunc(func(cmd command.Command) (interface{}, error) {
+			if set[CommandName(cmd)] == selected {
+				return wrapped.ExecuteCommand(cmd)
+			}
+			return next.ExecuteCommand(cmd)
+		})
+	}
+}
+
+// CommandNames are the type names of the commands of the service, which ForCommands and ExceptCommands select by.
+var CommandNames = []string{
+	"CreateTodoCommand", "FindTodoCommand", "FindTodosCommand", "DeleteTodoCommand", "UpdateTodoStatusCommand",
+	"UpdateTodoCommand", "ImportTodosCommand", "RescheduleTodosCommand", "MoveTodoCommand", "CreateTodoListCommand",
+	"FindTodoListCommand", "FindTodoListsCommand", "RenameTodoListCommand", "ArchiveTodoListCommand",
+	"DeleteTodoListCommand",
+}
+
+// CheckCommandNames fails on a name that isn't in CommandNames, which would select no command.
+func CheckCommandNames(names ...string) error {
+	for _, name := range names {
+		if !slices.Contains(CommandNames, name) {
+			return fmt.Errorf("unknown command %q", name)
+		}
+	}
+	return nil
+}
+
+// CommandName is the type name of cmd, e.g. CreateTodoCommand.
+func CommandName(cmd command.Command) string {
+	commandType := reflect.TypeOf(cmd)
+	for commandType.Kind() == reflect.Ptr {
+		commandType = commandType.Elem()
+	}
+	return commandType.Name()
+}
+
+// commandContext is the request context of commands embedding CommandContext, Background of others.
+func commandContext(cmd command.Command) context.Context {
+	if contextCmd, ok := cmd.(interface{ Context() context.Context }); ok {
+		return contextCmd.Context()
+	}
+	return context.Background()
+}
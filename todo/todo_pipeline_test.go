@@ -0,0 +1,197 @@
+package todo
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	command "github.com/kas2000/commandlib"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+// stubCommand returns the next of its errors on every run.
+type stubCommand struct {
+	CommandContext
+	errs  []error
+	runs  int
+	panic bool
+}
+
+func (cmd *stubCommand) Execute(svc interface{}) (interface{}, error) {
+	cmd.runs++
+	if cmd.panic {
+		panic("boom")
+	}
+	if len(cmd.errs) == 0 {
+		return "ok", nil
+	}
+	err := cmd.errs[0]
+	cmd.errs = cmd.errs[1:]
+	return nil, err
+}
+
+type recordingAuditor []CommandAudit
+
+func (auditor *recordingAuditor) Audit(ctx context.Context, audit CommandAudit) {
+	*auditor = append(*auditor, audit)
+}
+
+func tagging(tag string, order *[]string) CommandMiddleware {
+	return func(next command.CommandHandler) command.CommandHandler {
+		return CommandHandlerFunc(func(cmd command.Command) (interface{}, error) {
+			*order = append(*order, tag)
+			return next.ExecuteCommand(cmd)
+		})
+	}
+}
+
+func TestCommandPipeline(t *testing.T) {
+	var order []string
+	handler := NewCommandPipeline(command.NewCommandHandler(nil),
+		tagging("first", &order),
+		ForCommands(tagging("only-stub", &order), "stubCommand"),
+		ExceptCommands(tagging("except-stub", &order), "stubCommand"),
+		tagging("last", &order),
+	)
+	_, err := handler.ExecuteCommand(&stubCommand{})
+	require.NoError(t, err)
+	require.Equal(t, []string{"first", "only-stub", "last"}, order)
+
+	transient := &mongo.CommandError{Code: 91, Labels: []string{"RetryableWriteError"}}
+	log := zap.NewNop()
+	testCases := []struct {
+		title         string
+		middleware    CommandMiddleware
+		cmd           *stubCommand
+		expectedErr   error
+		expectedRuns  int
+		expectedError string
+	}{
+		{
+			title:        "Повтор при временной ошибке",
+			middleware:   RetryCommands(RetryPolicy{Attempts: 3, Backoff: time.Millisecond}),
+			cmd:          &stubCommand{errs: []error{transient, transient}},
+			expectedRuns: 3,
+		},
+		{
+			title:        "Повторы ограничены числом попыток",
+			middleware:   RetryCommands(RetryPolicy{Attempts: 2, Backoff: time.Millisecond}),
+			cmd:          &stubCommand{errs: []error{transient, transient}},
+			expectedErr:  transient,
+			expectedRuns: 2,
+		},
+		{
+			title:        "Доменная ошибка не повторяется",
+			middleware:   RetryCommands(RetryPolicy{Attempts: 3, Backoff: time.Millisecond}),
+			cmd:          &stubCommand{errs: []error{ErrTodoNotFound}},
+			expectedErr:  ErrTodoNotFound,
+			expectedRuns: 1,
+		},
+		{
+			title:         "Паника становится внутренней ошибкой",
+			middleware:    RecoverCommands(log),
+			cmd:           &stubCommand{panic: true},
+			expectedRuns:  1,
+			expectedError: "command stubCommand panicked: boom",
+		},
+		{
+			title: "Запрещённая команда не выполняется",
+			middleware: AuthorizeCommands(func(ctx context.Context, cmd command.Command) error {
+				return ErrForbidden
+			}),
+			cmd:          &stubCommand{},
+			expectedErr:  ErrForbidden,
+			expectedRuns: 0,
+		},
+		{
+			title:        "Разрешённая команда выполняется",
+			middleware:   ExceptCommands(AuthorizeCommands(DenyAll), "stubCommand"),
+			cmd:          &stubCommand{},
+			expectedRuns: 1,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.title, func(t *testing.T) {
+			_, err := NewCommandPipeline(command.NewCommandHandler(nil), testCase.middleware).ExecuteCommand(testCase.cmd)
+			switch {
+			case testCase.expectedError != "":
+				require.EqualError(t, err, testCase.expectedError)
+				_, registered := LookupError(err)
+				require.False(t, registered, "подробности паники не попадают клиенту")
+			case testCase.expectedErr != nil:
+				require.ErrorIs(t, err, testCase.expectedErr)
+			default:
+				require.NoError(t, err)
+			}
+			require.Equal(t, testCase.expectedRuns, testCase.cmd.runs)
+		})
+	}
+
+	t.Run("Невалидная команда отклоняется до выполнения", func(t *testing.T) {
+		handler := NewCommandPipeline(command.NewCommandHandler(nil), ValidateCommands(validator.New()))
+		_, err := handler.ExecuteCommand(&CreateTodoCommand{CreateTodoDTO: &CreateTodoDTO{Title: "Купить книгу"}})
+		require.ErrorIs(t, err, ErrValidationFailed)
+		var validationErr *ValidationError
+		require.True(t, errors.As(err, &validationErr))
+		require.Len(t, validationErr.Fields, 1)
+	})
+
+	t.Run("Аудит записывает команду, запрос и ошибку", func(t *testing.T) {
+		auditor := &recordingAuditor{}
+		handler := NewCommandPipeline(command.NewCommandHandler(nil), AuditCommands(auditor))
+		ctx := ContextWithRequestID(context.Background(), "req-42")
+		_, err := handler.ExecuteCommand(&stubCommand{CommandContext: NewCommandContext(ctx), errs: []error{ErrTodoNotFound}})
+		require.ErrorIs(t, err, ErrTodoNotFound)
+		require.Len(t, *auditor, 1)
+		require.Equal(t, "stubCommand", (*auditor)[0].Name)
+		require.Equal(t, "req-42", (*auditor)[0].RequestID)
+		require.ErrorIs(t, (*auditor)[0].Err, ErrTodoNotFound)
+	})
+	t.Run("Остановка ждёт выполняющиеся команды и отклоняет новые", func(t *testing.T) {
+		drain := NewCommandDrain()
+		started, release := make(chan struct{}), make(chan struct{})
+		handler := NewCommandPipeline(command.NewCommandHandler(nil), DrainCommands(drain),
+			func(next command.CommandHandler) command.CommandHandler {
+				return CommandHandlerFunc(func(cmd command.Command) (interface{}, error) {
+					close(started)
+					<-release
+					return next.ExecuteCommand(cmd)
+				})
+			})
+		running := &stubCommand{}
+		finished := make(chan error)
+		go func() {
+			_, err := handler.ExecuteCommand(running)
+			finished <- err
+		}()
+		<-started
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		require.ErrorIs(t, drain.Close(ctx), context.DeadlineExceeded)
+		_, err := handler.ExecuteCommand(&stubCommand{})
+		require.ErrorIs(t, err, ErrShuttingDown)
+
+		close(release)
+		require.NoError(t, drain.Close(context.Background()))
+		require.NoError(t, <-finished)
+		require.Equal(t, 1, running.runs)
+	})
+}
+
+func TestCheckCommandNames(t *testing.T) {
+	commands := []command.Command{
+		&CreateTodoCommand{}, &FindTodoCommand{}, &FindTodosCommand{}, &DeleteTodoCommand{}, &UpdateTodoStatusCommand{},
+		&UpdateTodoCommand{}, &ImportTodosCommand{}, &RescheduleTodosCommand{}, &MoveTodoCommand{}, &CreateTodoListCommand{},
+		&FindTodoListCommand{}, &FindTodoListsCommand{}, &RenameTodoListCommand{}, &ArchiveTodoListCommand{},
+		&DeleteTodoListCommand{},
+	}
+	for _, cmd := range commands {
+		require.NoError(t, CheckCommandNames(CommandName(cmd)))
+	}
+	require.EqualError(t, CheckCommandNames("FindTodosCommand", "FindTodoCommmand"), `unknown command "FindTodoCommmand"`)
+}
@@ -0,0 +1,509 @@
+package todo
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kas2000/logger"
+	"github.com/kas2000/service-todo/calendar"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+const (
+	// ConsistencyTokenHeader carries the change log position of a write. A read sending it back waits until the
+	// read model has caught up with that write.
+	ConsistencyTokenHeader = "X-Consistency-Token"
+
+	viewCollection = "todo_views"
+	viewPositionID = "todo_views"
+)
+
+// legacyViewIndexes are the listing indexes from before lists, of no use to listings within one.
+var legacyViewIndexes = []string{"status_1_active_date_1", "status_1_created_at_-1"}
+
+type consistencyRecorderKey struct{}
+type consistencyTokenKey struct{}
+
+type consistencyRecorder struct {
+	mu  sync.Mutex
+	seq int64
+}
+
+// ContextWithConsistencyRecorder returns a context in which the writes of the change log remember their position,
+// see RecordedConsistencyToken.
+func ContextWithConsistencyRecorder(ctx context.Context) context.Context {
+	return context.WithValue(ctx, consistencyRecorderKey{}, &consistencyRecorder{})
+}
+
+// RecordedConsistencyToken returns the position of the last change written with ctx, 0 if there is none.
+func RecordedConsistencyToken(ctx context.Context) int64 {
+	recorder, ok := ctx.Value(consistencyRecorderKey{}).(*consistencyRecorder)
+	if !ok {
+		return 0
+	}
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	return recorder.seq
+}
+
+func recordConsistencyToken(ctx context.Context, seq int64) {
+	if recorder, ok := ctx.Value(consistencyRecorderKey{}).(*consistencyRecorder); ok {
+		recorder.mu.Lock()
+		if seq > recorder.seq {
+			recorder.seq = seq
+		}
+		recorder.mu.Unlock()
+	}
+}
+
+// ContextWithConsistencyToken makes reads with the returned context wait for the read model to reach token.
+func ContextWithConsistencyToken(ctx context.Context, token int64) context.Context {
+	return context.WithValue(ctx, consistencyTokenKey{}, token)
+}
+
+func ConsistencyTokenFromContext(ctx context.Context) int64 {
+	token, _ := ctx.Value(consistencyTokenKey{}).(int64)
+	return token
+}
+
+// ParseConsistencyToken reads the value of ConsistencyTokenHeader; an empty value is no token.
+func ParseConsistencyToken(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	token, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || token < 0 {
+		return 0, NewValidationError(ErrValidationFailed, FieldError{Field: ConsistencyTokenHeader, Rule: "number"})
+	}
+	return token, nil
+}
+
+// TodoView is a task of the read model, with the fields of listings computed when the task changes.
+// Deleted tasks are kept as tombstones so that an older change replayed later can't bring them back.
+type TodoView struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	Seq        int64              `bson:"seq"`
+	Deleted    bool               `bson:"deleted,omitempty"`
+	ListID     primitive.ObjectID `bson:"list_id,omitempty"`
+	Title      string             `bson:"title,omitempty"`
+	Status     string             `bson:"status,omitempty"`
+	ActiveAt   time.Time          `bson:"active_at,omitempty"`
+	ActiveDate string             `bson:"active_date,omitempty"`
+	// DayType is recomputed for every view when the calendar changes, see TodoViews.UpdateDayTypes.
+	DayType   calendar.DayType `bson:"day_type,omitempty"`
+	CreatedAt time.Time        `bson:"created_at,omitempty"`
+	UpdatedAt *time.Time       `bson:"updated_at,omitempty"`
+}
+
+func NewTodoView(todo *Todo, seq int64, cal BusinessCalendar) *TodoView {
+	return &TodoView{
+		ID:         todo.ID,
+		Seq:        seq,
+		ListID:     listOf(todo),
+		Title:      todo.Title,
+		Status:     todo.Status,
+		ActiveAt:   todo.ActiveAt,
+		ActiveDate: ToDateString(todo.ActiveAt),
+		DayType:    cal.DayType(todo.ActiveAt),
+		CreatedAt:  todo.CreatedAt,
+		UpdatedAt:  todo.UpdatedAt,
+	}
+}
+
+func (view *TodoView) TodoDTO() *TodoDTO {
+	return &TodoDTO{
+		ID:        view.ID.Hex(),
+		ListID:    view.ListID.Hex(),
+		Title:     view.Title,
+		Status:    view.Status,
+		ActiveAt:  view.ActiveDate,
+		DayType:   view.DayType,
+		CreatedAt: view.CreatedAt,
+		UpdatedAt: view.UpdatedAt,
+	}
+}
+
+// viewColumns maps API field names to view keys. Views keep the date of a task as the API shows it, and its day type.
+var viewColumns = map[string]string{
+	"id":        "_id",
+	"listId":    "list_id",
+	"title":     "title",
+	"status":    "status",
+	"activeAt":  "active_date",
+	"dayType":   "day_type",
+	"createdAt": "created_at",
+	"updatedAt": "updated_at",
+}
+
+// viewProjection reads the view keys of fields and those every view needs: its id, and its list, which is checked
+// against the list of the request.
+func viewProjection(fields []string) bson.D {
+	projection := bson.D{{Key: "_id", Value: 1}, {Key: "list_id", Value: 1}}
+	for _, field := range fields {
+		column := viewColumns[field]
+		if slices.ContainsFunc(projection, func(e bson.E) bool { return e.Key == column }) {
+			continue
+		}
+		projection = append(projection, bson.E{Key: column, Value: 1})
+	}
+	return projection
+}
+
+// TodoViews is the read model of tasks. Every change is applied only over views of older changes.
+type TodoViews interface {
+	Apply(ctx context.Context, change *TodoChange) error
+	FindByID(ctx context.Context, id primitive.ObjectID) (*TodoView, error)
+	FindAll(ctx context.Context, pointers TodoPointers) ([]*TodoView, error)
+	// Tombstone marks every view older than seq as deleted.
+	Tombstone(ctx context.Context, seq int64) error
+	// Position is the change log position the read model has been brought to, 0 before it is first built.
+	Position(ctx context.Context) (int64, error)
+	// EnsureIndexes creates the listing indexes and drops the legacy ones.
+	EnsureIndexes(ctx context.Context) error
+	// UpdateDayTypes recomputes the day type of every view if they were computed with another version of the
+	// calendar.
+	UpdateDayTypes(ctx context.Context) error
+	SetPosition(ctx context.Context, seq int64) error
+}
+
+type todoViews struct {
+	collection *mongo.Collection
+	counters   *mongo.Collection
+	calendar   BusinessCalendar
+}
+
+// NewTodoViews keeps the read model in a collection created by Migrate, with day types by cal.
+func NewTodoViews(db *mongo.Database, cal BusinessCalendar) TodoViews {
+	return &todoViews{collection: db.Collection(viewCollection), counters: db.Collection("counters"), calendar: cal}
+}
+
+// EnsureIndexes creates the indexes of the listings, which filter by list, status and date and are ordered by creation
+// by default, and drops legacyViewIndexes.
+func (views *todoViews) EnsureIndexes(ctx context.Context) error {
+	indexes := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "list_id", Value: 1}, {Key: "status", Value: 1}, {Key: "active_date", Value: 1}}},
+		{Keys: bson.D{{Key: "list_id", Value: 1}, {Key: "status", Value: 1}, {Key: "created_at", Value: -1}}},
+	}
+	if _, err := views.collection.Indexes().CreateMany(ctx, indexes); err != nil {
+		return err
+	}
+	return dropIndexes(ctx, views.collection, legacyViewIndexes...)
+}
+
+func (views *todoViews) Apply(ctx context.Context, change *TodoChange) error {
+	view := &TodoView{ID: change.TodoID, Seq: change.Seq, Deleted: true}
+	if change.Type != ChangeDeleted && change.Todo != nil {
+		view = NewTodoView(change.Todo, change.Seq, views.calendar)
+	}
+	filter := bson.D{{Key: "_id", Value: view.ID}, {Key: "seq", Value: bson.D{{Key: "$lt", Value: view.Seq}}}}
+	_, err := views.collection.ReplaceOne(ctx, filter, view, options.Replace().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// The view already holds the same or a newer change.
+		return nil
+	}
+	return err
+}
+
+func (views *todoViews) FindByID(ctx context.Context, id primitive.ObjectID) (*TodoView, error) {
+	var view TodoView
+	err := views.collection.FindOne(ctx, bson.D{{Key: "_id", Value: id}, {Key: "deleted", Value: bson.D{{Key: "$ne", Value: true}}}}).Decode(&view)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrTodoNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &view, nil
+}
+
+func (views *todoViews) FindAll(ctx context.Context, pointers TodoPointers) ([]*TodoView, error) {
+	query, opts, err := findQuery(pointers)
+	if err != nil {
+		return nil, err
+	}
+	query = append(query, bson.E{Key: "deleted", Value: bson.D{{Key: "$ne", Value: true}}})
+	if len(pointers.Fields) > 0 {
+		opts.SetProjection(viewProjection(pointers.Fields))
+	}
+	cursor, err := views.collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*TodoView, 0, cursor.RemainingBatchLength())
+	return result, cursor.All(ctx, &result)
+}
+
+func (views *todoViews) Tombstone(ctx context.Context, seq int64) error {
+	filter := bson.D{{Key: "seq", Value: bson.D{{Key: "$lt", Value: seq}}}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "deleted", Value: true}, {Key: "seq", Value: seq}}}}
+	_, err := views.collection.UpdateMany(ctx, filter, update)
+	return err
+}
+
+// UpdateDayTypes sets the day types by date, of which there are far fewer than views, and keeps the version of the
+// calendar next to the position of the read model.
+func (views *todoViews) UpdateDayTypes(ctx context.Context) error {
+	version := views.calendar.Version()
+	var position struct {
+		Calendar string `bson:"calendar"`
+	}
+	err := views.counters.FindOne(ctx, bson.D{{Key: "_id", Value: viewPositionID}}).Decode(&position)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+	if position.Calendar == version {
+		return nil
+	}
+	values, err := views.collection.Distinct(ctx, "active_date", bson.D{{Key: "deleted", Value: bson.D{{Key: "$ne", Value: true}}}})
+	if err != nil {
+		return err
+	}
+	byType := make(map[calendar.DayType][]string)
+	for _, value := range values {
+		date, _ := value.(string)
+		activeAt, err := time.Parse(dateLayout, date)
+		if err != nil {
+			continue
+		}
+		dayType := views.calendar.DayType(activeAt)
+		byType[dayType] = append(byType[dayType], date)
+	}
+	for dayType, dates := range byType {
+		filter := bson.D{{Key: "active_date", Value: bson.D{{Key: "$in", Value: dates}}}}
+		update := bson.D{{Key: "$set", Value: bson.D{{Key: "day_type", Value: dayType}}}}
+		if _, err := views.collection.UpdateMany(ctx, filter, update); err != nil {
+			return err
+		}
+	}
+	_, err = views.counters.UpdateOne(ctx,
+		bson.D{{Key: "_id", Value: viewPositionID}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "calendar", Value: version}}}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+func (views *todoViews) Position(ctx context.Context) (int64, error) {
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	err := views.counters.FindOne(ctx, bson.D{{Key: "_id", Value: viewPositionID}}).Decode(&counter)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, nil
+	}
+	return counter.Seq, err
+}
+
+func (views *todoViews) SetPosition(ctx context.Context, seq int64) error {
+	_, err := views.counters.UpdateOne(ctx,
+		bson.D{{Key: "_id", Value: viewPositionID}},
+		bson.D{{Key: "$max", Value: bson.D{{Key: "seq", Value: seq}}}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// RebuildViews writes every task of the write model to the read model and returns the change log position the
+// read model is then at. Changes after that position are applied by the projector as usual.
+func RebuildViews(ctx context.Context, changes ChangeLog, todos TodoRepository, views TodoViews) (int64, error) {
+	// Tasks are read after the position, so they include every change up to it.
+	seq, err := changes.Latest(ctx)
+	if err != nil {
+		return 0, err
+	}
+	err = eachTodo(ctx, todos, func(todos []*Todo) error {
+		for _, todo := range todos {
+			if err := views.Apply(ctx, &TodoChange{Seq: seq, Type: ChangeUpdated, TodoID: todo.ID, Todo: todo}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	// Every task now has a view at seq or later, so the views still before it are of deleted tasks.
+	if err := views.Tombstone(ctx, seq); err != nil {
+		return 0, err
+	}
+	// Views already at seq keep the day types they were written with.
+	if err := views.UpdateDayTypes(ctx); err != nil {
+		return 0, err
+	}
+	return seq, views.SetPosition(ctx, seq)
+}
+
+// TodoProjector keeps the read model up to date by tailing the change log.
+type TodoProjector struct {
+	changes ChangeLog
+	views   TodoViews
+	todos   TodoRepository
+	log     logger.Logger
+
+	mu       sync.Mutex
+	position int64
+	// advanced is closed and replaced whenever position moves.
+	advanced chan struct{}
+}
+
+func NewTodoProjector(changes ChangeLog, views TodoViews, todos TodoRepository, log logger.Logger) *TodoProjector {
+	return &TodoProjector{changes: changes, views: views, todos: todos, log: log, advanced: make(chan struct{})}
+}
+
+// Run builds the read model if needed and then applies changes until ctx is done, retrying after failures.
+func (projector *TodoProjector) Run(ctx context.Context) {
+	for ctx.Err() == nil {
+		if err := projector.project(ctx); err != nil && ctx.Err() == nil {
+			projector.log.Warn("couldn't project todo changes", zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(changeLogRetryWait):
+		}
+	}
+}
+
+// Build loads the position of the read model, building it from the tasks if it never was, and returns it. The day
+// types of a built read model are updated if the calendar changed since.
+func (projector *TodoProjector) Build(ctx context.Context) (int64, error) {
+	position, err := projector.views.Position(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if position == 0 {
+		if position, err = RebuildViews(ctx, projector.changes, projector.todos, projector.views); err != nil {
+			return 0, err
+		}
+		projector.log.Info("todo read model built", zap.Int64("position", position))
+	} else if err := projector.views.UpdateDayTypes(ctx); err != nil {
+		return 0, err
+	}
+	projector.advance(position)
+	return position, nil
+}
+
+func (projector *TodoProjector) project(ctx context.Context) error {
+	position, err := projector.Build(ctx)
+	if err != nil {
+		return err
+	}
+	err = projector.changes.Tail(ctx, ChangeFilter{After: position, StopAtGap: true}, func(change *TodoChange) error {
+		if err := projector.views.Apply(ctx, change); err != nil {
+			return err
+		}
+		if err := projector.views.SetPosition(ctx, change.Seq); err != nil {
+			return err
+		}
+		projector.advance(change.Seq)
+		return nil
+	})
+	if !errors.Is(err, ErrChangeLogGap) {
+		return err
+	}
+	// Every write reaches the tasks before it takes its seq, so the tasks hold the lost change.
+	projector.log.Warn("rebuilding the todo read model", zap.Error(err))
+	if position, err = RebuildViews(ctx, projector.changes, projector.todos, projector.views); err != nil {
+		return err
+	}
+	projector.advance(position)
+	return nil
+}
+
+func (projector *TodoProjector) advance(seq int64) {
+	projector.mu.Lock()
+	defer projector.mu.Unlock()
+	if seq > projector.position {
+		projector.position = seq
+		close(projector.advanced)
+		projector.advanced = make(chan struct{})
+	}
+}
+
+// Position returns the change log position this instance has applied to the read model.
+func (projector *TodoProjector) Position() int64 {
+	projector.mu.Lock()
+	defer projector.mu.Unlock()
+	return projector.position
+}
+
+// Await blocks until the read model reaches token. It fails with ErrReadModelBehind when ctx is done first.
+func (projector *TodoProjector) Await(ctx context.Context, token int64) error {
+	for {
+		projector.mu.Lock()
+		position, advanced := projector.position, projector.advanced
+		projector.mu.Unlock()
+		if position >= token {
+			return nil
+		}
+		select {
+		case <-advanced:
+		case <-ctx.Done():
+			return ErrReadModelBehind
+		}
+	}
+}
+
+// readModelService answers queries from the read model and passes writes on.
+type readModelService struct {
+	Service
+	views     TodoViews
+	projector *TodoProjector
+	wait      time.Duration
+}
+
+// NewReadModelService serves FindTodo and FindTodos from views, which projector keeps up to date. A read with a
+// consistency token waits up to wait for the read model to catch up.
+func NewReadModelService(next Service, views TodoViews, projector *TodoProjector, wait time.Duration) Service {
+	return &readModelService{Service: next, views: views, projector: projector, wait: wait}
+}
+
+func (service *readModelService) await(ctx context.Context) error {
+	token := ConsistencyTokenFromContext(ctx)
+	if token == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, service.wait)
+	defer cancel()
+	return service.projector.Await(ctx, token)
+}
+
+func (service *readModelService) FindTodo(ctx context.Context, id primitive.ObjectID) (*TodoDTO, error) {
+	if err := service.await(ctx); err != nil {
+		return nil, err
+	}
+	view, err := service.views.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if view.ListID != ListFromContext(ctx) {
+		return nil, ErrTodoNotFound
+	}
+	return view.TodoDTO(), nil
+}
+
+func (service *readModelService) FindTodos(ctx context.Context, pointers TodoPointers) ([]*TodoDTO, error) {
+	if err := service.await(ctx); err != nil {
+		return nil, err
+	}
+	pointers, err := statusFilter(ctx, pointers)
+	if err != nil {
+		return nil, err
+	}
+	views, err := service.views.FindAll(ctx, inList(ctx, pointers))
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*TodoDTO, 0, len(views))
+	for _, view := range views {
+		result = append(result, view.TodoDTO())
+	}
+	return result, nil
+}
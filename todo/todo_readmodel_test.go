@@ -0,0 +1,459 @@
+package todo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	command "github.com/kas2000/commandlib"
+	"github.com/kas2000/service-todo/calendar"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+// memoryTodoViews filters by list, status and due date only, which is all the read service sets by itself.
+type memoryTodoViews struct {
+	mu       sync.Mutex
+	views    map[primitive.ObjectID]*TodoView
+	position int64
+	calendar BusinessCalendar
+	version  string
+}
+
+func newMemoryTodoViews() *memoryTodoViews {
+	return &memoryTodoViews{views: make(map[primitive.ObjectID]*TodoView), calendar: calendar.Default()}
+}
+
+func (views *memoryTodoViews) Apply(ctx context.Context, change *TodoChange) error {
+	views.mu.Lock()
+	defer views.mu.Unlock()
+	if existing, found := views.views[change.TodoID]; found && existing.Seq >= change.Seq {
+		return nil
+	}
+	view := &TodoView{ID: change.TodoID, Seq: change.Seq, Deleted: true}
+	if change.Type != ChangeDeleted && change.Todo != nil {
+		view = NewTodoView(change.Todo, change.Seq, views.calendar)
+	}
+	views.views[change.TodoID] = view
+	return nil
+}
+
+func (views *memoryTodoViews) FindByID(ctx context.Context, id primitive.ObjectID) (*TodoView, error) {
+	views.mu.Lock()
+	defer views.mu.Unlock()
+	view, found := views.views[id]
+	if !found || view.Deleted {
+		return nil, ErrTodoNotFound
+	}
+	return view, nil
+}
+
+func (views *memoryTodoViews) FindAll(ctx context.Context, pointers TodoPointers) ([]*TodoView, error) {
+	views.mu.Lock()
+	defer views.mu.Unlock()
+	result := make([]*TodoView, 0, len(views.views))
+	for _, view := range views.views {
+		if view.Deleted || (pointers.ListID != nil && view.ListID != *pointers.ListID) ||
+			(pointers.Status != nil && view.Status != *pointers.Status) ||
+			(pointers.Statuses != nil && !slices.Contains(pointers.Statuses, view.Status)) ||
+			(pointers.DueBy != nil && view.ActiveAt.After(*pointers.DueBy)) {
+			continue
+		}
+		result = append(result, view)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID.Hex() < result[j].ID.Hex() })
+	return result, nil
+}
+
+func (views *memoryTodoViews) Tombstone(ctx context.Context, seq int64) error {
+	views.mu.Lock()
+	defer views.mu.Unlock()
+	for id, view := range views.views {
+		if view.Seq < seq {
+			views.views[id] = &TodoView{ID: id, Seq: seq, Deleted: true}
+		}
+	}
+	return nil
+}
+
+func (views *memoryTodoViews) Position(ctx context.Context) (int64, error) {
+	views.mu.Lock()
+	defer views.mu.Unlock()
+	return views.position, nil
+}
+
+func (views *memoryTodoViews) EnsureIndexes(ctx context.Context) error {
+	return nil
+}
+
+func (views *memoryTodoViews) UpdateDayTypes(ctx context.Context) error {
+	views.mu.Lock()
+	defer views.mu.Unlock()
+	if views.version == views.calendar.Version() {
+		return nil
+	}
+	for id, view := range views.views {
+		if !view.Deleted {
+			updated := *view
+			updated.DayType = views.calendar.DayType(view.ActiveAt)
+			views.views[id] = &updated
+		}
+	}
+	views.version = views.calendar.Version()
+	return nil
+}
+
+func (views *memoryTodoViews) SetPosition(ctx context.Context, seq int64) error {
+	views.mu.Lock()
+	defer views.mu.Unlock()
+	if seq > views.position {
+		views.position = seq
+	}
+	return nil
+}
+
+func TestReadModel(t *testing.T) {
+	ctx := context.Background()
+	saturday := time.Date(2023, 8, 5, 0, 0, 0, 0, time.UTC)
+	monday := time.Date(2023, 8, 7, 0, 0, 0, 0, time.UTC)
+
+	t.Run("Представление не откатывается устаревшим изменением", func(t *testing.T) {
+		views := newMemoryTodoViews()
+		id := primitive.NewObjectID()
+		todo := &Todo{ID: id, Title: "Купить книгу", Status: StatusTodo, ActiveAt: saturday}
+		done := *todo
+		done.Status = StatusDone
+
+		require.NoError(t, views.Apply(ctx, &TodoChange{Seq: 1, Type: ChangeCreated, TodoID: id, Todo: todo}))
+		require.NoError(t, views.Apply(ctx, &TodoChange{Seq: 3, Type: ChangeDone, TodoID: id, Todo: &done}))
+		require.NoError(t, views.Apply(ctx, &TodoChange{Seq: 2, Type: ChangeUpdated, TodoID: id, Todo: todo}))
+		view, err := views.FindByID(ctx, id)
+		require.NoError(t, err)
+		require.Equal(t, StatusDone, view.Status)
+		require.Equal(t, "Купить книгу", view.Title)
+		require.Equal(t, "2023-08-05", view.ActiveDate)
+
+		require.NoError(t, views.Apply(ctx, &TodoChange{Seq: 4, Type: ChangeDeleted, TodoID: id}))
+		require.NoError(t, views.Apply(ctx, &TodoChange{Seq: 1, Type: ChangeCreated, TodoID: id, Todo: todo}))
+		_, err = views.FindByID(ctx, id)
+		require.ErrorIs(t, err, ErrTodoNotFound)
+	})
+
+	t.Run("Проектор строит модель из задач и догоняет журнал изменений", func(t *testing.T) {
+		changes := newTestChangeLog()
+		existing := &Todo{ID: primitive.NewObjectID(), Title: "Позвонить маме", Status: StatusTodo, ActiveAt: monday}
+		todos := newMemoryTodoRepo(existing)
+		views := newMemoryTodoViews()
+		projector := NewTodoProjector(changes, views, todos, zap.NewNop())
+
+		position, err := projector.Build(ctx)
+		require.NoError(t, err)
+		require.Equal(t, int64(3), position)
+		require.Equal(t, int64(3), projector.Position())
+
+		renamed := *existing
+		renamed.Title = "Позвонить папе"
+		changes.Append(ctx, &TodoChange{Type: ChangeUpdated, TodoID: existing.ID, Status: StatusTodo, Todo: &renamed})
+		changes.Append(ctx, &TodoChange{Type: ChangeDone, TodoID: existing.ID, Status: StatusDone, Todo: &Todo{ID: existing.ID, Title: "Позвонить папе", Status: StatusDone, ActiveAt: monday}})
+
+		runCtx, cancel := context.WithCancel(ctx)
+		stopped := make(chan struct{})
+		go func() {
+			projector.Run(runCtx)
+			close(stopped)
+		}()
+		awaitCtx, cancelAwait := context.WithTimeout(ctx, 5*time.Second)
+		defer cancelAwait()
+		require.NoError(t, projector.Await(awaitCtx, 5))
+		cancel()
+		<-stopped
+
+		service := NewReadModelService(NewService(todos, newMemoryTodoListRepo(), zap.NewNop()), views, projector, 50*time.Millisecond)
+		status := StatusDone
+		result, err := service.FindTodos(ContextWithConsistencyToken(ctx, 5), TodoPointers{Status: &status})
+		require.NoError(t, err)
+		require.Len(t, result, 1)
+		require.Equal(t, "Позвонить папе", result[0].Title)
+		require.Equal(t, "2023-08-07", result[0].ActiveAt)
+
+		_, err = service.FindTodos(ContextWithConsistencyToken(ctx, 6), TodoPointers{Status: &status})
+		require.ErrorIs(t, err, ErrReadModelBehind)
+	})
+
+	t.Run("Запись возвращает токен согласованности, чтение его проверяет", func(t *testing.T) {
+		changes := &memoryChangeLog{}
+		todos := NewChangeLoggingRepo(newMemoryTodoRepo(), changes, zap.NewNop())
+		views := newMemoryTodoViews()
+		projector := NewTodoProjector(changes, views, todos, zap.NewNop())
+		service := NewReadModelService(NewService(todos, newMemoryTodoListRepo(), zap.NewNop()), views, projector, 50*time.Millisecond)
+		todoHttp := NewTodoHttp(zap.NewNop(), command.NewCommandHandler(service), validator.New(), "todo-service").WithAPIVersion(APIVersion2)
+
+		body := `{"title":"Купить книгу","activeAt":"2023-08-05"}`
+		resp := todoHttp.CreateTodo()(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v2/todo-list/tasks", bytes.NewBufferString(body)))
+		require.Equal(t, http.StatusCreated, resp.StatusCode())
+		require.Equal(t, strconv.Itoa(len(changes.changes)), resp.Headers()[ConsistencyTokenHeader])
+
+		testCases := []struct {
+			title      string
+			token      string
+			wantStatus int
+		}{
+			{title: "Без токена", token: "", wantStatus: http.StatusOK},
+			{title: "Недостигнутый токен", token: "1", wantStatus: http.StatusServiceUnavailable},
+			{title: "Некорректный токен", token: "abc", wantStatus: http.StatusBadRequest},
+		}
+		for _, tc := range testCases {
+			t.Run(tc.title, func(t *testing.T) {
+				req := httptest.NewRequest("GET", "/api/v2/todo-list/tasks", nil)
+				if tc.token != "" {
+					req.Header.Set(ConsistencyTokenHeader, tc.token)
+				}
+				require.Equal(t, tc.wantStatus, todoHttp.FindTodos()(httptest.NewRecorder(), req).StatusCode())
+			})
+		}
+	})
+
+	t.Run("Тип дня пересчитывается при смене календаря", func(t *testing.T) {
+		nauryz := time.Date(2026, 3, 24, 0, 0, 0, 0, time.UTC)
+		todo := &Todo{ID: primitive.NewObjectID(), Title: "Купить книгу", Status: StatusTodo, ActiveAt: nauryz}
+		changes := &memoryChangeLog{}
+		require.NoError(t, changes.Append(ctx, &TodoChange{Type: ChangeCreated, TodoID: todo.ID, Status: StatusTodo, Todo: todo}))
+		views := newMemoryTodoViews()
+		projector := NewTodoProjector(changes, views, newMemoryTodoRepo(todo), zap.NewNop())
+		position, err := projector.Build(ctx)
+		require.NoError(t, err)
+		require.Equal(t, int64(1), position, "модель построена на позиции журнала")
+		view, err := views.FindByID(ctx, todo.ID)
+		require.NoError(t, err)
+		require.Equal(t, calendar.Workday, view.DayType)
+
+		views.calendar, err = calendar.Load(calendar.Regions(), "KZ")
+		require.NoError(t, err)
+		_, err = projector.Build(ctx)
+		require.NoError(t, err)
+		view, err = views.FindByID(ctx, todo.ID)
+		require.NoError(t, err)
+		require.Equal(t, calendar.Holiday, view.DayType)
+	})
+
+	t.Run("Запись не падает, когда изменение не удаётся добавить в журнал", func(t *testing.T) {
+		changes := &flakyChangeLog{failures: changeLogAppendAttempts + 1}
+		todos := NewChangeLoggingRepo(newMemoryTodoRepo(), changes, zap.NewNop())
+
+		created, err := todos.Create(ctx, &Todo{Title: "Купить книгу", Status: StatusTodo, ActiveAt: time.Date(2023, 8, 5, 0, 0, 0, 0, time.UTC)})
+		require.NoError(t, err)
+		_, err = todos.FindByID(ctx, created.ID)
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			appended := changes.appended()
+			return len(appended) == 1 && appended[0].TodoID == created.ID
+		}, 5*time.Second, 10*time.Millisecond, "изменение дописывается в фоне")
+	})
+
+	t.Run("Восстановление задачи попадает в журнал изменений", func(t *testing.T) {
+		changes := &memoryChangeLog{}
+		todos := NewChangeLoggingRepo(newMemoryTodoRepo(), changes, zap.NewNop())
+		todo := &Todo{ID: primitive.NewObjectID(), Title: "Купить книгу", Status: StatusTodo, ActiveAt: time.Date(2023, 8, 5, 0, 0, 0, 0, time.UTC)}
+
+		require.NoError(t, todos.Restore(ctx, todo))
+		todo.Status = StatusDone
+		require.NoError(t, todos.Restore(ctx, todo))
+		require.Len(t, changes.changes, 2)
+		require.Equal(t, ChangeCreated, changes.changes[0].Type)
+		require.Equal(t, ChangeUpdated, changes.changes[1].Type)
+		require.Equal(t, StatusDone, changes.changes[1].Status)
+	})
+}
+
+// flakyChangeLog fails the first failures appends after taking their seq, the way a lost insert does.
+type flakyChangeLog struct {
+	memoryChangeLog
+	mu       sync.Mutex
+	failures int
+}
+
+func (changes *flakyChangeLog) Append(ctx context.Context, change *TodoChange) error {
+	changes.mu.Lock()
+	defer changes.mu.Unlock()
+	if change.Seq == 0 {
+		change.Seq = int64(len(changes.changes) + 1)
+		change.OccurredAt = time.Now().UTC()
+	}
+	if changes.failures > 0 {
+		changes.failures--
+		return errors.New("write concern timeout")
+	}
+	changes.changes = append(changes.changes, change)
+	return nil
+}
+
+func (changes *flakyChangeLog) appended() []*TodoChange {
+	changes.mu.Lock()
+	defer changes.mu.Unlock()
+	return slices.Clone(changes.changes)
+}
+
+// racingChangeLog takes seqs and writes changes in separate steps, as concurrent writers of the capped collection do,
+// and is tailed with the cursor loop of the change log.
+type racingChangeLog struct {
+	mu      sync.Mutex
+	seq     int64
+	changes []*TodoChange
+	gapWait time.Duration
+}
+
+func (changes *racingChangeLog) reserve(change *TodoChange) *TodoChange {
+	changes.mu.Lock()
+	defer changes.mu.Unlock()
+	changes.seq++
+	change.Seq = changes.seq
+	return change
+}
+
+func (changes *racingChangeLog) write(change *TodoChange) {
+	changes.mu.Lock()
+	defer changes.mu.Unlock()
+	changes.changes = append(changes.changes, change)
+}
+
+func (changes *racingChangeLog) Append(ctx context.Context, change *TodoChange) error {
+	changes.write(changes.reserve(change))
+	return nil
+}
+
+func (changes *racingChangeLog) Tail(ctx context.Context, filter ChangeFilter, fn func(change *TodoChange) error) error {
+	return tailChanges(ctx, filter, changes.gapWait, func(ctx context.Context, after int64) (changeCursor, error) {
+		return &racingCursor{changes: changes, after: after}, nil
+	}, fn)
+}
+
+func (changes *racingChangeLog) Latest(ctx context.Context) (int64, error) {
+	changes.mu.Lock()
+	defer changes.mu.Unlock()
+	return changes.seq, nil
+}
+
+// racingCursor returns the changes after its seq in the order they were written and waits briefly for new ones.
+type racingCursor struct {
+	changes *racingChangeLog
+	after   int64
+	read    int
+	current *TodoChange
+	err     error
+}
+
+func (cursor *racingCursor) TryNext(ctx context.Context) bool {
+	if cursor.err = ctx.Err(); cursor.err != nil {
+		return false
+	}
+	cursor.changes.mu.Lock()
+	defer cursor.changes.mu.Unlock()
+	for ; cursor.read < len(cursor.changes.changes); cursor.read++ {
+		if change := cursor.changes.changes[cursor.read]; change.Seq > cursor.after {
+			cursor.current = change
+			cursor.read++
+			return true
+		}
+	}
+	cursor.changes.mu.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	cursor.changes.mu.Lock()
+	return false
+}
+
+func (cursor *racingCursor) Decode(val interface{}) error {
+	*val.(*TodoChange) = *cursor.current
+	return nil
+}
+
+func (cursor *racingCursor) Err() error                      { return cursor.err }
+func (cursor *racingCursor) ID() int64                       { return 1 }
+func (cursor *racingCursor) Close(ctx context.Context) error { return nil }
+
+func TestChangeLogOrder(t *testing.T) {
+	ctx := context.Background()
+	monday := time.Date(2023, 8, 7, 0, 0, 0, 0, time.UTC)
+	first := &Todo{ID: primitive.NewObjectID(), Title: "Купить книгу", Status: StatusTodo, ActiveAt: monday}
+	second := &Todo{ID: primitive.NewObjectID(), Title: "Позвонить маме", Status: StatusTodo, ActiveAt: monday}
+	lost := &Todo{ID: primitive.NewObjectID(), Title: "Полить цветы", Status: StatusTodo, ActiveAt: monday}
+	changes := &racingChangeLog{gapWait: 100 * time.Millisecond}
+	todos := newMemoryTodoRepo(first, second, lost)
+	views := newMemoryTodoViews()
+	views.position = 1
+	projector := NewTodoProjector(changes, views, todos, zap.NewNop())
+	changes.reserve(&TodoChange{})
+
+	runCtx, cancel := context.WithCancel(ctx)
+	stopped := make(chan struct{})
+	go func() {
+		projector.Run(runCtx)
+		close(stopped)
+	}()
+	defer func() {
+		cancel()
+		<-stopped
+	}()
+	await := func(token int64, wait time.Duration) error {
+		ctx, cancel := context.WithTimeout(ctx, wait)
+		defer cancel()
+		return projector.Await(ctx, token)
+	}
+
+	t.Run("Изменение, записанное позже следующего, не пропускается", func(t *testing.T) {
+		early := changes.reserve(&TodoChange{Type: ChangeCreated, TodoID: first.ID, Status: StatusTodo, Todo: first})
+		late := changes.reserve(&TodoChange{Type: ChangeCreated, TodoID: second.ID, Status: StatusTodo, Todo: second})
+		changes.write(late)
+		require.ErrorIs(t, await(late.Seq, 50*time.Millisecond), ErrReadModelBehind, "токен записи ждёт и более ранние изменения")
+		changes.write(early)
+		require.NoError(t, await(late.Seq, 5*time.Second))
+		for _, todo := range []*Todo{first, second} {
+			_, err := views.FindByID(ctx, todo.ID)
+			require.NoError(t, err)
+		}
+	})
+
+	t.Run("Потерянное изменение перестраивает модель из задач", func(t *testing.T) {
+		changes.reserve(&TodoChange{Type: ChangeCreated, TodoID: lost.ID, Status: StatusTodo, Todo: lost})
+		next := &TodoChange{Type: ChangeDeleted, TodoID: second.ID, Status: StatusTodo}
+		require.NoError(t, changes.Append(ctx, next))
+		require.NoError(t, await(next.Seq, 5*time.Second))
+		_, err := views.FindByID(ctx, lost.ID)
+		require.NoError(t, err)
+	})
+}
+
+func TestViewProjection(t *testing.T) {
+	testCases := []struct {
+		title    string
+		fields   []string
+		expected bson.D
+	}{
+		{
+			title:    "Идентификатор и список читаются всегда",
+			fields:   []string{"title"},
+			expected: bson.D{{Key: "_id", Value: 1}, {Key: "list_id", Value: 1}, {Key: "title", Value: 1}},
+		},
+		{
+			title:    "Тип дня хранится в представлении",
+			fields:   []string{"id", "activeAt", "dayType"},
+			expected: bson.D{{Key: "_id", Value: 1}, {Key: "list_id", Value: 1}, {Key: "active_date", Value: 1}, {Key: "day_type", Value: 1}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.title, func(t *testing.T) {
+			require.Equal(t, tc.expected, viewProjection(tc.fields))
+		})
+	}
+}
@@ -6,43 +6,56 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"slices"
 	"time"
 )
 
+// todoColumns maps API field names to document keys. The day type is worked out from the date.
+var todoColumns = map[string]string{
+	"id":        "_id",
+	"listId":    "list_id",
+	"title":     "title",
+	"status":    "status",
+	"activeAt":  "active_at",
+	"dayType":   "active_at",
+	"createdAt": "created_at",
+	"updatedAt": "updated_at",
+}
+
+// findBatchSize is the number of tasks FindEach passes to its function at a time.
+const findBatchSize = 500
+
 type todoRepo struct {
 	collectionName string
 	collection     *mongo.Collection
 }
 
-func NewTodoRepo(collNames map[string]int, db *mongo.Database) (TodoRepository, error) {
-	var collectionName = "todos"
-
-	if _, exists := collNames[collectionName]; !exists {
-		if err := db.CreateCollection(context.TODO(), collectionName); err != nil {
-			return nil, err
-		}
-		index := mongo.IndexModel {
-			Keys: bson.D{
-				{Key: "title", Value: 1},
-				{Key: "active_at", Value: 1},
-			},
-			Options: options.Index().SetUnique(true),
-		}
-		_, err := db.Collection(collectionName).Indexes().CreateOne(context.TODO(), index)
-		if err != nil {
-			return nil, err
-		}
-	}
-
+// NewTodoRepo doesn't touch the database; Migrate creates the collection and its indexes.
+func NewTodoRepo(db *mongo.Database, collectionName string) TodoRepository {
 	return &todoRepo{
 		collectionName: collectionName,
 		collection:     db.Collection(collectionName),
-	}, nil
+	}
+}
+
+// EnsureIndexes creates the unique (list_id, title, active_at) index. It fails while duplicates exist.
+func (repository *todoRepo) EnsureIndexes(ctx context.Context) error {
+	index := mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "list_id", Value: 1},
+			{Key: "title", Value: 1},
+			{Key: "active_at", Value: 1},
+		},
+		Options: options.Index().SetUnique(true),
+	}
+	_, err := repository.collection.Indexes().CreateOne(ctx, index)
+	return err
 }
 
-func (repository *todoRepo) Create(todo *Todo) (*Todo, error) {
+func (repository *todoRepo) Create(ctx context.Context, todo *Todo) (*Todo, error) {
 	todo.CreatedAt = time.Now().UTC()
-	result, err := repository.collection.InsertOne(context.TODO(), todo)
+	result, err := repository.collection.InsertOne(ctx, todo)
 	if err != nil {
 		if mongo.IsDuplicateKeyError(err) {
 			return nil, ErrTodoAlreadyExists
@@ -53,9 +66,9 @@ func (repository *todoRepo) Create(todo *Todo) (*Todo, error) {
 	return todo, nil
 }
 
-func (repository *todoRepo) FindByID(id primitive.ObjectID) (*Todo, error) {
+func (repository *todoRepo) FindByID(ctx context.Context, id primitive.ObjectID) (*Todo, error) {
 	var todo Todo
-	err := repository.collection.FindOne(context.TODO(), bson.D{{"_id", id}}).Decode(&todo)
+	err := repository.collection.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&todo)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return nil, ErrTodoNotFound
@@ -65,14 +78,29 @@ func (repository *todoRepo) FindByID(id primitive.ObjectID) (*Todo, error) {
 	return &todo, nil
 }
 
-func (repository *todoRepo) FindAll(pointers TodoPointers) ([]*Todo, error) {
+// findQuery translates the filters, order, paging and projection of a listing into a find on task documents.
+func findQuery(pointers TodoPointers) (bson.D, *options.FindOptions, error) {
 	query := bson.D{}
+	if pointers.ListID != nil {
+		query = append(query, bson.E{Key: "list_id", Value: *pointers.ListID})
+	}
 	if pointers.Title != nil {
 		query = append(query, bson.E{Key: "title", Value: *pointers.Title})
 	}
-	if pointers.Status != nil {
+	switch {
+	case pointers.Status != nil && pointers.Statuses != nil:
+		// A task has to be in both, so Status only when it is one of Statuses.
+		statuses := []string{}
+		if slices.Contains(pointers.Statuses, *pointers.Status) {
+			statuses = append(statuses, *pointers.Status)
+		}
+		query = append(query, bson.E{Key: "status", Value: bson.D{{Key: "$in", Value: statuses}}})
+	case pointers.Status != nil:
 		query = append(query, bson.E{Key: "status", Value: *pointers.Status})
+	case pointers.Statuses != nil:
+		query = append(query, bson.E{Key: "status", Value: bson.D{{Key: "$in", Value: pointers.Statuses}}})
 	}
+	activeAt := bson.M{}
 	if pointers.ActiveAt != nil {
 		var comparisonOperator string
 		switch *pointers.ActiveAt.ComparisonOperator {
@@ -87,32 +115,104 @@ func (repository *todoRepo) FindAll(pointers TodoPointers) ([]*Todo, error) {
 		case ComparisonOperatorLTE:
 			comparisonOperator = "$lte"
 		default:
-			return nil, ErrUnknownComparisonOperator
+			return nil, nil, ErrUnknownComparisonOperator
+		}
+		activeAt[comparisonOperator] = *pointers.ActiveAt.ActiveAt
+	}
+	if pointers.DueBy != nil {
+		if current, found := activeAt["$lte"]; !found || current.(time.Time).After(*pointers.DueBy) {
+			activeAt["$lte"] = *pointers.DueBy
 		}
-		query = append(query, bson.E{Key: "active_at", Value: bson.M{comparisonOperator: *pointers.ActiveAt.ActiveAt}})
+	}
+	if len(activeAt) > 0 {
+		query = append(query, bson.E{Key: "active_at", Value: activeAt})
 	}
 
 	opts := options.Find()
-	opts.SetSort(bson.D{{"created_at", -1}})
-	cursor, err := repository.collection.Find(context.TODO(), query, opts)
+	sort := bson.D{}
+	for _, field := range pointers.Sort {
+		direction := 1
+		if field.Descending {
+			direction = -1
+		}
+		sort = append(sort, bson.E{Key: todoColumns[field.Field], Value: direction})
+	}
+	if len(sort) == 0 {
+		sort = append(sort, bson.E{Key: "created_at", Value: -1})
+	}
+	// Ties are broken by id so that pages neither repeat nor skip tasks.
+	if !slices.ContainsFunc(sort, func(e bson.E) bool { return e.Key == "_id" }) {
+		sort = append(sort, bson.E{Key: "_id", Value: 1})
+	}
+	opts.SetSort(sort)
+	if pointers.Offset > 0 {
+		opts.SetSkip(pointers.Offset)
+	}
+	if pointers.Limit > 0 {
+		opts.SetLimit(pointers.Limit)
+	}
+	if len(pointers.Fields) > 0 {
+		projection := bson.D{}
+		for _, field := range pointers.Fields {
+			projection = append(projection, bson.E{Key: todoColumns[field], Value: 1})
+		}
+		opts.SetProjection(projection)
+	}
+	return query, opts, nil
+}
+
+func (repository *todoRepo) FindAll(ctx context.Context, pointers TodoPointers) ([]*Todo, error) {
+	query, opts, err := findQuery(pointers)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := repository.collection.Find(ctx, query, opts)
 	if err != nil {
 		return nil, err
 	}
 	todos := make([]*Todo, 0, cursor.RemainingBatchLength())
-	for cursor.Next(context.TODO()) {
+	return todos, cursor.All(ctx, &todos)
+}
+
+func (repository *todoRepo) FindEach(ctx context.Context, pointers TodoPointers, fn func(todos []*Todo) error) error {
+	query, opts, err := findQuery(pointers)
+	if err != nil {
+		return err
+	}
+	cursor, err := repository.collection.Find(ctx, query, opts.SetBatchSize(findBatchSize))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	batch := make([]*Todo, 0, findBatchSize)
+	for cursor.Next(ctx) {
 		var todo Todo
-		err := cursor.Decode(&todo)
-		if err != nil {
-			return nil, err
+		if err := cursor.Decode(&todo); err != nil {
+			return err
 		}
-		todos = append(todos, &todo)
+		batch = append(batch, &todo)
+		if len(batch) == findBatchSize {
+			if err := fn(batch); err != nil {
+				return err
+			}
+			batch = make([]*Todo, 0, findBatchSize)
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
 	}
-	return todos, nil
+	if len(batch) == 0 {
+		return nil
+	}
+	return fn(batch)
 }
 
-func (repository *todoRepo) Update(upd TodoPointers) error {
-	filter := bson.D{{"_id", *upd.ID}}
+func (repository *todoRepo) Update(ctx context.Context, upd TodoPointers) error {
+	filter := bson.D{{Key: "_id", Value: *upd.ID}}
 	values := bson.D{}
+	if upd.ListID != nil {
+		values = append(values, bson.E{Key: "list_id", Value: *upd.ListID})
+	}
 	if upd.Title != nil {
 		values = append(values, bson.E{Key: "title", Value: *upd.Title})
 	}
@@ -129,8 +229,8 @@ func (repository *todoRepo) Update(upd TodoPointers) error {
 
 	updatedAt := time.Now().UTC()
 	values = append(values, bson.E{Key: "updated_at", Value: updatedAt})
-	update := bson.D{{"$set", values}}
-	result := repository.collection.FindOneAndUpdate(context.TODO(), filter, update)
+	update := bson.D{{Key: "$set", Value: values}}
+	result := repository.collection.FindOneAndUpdate(ctx, filter, update)
 	if result.Err() != nil {
 		if result.Err() == mongo.ErrNoDocuments {
 			return ErrTodoNotFound
@@ -143,8 +243,8 @@ func (repository *todoRepo) Update(upd TodoPointers) error {
 	return nil
 }
 
-func (repository *todoRepo) Delete(id primitive.ObjectID) error {
-	result := repository.collection.FindOneAndDelete(context.TODO(), bson.D{{"_id", id}})
+func (repository *todoRepo) Delete(ctx context.Context, id primitive.ObjectID) error {
+	result := repository.collection.FindOneAndDelete(ctx, bson.D{{Key: "_id", Value: id}})
 	if result.Err() != nil {
 		if result.Err() == mongo.ErrNoDocuments {
 			return ErrTodoNotFound
@@ -152,4 +252,40 @@ func (repository *todoRepo) Delete(id primitive.ObjectID) error {
 		return result.Err()
 	}
 	return nil
-}
\ No newline at end of file
+}
+
+func (repository *todoRepo) Restore(ctx context.Context, todo *Todo) error {
+	opts := options.Replace().SetUpsert(true)
+	_, err := repository.collection.ReplaceOne(ctx, bson.D{{Key: "_id", Value: todo.ID}}, todo, opts)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrTodoAlreadyExists
+	}
+	return err
+}
+
+func (repository *todoRepo) Ping(ctx context.Context) error {
+	return repository.collection.Database().Client().Ping(ctx, readpref.Primary())
+}
+
+func (repository *todoRepo) CountByStatus(ctx context.Context) (map[string]int64, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$group", Value: bson.D{{Key: "_id", Value: "$status"}, {Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}}}}},
+	}
+	cursor, err := repository.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+	counts := make(map[string]int64)
+	for cursor.Next(ctx) {
+		var group struct {
+			Status string `bson:"_id"`
+			Count  int64  `bson:"count"`
+		}
+		if err := cursor.Decode(&group); err != nil {
+			return nil, err
+		}
+		counts[group.Status] = group.Count
+	}
+	return counts, cursor.Err()
+}
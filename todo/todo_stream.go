@@ -0,0 +1,266 @@
+package todo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	httpLib "github.com/kas2000/http"
+	"github.com/kas2000/logger"
+	"go.uber.org/zap"
+)
+
+const (
+	streamHeartbeat  = 15 * time.Second
+	streamRetry      = 3 * time.Second
+	streamWriteLimit = 10 * time.Second
+)
+
+// TodoChangeEvent is the payload pushed to stream subscribers. Seq is also the SSE event id.
+type TodoChangeEvent struct {
+	Seq        int64     `json:"seq"`
+	Type       string    `json:"type"`
+	TodoID     string    `json:"todoId"`
+	Todo       *TodoDTO  `json:"todo,omitempty"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+func NewTodoChangeEvent(change *TodoChange) *TodoChangeEvent {
+	event := &TodoChangeEvent{
+		Seq:        change.Seq,
+		Type:       change.Type,
+		TodoID:     change.TodoID.Hex(),
+		OccurredAt: change.OccurredAt,
+	}
+	if change.Todo != nil {
+		event.Todo = NewTodoDTO(change.Todo)
+	}
+	return event
+}
+
+// TodoStream pushes live task changes over Server-Sent Events or, on an upgrade request, a WebSocket.
+type TodoStream struct {
+	log        logger.Logger
+	changes    ChangeLog
+	systemName string
+	upgrader   websocket.Upgrader
+	calendar   BusinessCalendar
+	// closed ends every stream when the service shuts down.
+	closed context.Context
+	close  context.CancelFunc
+}
+
+func NewTodoStream(log logger.Logger, changes ChangeLog, systemName string) *TodoStream {
+	closed, close := context.WithCancel(context.Background())
+	return &TodoStream{
+		log:        log,
+		changes:    changes,
+		systemName: systemName,
+		closed:     closed,
+		close:      close,
+	}
+}
+
+// WithCalendar sets the day type of the tasks in the events by cal.
+func (factory *TodoStream) WithCalendar(cal BusinessCalendar) *TodoStream {
+	factory.calendar = cal
+	return factory
+}
+
+func (factory *TodoStream) event(change *TodoChange) *TodoChangeEvent {
+	event := NewTodoChangeEvent(change)
+	if event.Todo != nil && factory.calendar != nil {
+		withDayType(event.Todo, factory.calendar)
+	}
+	return event
+}
+
+// Close ends the open streams, which otherwise would keep the HTTP server from shutting down. SSE clients reconnect
+// with their Last-Event-ID, to another instance.
+func (factory *TodoStream) Close() {
+	factory.close()
+}
+
+// streamContext is done when the client goes away or the stream is closed.
+func (factory *TodoStream) streamContext(r *http.Request) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(r.Context())
+	stop := context.AfterFunc(factory.closed, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// filter reads the status filter and the resume position from Last-Event-ID or, for clients that cannot
+// set headers, the lastEventId query parameter. Only the changes of the list of the request are streamed; a task
+// moved to another list shows up in the stream of that list.
+func (factory *TodoStream) filter(r *http.Request) (ChangeFilter, error) {
+	list := ListFromContext(r.Context())
+	filter := ChangeFilter{ListID: &list}
+	if r.URL.Query().Has("status") {
+		status := strings.ToUpper(r.URL.Query().Get("status"))
+		switch {
+		case status == StatusActive:
+			filter.Statuses = OpenStatuses
+		case slices.Contains(Statuses, status):
+			filter.Statuses = []string{status}
+		default:
+			return filter, NewValidationError(ErrValidationFailed, FieldError{
+				Field: "status",
+				Rule:  "oneof",
+				Param: strings.Join(StatusFilters, " "),
+			})
+		}
+	}
+
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("lastEventId")
+	}
+	if lastEventID != "" {
+		after, err := strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil || after < 0 {
+			return filter, NewValidationError(ErrValidationFailed, FieldError{
+				Field: "Last-Event-ID",
+				Rule:  "number",
+			})
+		}
+		filter.After = after
+	}
+	return filter, nil
+}
+
+// tail forwards changes to a channel until ctx is done. The error channel receives the tail's result.
+func (factory *TodoStream) tail(ctx context.Context, filter ChangeFilter) (<-chan *TodoChange, <-chan error) {
+	events := make(chan *TodoChange)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- factory.changes.Tail(ctx, filter, func(change *TodoChange) error {
+			select {
+			case events <- change:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+	return events, errs
+}
+
+func (factory *TodoStream) Stream() httpLib.Endpoint {
+	return func(w http.ResponseWriter, r *http.Request) httpLib.Response {
+		filter, err := factory.filter(r)
+		if err != nil {
+			return newProblemResponse(NewProblem(err, factory.systemName, r.URL.Path))
+		}
+		if websocket.IsWebSocketUpgrade(r) {
+			factory.serveWebSocket(w, r, filter)
+			return nil
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			return newProblemResponse(NewProblem(ErrInternal, factory.systemName, r.URL.Path))
+		}
+		factory.serveSSE(w, flusher, r, filter)
+		return nil
+	}
+}
+
+func (factory *TodoStream) serveSSE(w http.ResponseWriter, flusher http.Flusher, r *http.Request, filter ChangeFilter) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	// The write timeout of the server would cut the stream, so every write gets its own deadline, as on WebSockets.
+	controller := http.NewResponseController(w)
+	write := func(format string, args ...any) error {
+		controller.SetWriteDeadline(time.Now().Add(streamWriteLimit))
+		if _, err := fmt.Fprintf(w, format, args...); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	w.WriteHeader(http.StatusOK)
+	write("retry: %d\n\n", streamRetry.Milliseconds())
+
+	ctx, cancel := factory.streamContext(r)
+	defer cancel()
+	events, errs := factory.tail(ctx, filter)
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case change := <-events:
+			data, err := json.Marshal(factory.event(change))
+			if err != nil {
+				factory.log.Warn("couldn't marshal todo change", zap.Error(err))
+				continue
+			}
+			if err := write("id: %d\nevent: %s\ndata: %s\n\n", change.Seq, change.Type, data); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if err := write(": ping\n\n"); err != nil {
+				return
+			}
+		case err := <-errs:
+			if err != nil && ctx.Err() == nil {
+				factory.log.Warn("todo change stream stopped", zap.Error(err))
+			}
+			return
+		}
+	}
+}
+
+func (factory *TodoStream) serveWebSocket(w http.ResponseWriter, r *http.Request, filter ChangeFilter) {
+	conn, err := factory.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already replied with an error status.
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := factory.streamContext(r)
+	defer cancel()
+	// Reading is required to process control frames; any error means the client went away.
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	events, errs := factory.tail(ctx, filter)
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case change := <-events:
+			conn.SetWriteDeadline(time.Now().Add(streamWriteLimit))
+			if err := conn.WriteJSON(factory.event(change)); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteLimit)); err != nil {
+				return
+			}
+		case err := <-errs:
+			if err != nil && ctx.Err() == nil {
+				factory.log.Warn("todo change stream stopped", zap.Error(err))
+			}
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(streamWriteLimit))
+			return
+		}
+	}
+}
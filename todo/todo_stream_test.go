@@ -0,0 +1,279 @@
+package todo
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kas2000/logger"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryChangeLog replays its changes once and stops, standing in for the capped collection.
+type memoryChangeLog struct {
+	changes []*TodoChange
+	filters []ChangeFilter
+}
+
+func (changes *memoryChangeLog) Append(ctx context.Context, change *TodoChange) error {
+	change.Seq = int64(len(changes.changes) + 1)
+	changes.changes = append(changes.changes, change)
+	return nil
+}
+
+func (changes *memoryChangeLog) Tail(ctx context.Context, filter ChangeFilter, fn func(change *TodoChange) error) error {
+	changes.filters = append(changes.filters, filter)
+	for _, change := range changes.changes {
+		if change.Seq <= filter.After || !filter.matches(change) {
+			continue
+		}
+		if err := fn(change); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (changes *memoryChangeLog) Latest(ctx context.Context) (int64, error) {
+	return int64(len(changes.changes)), nil
+}
+
+func newTestChangeLog() *memoryChangeLog {
+	changes := &memoryChangeLog{}
+	id, _ := primitive.ObjectIDFromHex("64da1f106083a1acd4d8f116")
+	todo := &Todo{ID: id, Title: "Купить книгу", Status: StatusTodo, ActiveAt: time.Date(2023, 8, 4, 0, 0, 0, 0, time.UTC)}
+	changes.Append(context.Background(), &TodoChange{Type: ChangeCreated, TodoID: id, Status: StatusTodo, Todo: todo})
+	started := *todo
+	started.Status = StatusInProgress
+	changes.Append(context.Background(), &TodoChange{Type: ChangeUpdated, TodoID: id, Status: StatusInProgress, Todo: &started})
+	done := *todo
+	done.Status = StatusDone
+	changes.Append(context.Background(), &TodoChange{Type: ChangeDone, TodoID: id, Status: StatusDone, Todo: &done})
+	return changes
+}
+
+func TestStreamSSE(t *testing.T) {
+	log, _ := logger.New("debug")
+	changes := newTestChangeLog()
+	todoStream := NewTodoStream(log, changes, "todo-service")
+
+	testCases := []struct {
+		title          string
+		url            string
+		lastEventID    string
+		expectedEvents []string
+	}{
+		{
+			title:          "Все изменения",
+			url:            "/api/todo-list/tasks/stream",
+			expectedEvents: []string{"id: 1\nevent: created\n", "id: 2\nevent: updated\n", "id: 3\nevent: done\n"},
+		},
+		{
+			title:          "Фильтр по статусу",
+			url:            "/api/todo-list/tasks/stream?status=done",
+			expectedEvents: []string{"id: 3\nevent: done\n"},
+		},
+		{
+			title:          "Открытые задачи",
+			url:            "/api/todo-list/tasks/stream?status=active",
+			expectedEvents: []string{"id: 1\nevent: created\n", "id: 2\nevent: updated\n"},
+		},
+		{
+			title:          "Задачи в работе",
+			url:            "/api/todo-list/tasks/stream?status=in_progress",
+			expectedEvents: []string{"id: 2\nevent: updated\n"},
+		},
+		{
+			title:          "Возобновление после Last-Event-ID",
+			url:            "/api/todo-list/tasks/stream",
+			lastEventID:    "1",
+			expectedEvents: []string{"id: 2\nevent: updated\n", "id: 3\nevent: done\n"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.title, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, tc.url, nil)
+			require.NoError(t, err)
+			if tc.lastEventID != "" {
+				req.Header.Set("Last-Event-ID", tc.lastEventID)
+			}
+
+			retData := todoStream.Stream()(resp, req)
+			require.Nil(t, retData)
+			require.Equal(t, "text/event-stream", resp.Header().Get("Content-Type"))
+			body := resp.Body.String()
+			require.Equal(t, len(tc.expectedEvents), strings.Count(body, "data: "))
+			for _, event := range tc.expectedEvents {
+				require.Contains(t, body, event)
+			}
+		})
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "/api/todo-list/tasks/stream?status=unknown", nil)
+	require.NoError(t, err)
+	retData := todoStream.Stream()(httptest.NewRecorder(), req)
+	require.Equal(t, http.StatusBadRequest, retData.StatusCode())
+}
+
+func TestStreamListScoped(t *testing.T) {
+	log, _ := logger.New("debug")
+	changes := newTestChangeLog()
+	list := primitive.NewObjectID()
+	id := primitive.NewObjectID()
+	moved := *changes.changes[0].Todo
+	moved.ListID = list
+	changes.Append(context.Background(), &TodoChange{Type: ChangeUpdated, TodoID: moved.ID, Status: StatusTodo, Todo: &moved})
+	changes.Append(context.Background(), &TodoChange{Type: ChangeCreated, TodoID: id, Status: StatusTodo, Todo: &Todo{ID: id, ListID: list, Title: "Позвонить", Status: StatusTodo}})
+	todoStream := NewTodoStream(log, changes, "todo-service")
+
+	testCases := []struct {
+		title          string
+		list           primitive.ObjectID
+		expectedEvents []string
+	}{
+		{
+			title:          "Список по умолчанию",
+			list:           DefaultListID,
+			expectedEvents: []string{"id: 1\n", "id: 2\n", "id: 3\n"},
+		},
+		{
+			title:          "Другой список",
+			list:           list,
+			expectedEvents: []string{"id: 4\n", "id: 5\n"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.title, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/api/todo-list/lists/"+tc.list.Hex()+"/tasks/stream", nil)
+			require.NoError(t, err)
+			req = req.WithContext(ContextWithList(req.Context(), tc.list))
+
+			require.Nil(t, todoStream.Stream()(resp, req))
+			body := resp.Body.String()
+			require.Equal(t, len(tc.expectedEvents), strings.Count(body, "data: "))
+			for _, event := range tc.expectedEvents {
+				require.Contains(t, body, event)
+			}
+		})
+	}
+}
+
+func TestStreamWebSocket(t *testing.T) {
+	log, _ := logger.New("debug")
+	todoStream := NewTodoStream(log, newTestChangeLog(), "todo-service")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		todoStream.Stream()(w, r)
+	}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"?lastEventId=2", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	var event TodoChangeEvent
+	require.NoError(t, conn.ReadJSON(&event))
+	require.Equal(t, int64(3), event.Seq)
+	require.Equal(t, ChangeDone, event.Type)
+	require.Equal(t, StatusDone, event.Todo.Status)
+	require.Equal(t, "2023-08-04", event.Todo.ActiveAt)
+}
+
+// blockingChangeLog waits for new changes after the replay, like the capped collection, until ctx is done.
+type blockingChangeLog struct {
+	*memoryChangeLog
+}
+
+func (changes blockingChangeLog) Tail(ctx context.Context, filter ChangeFilter, fn func(change *TodoChange) error) error {
+	if err := changes.memoryChangeLog.Tail(ctx, filter, fn); err != nil {
+		return err
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestStreamClose(t *testing.T) {
+	log, _ := logger.New("debug")
+	todoStream := NewTodoStream(log, blockingChangeLog{newTestChangeLog()}, "todo-service")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		todoStream.Stream()(w, r)
+	}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"?lastEventId=3", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	todoStream.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, 3, strings.Count(string(body), "data: "))
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = conn.ReadMessage()
+	require.True(t, websocket.IsCloseError(err, websocket.CloseGoingAway), err)
+}
+
+// laterChangeLog replays its changes, then passes on the changes sent to later until ctx is done.
+type laterChangeLog struct {
+	*memoryChangeLog
+	later chan *TodoChange
+}
+
+func (changes laterChangeLog) Tail(ctx context.Context, filter ChangeFilter, fn func(change *TodoChange) error) error {
+	if err := changes.memoryChangeLog.Tail(ctx, filter, fn); err != nil {
+		return err
+	}
+	for {
+		select {
+		case change := <-changes.later:
+			if err := fn(change); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func TestStreamOutlivesWriteTimeout(t *testing.T) {
+	log, _ := logger.New("debug")
+	changes := laterChangeLog{memoryChangeLog: newTestChangeLog(), later: make(chan *TodoChange)}
+	todoStream := NewTodoStream(log, changes, "todo-service")
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		todoStream.Stream()(w, r)
+	}))
+	server.Config.WriteTimeout = 200 * time.Millisecond
+	server.Start()
+	defer server.Close()
+	defer todoStream.Close()
+
+	resp, err := http.Get(server.URL + "?lastEventId=3")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	time.Sleep(2 * server.Config.WriteTimeout)
+	latest := changes.changes[2]
+	changes.later <- &TodoChange{Seq: 4, Type: ChangeDeleted, TodoID: latest.TodoID, Status: StatusDone}
+
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err, "поток закрыт по таймауту записи сервера")
+		if line == "id: 4\n" {
+			return
+		}
+	}
+}
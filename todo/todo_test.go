@@ -6,6 +6,7 @@ import (
 	"github.com/gorilla/mux"
 	command "github.com/kas2000/commandlib"
 	"github.com/kas2000/logger"
+	"github.com/kas2000/service-todo/calendar"
 	"github.com/stretchr/testify/require"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -31,16 +32,11 @@ func TestCreate(t *testing.T) {
 		}
 	}()
 	mongoDB := mongoClient.Database("regionTaxiDB")
-	collNames, _ := mongoDB.ListCollectionNames(context.TODO(), bson.M{})
-	collectionsNames := make(map[string]int)
-	for _, collName := range collNames {
-		collectionsNames[collName]++
+	if err := Migrate(context.TODO(), mongoDB, "todos"); err != nil {
+		log.Fatal("couldn't migrate database: " + err.Error())
 	}
-	todoRepo, err := NewTodoRepo(collectionsNames, mongoDB)
-	if err != nil {
-		log.Fatal("couldn't initialize maintenance repository: " + err.Error())
-	}
-	service := NewService(todoRepo, log)
+	todoRepo := NewTodoRepo(mongoDB, "todos")
+	service := NewService(todoRepo, NewTodoListRepo(mongoDB), log)
 	todoCh := command.NewCommandHandler(service)
 	todoHttp := NewTodoHttp(log, todoCh, validate, "todo-service")
 
@@ -64,17 +60,17 @@ func TestCreate(t *testing.T) {
 		{
 			title:              "Проверка на дубликаты",
 			body:               `{"title":"Купить книгу","activeAt":"2023-08-04"}`,
-			expectedHTTPStatus: 404,
+			expectedHTTPStatus: 409,
 		},
 		{
 			title:              "Проверка на длину заголовка",
 			body:               `{"title":"Lorem ipsum dolor sit amet consectetur adipisicing elit. Maxime mollitia, molestiae quas vel sint commodi repudiandae consequuntur voluptatum laborum numquam blanditiis harum quisquam eius sed odit fugiat iusto fuga praesentium optio, eaque rerum! Provident similique accusantium nemo autem. Veritatis obcaecati tenetur iure eius earum ut molestias architecto voluptate aliquam nihil, eveniet aliquid culpa officia aut! Impedit sit sunt quaerat, odit, tenetur error, harum nesciunt ipsum debitis quas aliquid. Reprehenderit, quia. Quo neque error repudiandae fuga? Ipsa laudantium molestias eos sapiente officiis modi at sunt excepturi expedita sint? Sed quibusdam recusandae alias error harum maxime adipisci amet laborum. Perspiciatis minima nesciunt dolorem! Officiis iure rerum voluptates a cumque velit quibusdam sed amet tempora. Sit laborum ab, eius fugit doloribus tenetur fugiat, temporibus enim commodi iusto libero magni deleniti quod quam consequuntur! Commodi minima excepturi repudiandae velit hic maxime doloremque. Quaerat provident commodi consectetur veniam similique ad earum omnis ipsum saepe, voluptas, hic voluptates pariatur est explicabo fugiat, dolorum eligendi quam cupiditate excepturi mollitia maiores labore suscipit quas? Nulla, placeat. Voluptatem quaerat non architecto ab laudantium modi minima sunt esse temporibus sint culpa, recusandae aliquam numquam totam ratione voluptas quod exercitationem fuga. Possimus quis earum veniam quasi aliquam eligendi, placeat qui corporis!","activeAt":"2023-08-04"}`,
-			expectedHTTPStatus: 404,
+			expectedHTTPStatus: 400,
 		},
 		{
 			title:              "Проверка на валидность даты",
 			body:               `{"title":"Купить книгу","activeAt":"2023-13-04"}`,
-			expectedHTTPStatus: 404,
+			expectedHTTPStatus: 400,
 		},
 	}
 
@@ -91,9 +87,9 @@ func TestCreate(t *testing.T) {
 	}
 }
 
-func TestDelete(t *testing.T) {
-	log, _ := logger.New("debug")
+func TestCreateV2(t *testing.T) {
 	validate := validator.New()
+	log, _ := logger.New("debug")
 
 	mongoClient, err := mongo.Connect(context.TODO(), options.Client().ApplyURI("mongodb://localhost:27017"))
 	if err != nil {
@@ -105,16 +101,53 @@ func TestDelete(t *testing.T) {
 		}
 	}()
 	mongoDB := mongoClient.Database("regionTaxiDB")
-	collNames, _ := mongoDB.ListCollectionNames(context.TODO(), bson.M{})
-	collectionsNames := make(map[string]int)
-	for _, collName := range collNames {
-		collectionsNames[collName]++
+	if err := Migrate(context.TODO(), mongoDB, "todos"); err != nil {
+		log.Fatal("couldn't migrate database: " + err.Error())
 	}
-	todoRepo, err := NewTodoRepo(collectionsNames, mongoDB)
+	todoRepo := NewTodoRepo(mongoDB, "todos")
+	service := NewService(todoRepo, NewTodoListRepo(mongoDB), log)
+	todoCh := command.NewCommandHandler(service)
+	todoHttp := NewTodoHttp(log, todoCh, validate, "todo-service").WithAPIVersion(APIVersion2)
+
+	reqURL := "/api/v2/todo-list/tasks"
+
+	resp := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodPost, reqURL, strings.NewReader(`{"title":"Купить книгу в январе","activeAt":"2024-01-04"}`))
+	require.NoError(t, err)
+
+	retData := todoHttp.CreateTodo()(resp, req)
+	require.Equal(t, http.StatusCreated, retData.StatusCode())
+
+	created := retData.Response().(*TodoDTO)
+	require.NotEmpty(t, created.ID)
+	require.Equal(t, StatusTodo, created.Status)
+	require.Equal(t, "2024-01-04", created.ActiveAt)
+	require.False(t, created.CreatedAt.IsZero())
+	require.Equal(t, reqURL+"/"+created.ID, retData.GetHeader("Location"))
+
+	id, _ := primitive.ObjectIDFromHex(created.ID)
+	require.NoError(t, todoRepo.Delete(context.TODO(), id))
+}
+
+func TestDelete(t *testing.T) {
+	log, _ := logger.New("debug")
+	validate := validator.New()
+
+	mongoClient, err := mongo.Connect(context.TODO(), options.Client().ApplyURI("mongodb://localhost:27017"))
 	if err != nil {
-		log.Fatal("couldn't initialize maintenance repository: " + err.Error())
+		log.Fatal("couldn't connect to mongodb: " + err.Error())
 	}
-	service := NewService(todoRepo, log)
+	defer func() {
+		if err := mongoClient.Disconnect(context.TODO()); err != nil {
+			log.Fatal(err.Error())
+		}
+	}()
+	mongoDB := mongoClient.Database("regionTaxiDB")
+	if err := Migrate(context.TODO(), mongoDB, "todos"); err != nil {
+		log.Fatal("couldn't migrate database: " + err.Error())
+	}
+	todoRepo := NewTodoRepo(mongoDB, "todos")
+	service := NewService(todoRepo, NewTodoListRepo(mongoDB), log)
 	todoCh := command.NewCommandHandler(service)
 	todoHttp := NewTodoHttp(log, todoCh, validate, "todo-service")
 
@@ -163,16 +196,11 @@ func TestUpdate(t *testing.T) {
 		}
 	}()
 	mongoDB := mongoClient.Database("regionTaxiDB")
-	collNames, _ := mongoDB.ListCollectionNames(context.TODO(), bson.M{})
-	collectionsNames := make(map[string]int)
-	for _, collName := range collNames {
-		collectionsNames[collName]++
-	}
-	todoRepo, err := NewTodoRepo(collectionsNames, mongoDB)
-	if err != nil {
-		log.Fatal("couldn't initialize maintenance repository: " + err.Error())
+	if err := Migrate(context.TODO(), mongoDB, "todos"); err != nil {
+		log.Fatal("couldn't migrate database: " + err.Error())
 	}
-	service := NewService(todoRepo, log)
+	todoRepo := NewTodoRepo(mongoDB, "todos")
+	service := NewService(todoRepo, NewTodoListRepo(mongoDB), log)
 	todoCh := command.NewCommandHandler(service)
 	todoHttp := NewTodoHttp(log, todoCh, validate, "todo-service")
 
@@ -220,7 +248,7 @@ func TestUpdate(t *testing.T) {
 			title:              "Проверка на дубликаты",
 			id:                 "64da1fabd21e112c5bb1c299",
 			body:               `{"title":"Купить книгу - Высоконагруженные приложения","activeAt":"2023-08-05"}`,
-			expectedHTTPStatus: 400,
+			expectedHTTPStatus: 409,
 		},
 	}
 
@@ -238,7 +266,7 @@ func TestUpdate(t *testing.T) {
 
 			if tc.title == "Проверка на корректное обновление" {
 				id, _ := primitive.ObjectIDFromHex("64da1f106083a1acd4d8f116")
-				result, err := service.FindTodo(id)
+				result, err := service.FindTodo(context.TODO(), id)
 				if err != nil {
 					log.Fatal(err.Error())
 				}
@@ -263,16 +291,11 @@ func TestSettingStatusDone(t *testing.T) {
 		}
 	}()
 	mongoDB := mongoClient.Database("regionTaxiDB")
-	collNames, _ := mongoDB.ListCollectionNames(context.TODO(), bson.M{})
-	collectionsNames := make(map[string]int)
-	for _, collName := range collNames {
-		collectionsNames[collName]++
+	if err := Migrate(context.TODO(), mongoDB, "todos"); err != nil {
+		log.Fatal("couldn't migrate database: " + err.Error())
 	}
-	todoRepo, err := NewTodoRepo(collectionsNames, mongoDB)
-	if err != nil {
-		log.Fatal("couldn't initialize maintenance repository: " + err.Error())
-	}
-	service := NewService(todoRepo, log)
+	todoRepo := NewTodoRepo(mongoDB, "todos")
+	service := NewService(todoRepo, NewTodoListRepo(mongoDB), log)
 	todoCh := command.NewCommandHandler(service)
 	todoHttp := NewTodoHttp(log, todoCh, validate, "todo-service")
 
@@ -306,7 +329,7 @@ func TestSettingStatusDone(t *testing.T) {
 
 			if tc.title == "Проверка на корректное обновление статуса" {
 				id, _ := primitive.ObjectIDFromHex("64da1f106083a1acd4d8f116")
-				result, err := todoRepo.FindByID(id)
+				result, err := todoRepo.FindByID(context.TODO(), id)
 				if err != nil {
 					log.Fatal(err.Error())
 				}
@@ -330,16 +353,11 @@ func TestFind(t *testing.T) {
 		}
 	}()
 	mongoDB := mongoClient.Database("regionTaxiDB")
-	collNames, _ := mongoDB.ListCollectionNames(context.TODO(), bson.M{})
-	collectionsNames := make(map[string]int)
-	for _, collName := range collNames {
-		collectionsNames[collName]++
-	}
-	todoRepo, err := NewTodoRepo(collectionsNames, mongoDB)
-	if err != nil {
-		log.Fatal("couldn't initialize maintenance repository: " + err.Error())
+	if err := Migrate(context.TODO(), mongoDB, "todos"); err != nil {
+		log.Fatal("couldn't migrate database: " + err.Error())
 	}
-	service := NewService(todoRepo, log)
+	todoRepo := NewTodoRepo(mongoDB, "todos")
+	service := NewCalendarService(NewService(todoRepo, NewTodoListRepo(mongoDB), log), calendar.Default())
 	todoCh := command.NewCommandHandler(service)
 	todoHttp := NewTodoHttp(log, todoCh, validate, "todo-service")
 
@@ -393,3 +411,94 @@ func TestFind(t *testing.T) {
 		})
 	}
 }
+
+func TestParseSort(t *testing.T) {
+	testCases := []struct {
+		title         string
+		sort          string
+		expectedSort  []SortField
+		expectedError error
+	}{
+		{
+			title:        "Пустая сортировка",
+			sort:         "",
+			expectedSort: nil,
+		},
+		{
+			title: "Сортировка по нескольким полям",
+			sort:  "activeAt,-title",
+			expectedSort: []SortField{
+				{Field: "activeAt"},
+				{Field: "title", Descending: true},
+			},
+		},
+		{
+			title:         "Сортировка по неизвестному полю",
+			sort:          "activeAt,-password",
+			expectedError: ErrInvalidSortField,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.title, func(t *testing.T) {
+			sort, err := ParseSort(tc.sort)
+			if tc.expectedError != nil {
+				require.ErrorIs(t, err, tc.expectedError)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedSort, sort)
+		})
+	}
+}
+
+func TestFindQuery(t *testing.T) {
+	done, todo := StatusDone, StatusTodo
+	testCases := []struct {
+		title          string
+		pointers       TodoPointers
+		expectedStatus interface{}
+		expectedSort   bson.D
+	}{
+		{
+			title:          "Сортировка по умолчанию добирается идентификатором",
+			pointers:       TodoPointers{Status: &done},
+			expectedStatus: StatusDone,
+			expectedSort:   bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: 1}},
+		},
+		{
+			title:          "Статус из списка статусов",
+			pointers:       TodoPointers{Status: &todo, Statuses: OpenStatuses, Sort: []SortField{{Field: "activeAt"}}},
+			expectedStatus: bson.D{{Key: "$in", Value: []string{StatusTodo}}},
+			expectedSort:   bson.D{{Key: "active_at", Value: 1}, {Key: "_id", Value: 1}},
+		},
+		{
+			title:          "Статус не из списка статусов не находит ничего",
+			pointers:       TodoPointers{Status: &done, Statuses: OpenStatuses, Sort: []SortField{{Field: "id", Descending: true}}},
+			expectedStatus: bson.D{{Key: "$in", Value: []string{}}},
+			expectedSort:   bson.D{{Key: "_id", Value: -1}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.title, func(t *testing.T) {
+			query, opts, err := findQuery(tc.pointers)
+			require.NoError(t, err)
+			require.Equal(t, bson.D{{Key: "status", Value: tc.expectedStatus}}, query)
+			require.Equal(t, tc.expectedSort, opts.Sort)
+		})
+	}
+}
+
+func TestParseFields(t *testing.T) {
+	fields, err := ParseFields("id, title,status,title")
+	require.NoError(t, err)
+	require.Equal(t, []string{"id", "title", "status"}, fields)
+
+	_, err = ParseFields("id,_id")
+	require.ErrorIs(t, err, ErrInvalidProjectionField)
+
+	todo := &TodoDTO{ID: "64da1f106083a1acd4d8f116", Title: "Купить книгу", Status: StatusTodo, ActiveAt: "2023-08-04"}
+	require.Equal(t, map[string]interface{}{"title": "Купить книгу"}, todo.Project([]string{"title"}))
+	require.Equal(t, todo, todo.Project(nil))
+}
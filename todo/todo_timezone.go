@@ -0,0 +1,111 @@
+package todo
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	httpLib "github.com/kas2000/http"
+)
+
+// TimeZoneHeader names the IANA time zone of the caller, e.g. Asia/Almaty, in which "today" is reckoned.
+const TimeZoneHeader = "Time-Zone"
+
+type locationKey struct{}
+
+// ContextWithLocation makes the dates of the request, such as today, those of the time zone loc.
+func ContextWithLocation(ctx context.Context, loc *time.Location) context.Context {
+	return context.WithValue(ctx, locationKey{}, loc)
+}
+
+// LocationFromContext returns the time zone of the caller, UTC when it isn't known.
+func LocationFromContext(ctx context.Context) *time.Location {
+	if loc, ok := ctx.Value(locationKey{}).(*time.Location); ok && loc != nil {
+		return loc
+	}
+	return time.UTC
+}
+
+// Today returns the current date in the time zone of ctx, in the form task dates are stored in.
+func Today(ctx context.Context) time.Time {
+	return civilDate(time.Now().In(LocationFromContext(ctx)))
+}
+
+// civilDate returns the calendar date of t, wherever t is, at midnight UTC. Task dates are stored so, which
+// keeps them the same day in every time zone.
+func civilDate(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// ParseTimeZone reads an IANA time zone name; an empty name is no zone.
+func ParseTimeZone(name string) (*time.Location, error) {
+	if name == "" {
+		return nil, nil
+	}
+	loc, err := time.LoadLocation(name)
+	// LoadLocation also takes "Local", the zone of the server rather than of anyone calling it.
+	if err != nil || strings.EqualFold(name, "Local") {
+		return nil, NewValidationError(ErrInvalidTimeZone, FieldError{Field: TimeZoneHeader, Rule: "timezone", Param: name})
+	}
+	return loc, nil
+}
+
+// TimeZones picks the time zone of a request: the one it names, else the one of the profile of the user, else
+// Default.
+type TimeZones struct {
+	// Default is used when the caller names no zone; UTC when nil.
+	Default *time.Location
+	// Profile finds the zone of the user from the Authorization header, nil when there is none.
+	Profile func(authorization string) *time.Location
+}
+
+// Resolve returns the zone for a request with the given Time-Zone and Authorization headers. A zone named in the
+// header must exist.
+func (zones TimeZones) Resolve(header string, authorization string) (*time.Location, error) {
+	loc, err := ParseTimeZone(strings.TrimSpace(header))
+	if err != nil || loc != nil {
+		return loc, err
+	}
+	if zones.Profile != nil {
+		if loc := zones.Profile(authorization); loc != nil {
+			return loc, nil
+		}
+	}
+	if zones.Default != nil {
+		return zones.Default, nil
+	}
+	return time.UTC, nil
+}
+
+// TokenTimeZone reads the zoneinfo claim of the OpenID Connect profile from a bearer token. The token isn't
+// verified here: that is up to the gateway, and the zone only decides which day is today for the caller.
+func TokenTimeZone(authorization string) *time.Location {
+	token := strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+	if token == "" {
+		return nil
+	}
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, claims); err != nil {
+		return nil
+	}
+	name, _ := claims["zoneinfo"].(string)
+	loc, err := ParseTimeZone(name)
+	if err != nil {
+		return nil
+	}
+	return loc
+}
+
+// zoned runs endpoint in the time zone of the request.
+func (factory *TodoHttp) zoned(endpoint httpLib.Endpoint) httpLib.Endpoint {
+	return func(w http.ResponseWriter, r *http.Request) httpLib.Response {
+		loc, err := factory.zones.Resolve(r.Header.Get(TimeZoneHeader), r.Header.Get("Authorization"))
+		if err != nil {
+			return factory.problem(r, err)
+		}
+		return endpoint(w, r.WithContext(ContextWithLocation(r.Context(), loc)))
+	}
+}
@@ -0,0 +1,143 @@
+package todo
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	httpLib "github.com/kas2000/http"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+func TestTimeZones(t *testing.T) {
+	almaty, err := time.LoadLocation("Asia/Almaty")
+	require.NoError(t, err)
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	require.NoError(t, err)
+	token := func(claims jwt.MapClaims) string {
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+		require.NoError(t, err)
+		return "Bearer " + signed
+	}
+	zones := TimeZones{Default: moscow, Profile: TokenTimeZone}
+
+	testCases := []struct {
+		title         string
+		zones         TimeZones
+		header        string
+		authorization string
+		expected      *time.Location
+		expectedErr   error
+	}{
+		{
+			title:         "Заголовок важнее профиля",
+			zones:         zones,
+			header:        "Asia/Almaty",
+			authorization: token(jwt.MapClaims{"zoneinfo": "Europe/Moscow"}),
+			expected:      almaty,
+		},
+		{
+			title:         "Пояс из профиля",
+			zones:         zones,
+			authorization: token(jwt.MapClaims{"zoneinfo": "Asia/Almaty"}),
+			expected:      almaty,
+		},
+		{
+			title:         "Неизвестный пояс в профиле не мешает запросу",
+			zones:         zones,
+			authorization: token(jwt.MapClaims{"zoneinfo": "Asia/Atlantis"}),
+			expected:      moscow,
+		},
+		{
+			title:         "Без заголовка и токена пояс по умолчанию",
+			zones:         zones,
+			authorization: "Bearer not-a-token",
+			expected:      moscow,
+		},
+		{
+			title:    "Без настройки UTC",
+			expected: time.UTC,
+		},
+		{
+			title:       "Неизвестный пояс в заголовке",
+			zones:       zones,
+			header:      "Asia/Atlantis",
+			expectedErr: ErrInvalidTimeZone,
+		},
+		{
+			title:       "Пояс сервера не принимается",
+			zones:       zones,
+			header:      "Local",
+			expectedErr: ErrInvalidTimeZone,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.title, func(t *testing.T) {
+			loc, err := tc.zones.Resolve(tc.header, tc.authorization)
+			if tc.expectedErr != nil {
+				require.ErrorIs(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected.String(), loc.String())
+		})
+	}
+}
+
+func TestActiveTodosInTimeZone(t *testing.T) {
+	// Kiritimati is always a day or two ahead of Pago Pago.
+	ahead, err := time.LoadLocation("Pacific/Kiritimati")
+	require.NoError(t, err)
+	behind, err := time.LoadLocation("Pacific/Pago_Pago")
+	require.NoError(t, err)
+	aheadCtx, behindCtx := ContextWithLocation(context.Background(), ahead), ContextWithLocation(context.Background(), behind)
+
+	today := Today(aheadCtx)
+	require.True(t, Today(behindCtx).Before(today))
+	repository := newMemoryTodoRepo(&Todo{ID: primitive.NewObjectID(), Title: "Купить книгу", Status: StatusTodo, ActiveAt: today})
+	service := NewService(repository, newMemoryTodoListRepo(), zap.NewNop())
+	active := StatusActive
+
+	todos, err := service.FindTodos(aheadCtx, TodoPointers{Status: &active})
+	require.NoError(t, err)
+	require.Len(t, todos, 1, "для того, у кого этот день уже наступил, задача активна")
+	require.Equal(t, ToDateString(today), todos[0].ActiveAt)
+
+	todos, err = service.FindTodos(behindCtx, TodoPointers{Status: &active})
+	require.NoError(t, err)
+	require.Empty(t, todos, "для того, у кого этот день ещё не наступил, задача не активна")
+}
+
+func TestTimeZoneHeader(t *testing.T) {
+	routes := routeServer{}
+	var server httpLib.Server = routes
+	ch := &stubCommandHandler{resp: []*TodoDTO{}}
+	controller := newTestTodoController(t, ch)
+	controller.http = controller.http.WithTimeZones(TimeZones{Default: time.UTC})
+	controller.server = &server
+	require.NoError(t, controller.Bind())
+	list := routes["GET /v2/todo-list/tasks"]
+
+	req := httptest.NewRequest(http.MethodGet, "/v2/todo-list/tasks", nil)
+	req.Header.Set(TimeZoneHeader, "Asia/Almaty")
+	resp := list(httptest.NewRecorder(), req)
+	require.Equal(t, http.StatusOK, resp.StatusCode())
+	require.Len(t, ch.executed, 1)
+	cmd := ch.executed[0].(*FindTodosCommand)
+	require.Equal(t, "Asia/Almaty", LocationFromContext(cmd.Context()).String())
+
+	req = httptest.NewRequest(http.MethodGet, "/v2/todo-list/tasks", nil)
+	req.Header.Set(TimeZoneHeader, "Almaty")
+	resp = list(httptest.NewRecorder(), req)
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode())
+	problem := resp.Response().(*Problem)
+	require.Equal(t, "todo-service.1010", problem.Code)
+	require.Equal(t, TimeZoneHeader, problem.Errors[0].Field)
+	require.Len(t, ch.executed, 1)
+}
@@ -0,0 +1,106 @@
+package todo
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/ru"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	enTranslations "github.com/go-playground/validator/v10/translations/en"
+	ruTranslations "github.com/go-playground/validator/v10/translations/ru"
+	"golang.org/x/text/language"
+)
+
+// domainTranslations holds messages for field errors reported by the service rather than the validator.
+// Keys are prefixed so they never collide with the validator's own tags.
+var domainTranslations = map[string]map[string]string{
+	"en": {
+		"domain-max":        "{0} must be a maximum of {1} characters in length",
+		"domain-datetime":   "{0} does not match the {1} format",
+		"domain-oneof":      "{0} must be one of [{1}]",
+		"domain-timezone":   "{0} must be an IANA time zone such as Asia/Almaty, not {1}",
+		"domain-transition": "{0} can only change to one of [{1}]",
+	},
+	"ru": {
+		"domain-max":        "{0} должен содержать максимум {1} символов",
+		"domain-datetime":   "{0} не соответствует формату {1}",
+		"domain-oneof":      "{0} должен быть одним из [{1}]",
+		"domain-timezone":   "{0} должен быть часовым поясом IANA, например Asia/Almaty, а не {1}",
+		"domain-transition": "{0} может смениться только на один из [{1}]",
+	},
+}
+
+// NewUniversalTranslator bundles English (the fallback) and Russian and registers validator messages for both.
+func NewUniversalTranslator(validate *validator.Validate) (*ut.UniversalTranslator, error) {
+	enLocale := en.New()
+	uni := ut.New(enLocale, enLocale, ru.New())
+
+	enTrans, _ := uni.GetTranslator("en")
+	if err := enTranslations.RegisterDefaultTranslations(validate, enTrans); err != nil {
+		return nil, err
+	}
+	ruTrans, _ := uni.GetTranslator("ru")
+	if err := ruTranslations.RegisterDefaultTranslations(validate, ruTrans); err != nil {
+		return nil, err
+	}
+
+	for _, translations := range []map[string]map[string]string{domainTranslations, dayTypePrefixes} {
+		for locale, messages := range translations {
+			trans, _ := uni.GetTranslator(locale)
+			for key, text := range messages {
+				if err := trans.Add(key, text, false); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return uni, nil
+}
+
+// translator picks the best bundled translator for the request's Accept-Language header.
+func (factory *TodoHttp) translator(r *http.Request) ut.Translator {
+	tags, _, _ := language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	locales := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		base, _ := tag.Base()
+		locales = append(locales, base.String())
+	}
+	trans, _ := factory.uni.FindTranslator(locales...)
+	return trans
+}
+
+// translateValidationError converts validator errors to a ValidationError and fills in field messages
+// in the request's language.
+func (factory *TodoHttp) translateValidationError(r *http.Request, err error) error {
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		translated := newValidationErrorFromValidator(validationErrs)
+		if factory.uni != nil {
+			trans := factory.translator(r)
+			for i, fe := range validationErrs {
+				translated.Fields[i].Message = fe.Translate(trans)
+			}
+		}
+		return translated
+	}
+	if factory.uni == nil {
+		return err
+	}
+	trans := factory.translator(r)
+
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		for i, fe := range validationErr.Fields {
+			if fe.Message != "" {
+				continue
+			}
+			message, tErr := trans.T("domain-"+fe.Rule, fe.Field, fe.Param)
+			if tErr == nil {
+				validationErr.Fields[i].Message = message
+			}
+		}
+	}
+	return err
+}
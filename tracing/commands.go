@@ -0,0 +1,36 @@
+package tracing
+
+import (
+	"context"
+
+	command "github.com/kas2000/commandlib"
+	"github.com/kas2000/service-todo/todo"
+)
+
+// contextCommand is implemented by commands embedding todo.CommandContext.
+type contextCommand interface {
+	Context() context.Context
+	SetContext(ctx context.Context)
+}
+
+type commandHandler struct {
+	next command.CommandHandler
+}
+
+// NewCommandHandler starts a span named after the command type, e.g. CreateTodoCommand, and hands it to the
+// command so that the service and repository spans nest under it. Commands without a context run untraced.
+func NewCommandHandler(next command.CommandHandler) command.CommandHandler {
+	return &commandHandler{next: next}
+}
+
+func (handler *commandHandler) ExecuteCommand(cmd command.Command) (interface{}, error) {
+	contextCmd, ok := cmd.(contextCommand)
+	if !ok {
+		return handler.next.ExecuteCommand(cmd)
+	}
+	ctx, span := tracer().Start(contextCmd.Context(), todo.CommandName(cmd))
+	contextCmd.SetContext(ctx)
+	result, err := handler.next.ExecuteCommand(cmd)
+	end(span, err)
+	return result, err
+}
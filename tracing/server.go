@@ -0,0 +1,47 @@
+package tracing
+
+import (
+	"net/http"
+
+	httpLib "github.com/kas2000/http"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type server struct {
+	httpLib.Server
+}
+
+// NewServer starts a span for every endpoint registered through Handle, continuing the caller's trace from
+// the traceparent header. Endpoints get the span in the request context.
+func NewServer(next httpLib.Server) httpLib.Server {
+	return &server{Server: next}
+}
+
+func (s *server) Handle(method string, path string, final httpLib.Endpoint) {
+	s.Server.Handle(method, path, func(w http.ResponseWriter, r *http.Request) httpLib.Response {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer().Start(ctx, method+" "+path,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(method),
+				semconv.HTTPRoute(path),
+				semconv.URLPath(r.URL.Path),
+			),
+		)
+		defer span.End()
+
+		response := final(w, r.WithContext(ctx))
+		// Streaming endpoints write the response themselves and are left without a status.
+		if response != nil {
+			span.SetAttributes(semconv.HTTPResponseStatusCode(response.StatusCode()))
+			if response.StatusCode() >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(response.StatusCode()))
+			}
+		}
+		return response
+	})
+}
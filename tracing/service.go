@@ -0,0 +1,122 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/kas2000/service-todo/todo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type service struct {
+	next todo.Service
+}
+
+// NewService starts a span for every service method, named e.g. TodoService.CreateTodo.
+func NewService(next todo.Service) todo.Service {
+	return &service{next: next}
+}
+
+func (s *service) CreateTodo(ctx context.Context, dto *todo.CreateTodoDTO) (*todo.TodoDTO, error) {
+	ctx, span := tracer().Start(ctx, "TodoService.CreateTodo")
+	result, err := s.next.CreateTodo(ctx, dto)
+	end(span, err)
+	return result, err
+}
+
+func (s *service) FindTodo(ctx context.Context, id primitive.ObjectID) (*todo.TodoDTO, error) {
+	ctx, span := tracer().Start(ctx, "TodoService.FindTodo")
+	result, err := s.next.FindTodo(ctx, id)
+	end(span, err)
+	return result, err
+}
+
+func (s *service) FindTodos(ctx context.Context, pointers todo.TodoPointers) ([]*todo.TodoDTO, error) {
+	ctx, span := tracer().Start(ctx, "TodoService.FindTodos")
+	result, err := s.next.FindTodos(ctx, pointers)
+	end(span, err)
+	return result, err
+}
+
+func (s *service) UpdateTodo(ctx context.Context, upd todo.UpdateTodoDTO) error {
+	ctx, span := tracer().Start(ctx, "TodoService.UpdateTodo")
+	err := s.next.UpdateTodo(ctx, upd)
+	end(span, err)
+	return err
+}
+
+func (s *service) UpdateTodoStatus(ctx context.Context, transition todo.TransitionTodoDTO) error {
+	ctx, span := tracer().Start(ctx, "TodoService.UpdateTodoStatus")
+	err := s.next.UpdateTodoStatus(ctx, transition)
+	end(span, err)
+	return err
+}
+
+func (s *service) DeleteTodo(ctx context.Context, id primitive.ObjectID) error {
+	ctx, span := tracer().Start(ctx, "TodoService.DeleteTodo")
+	err := s.next.DeleteTodo(ctx, id)
+	end(span, err)
+	return err
+}
+
+func (s *service) ImportTodos(ctx context.Context, todos []todo.CreateTodoDTO) (*todo.BulkResult, error) {
+	ctx, span := tracer().Start(ctx, "TodoService.ImportTodos")
+	result, err := s.next.ImportTodos(ctx, todos)
+	end(span, err)
+	return result, err
+}
+
+func (s *service) RescheduleTodos(ctx context.Context, reschedule todo.RescheduleTodosDTO) (*todo.BulkResult, error) {
+	ctx, span := tracer().Start(ctx, "TodoService.RescheduleTodos")
+	result, err := s.next.RescheduleTodos(ctx, reschedule)
+	end(span, err)
+	return result, err
+}
+
+func (s *service) MoveTodo(ctx context.Context, move todo.MoveTodoDTO) error {
+	ctx, span := tracer().Start(ctx, "TodoService.MoveTodo")
+	err := s.next.MoveTodo(ctx, move)
+	end(span, err)
+	return err
+}
+
+func (s *service) CreateTodoList(ctx context.Context, list *todo.CreateTodoListDTO) (*todo.TodoListDTO, error) {
+	ctx, span := tracer().Start(ctx, "TodoService.CreateTodoList")
+	result, err := s.next.CreateTodoList(ctx, list)
+	end(span, err)
+	return result, err
+}
+
+func (s *service) FindTodoList(ctx context.Context, id primitive.ObjectID) (*todo.TodoListDTO, error) {
+	ctx, span := tracer().Start(ctx, "TodoService.FindTodoList")
+	result, err := s.next.FindTodoList(ctx, id)
+	end(span, err)
+	return result, err
+}
+
+func (s *service) FindTodoLists(ctx context.Context) ([]*todo.TodoListDTO, error) {
+	ctx, span := tracer().Start(ctx, "TodoService.FindTodoLists")
+	result, err := s.next.FindTodoLists(ctx)
+	end(span, err)
+	return result, err
+}
+
+func (s *service) RenameTodoList(ctx context.Context, rename todo.RenameTodoListDTO) error {
+	ctx, span := tracer().Start(ctx, "TodoService.RenameTodoList")
+	err := s.next.RenameTodoList(ctx, rename)
+	end(span, err)
+	return err
+}
+
+func (s *service) ArchiveTodoList(ctx context.Context, id primitive.ObjectID, archived bool) error {
+	ctx, span := tracer().Start(ctx, "TodoService.ArchiveTodoList")
+	err := s.next.ArchiveTodoList(ctx, id, archived)
+	end(span, err)
+	return err
+}
+
+func (s *service) DeleteTodoList(ctx context.Context, id primitive.ObjectID) error {
+	ctx, span := tracer().Start(ctx, "TodoService.DeleteTodoList")
+	err := s.next.DeleteTodoList(ctx, id)
+	end(span, err)
+	return err
+}
@@ -0,0 +1,110 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	command "github.com/kas2000/commandlib"
+	httpLib "github.com/kas2000/http"
+	"github.com/kas2000/service-todo/config"
+	"github.com/kas2000/service-todo/todo"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// routeServer keeps the wrapped endpoints by "METHOD path" instead of serving them.
+type routeServer map[string]httpLib.Endpoint
+
+func (routes routeServer) ListenAndServe() {}
+
+func (routes routeServer) Handle(method string, path string, final httpLib.Endpoint) {
+	routes[method+" "+path] = final
+}
+
+type stubService struct {
+	todo.Service
+	err error
+}
+
+func (s *stubService) FindTodo(ctx context.Context, id primitive.ObjectID) (*todo.TodoDTO, error) {
+	return nil, s.err
+}
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	_, err := Setup(context.Background(), config.TracingConfig{Exporter: "none"})
+	require.NoError(t, err)
+
+	svc := &stubService{}
+	handler := NewCommandHandler(command.NewCommandHandler(NewService(svc)))
+	routes := routeServer{}
+	NewServer(routes).Handle("GET", "/api/todo-list/tasks/{id}", func(w http.ResponseWriter, r *http.Request) httpLib.Response {
+		_, err := handler.ExecuteCommand(&todo.FindTodoCommand{CommandContext: todo.NewCommandContext(r.Context())})
+		if err != nil {
+			def, _ := todo.LookupError(err)
+			return httpLib.NewResponse(def.Status, nil, nil)
+		}
+		return httpLib.NewResponse(http.StatusOK, nil, nil)
+	})
+
+	req := httptest.NewRequest("GET", "/api/todo-list/tasks/1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	routes["GET /api/todo-list/tasks/{id}"](httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+	serviceSpan, commandSpan, httpSpan := spans[0], spans[1], spans[2]
+	require.Equal(t, "TodoService.FindTodo", serviceSpan.Name())
+	require.Equal(t, "FindTodoCommand", commandSpan.Name())
+	require.Equal(t, "GET /api/todo-list/tasks/{id}", httpSpan.Name())
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", httpSpan.SpanContext().TraceID().String(), "продолжает трассу вызывающего")
+	require.Equal(t, "00f067aa0ba902b7", httpSpan.Parent().SpanID().String())
+	require.Equal(t, httpSpan.SpanContext().SpanID(), commandSpan.Parent().SpanID())
+	require.Equal(t, commandSpan.SpanContext().SpanID(), serviceSpan.Parent().SpanID())
+
+	tests := []struct {
+		title  string
+		err    error
+		status codes.Code
+	}{
+		{title: "Ожидаемая ошибка не помечает спан как сбой", err: todo.ErrTodoNotFound, status: codes.Unset},
+		{title: "Внутренняя ошибка помечает спан как сбой", err: errors.New("connection reset"), status: codes.Error},
+	}
+	for _, test := range tests {
+		t.Run(test.title, func(t *testing.T) {
+			svc.err = test.err
+			before := len(recorder.Ended())
+			routes["GET /api/todo-list/tasks/{id}"](httptest.NewRecorder(), httptest.NewRequest("GET", "/api/todo-list/tasks/1", nil))
+			spans := recorder.Ended()[before:]
+			require.Len(t, spans, 3)
+			for _, span := range spans {
+				require.Equal(t, test.status, span.Status().Code, span.Name())
+			}
+			require.Len(t, spans[0].Events(), 1, "ошибка записана в спан сервиса")
+		})
+	}
+}
+
+func TestSetupStdoutFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "spans.json")
+	shutdown, err := Setup(context.Background(), config.TracingConfig{Exporter: "stdout", File: file, ServiceName: "service-todo"})
+	require.NoError(t, err)
+	_, span := tracer().Start(context.Background(), "CreateTodoCommand")
+	span.End()
+	require.NoError(t, shutdown(context.Background()))
+
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"Name":"CreateTodoCommand"`)
+	require.Contains(t, string(data), `"Value":"service-todo"`)
+}